@@ -17,3 +17,17 @@ data "pkcs11_decrypt" "cbc" {
   mechanism_parameter = base64encode("0123456789abcdef") # same IV used for encryption
   ciphertext          = data.pkcs11_encrypt.cbc.ciphertext
 }
+
+# Decrypt RSA-OAEP with SHA-256
+data "pkcs11_decrypt" "oaep" {
+  mechanism  = "CKM_RSA_PKCS_OAEP"
+  key_label  = "my-rsa-key"
+  key_class  = "CKO_PRIVATE_KEY"
+  ciphertext = var.oaep_ciphertext
+
+  mechanism_parameters = {
+    oaep = {
+      hash = "CKM_SHA256"
+    }
+  }
+}
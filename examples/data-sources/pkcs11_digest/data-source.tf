@@ -0,0 +1,21 @@
+# Hash data with SHA-256 on the token
+data "pkcs11_digest" "sha256" {
+  mechanism = "CKM_SHA256"
+  data      = base64encode("hello world")
+}
+
+output "sha256_hex" {
+  value = data.pkcs11_digest.sha256.digest_hex
+}
+
+# Hash a large file in chunks with SHA3-256
+data "pkcs11_digest" "artifact" {
+  mechanism  = "CKM_SHA3_256"
+  input_file = "${path.module}/artifact.tar.gz"
+}
+
+# Fingerprint a secret key without extracting it
+data "pkcs11_digest" "key_fingerprint" {
+  mechanism = "CKM_SHA256"
+  key_label = "my-aes-key"
+}
@@ -17,3 +17,17 @@ data "pkcs11_encrypt" "cbc" {
   mechanism_parameter = base64encode("0123456789abcdef") # 16-byte IV
   plaintext           = base64encode("hello world")
 }
+
+# Encrypt with AES-GCM using typed mechanism parameters
+data "pkcs11_encrypt" "gcm" {
+  mechanism = "CKM_AES_GCM"
+  key_label = "my-aes-key"
+  plaintext = base64encode("hello world")
+
+  mechanism_parameters = {
+    gcm = {
+      iv  = base64encode("0123456789ab") # 12-byte IV
+      aad = base64encode("header")
+    }
+  }
+}
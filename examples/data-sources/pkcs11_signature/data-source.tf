@@ -16,3 +16,17 @@ data "pkcs11_signature" "ecdsa" {
   key_class = "CKO_PRIVATE_KEY"
   data      = base64encode("message to sign")
 }
+
+# Sign data with RSA-PSS (MGF and salt length default to the digest)
+data "pkcs11_signature" "pss" {
+  mechanism = "CKM_SHA256_RSA_PKCS_PSS"
+  key_label = "my-signing-key"
+  key_class = "CKO_PRIVATE_KEY"
+  data      = base64encode("message to sign")
+
+  mechanism_parameters = {
+    pss = {
+      hash = "CKM_SHA256"
+    }
+  }
+}
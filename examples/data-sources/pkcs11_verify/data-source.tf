@@ -0,0 +1,30 @@
+# Verify an RSA signature with the public key
+data "pkcs11_verify" "rsa" {
+  mechanism = "CKM_SHA256_RSA_PKCS"
+  key_label = "my-signing-key"
+  data      = base64encode("message to sign")
+  signature = data.pkcs11_signature.rsa.signature
+}
+
+output "rsa_signature_valid" {
+  value = data.pkcs11_verify.rsa.valid
+}
+
+# Verify an HMAC with a secret key
+data "pkcs11_verify" "hmac" {
+  mechanism = "CKM_SHA256_HMAC"
+  key_label = "my-hmac-key"
+  key_class = "CKO_SECRET_KEY"
+  data      = filebase64("${path.module}/artifact.tar.gz")
+  signature = var.artifact_mac
+}
+
+# Gate a resource on a valid artifact signature
+resource "terraform_data" "deploy" {
+  lifecycle {
+    precondition {
+      condition     = data.pkcs11_verify.rsa.valid
+      error_message = "Artifact signature is invalid."
+    }
+  }
+}
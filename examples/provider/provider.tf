@@ -29,3 +29,46 @@ provider "pkcs11" {
     "YUBIHSM_PKCS11_CONF" = "/etc/yubihsm_pkcs11.conf"
   }
 }
+
+# Connect using a PKCS#11 URI (RFC 7512)
+provider "pkcs11" {
+  uri = "pkcs11:token=MyToken?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=/run/secrets/pkcs11-pin"
+}
+
+# Several tokens, selected by resources and data sources through token_name
+provider "pkcs11" {
+  module_path = "/usr/lib/softhsm/libsofthsm2.so"
+
+  tokens = {
+    source = {
+      token_label = "Source"
+      pin         = var.source_pin
+    }
+    destination = {
+      module_path = "/usr/lib/pkcs11/yubihsm_pkcs11.so"
+      slot_id     = 0
+      pin         = var.destination_pin
+    }
+  }
+}
+
+# Network HSM with retries of transient errors
+provider "pkcs11" {
+  module_path = "/opt/hsm/lib/libpkcs11.so"
+  token_label = "Production"
+  pin         = var.pkcs11_pin
+
+  retry = {
+    max_attempts     = 5
+    initial_backoff  = "200ms"
+    max_backoff      = "10s"
+    retryable_errors = ["CKR_DEVICE_ERROR", "CKR_FUNCTION_FAILED"]
+  }
+}
+
+# In-memory token emulator for development and terraform test, kept in a file
+provider "pkcs11" {
+  module_path = "memory:${path.root}/.pkcs11-token.json"
+  slot_id     = 0
+  pin         = var.pkcs11_pin
+}
@@ -0,0 +1,23 @@
+# Derive a per-tenant AES key from a master key with HKDF
+resource "pkcs11_derived_key" "tenant" {
+  mechanism      = "CKM_HKDF_DERIVE"
+  base_key_label = "master-key"
+
+  mechanism_parameters = {
+    hkdf = {
+      prf  = "CKM_SHA256"
+      salt = base64encode("tenant-salt")
+      info = base64encode("tenant-a")
+    }
+  }
+
+  # Template attributes for the derived key
+  label     = "tenant-a-key"
+  class     = "CKO_SECRET_KEY"
+  key_type  = "CKK_AES"
+  value_len = 32
+  encrypt   = true
+  decrypt   = true
+  token     = true
+  sensitive = true
+}
@@ -0,0 +1,20 @@
+# Agree on an AES wrapping key with a partner system whose public key is
+# provided as PEM. The partner derives the same key from its private key
+# and our public key.
+resource "pkcs11_ecdh_key" "partner" {
+  private_key_label = "key-exchange"
+  peer_public_key   = file("${path.module}/partner.pem")
+
+  kdf         = "CKD_SHA256_KDF"
+  shared_data = base64encode("partner-kek-2024")
+
+  # Template attributes for the derived key
+  label     = "partner-kek"
+  class     = "CKO_SECRET_KEY"
+  key_type  = "CKK_AES"
+  value_len = 32
+  wrap      = true
+  unwrap    = true
+  token     = true
+  sensitive = true
+}
@@ -5,3 +5,18 @@ resource "pkcs11_object" "my_data" {
   value = base64encode("hello world")
   token = true
 }
+
+# Import an RSA public key as trusted wrapping key. Only the Security Officer
+# may set trusted; keys with wrap_with_trusted = true can then only be wrapped
+# with trusted keys such as this one.
+resource "pkcs11_object" "escrow_wrapping_key" {
+  class           = "CKO_PUBLIC_KEY"
+  key_type        = "CKK_RSA"
+  label           = "escrow-wrapping-key"
+  modulus         = var.escrow_modulus_hex
+  public_exponent = "010001"
+  token           = true
+  wrap            = true
+  trusted         = true
+  login_as        = "so"
+}
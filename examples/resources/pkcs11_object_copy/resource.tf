@@ -0,0 +1,8 @@
+# Derive a verify-only variant of a non-extractable HMAC key
+resource "pkcs11_object_copy" "hmac_verify" {
+  source_label = "hmac-key"
+  source_class = "CKO_SECRET_KEY"
+
+  label = "hmac-key-verify"
+  sign  = false
+}
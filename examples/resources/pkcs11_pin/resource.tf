@@ -0,0 +1,6 @@
+# Rotate the user PIN once per quarter
+resource "pkcs11_pin" "user" {
+  old_pin          = var.pkcs11_pin
+  new_pin          = var.pkcs11_next_pin
+  rotation_trigger = "2026-Q4"
+}
@@ -0,0 +1,14 @@
+# Bootstrap secret generated by the token RNG, rotated together with the release
+resource "pkcs11_random" "bootstrap" {
+  length   = 32
+  alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+
+  keepers = {
+    release = var.release
+  }
+}
+
+output "bootstrap_token" {
+  value     = pkcs11_random.bootstrap.password
+  sensitive = true
+}
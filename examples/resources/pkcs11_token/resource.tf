@@ -0,0 +1,19 @@
+# Initialize a fresh token; the provider selects it by token_label = "signing"
+# with defer_token_resolution = true
+resource "pkcs11_token" "signing" {
+  slot_id  = 1
+  label    = "signing"
+  so_pin   = var.so_pin
+  user_pin = var.pkcs11_pin
+}
+
+resource "pkcs11_symmetric_key" "master" {
+  mechanism = "CKM_AES_KEY_GEN"
+  label     = "master"
+  class     = "CKO_SECRET_KEY"
+  key_type  = "CKK_AES"
+  value_len = 32
+  token     = true
+
+  depends_on = [pkcs11_token.signing]
+}
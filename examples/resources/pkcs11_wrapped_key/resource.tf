@@ -35,3 +35,14 @@ output "wrapped_key_material" {
   value     = pkcs11_wrapped_key.wrapped.wrapped_key_material
   sensitive = true
 }
+
+# Wrap with AES-CBC-PAD, passing the IV as a mechanism parameter
+resource "pkcs11_wrapped_key" "wrapped_cbc" {
+  mechanism          = "CKM_AES_CBC_PAD"
+  wrapping_key_label = pkcs11_symmetric_key.wrapping_key.label
+  key_label          = pkcs11_symmetric_key.target_key.label
+
+  mechanism_parameters = {
+    iv = base64encode("0123456789abcdef")
+  }
+}
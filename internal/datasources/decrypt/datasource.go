@@ -1,22 +1,24 @@
 package decrypt
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/types"
-	"github.com/miekg/pkcs11"
 
 	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
 )
 
 var _ datasource.DataSource = &DecryptDataSource{}
 
 type DecryptDataSource struct {
-	client *pkcs11client.Client
+	clients *pkcs11client.Clients
 }
 
 func NewDataSource() datasource.DataSource {
@@ -29,15 +31,17 @@ func (d *DecryptDataSource) Metadata(_ context.Context, req datasource.MetadataR
 
 func (d *DecryptDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
 	resp.Schema = schema.Schema{
-		Description: "Decrypts data using a key on the PKCS#11 token via C_DecryptInit + C_Decrypt.",
+		Description: "Decrypts data using a key on the PKCS#11 token via C_DecryptInit + C_Decrypt. " +
+			"Files are decrypted in chunks via C_DecryptUpdate + C_DecryptFinal. Exactly one of ciphertext and input_file must be set.",
 		Attributes: map[string]schema.Attribute{
+			"token_name": shared.TokenNameDataSourceSchema(),
 			"mechanism": schema.StringAttribute{
 				Required:    true,
 				Description: "PKCS#11 mechanism name (e.g. CKM_AES_ECB). Accepts CKM_ prefix or without.",
 			},
 			"key_label": schema.StringAttribute{
 				Required:    true,
-				Description: "Label of the decryption key on the token.",
+				Description: "Label or PKCS#11 URI of the decryption key on the token.",
 			},
 			"key_class": schema.StringAttribute{
 				Optional:    true,
@@ -45,13 +49,23 @@ func (d *DecryptDataSource) Schema(_ context.Context, _ datasource.SchemaRequest
 			},
 			"mechanism_parameter": schema.StringAttribute{
 				Optional:    true,
-				Description: "Base64-encoded mechanism parameter (e.g. IV for CBC modes).",
+				Description: "Base64-encoded mechanism parameter (e.g. IV for CBC modes). Mutually exclusive with mechanism_parameters.",
 			},
+			"mechanism_parameters": shared.MechanismParamsDataSourceSchema(),
 			"ciphertext": schema.StringAttribute{
-				Required:    true,
+				Optional:    true,
 				Sensitive:   true,
 				Description: "Base64-encoded ciphertext to decrypt.",
 			},
+			"input_file": schema.StringAttribute{
+				Optional:    true,
+				Description: "Path to a file with the ciphertext to decrypt instead of ciphertext. The file is passed to the token in chunks, but the whole plaintext is held in memory and stored base64-encoded in state, so this only suits files of moderate size.",
+			},
+			"key_pin": schema.StringAttribute{
+				Optional:    true,
+				Sensitive:   true,
+				Description: "PIN for the context-specific login required by keys with always_authenticate set (e.g. PIV signature keys). Defaults to the user PIN of the token.",
+			},
 			"plaintext": schema.StringAttribute{
 				Computed:    true,
 				Sensitive:   true,
@@ -65,31 +79,46 @@ func (d *DecryptDataSource) Configure(_ context.Context, req datasource.Configur
 	if req.ProviderData == nil {
 		return
 	}
-	client, ok := req.ProviderData.(*pkcs11client.Client)
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
 	if !ok {
 		resp.Diagnostics.AddError("Unexpected DataSource Configure Type",
-			fmt.Sprintf("Expected *pkcs11client.Client, got: %T", req.ProviderData))
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
 		return
 	}
-	d.client = client
+	d.clients = clients
 }
 
 func (d *DecryptDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	client, diags := shared.ClientFor(ctx, d.clients, req.Config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	var mechanism types.String
 	var keyLabel types.String
 	var keyClass types.String
 	var mechParam types.String
 	var ciphertext types.String
+	var inputFile types.String
+	var keyPin types.String
 
 	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("mechanism"), &mechanism)...)
 	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("key_label"), &keyLabel)...)
 	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("key_class"), &keyClass)...)
 	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("mechanism_parameter"), &mechParam)...)
 	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("ciphertext"), &ciphertext)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("input_file"), &inputFile)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("key_pin"), &keyPin)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	if ciphertext.IsNull() == inputFile.IsNull() {
+		resp.Diagnostics.AddError("Invalid input", "Exactly one of ciphertext and input_file must be set.")
+		return
+	}
+
 	// Resolve mechanism
 	mechID, err := pkcs11client.MechanismEnum.Resolve(mechanism.ValueString())
 	if err != nil {
@@ -109,37 +138,69 @@ func (d *DecryptDataSource) Read(ctx context.Context, req datasource.ReadRequest
 	}
 
 	// Find key
-	keyHandle, err := d.client.FindObjectByLabelAndClass(keyLabel.ValueString(), classID)
+	keyHandle, err := client.FindKey(ctx, keyLabel.ValueString(), classID)
 	if err != nil {
 		resp.Diagnostics.AddError("Key not found", fmt.Sprintf("Failed to find key with label %q: %s", keyLabel.ValueString(), err))
 		return
 	}
 
-	// Build mechanism
-	var mechParamBytes []byte
-	if !mechParam.IsNull() && !mechParam.IsUnknown() {
-		mechParamBytes, err = pkcs11client.DecodeBase64(mechParam.ValueString())
+	// Read mechanism parameters
+	mechParams, diags := shared.DataSourceMechanismParams(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Read ciphertext or open input_file
+	var inputBytes []byte
+	var input *os.File
+	var inputLen int
+	if !ciphertext.IsNull() {
+		inputBytes, err = pkcs11client.DecodeBase64(ciphertext.ValueString())
 		if err != nil {
-			resp.Diagnostics.AddError("Invalid mechanism_parameter", fmt.Sprintf("Failed to decode base64: %s", err))
+			resp.Diagnostics.AddError("Invalid ciphertext", fmt.Sprintf("Failed to decode base64: %s", err))
 			return
 		}
-	}
-	var mech []*pkcs11.Mechanism
-	if mechParamBytes != nil {
-		mech = []*pkcs11.Mechanism{pkcs11.NewMechanism(mechID, mechParamBytes)}
+		inputLen = len(inputBytes)
 	} else {
-		mech = []*pkcs11.Mechanism{pkcs11.NewMechanism(mechID, nil)}
+		input, err = os.Open(inputFile.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid input_file", err.Error())
+			return
+		}
+		defer input.Close()
+		info, err := input.Stat()
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid input_file", err.Error())
+			return
+		}
+		inputLen = int(info.Size())
 	}
 
-	// Decode ciphertext
-	ciphertextBytes, err := pkcs11client.DecodeBase64(ciphertext.ValueString())
+	// Build mechanism
+	if mechParams != nil && mechParams.CCM != nil && mechParams.CCM.DataLen == 0 {
+		mechParams.CCM.DataLen = mechParams.CCM.PlaintextLen(inputLen)
+	}
+	mech, freeMech, err := pkcs11client.NewMechanism(mechID, mechParams)
 	if err != nil {
-		resp.Diagnostics.AddError("Invalid ciphertext", fmt.Sprintf("Failed to decode base64: %s", err))
+		resp.Diagnostics.AddError("Invalid mechanism_parameters", err.Error())
 		return
 	}
+	defer freeMech()
+
+	if !keyPin.IsNull() {
+		ctx = pkcs11client.WithKeyPin(ctx, keyPin.ValueString())
+	}
 
 	// Decrypt
-	plaintext, err := d.client.Decrypt(mech, keyHandle, ciphertextBytes)
+	var plaintext []byte
+	if input == nil {
+		plaintext, err = client.Decrypt(ctx, mech, keyHandle, inputBytes)
+	} else {
+		var buf bytes.Buffer
+		err = client.DecryptStream(ctx, mech, keyHandle, input, &buf)
+		plaintext = buf.Bytes()
+	}
 	if err != nil {
 		resp.Diagnostics.AddError("Decryption failed", err.Error())
 		return
@@ -154,6 +215,8 @@ func (d *DecryptDataSource) Read(ctx context.Context, req datasource.ReadRequest
 	if !mechParam.IsNull() && !mechParam.IsUnknown() {
 		resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("mechanism_parameter"), mechParam.ValueString())...)
 	}
-	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("ciphertext"), ciphertext.ValueString())...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("ciphertext"), ciphertext)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("input_file"), inputFile)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("key_pin"), keyPin)...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("plaintext"), pkcs11client.EncodeBase64(plaintext))...)
 }
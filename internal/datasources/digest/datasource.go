@@ -0,0 +1,199 @@
+package digest
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
+)
+
+var _ datasource.DataSource = &DigestDataSource{}
+
+type DigestDataSource struct {
+	clients *pkcs11client.Clients
+}
+
+func NewDataSource() datasource.DataSource {
+	return &DigestDataSource{}
+}
+
+func (d *DigestDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_digest"
+}
+
+func (d *DigestDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Computes a message digest on the PKCS#11 token via C_DigestInit + C_Digest. " +
+			"Files are hashed in chunks via C_DigestUpdate + C_DigestFinal, and secret keys via C_DigestKey. " +
+			"Exactly one of data, input_file and key_label must be set.",
+		Attributes: map[string]schema.Attribute{
+			"token_name": shared.TokenNameDataSourceSchema(),
+			"mechanism": schema.StringAttribute{
+				Required:    true,
+				Description: "PKCS#11 digest mechanism name (e.g. CKM_SHA256, CKM_SHA3_256) or numeric value for vendor mechanisms. Accepts CKM_ prefix or without.",
+			},
+			"mechanism_parameter": schema.StringAttribute{
+				Optional:    true,
+				Description: "Base64-encoded parameter of vendor digest mechanisms that take one. The standard digest mechanisms have no parameter.",
+			},
+			"data": schema.StringAttribute{
+				Optional:    true,
+				Description: "Base64-encoded data to hash.",
+			},
+			"input_file": schema.StringAttribute{
+				Optional:    true,
+				Description: "Path to a file to hash instead of data. The file is read in chunks with C_DigestUpdate, so only the digest is kept in memory and state.",
+			},
+			"key_label": schema.StringAttribute{
+				Optional:    true,
+				Description: "Label or PKCS#11 URI of a secret key whose value is hashed via C_DigestKey. The key value does not leave the token.",
+			},
+			"key_class": schema.StringAttribute{
+				Optional:    true,
+				Description: "Object class of the key (e.g. CKO_SECRET_KEY). Defaults to CKO_SECRET_KEY.",
+			},
+			"digest": schema.StringAttribute{
+				Computed:    true,
+				Description: "Base64-encoded digest.",
+			},
+			"digest_hex": schema.StringAttribute{
+				Computed:    true,
+				Description: "Hex-encoded digest.",
+			},
+		},
+	}
+}
+
+func (d *DigestDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected DataSource Configure Type",
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
+		return
+	}
+	d.clients = clients
+}
+
+func (d *DigestDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	client, diags := shared.ClientFor(ctx, d.clients, req.Config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var mechanism types.String
+	var mechParam types.String
+	var data types.String
+	var inputFile types.String
+	var keyLabel types.String
+	var keyClass types.String
+
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("mechanism"), &mechanism)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("mechanism_parameter"), &mechParam)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("data"), &data)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("input_file"), &inputFile)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("key_label"), &keyLabel)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("key_class"), &keyClass)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	inputs := 0
+	for _, v := range []types.String{data, inputFile, keyLabel} {
+		if !v.IsNull() {
+			inputs++
+		}
+	}
+	if inputs != 1 {
+		resp.Diagnostics.AddError("Invalid input", "Exactly one of data, input_file and key_label must be set.")
+		return
+	}
+
+	// Resolve mechanism
+	mechID, err := pkcs11client.MechanismEnum.Resolve(mechanism.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid mechanism", err.Error())
+		return
+	}
+
+	// Build mechanism
+	var mechParams *pkcs11client.MechanismParams
+	if !mechParam.IsNull() {
+		raw, err := pkcs11client.DecodeBase64(mechParam.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid mechanism_parameter", fmt.Sprintf("Failed to decode base64: %s", err))
+			return
+		}
+		mechParams = &pkcs11client.MechanismParams{Raw: raw}
+	}
+	mech, freeMech, err := pkcs11client.NewMechanism(mechID, mechParams)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid mechanism_parameter", err.Error())
+		return
+	}
+	defer freeMech()
+
+	// Digest
+	var digest []byte
+	switch {
+	case !data.IsNull():
+		dataBytes, err := pkcs11client.DecodeBase64(data.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid data", fmt.Sprintf("Failed to decode base64: %s", err))
+			return
+		}
+		digest, err = client.Digest(ctx, mech, dataBytes)
+		if err != nil {
+			resp.Diagnostics.AddError("Digest failed", err.Error())
+			return
+		}
+
+	case !inputFile.IsNull():
+		f, err := os.Open(inputFile.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid input_file", err.Error())
+			return
+		}
+		defer f.Close()
+		digest, err = client.DigestReader(ctx, mech, f)
+		if err != nil {
+			resp.Diagnostics.AddError("Digest failed", err.Error())
+			return
+		}
+
+	default:
+		classID := pkcs11client.ObjectClassNameToID["CKO_SECRET_KEY"]
+		if !keyClass.IsNull() && !keyClass.IsUnknown() {
+			classEnum := pkcs11client.AttributeNameToDef["class"].Pkcs11Enum
+			classID, err = classEnum.Resolve(keyClass.ValueString())
+			if err != nil {
+				resp.Diagnostics.AddError("Invalid key_class", err.Error())
+				return
+			}
+		}
+		keyHandle, err := client.FindKey(ctx, keyLabel.ValueString(), classID)
+		if err != nil {
+			resp.Diagnostics.AddError("Key not found", fmt.Sprintf("Failed to find key with label %q: %s", keyLabel.ValueString(), err))
+			return
+		}
+		digest, err = client.DigestKey(ctx, mech, keyHandle)
+		if err != nil {
+			resp.Diagnostics.AddError("Digest failed", err.Error())
+			return
+		}
+	}
+
+	// Set state
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("digest"), pkcs11client.EncodeBase64(digest))...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("digest_hex"), pkcs11client.EncodeHex(digest))...)
+}
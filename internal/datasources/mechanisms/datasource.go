@@ -5,19 +5,22 @@ import (
 	"fmt"
 
 	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 )
 
 var _ datasource.DataSource = &MechanismsDataSource{}
 
 type MechanismsDataSource struct {
-	client *pkcs11client.Client
+	clients *pkcs11client.Clients
 }
 
 type MechanismsModel struct {
 	Mechanisms []MechanismModel `tfsdk:"mechanisms"`
+	TokenName  types.String     `tfsdk:"token_name"`
 }
 
 type MechanismModel struct {
@@ -40,6 +43,7 @@ func (d *MechanismsDataSource) Schema(_ context.Context, _ datasource.SchemaRequ
 	resp.Schema = schema.Schema{
 		Description: "Lists mechanisms supported by the PKCS#11 token.",
 		Attributes: map[string]schema.Attribute{
+			"token_name": shared.TokenNameDataSourceSchema(),
 			"mechanisms": schema.ListNestedAttribute{
 				Computed: true,
 				NestedObject: schema.NestedAttributeObject{
@@ -60,17 +64,23 @@ func (d *MechanismsDataSource) Configure(_ context.Context, req datasource.Confi
 	if req.ProviderData == nil {
 		return
 	}
-	client, ok := req.ProviderData.(*pkcs11client.Client)
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
 	if !ok {
 		resp.Diagnostics.AddError("Unexpected DataSource Configure Type",
-			fmt.Sprintf("Expected *pkcs11client.Client, got: %T", req.ProviderData))
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
 		return
 	}
-	d.client = client
+	d.clients = clients
 }
 
-func (d *MechanismsDataSource) Read(ctx context.Context, _ datasource.ReadRequest, resp *datasource.ReadResponse) {
-	mechs, err := d.client.GetMechanismList()
+func (d *MechanismsDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	client, diags := shared.ClientFor(ctx, d.clients, req.Config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	mechs, err := client.GetMechanismList(ctx)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to list mechanisms", err.Error())
 		return
@@ -89,5 +99,6 @@ func (d *MechanismsDataSource) Read(ctx context.Context, _ datasource.ReadReques
 		}
 	}
 
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("token_name"), &state.TokenName)...)
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
 }
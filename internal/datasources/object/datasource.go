@@ -12,12 +12,13 @@ import (
 	"github.com/miekg/pkcs11"
 
 	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
 )
 
 var _ datasource.DataSource = &ObjectDataSource{}
 
 type ObjectDataSource struct {
-	client *pkcs11client.Client
+	clients *pkcs11client.Clients
 }
 
 func NewDataSource() datasource.DataSource {
@@ -63,6 +64,8 @@ func (d *ObjectDataSource) Schema(_ context.Context, _ datasource.SchemaRequest,
 		Computed:    true,
 		Description: "If null or set to true, the data source will return an error if no matching object is found. If set to false, the data source will return an empty result instead. The data source will update the field to indicate whether the object exists or not.",
 	}
+	attrs["token_name"] = shared.TokenNameDataSourceSchema()
+	attrs["uri"] = shared.ObjectURIDataSourceSchema()
 
 	resp.Schema = schema.Schema{
 		Description: "Looks up a PKCS#11 object by attributes (CKA constants without CKA_prefix, lower-cased), returning all readable attributes.",
@@ -74,16 +77,22 @@ func (d *ObjectDataSource) Configure(_ context.Context, req datasource.Configure
 	if req.ProviderData == nil {
 		return
 	}
-	client, ok := req.ProviderData.(*pkcs11client.Client)
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
 	if !ok {
 		resp.Diagnostics.AddError("Unexpected DataSource Configure Type",
-			fmt.Sprintf("Expected *pkcs11client.Client, got: %T", req.ProviderData))
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
 		return
 	}
-	d.client = client
+	d.clients = clients
 }
 
 func (d *ObjectDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	client, diags := shared.ClientFor(ctx, d.clients, req.Config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	var must_exist types.Bool
 
 	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("exists"), &must_exist)...)
@@ -130,7 +139,7 @@ func (d *ObjectDataSource) Read(ctx context.Context, req datasource.ReadRequest,
 		}
 	}
 
-	handle, err := d.client.FindOneObject(template)
+	handle, err := client.FindOneObject(ctx, template)
 	if err != nil {
 		if must_exist.IsNull() || must_exist.ValueBool() {
 			resp.Diagnostics.AddError("Object not found", fmt.Sprintf("No object found matching the template: %s", err))
@@ -145,7 +154,7 @@ func (d *ObjectDataSource) Read(ctx context.Context, req datasource.ReadRequest,
 		resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("exists"), true)...)
 	}
 
-	rawAttrs := d.client.GetAllObjectAttributes(handle)
+	rawAttrs := client.GetAllObjectAttributes(ctx, handle)
 
 	for _, def := range pkcs11client.ObjectAttrs {
 		attrName := def.TFKey
@@ -175,4 +184,11 @@ func (d *ObjectDataSource) Read(ctx context.Context, req datasource.ReadRequest,
 		}
 		resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root(attrName), setVal)...)
 	}
+
+	uri, err := client.ObjectURI(ctx, handle)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to build object URI", err.Error())
+		return
+	}
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("uri"), uri)...)
 }
@@ -3,20 +3,21 @@ package signature
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/types"
-	"github.com/miekg/pkcs11"
 
 	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
 )
 
 var _ datasource.DataSource = &SignatureDataSource{}
 
 type SignatureDataSource struct {
-	client *pkcs11client.Client
+	clients *pkcs11client.Clients
 }
 
 func NewDataSource() datasource.DataSource {
@@ -29,15 +30,18 @@ func (d *SignatureDataSource) Metadata(_ context.Context, req datasource.Metadat
 
 func (d *SignatureDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
 	resp.Schema = schema.Schema{
-		Description: "Signs data using a key on the PKCS#11 token via C_SignInit + C_Sign.",
+		Description: "Signs data using a key on the PKCS#11 token via C_SignInit + C_Sign. " +
+			"Files are signed in chunks via C_SignUpdate + C_SignFinal, which requires a mechanism that hashes on the token " +
+			"(e.g. CKM_SHA256_RSA_PKCS, CKM_ECDSA_SHA256). Exactly one of data and input_file must be set.",
 		Attributes: map[string]schema.Attribute{
+			"token_name": shared.TokenNameDataSourceSchema(),
 			"mechanism": schema.StringAttribute{
 				Required:    true,
 				Description: "PKCS#11 mechanism name (e.g. CKM_RSA_PKCS, CKM_ECDSA). Accepts CKM_ prefix or without.",
 			},
 			"key_label": schema.StringAttribute{
 				Required:    true,
-				Description: "Label of the signing key on the token.",
+				Description: "Label or PKCS#11 URI of the signing key on the token.",
 			},
 			"key_class": schema.StringAttribute{
 				Optional:    true,
@@ -45,12 +49,22 @@ func (d *SignatureDataSource) Schema(_ context.Context, _ datasource.SchemaReque
 			},
 			"mechanism_parameter": schema.StringAttribute{
 				Optional:    true,
-				Description: "Base64-encoded mechanism parameter.",
+				Description: "Base64-encoded mechanism parameter. Mutually exclusive with mechanism_parameters.",
 			},
+			"mechanism_parameters": shared.MechanismParamsDataSourceSchema(),
 			"data": schema.StringAttribute{
-				Required:    true,
+				Optional:    true,
 				Description: "Base64-encoded data to sign.",
 			},
+			"input_file": schema.StringAttribute{
+				Optional:    true,
+				Description: "Path to a file to sign instead of data, e.g. a release archive. It is read in chunks with C_SignUpdate, so files of any size can be signed.",
+			},
+			"key_pin": schema.StringAttribute{
+				Optional:    true,
+				Sensitive:   true,
+				Description: "PIN for the context-specific login required by keys with always_authenticate set (e.g. PIV signature keys). Defaults to the user PIN of the token.",
+			},
 			"signature": schema.StringAttribute{
 				Computed:    true,
 				Description: "Base64-encoded signature result.",
@@ -63,31 +77,46 @@ func (d *SignatureDataSource) Configure(_ context.Context, req datasource.Config
 	if req.ProviderData == nil {
 		return
 	}
-	client, ok := req.ProviderData.(*pkcs11client.Client)
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
 	if !ok {
 		resp.Diagnostics.AddError("Unexpected DataSource Configure Type",
-			fmt.Sprintf("Expected *pkcs11client.Client, got: %T", req.ProviderData))
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
 		return
 	}
-	d.client = client
+	d.clients = clients
 }
 
 func (d *SignatureDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	client, diags := shared.ClientFor(ctx, d.clients, req.Config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	var mechanism types.String
 	var keyLabel types.String
 	var keyClass types.String
 	var mechParam types.String
 	var data types.String
+	var inputFile types.String
+	var keyPin types.String
 
 	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("mechanism"), &mechanism)...)
 	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("key_label"), &keyLabel)...)
 	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("key_class"), &keyClass)...)
 	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("mechanism_parameter"), &mechParam)...)
 	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("data"), &data)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("input_file"), &inputFile)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("key_pin"), &keyPin)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	if data.IsNull() == inputFile.IsNull() {
+		resp.Diagnostics.AddError("Invalid input", "Exactly one of data and input_file must be set.")
+		return
+	}
+
 	// Resolve mechanism
 	mechID, err := pkcs11client.MechanismEnum.Resolve(mechanism.ValueString())
 	if err != nil {
@@ -107,40 +136,56 @@ func (d *SignatureDataSource) Read(ctx context.Context, req datasource.ReadReque
 	}
 
 	// Find key
-	keyHandle, err := d.client.FindObjectByLabelAndClass(keyLabel.ValueString(), classID)
+	keyHandle, err := client.FindKey(ctx, keyLabel.ValueString(), classID)
 	if err != nil {
 		resp.Diagnostics.AddError("Key not found", fmt.Sprintf("Failed to find key with label %q: %s", keyLabel.ValueString(), err))
 		return
 	}
 
-	// Build mechanism
-	var mechParamBytes []byte
-	if !mechParam.IsNull() && !mechParam.IsUnknown() {
-		mechParamBytes, err = pkcs11client.DecodeBase64(mechParam.ValueString())
-		if err != nil {
-			resp.Diagnostics.AddError("Invalid mechanism_parameter", fmt.Sprintf("Failed to decode base64: %s", err))
-			return
-		}
-	}
-	var mech []*pkcs11.Mechanism
-	if mechParamBytes != nil {
-		mech = []*pkcs11.Mechanism{pkcs11.NewMechanism(mechID, mechParamBytes)}
-	} else {
-		mech = []*pkcs11.Mechanism{pkcs11.NewMechanism(mechID, nil)}
+	// Read mechanism parameters
+	mechParams, diags := shared.DataSourceMechanismParams(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
 
-	// Decode data
-	dataBytes, err := pkcs11client.DecodeBase64(data.ValueString())
+	// Build mechanism
+	mech, freeMech, err := pkcs11client.NewMechanism(mechID, mechParams)
 	if err != nil {
-		resp.Diagnostics.AddError("Invalid data", fmt.Sprintf("Failed to decode base64: %s", err))
+		resp.Diagnostics.AddError("Invalid mechanism_parameters", err.Error())
 		return
 	}
+	defer freeMech()
+
+	if !keyPin.IsNull() {
+		ctx = pkcs11client.WithKeyPin(ctx, keyPin.ValueString())
+	}
 
 	// Sign
-	sig, err := d.client.Sign(mech, keyHandle, dataBytes)
-	if err != nil {
-		resp.Diagnostics.AddError("Signing failed", err.Error())
-		return
+	var sig []byte
+	if !data.IsNull() {
+		dataBytes, err := pkcs11client.DecodeBase64(data.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid data", fmt.Sprintf("Failed to decode base64: %s", err))
+			return
+		}
+		sig, err = client.Sign(ctx, mech, keyHandle, dataBytes)
+		if err != nil {
+			resp.Diagnostics.AddError("Signing failed", err.Error())
+			return
+		}
+	} else {
+		f, err := os.Open(inputFile.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid input_file", err.Error())
+			return
+		}
+		defer f.Close()
+		sig, err = client.SignReader(ctx, mech, keyHandle, f)
+		if err != nil {
+			resp.Diagnostics.AddError("Signing failed", err.Error())
+			return
+		}
 	}
 
 	// Set state
@@ -152,6 +197,8 @@ func (d *SignatureDataSource) Read(ctx context.Context, req datasource.ReadReque
 	if !mechParam.IsNull() && !mechParam.IsUnknown() {
 		resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("mechanism_parameter"), mechParam.ValueString())...)
 	}
-	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("data"), data.ValueString())...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("data"), data)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("input_file"), inputFile)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("key_pin"), keyPin)...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("signature"), pkcs11client.EncodeBase64(sig))...)
 }
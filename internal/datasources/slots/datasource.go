@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/types"
@@ -13,12 +14,13 @@ import (
 var _ datasource.DataSource = &SlotsDataSource{}
 
 type SlotsDataSource struct {
-	client *pkcs11client.Client
+	clients *pkcs11client.Clients
 }
 
 type SlotsModel struct {
-	TokenPresent types.Bool  `tfsdk:"token_present"`
-	Slots        []SlotModel `tfsdk:"slots"`
+	TokenPresent types.Bool   `tfsdk:"token_present"`
+	Slots        []SlotModel  `tfsdk:"slots"`
+	TokenName    types.String `tfsdk:"token_name"`
 }
 
 type SlotModel struct {
@@ -42,6 +44,7 @@ func (d *SlotsDataSource) Schema(_ context.Context, _ datasource.SchemaRequest,
 	resp.Schema = schema.Schema{
 		Description: "Lists PKCS#11 slots.",
 		Attributes: map[string]schema.Attribute{
+			"token_name": shared.TokenNameDataSourceSchema(),
 			"token_present": schema.BoolAttribute{
 				Optional:    true,
 				Description: "If true, only return slots with a token present. Default: false.",
@@ -80,16 +83,22 @@ func (d *SlotsDataSource) Configure(_ context.Context, req datasource.ConfigureR
 	if req.ProviderData == nil {
 		return
 	}
-	client, ok := req.ProviderData.(*pkcs11client.Client)
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
 	if !ok {
 		resp.Diagnostics.AddError("Unexpected DataSource Configure Type",
-			fmt.Sprintf("Expected *pkcs11client.Client, got: %T", req.ProviderData))
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
 		return
 	}
-	d.client = client
+	d.clients = clients
 }
 
 func (d *SlotsDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	client, diags := shared.ClientFor(ctx, d.clients, req.Config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	var config SlotsModel
 	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
 	if resp.Diagnostics.HasError() {
@@ -101,7 +110,7 @@ func (d *SlotsDataSource) Read(ctx context.Context, req datasource.ReadRequest,
 		tokenPresent = config.TokenPresent.ValueBool()
 	}
 
-	slots, err := d.client.GetSlotList(tokenPresent)
+	slots, err := client.GetSlotList(ctx, tokenPresent)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to list slots", err.Error())
 		return
@@ -5,15 +5,17 @@ import (
 	"fmt"
 
 	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 )
 
 var _ datasource.DataSource = &TokenInfoDataSource{}
 
 type TokenInfoDataSource struct {
-	client *pkcs11client.Client
+	clients *pkcs11client.Clients
 }
 
 type TokenInfoModel struct {
@@ -33,6 +35,7 @@ type TokenInfoModel struct {
 	FreePrivateMemory  types.Int64  `tfsdk:"free_private_memory"`
 	HardwareVersion    types.String `tfsdk:"hardware_version"`
 	FirmwareVersion    types.String `tfsdk:"firmware_version"`
+	TokenName          types.String `tfsdk:"token_name"`
 }
 
 func NewDataSource() datasource.DataSource {
@@ -47,6 +50,7 @@ func (d *TokenInfoDataSource) Schema(_ context.Context, _ datasource.SchemaReque
 	resp.Schema = schema.Schema{
 		Description: "Reads token information from the configured PKCS#11 slot.",
 		Attributes: map[string]schema.Attribute{
+			"token_name":           shared.TokenNameDataSourceSchema(),
 			"label":                schema.StringAttribute{Computed: true},
 			"manufacturer_id":      schema.StringAttribute{Computed: true},
 			"model":                schema.StringAttribute{Computed: true},
@@ -71,17 +75,23 @@ func (d *TokenInfoDataSource) Configure(_ context.Context, req datasource.Config
 	if req.ProviderData == nil {
 		return
 	}
-	client, ok := req.ProviderData.(*pkcs11client.Client)
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
 	if !ok {
 		resp.Diagnostics.AddError("Unexpected DataSource Configure Type",
-			fmt.Sprintf("Expected *pkcs11client.Client, got: %T", req.ProviderData))
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
 		return
 	}
-	d.client = client
+	d.clients = clients
 }
 
-func (d *TokenInfoDataSource) Read(ctx context.Context, _ datasource.ReadRequest, resp *datasource.ReadResponse) {
-	info, err := d.client.GetTokenInfo()
+func (d *TokenInfoDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	client, diags := shared.ClientFor(ctx, d.clients, req.Config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	info, err := client.GetTokenInfo(ctx)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to get token info", err.Error())
 		return
@@ -106,5 +116,6 @@ func (d *TokenInfoDataSource) Read(ctx context.Context, _ datasource.ReadRequest
 		FirmwareVersion:    types.StringValue(info.FirmwareVersion),
 	}
 
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("token_name"), &state.TokenName)...)
 	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
 }
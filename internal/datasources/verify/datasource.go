@@ -0,0 +1,168 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
+)
+
+var _ datasource.DataSource = &VerifyDataSource{}
+
+type VerifyDataSource struct {
+	clients *pkcs11client.Clients
+}
+
+func NewDataSource() datasource.DataSource {
+	return &VerifyDataSource{}
+}
+
+func (d *VerifyDataSource) Metadata(_ context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_verify"
+}
+
+func (d *VerifyDataSource) Schema(_ context.Context, _ datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Verifies a signature using a key on the PKCS#11 token via C_VerifyInit + C_Verify. " +
+			"An invalid signature does not cause an error but sets valid to false.",
+		Attributes: map[string]schema.Attribute{
+			"token_name": shared.TokenNameDataSourceSchema(),
+			"mechanism": schema.StringAttribute{
+				Required:    true,
+				Description: "PKCS#11 mechanism name (e.g. CKM_SHA256_RSA_PKCS, CKM_ECDSA, CKM_SHA256_HMAC). Accepts CKM_ prefix or without.",
+			},
+			"key_label": schema.StringAttribute{
+				Required:    true,
+				Description: "Label or PKCS#11 URI of the verification key on the token.",
+			},
+			"key_class": schema.StringAttribute{
+				Optional:    true,
+				Description: "Object class of the key (e.g. CKO_PUBLIC_KEY, CKO_SECRET_KEY). Defaults to CKO_PUBLIC_KEY.",
+			},
+			"mechanism_parameters": shared.MechanismParamsDataSourceSchema(),
+			"data": schema.StringAttribute{
+				Required:    true,
+				Description: "Base64-encoded data that was signed.",
+			},
+			"signature": schema.StringAttribute{
+				Required:    true,
+				Description: "Base64-encoded signature to verify.",
+			},
+			"valid": schema.BoolAttribute{
+				Computed:    true,
+				Description: "Whether the signature is valid for the given data and key.",
+			},
+		},
+	}
+}
+
+func (d *VerifyDataSource) Configure(_ context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected DataSource Configure Type",
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
+		return
+	}
+	d.clients = clients
+}
+
+func (d *VerifyDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	client, diags := shared.ClientFor(ctx, d.clients, req.Config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var mechanism types.String
+	var keyLabel types.String
+	var keyClass types.String
+	var data types.String
+	var signature types.String
+
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("mechanism"), &mechanism)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("key_label"), &keyLabel)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("key_class"), &keyClass)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("data"), &data)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("signature"), &signature)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Resolve mechanism
+	mechID, err := pkcs11client.MechanismEnum.Resolve(mechanism.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid mechanism", err.Error())
+		return
+	}
+
+	// Resolve key class — default to CKO_PUBLIC_KEY for verification
+	classID := pkcs11client.ObjectClassNameToID["CKO_PUBLIC_KEY"]
+	if !keyClass.IsNull() && !keyClass.IsUnknown() {
+		classEnum := pkcs11client.AttributeNameToDef["class"].Pkcs11Enum
+		classID, err = classEnum.Resolve(keyClass.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid key_class", err.Error())
+			return
+		}
+	}
+
+	// Find key
+	keyHandle, err := client.FindKey(ctx, keyLabel.ValueString(), classID)
+	if err != nil {
+		resp.Diagnostics.AddError("Key not found", fmt.Sprintf("Failed to find key with label %q: %s", keyLabel.ValueString(), err))
+		return
+	}
+
+	// Read mechanism parameters
+	mechParams, diags := shared.MechanismParamsFrom(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Decode data and signature
+	dataBytes, err := pkcs11client.DecodeBase64(data.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid data", fmt.Sprintf("Failed to decode base64: %s", err))
+		return
+	}
+	sigBytes, err := pkcs11client.DecodeBase64(signature.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid signature", fmt.Sprintf("Failed to decode base64: %s", err))
+		return
+	}
+
+	// Build mechanism
+	mech, freeMech, err := pkcs11client.NewMechanism(mechID, mechParams)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid mechanism_parameters", err.Error())
+		return
+	}
+	defer freeMech()
+
+	// Verify
+	valid, err := client.Verify(ctx, mech, keyHandle, dataBytes, sigBytes)
+	if err != nil {
+		resp.Diagnostics.AddError("Verification failed", err.Error())
+		return
+	}
+
+	// Set state
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("mechanism"), mechanism.ValueString())...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("key_label"), keyLabel.ValueString())...)
+	if !keyClass.IsNull() && !keyClass.IsUnknown() {
+		resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("key_class"), keyClass.ValueString())...)
+	}
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("data"), data.ValueString())...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("signature"), signature.ValueString())...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("valid"), valid)...)
+}
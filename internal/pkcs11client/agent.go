@@ -0,0 +1,643 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// AgentCommand is the first argument of the provider executable when it runs
+// as agent, which serves a module to providers on other hosts.
+const AgentCommand = "pkcs11-agent"
+
+// agentDialTimeout is how long connecting to an agent may take.
+const agentDialTimeout = 30 * time.Second
+
+// ErrAgentUnavailable is returned for operations that were running while the
+// connection to the agent was lost, or that could not connect to the agent.
+// The next operation connects again.
+var ErrAgentUnavailable = errors.New("pkcs11: agent unavailable")
+
+// AgentConfig configures the connection to an agent.
+type AgentConfig struct {
+	// Address is "unix:" followed by the path of a Unix socket, or the
+	// host:port of an agent listening with TLS.
+	Address string
+	// CAFile holds the PEM certificates that verify the certificate of the
+	// agent. If empty, the system certificates are used.
+	CAFile string
+	// CertFile and KeyFile hold the PEM client certificate and key that
+	// authenticate the provider to an agent listening with TLS.
+	CertFile string
+	KeyFile  string
+}
+
+// dialAgent returns a dialer that connects to the agent of cfg.
+func dialAgent(cfg AgentConfig) (hostDialer, error) {
+	noWait := func() error { return nil }
+	if socket, ok := strings.CutPrefix(cfg.Address, "unix:"); ok {
+		return func() (io.ReadWriteCloser, func() error, error) {
+			conn, err := net.DialTimeout("unix", socket, agentDialTimeout)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %w", ErrAgentUnavailable, err)
+			}
+			return conn, noWait, nil
+		}, nil
+	}
+
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("pkcs11: a client certificate and key are required for agents listening with TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: loading the agent client certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+	}
+	if cfg.CAFile != "" {
+		if tlsConfig.RootCAs, err = LoadCertPool(cfg.CAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return func() (io.ReadWriteCloser, func() error, error) {
+		dialer := &net.Dialer{Timeout: agentDialTimeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", cfg.Address, tlsConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrAgentUnavailable, err)
+		}
+		return conn, noWait, nil
+	}, nil
+}
+
+// LoadCertPool returns a certificate pool with the PEM certificates of file.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: reading certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("pkcs11: no PEM certificates in %s", file)
+	}
+	return pool, nil
+}
+
+// Operations that an AgentPolicy can allow.
+const (
+	AgentOpCreateObject  = "create_object"
+	AgentOpDestroyObject = "destroy_object"
+	AgentOpSetAttributes = "set_attributes"
+	AgentOpGenerateKey   = "generate_key"
+	AgentOpWrapKey       = "wrap_key"
+	AgentOpUnwrapKey     = "unwrap_key"
+	AgentOpDeriveKey     = "derive_key"
+	AgentOpEncrypt       = "encrypt"
+	AgentOpDecrypt       = "decrypt"
+	AgentOpSign          = "sign"
+	AgentOpVerify        = "verify"
+	AgentOpDigest        = "digest"
+	AgentOpRandom        = "random"
+	AgentOpInitToken     = "init_token"
+	AgentOpInitPIN       = "init_pin"
+	AgentOpSetPIN        = "set_pin"
+	AgentOpLoginSO       = "login_so"
+)
+
+// AgentOperations lists the operations that an AgentPolicy can allow.
+var AgentOperations = []string{
+	AgentOpCreateObject, AgentOpDestroyObject, AgentOpSetAttributes, AgentOpGenerateKey,
+	AgentOpWrapKey, AgentOpUnwrapKey, AgentOpDeriveKey, AgentOpEncrypt, AgentOpDecrypt,
+	AgentOpSign, AgentOpVerify, AgentOpDigest, AgentOpRandom, AgentOpInitToken,
+	AgentOpInitPIN, AgentOpSetPIN, AgentOpLoginSO,
+}
+
+// AgentPolicy restricts what the clients of an agent may do with the token.
+// Reading slot, token and mechanism information, opening sessions, logging
+// in as user, finding objects and reading their attributes are always
+// allowed, subject to KeyLabels.
+type AgentPolicy struct {
+	// Operations lists the allowed operations, see AgentOperations.
+	Operations []string `json:"operations"`
+	// KeyLabels lists path.Match patterns, e.g. "ci-*", of the labels of the
+	// objects that clients may find, use and create. Objects with other
+	// labels are invisible to clients. If empty, all objects are allowed.
+	KeyLabels []string `json:"key_labels"`
+}
+
+// LoadAgentPolicy reads an AgentPolicy from a JSON file.
+func LoadAgentPolicy(file string) (AgentPolicy, error) {
+	var policy AgentPolicy
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return policy, fmt.Errorf("pkcs11: reading agent policy: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policy); err != nil {
+		return policy, fmt.Errorf("pkcs11: parsing agent policy %s: %w", file, err)
+	}
+	return policy, policy.validate()
+}
+
+func (p AgentPolicy) validate() error {
+	for _, op := range p.Operations {
+		if !slices.Contains(AgentOperations, op) {
+			return fmt.Errorf("pkcs11: unknown agent operation %q, expected one of %s", op, strings.Join(AgentOperations, ", "))
+		}
+	}
+	for _, pattern := range p.KeyLabels {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("pkcs11: invalid key label pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// allowsLabel reports whether objects with label are allowed.
+func (p AgentPolicy) allowsLabel(label string) bool {
+	if len(p.KeyLabels) == 0 {
+		return true
+	}
+	for _, pattern := range p.KeyLabels {
+		if ok, _ := path.Match(pattern, label); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Kinds of templates checked by an agent.
+const (
+	templateNone   = iota
+	templateCreate // creates an object, the label must be set and allowed
+	templateChange // may change the label, which must then be allowed
+)
+
+// agentMethod describes how an agent authorizes a Pkcs11Context method.
+type agentMethod struct {
+	operation string // the operation that must be allowed, empty if always allowed
+	session   bool   // operates on a session of the client
+	object    bool   // the label of Object must be allowed
+	key       bool   // the label of Key must be allowed
+	template  int    // kind of Template and Template2
+}
+
+var agentMethods = map[string]agentMethod{
+	"Initialize":        {},
+	"Finalize":          {},
+	"GetSlotList":       {},
+	"GetSlotInfo":       {},
+	"GetTokenInfo":      {},
+	"GetMechanismList":  {},
+	"GetMechanismInfo":  {},
+	"OpenSession":       {},
+	"InitToken":         {operation: AgentOpInitToken},
+	"CloseSession":      {session: true},
+	"GetSessionInfo":    {session: true},
+	"Login":             {session: true},
+	"Logout":            {session: true},
+	"InitPIN":           {operation: AgentOpInitPIN, session: true},
+	"SetPIN":            {operation: AgentOpSetPIN, session: true},
+	"CreateObject":      {operation: AgentOpCreateObject, session: true, template: templateCreate},
+	"CopyObject":        {operation: AgentOpCreateObject, session: true, object: true, template: templateChange},
+	"DestroyObject":     {operation: AgentOpDestroyObject, session: true, object: true},
+	"FindObjectsInit":   {session: true},
+	"FindObjects":       {session: true},
+	"FindObjectsFinal":  {session: true},
+	"GetAttributeValue": {session: true, object: true},
+	"SetAttributeValue": {operation: AgentOpSetAttributes, session: true, object: true, template: templateChange},
+	"GenerateKeyPair":   {operation: AgentOpGenerateKey, session: true, template: templateCreate},
+	"GenerateKey":       {operation: AgentOpGenerateKey, session: true, template: templateCreate},
+	"WrapKey":           {operation: AgentOpWrapKey, session: true, object: true, key: true},
+	"UnwrapKey":         {operation: AgentOpUnwrapKey, session: true, key: true, template: templateCreate},
+	"DeriveKey":         {operation: AgentOpDeriveKey, session: true, key: true, template: templateCreate},
+	"EncryptInit":       {operation: AgentOpEncrypt, session: true, key: true},
+	"Encrypt":           {operation: AgentOpEncrypt, session: true},
+	"EncryptUpdate":     {operation: AgentOpEncrypt, session: true},
+	"EncryptFinal":      {operation: AgentOpEncrypt, session: true},
+	"DecryptInit":       {operation: AgentOpDecrypt, session: true, key: true},
+	"Decrypt":           {operation: AgentOpDecrypt, session: true},
+	"DecryptUpdate":     {operation: AgentOpDecrypt, session: true},
+	"DecryptFinal":      {operation: AgentOpDecrypt, session: true},
+	"SignInit":          {operation: AgentOpSign, session: true, key: true},
+	"Sign":              {operation: AgentOpSign, session: true},
+	"SignUpdate":        {operation: AgentOpSign, session: true},
+	"SignFinal":         {operation: AgentOpSign, session: true},
+	"VerifyInit":        {operation: AgentOpVerify, session: true, key: true},
+	"Verify":            {operation: AgentOpVerify, session: true},
+	"DigestInit":        {operation: AgentOpDigest, session: true},
+	"Digest":            {operation: AgentOpDigest, session: true},
+	"DigestUpdate":      {operation: AgentOpDigest, session: true},
+	"DigestKey":         {operation: AgentOpDigest, session: true, key: true},
+	"DigestFinal":       {operation: AgentOpDigest, session: true},
+	"SeedRandom":        {operation: AgentOpRandom, session: true},
+	"GenerateRandom":    {operation: AgentOpRandom, session: true},
+}
+
+// Agent serves an initialized module to the providers that connect to it.
+// The module stays initialized while the agent runs; clients only see and
+// use their own sessions, which are closed when they disconnect, and are
+// restricted by the policy of the agent. The login state is shared by all
+// clients, as by all applications using a token through one process, so the
+// agent should only accept authenticated connections.
+type Agent struct {
+	ctx     Pkcs11Context
+	policy  AgentPolicy
+	allowed map[string]bool
+
+	// Log receives connection and authorization events if not nil.
+	Log *log.Logger
+}
+
+// OpenAgent loads and initializes the module at modulePath for serving it
+// with policy. Close finalizes the module.
+func OpenAgent(modulePath string, policy AgentPolicy) (*Agent, error) {
+	ctx, err := loadModule(modulePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		unloadModule(ctx)
+		return nil, wrapError("Initialize", err)
+	}
+	return newAgent(ctx, policy)
+}
+
+// ServeAgent runs the agent command with the command line arguments args. It
+// serves a module until the process receives SIGINT or SIGTERM.
+func ServeAgent(args []string) error {
+	flags := flag.NewFlagSet(AgentCommand, flag.ContinueOnError)
+	modulePath := flags.String("module", os.Getenv("PKCS11_MODULE_PATH"), "path of the PKCS#11 module to serve")
+	listen := flags.String("listen", "", `address to listen on: "unix:" followed by a socket path, or host:port for TLS`)
+	policyFile := flags.String("policy", "", "JSON file with the allowed operations and key labels")
+	certFile := flags.String("tls-cert", "", "PEM certificate of the agent")
+	keyFile := flags.String("tls-key", "", "PEM key of the agent")
+	clientCAFile := flags.String("tls-client-ca", "", "PEM certificates that verify the client certificates")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *modulePath == "" || *listen == "" || *policyFile == "" {
+		return errors.New("pkcs11: -module, -listen and -policy are required")
+	}
+
+	policy, err := LoadAgentPolicy(*policyFile)
+	if err != nil {
+		return err
+	}
+	l, err := agentListener(*listen, *certFile, *keyFile, *clientCAFile)
+	if err != nil {
+		return err
+	}
+	agent, err := OpenAgent(*modulePath, policy)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	agent.Log = log.Default()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		l.Close()
+	}()
+	agent.logf("serving %s on %s", *modulePath, *listen)
+	err = agent.Serve(l)
+	return errors.Join(err, agent.Close())
+}
+
+// agentListener listens on a Unix socket only accessible by the user, or
+// with TLS requiring client certificates issued by the CAs in clientCAFile.
+func agentListener(address, certFile, keyFile, clientCAFile string) (net.Listener, error) {
+	if socket, ok := strings.CutPrefix(address, "unix:"); ok {
+		// The socket is created with the permissions of the umask
+		oldMask := syscall.Umask(0o077)
+		defer syscall.Umask(oldMask)
+		return net.Listen("unix", socket)
+	}
+
+	if certFile == "" || keyFile == "" || clientCAFile == "" {
+		return nil, errors.New("pkcs11: -tls-cert, -tls-key and -tls-client-ca are required for TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: loading the agent certificate: %w", err)
+	}
+	clientCAs, err := LoadCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", address, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS13,
+	})
+}
+
+// newAgent creates an agent for an initialized module.
+func newAgent(ctx Pkcs11Context, policy AgentPolicy) (*Agent, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool, len(policy.Operations))
+	for _, op := range policy.Operations {
+		allowed[op] = true
+	}
+	return &Agent{ctx: ctx, policy: policy, allowed: allowed}, nil
+}
+
+// Close finalizes the module.
+func (a *Agent) Close() error {
+	return wrapError("Finalize", a.ctx.Finalize())
+}
+
+// Serve serves the connections accepted by l until l is closed.
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+func (a *Agent) logf(format string, args ...any) {
+	if a.Log != nil {
+		a.Log.Printf(format, args...)
+	}
+}
+
+// serveConn serves a client until it disconnects and closes its sessions.
+func (a *Agent) serveConn(conn net.Conn) {
+	client := conn.RemoteAddr().String()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			a.logf("rejected connection from %s: %v", client, err)
+			conn.Close()
+			return
+		}
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			client = fmt.Sprintf("%s (%s)", certs[0].Subject.CommonName, client)
+		}
+	}
+	a.logf("accepted connection from %s", client)
+
+	c := &agentConn{agent: a, client: client, sessions: make(map[pkcs11.SessionHandle]bool)}
+	if err := serveModule(conn, c.handle); err != nil {
+		a.logf("connection from %s failed: %v", client, err)
+	}
+	c.close()
+	a.logf("closed connection from %s", client)
+}
+
+// agentConn is the state of a client connection.
+type agentConn struct {
+	agent    *Agent
+	client   string
+	mu       sync.Mutex // guards the fields below
+	sessions map[pkcs11.SessionHandle]bool
+	closed   bool
+}
+
+// handle authorizes and performs a call of the client.
+func (c *agentConn) handle(req hostRequest) hostResponse {
+	m, ok := agentMethods[req.Method]
+	if !ok {
+		return hostResponse{Err: fmt.Sprintf("pkcs11 agent: unknown method %q", req.Method)}
+	}
+	switch req.Method {
+	case "Initialize":
+		// The agent initialized the module for all clients
+		return hostResponse{}
+	case "Finalize":
+		c.closeSessions()
+		return hostResponse{}
+	}
+
+	if m.session && !c.owns(req.Session) {
+		return hostResponse{Code: pkcs11.CKR_SESSION_HANDLE_INVALID}
+	}
+	if err := c.authorize(req, m); err != nil {
+		c.agent.logf("denied %s to %s: %v", req.Method, c.client, err)
+		return hostResponse{Err: err.Error()}
+	}
+
+	resp := handleHostRequest(c.agent.ctx, req)
+	if resp.Code != pkcs11.CKR_OK || resp.Err != "" {
+		return resp
+	}
+	switch req.Method {
+	case "OpenSession":
+		c.addSession(resp.Session)
+	case "CloseSession":
+		c.mu.Lock()
+		delete(c.sessions, req.Session)
+		c.mu.Unlock()
+	case "FindObjects":
+		resp.Objects = slices.DeleteFunc(resp.Objects, func(oh pkcs11.ObjectHandle) bool {
+			return c.checkObject(req.Session, oh) != nil
+		})
+	}
+	return resp
+}
+
+// authorize checks req against the policy of the agent.
+func (c *agentConn) authorize(req hostRequest, m agentMethod) error {
+	op := m.operation
+	if req.Method == "Login" && req.Flags == pkcs11.CKU_SO {
+		op = AgentOpLoginSO
+	}
+	if op != "" && !c.agent.allowed[op] {
+		return fmt.Errorf("pkcs11 agent: operation %s is not allowed", op)
+	}
+	for _, mech := range req.Mechanism {
+		if err := checkAgentMechanism(mech); err != nil {
+			return err
+		}
+	}
+	if len(c.agent.policy.KeyLabels) == 0 {
+		return nil
+	}
+
+	if m.object {
+		if err := c.checkObject(req.Session, req.Object); err != nil {
+			return err
+		}
+	}
+	if m.key {
+		if err := c.checkObject(req.Session, req.Key); err != nil {
+			return err
+		}
+	}
+	for _, mech := range req.Mechanism {
+		switch {
+		case mech.Params != nil && mech.Params.Key != nil:
+			if err := c.checkObject(req.Session, mech.Params.Key.Handle); err != nil {
+				return err
+			}
+		case mech.ID == pkcs11.CKM_CONCATENATE_BASE_AND_KEY:
+			return errors.New("pkcs11 agent: the key of the mechanism parameters cannot be checked")
+		}
+	}
+	templates := [][]*pkcs11.Attribute{req.Template}
+	if req.Method == "GenerateKeyPair" {
+		templates = append(templates, req.Template2)
+	}
+	for _, template := range templates {
+		if err := c.checkTemplate(m.template, template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// agentRawMechanisms are the mechanisms whose parameter is an IV, a CK_ULONG
+// or another structure without pointers. The agent only passes parameters
+// that were encoded by the client to the module for these mechanisms; the
+// module would follow the pointers in other parameters into the memory of
+// the agent.
+var agentRawMechanisms = map[uint]bool{
+	pkcs11.CKM_AES_CBC:                  true,
+	pkcs11.CKM_AES_CBC_PAD:              true,
+	pkcs11.CKM_AES_OFB:                  true,
+	pkcs11.CKM_AES_CFB8:                 true,
+	pkcs11.CKM_AES_CFB64:                true,
+	pkcs11.CKM_AES_CFB128:               true,
+	pkcs11.CKM_AES_CTS:                  true,
+	pkcs11.CKM_AES_CTR:                  true,
+	pkcs11.CKM_AES_KEY_WRAP:             true,
+	pkcs11.CKM_AES_KEY_WRAP_PAD:         true,
+	pkcs11.CKM_DES_CBC:                  true,
+	pkcs11.CKM_DES_CBC_PAD:              true,
+	pkcs11.CKM_DES3_CBC:                 true,
+	pkcs11.CKM_DES3_CBC_PAD:             true,
+	pkcs11.CKM_AES_MAC_GENERAL:          true,
+	pkcs11.CKM_AES_CMAC_GENERAL:         true,
+	pkcs11.CKM_DES3_MAC_GENERAL:         true,
+	pkcs11.CKM_SHA_1_HMAC_GENERAL:       true,
+	pkcs11.CKM_SHA224_HMAC_GENERAL:      true,
+	pkcs11.CKM_SHA256_HMAC_GENERAL:      true,
+	pkcs11.CKM_SHA384_HMAC_GENERAL:      true,
+	pkcs11.CKM_SHA512_HMAC_GENERAL:      true,
+	pkcs11.CKM_RSA_PKCS_PSS:             true,
+	pkcs11.CKM_SHA1_RSA_PKCS_PSS:        true,
+	pkcs11.CKM_SHA224_RSA_PKCS_PSS:      true,
+	pkcs11.CKM_SHA256_RSA_PKCS_PSS:      true,
+	pkcs11.CKM_SHA384_RSA_PKCS_PSS:      true,
+	pkcs11.CKM_SHA512_RSA_PKCS_PSS:      true,
+	pkcs11.CKM_CONCATENATE_BASE_AND_KEY: true,
+}
+
+// checkAgentMechanism returns an error if mech has a parameter encoded by
+// the client, either raw or as IV, for a mechanism that is not in
+// agentRawMechanisms. Other typed parameters are encoded by the agent.
+func checkAgentMechanism(mech hostMechanism) error {
+	raw := mech.Parameter
+	if mech.Params != nil {
+		raw = mech.Params.Raw
+		if raw == nil {
+			raw = mech.Params.IV
+		}
+	}
+	if len(raw) == 0 || agentRawMechanisms[mech.ID] {
+		return nil
+	}
+	return fmt.Errorf("pkcs11 agent: %s requires typed mechanism parameters", MechanismEnum.Format(mech.ID))
+}
+
+// checkObject returns an error unless the label of the object is allowed.
+func (c *agentConn) checkObject(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle) error {
+	attrs, err := c.agent.ctx.GetAttributeValue(sh, oh, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+	})
+	if err != nil || len(attrs) == 0 {
+		return fmt.Errorf("pkcs11 agent: object %d not found", oh)
+	}
+	if label := string(attrs[0].Value); !c.agent.policy.allowsLabel(label) {
+		return fmt.Errorf("pkcs11 agent: object label %q is not allowed", label)
+	}
+	return nil
+}
+
+// checkTemplate returns an error unless the label set by a template of the
+// given kind is allowed.
+func (c *agentConn) checkTemplate(kind int, template []*pkcs11.Attribute) error {
+	if kind == templateNone || (kind == templateChange && template == nil) {
+		return nil
+	}
+	for _, attr := range template {
+		if attr.Type != pkcs11.CKA_LABEL {
+			continue
+		}
+		if label := string(attr.Value); !c.agent.policy.allowsLabel(label) {
+			return fmt.Errorf("pkcs11 agent: object label %q is not allowed", label)
+		}
+		return nil
+	}
+	if kind == templateCreate {
+		return errors.New("pkcs11 agent: objects must have a label")
+	}
+	return nil
+}
+
+// owns reports whether sh is a session of the client.
+func (c *agentConn) owns(sh pkcs11.SessionHandle) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessions[sh]
+}
+
+// addSession registers a session opened by the client. Sessions opened after
+// the client disconnected are closed right away.
+func (c *agentConn) addSession(sh pkcs11.SessionHandle) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		c.agent.ctx.CloseSession(sh)
+		return
+	}
+	c.sessions[sh] = true
+}
+
+// closeSessions closes the sessions of the client.
+func (c *agentConn) closeSessions() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for sh := range c.sessions {
+		c.agent.ctx.CloseSession(sh)
+		delete(c.sessions, sh)
+	}
+}
+
+// close closes the sessions of a disconnected client.
+func (c *agentConn) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.closeSessions()
+}
@@ -0,0 +1,334 @@
+package pkcs11client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// startTestAgent serves a mock token with policy on a Unix socket and returns
+// the mock and the agent address.
+func startTestAgent(t *testing.T, policy AgentPolicy) (*MockContext, string) {
+	t.Helper()
+	mock := NewMockContext("agent-token")
+	if err := mock.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	agent, err := newAgent(mock, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go agent.Serve(l)
+	t.Cleanup(func() { l.Close() })
+	return mock, "unix:" + socket
+}
+
+func newAgentTestClients(t *testing.T, agent AgentConfig) *Clients {
+	t.Helper()
+	clients, err := newClients(newModuleRegistry(nil), map[string]Config{
+		DefaultToken: {TokenLabel: "agent-token", Pin: "1234", PoolSize: 2, Agent: &agent},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(clients.Close)
+	return clients
+}
+
+func TestAgent_Policy(t *testing.T) {
+	mock, address := startTestAgent(t, AgentPolicy{
+		Operations: []string{AgentOpCreateObject, AgentOpRandom},
+		KeyLabels:  []string{"ci-*"},
+	})
+	sh, _ := mock.OpenSession(0, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if _, err := mock.CreateObject(sh, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "prod-data"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	mock.CloseSession(sh)
+	client, _ := newAgentTestClients(t, AgentConfig{Address: address}).Get(DefaultToken)
+	ctx := t.Context()
+
+	if _, err := client.GenerateRandom(ctx, 8); err != nil {
+		t.Fatalf("GenerateRandom failed: %v", err)
+	}
+	handle, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "ci-data"),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	// Objects with other labels can neither be created nor found
+	_, err = client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "prod-data"),
+	})
+	if err == nil || !strings.Contains(err.Error(), `object label "prod-data" is not allowed`) {
+		t.Errorf("expected label to be denied, got: %v", err)
+	}
+	_, err = client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+	})
+	if err == nil || !strings.Contains(err.Error(), "must have a label") {
+		t.Errorf("expected object without label to be denied, got: %v", err)
+	}
+	found, err := client.FindObjects(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+	}, 10)
+	if err != nil {
+		t.Fatalf("FindObjects failed: %v", err)
+	}
+	if len(found) != 1 || found[0] != handle {
+		t.Errorf("expected to find only %d, got %v", handle, found)
+	}
+
+	// Operations that are not allowed are denied
+	err = client.DestroyObject(ctx, handle)
+	if err == nil || !strings.Contains(err.Error(), "operation destroy_object is not allowed") {
+		t.Errorf("expected destroy_object to be denied, got: %v", err)
+	}
+	mock.mu.Lock()
+	defer mock.mu.Unlock()
+	if len(mock.objects) != 2 {
+		t.Errorf("expected 2 objects on the token, got %d", len(mock.objects))
+	}
+}
+
+func TestAgent_RawMechanismParameters(t *testing.T) {
+	mock, address := startTestAgent(t, AgentPolicy{Operations: []string{AgentOpEncrypt}})
+	sh, _ := mock.OpenSession(0, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	key, err := mock.CreateObject(sh, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "key"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.CloseSession(sh)
+	client, _ := newAgentTestClients(t, AgentConfig{Address: address}).Get(DefaultToken)
+	ctx := t.Context()
+
+	// CK_GCM_PARAMS holds pointers, which the module would follow into the
+	// memory of the agent
+	for _, params := range []*MechanismParams{
+		{Raw: make([]byte, 48)},
+		{IV: make([]byte, 48)},
+	} {
+		mech, free, err := NewMechanism(pkcs11.CKM_AES_GCM, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Encrypt(ctx, mech, key, []byte("data"))
+		free()
+		if err == nil || !strings.Contains(err.Error(), "CKM_AES_GCM requires typed mechanism parameters") {
+			t.Errorf("expected raw GCM parameters to be denied, got: %v", err)
+		}
+	}
+	_, err = client.Encrypt(ctx, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, make([]byte, 48))}, key, []byte("data"))
+	if err == nil || !strings.Contains(err.Error(), "requires typed mechanism parameters") {
+		t.Errorf("expected raw GCM parameter to be denied, got: %v", err)
+	}
+
+	// IVs and typed parameters are passed on
+	for _, m := range []struct {
+		id     uint
+		params *MechanismParams
+	}{
+		{pkcs11.CKM_AES_CBC_PAD, &MechanismParams{IV: make([]byte, 16)}},
+		{pkcs11.CKM_AES_GCM, &MechanismParams{GCM: &GCMParams{IV: make([]byte, 12)}}},
+	} {
+		mech, free, err := NewMechanism(m.id, m.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Encrypt(ctx, mech, key, []byte("data"))
+		free()
+		if err != nil && strings.Contains(err.Error(), "requires typed mechanism parameters") {
+			t.Errorf("expected %s parameters to be passed on, got: %v", MechanismEnum.Format(m.id), err)
+		}
+	}
+}
+
+func TestAgent_Sessions(t *testing.T) {
+	mock, address := startTestAgent(t, AgentPolicy{})
+	dial, err := dialAgent(AgentConfig{Address: address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := newHostContext(dial, ErrAgentUnavailable)
+	second := newHostContext(dial, ErrAgentUnavailable)
+	for _, h := range []*hostContext{first, second} {
+		if err := h.Initialize(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer second.Finalize()
+
+	sh, err := first.OpenSession(0, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.GetSessionInfo(sh); err != nil {
+		t.Errorf("GetSessionInfo failed: %v", err)
+	}
+	// Clients cannot use the sessions of other clients
+	if _, err := second.GetSessionInfo(sh); !errors.Is(err, pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)) {
+		t.Errorf("expected CKR_SESSION_HANDLE_INVALID, got: %v", err)
+	}
+
+	// Finalizing closes the sessions of the client but keeps the module initialized
+	if err := first.Finalize(); err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+	if _, err := second.GetSlotList(true); err != nil {
+		t.Errorf("GetSlotList failed after other client finalized: %v", err)
+	}
+	if mock.OpenSessionCount() != 0 {
+		t.Errorf("expected sessions to be closed, got %d", mock.OpenSessionCount())
+	}
+}
+
+func TestAgent_Unavailable(t *testing.T) {
+	dial, err := dialAgent(AgentConfig{Address: "unix:" + filepath.Join(t.TempDir(), "missing.sock")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = newHostContext(dial, ErrAgentUnavailable).Initialize()
+	if !errors.Is(err, ErrAgentUnavailable) || !DefaultRetryPolicy().retryable(err) {
+		t.Errorf("expected retryable ErrAgentUnavailable, got: %v", err)
+	}
+
+	if _, err := dialAgent(AgentConfig{Address: "agent.example.com:8443"}); err == nil {
+		t.Errorf("expected error for TLS without client certificate")
+	}
+}
+
+// writeTestCert writes a certificate and key signed by parent, or a
+// self-signed CA if parent is nil, and returns them with the file paths.
+func writeTestCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return cert, key, certFile, keyFile
+}
+
+func TestAgent_MutualTLS(t *testing.T) {
+	ca, caKey, caFile, _ := writeTestCert(t, "ca", nil, nil)
+	_, _, serverCert, serverKey := writeTestCert(t, "agent", ca, caKey)
+	_, _, clientCert, clientKey := writeTestCert(t, "runner", ca, caKey)
+	otherCA, otherKey, _, _ := writeTestCert(t, "other-ca", nil, nil)
+	_, _, rogueCert, rogueKey := writeTestCert(t, "rogue", otherCA, otherKey)
+
+	mock := NewMockContext("agent-token")
+	mock.Initialize()
+	agent, err := newAgent(mock, AgentPolicy{Operations: []string{AgentOpRandom}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := agentListener("127.0.0.1:0", serverCert, serverKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go agent.Serve(l)
+
+	client, _ := newAgentTestClients(t, AgentConfig{
+		Address:  l.Addr().String(),
+		CAFile:   caFile,
+		CertFile: clientCert,
+		KeyFile:  clientKey,
+	}).Get(DefaultToken)
+	if _, err := client.GenerateRandom(t.Context(), 8); err != nil {
+		t.Fatalf("GenerateRandom failed: %v", err)
+	}
+
+	// Clients with certificates of other CAs are rejected
+	dial, err := dialAgent(AgentConfig{Address: l.Addr().String(), CAFile: caFile, CertFile: rogueCert, KeyFile: rogueKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newHostContext(dial, ErrAgentUnavailable).Initialize(); !errors.Is(err, ErrAgentUnavailable) {
+		t.Errorf("expected connection with untrusted certificate to fail, got: %v", err)
+	}
+}
+
+func TestLoadAgentPolicy(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		file := filepath.Join(dir, "policy.json")
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	policy, err := LoadAgentPolicy(write(`{"operations": ["sign", "verify"], "key_labels": ["ci-*"]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(policy.Operations) != 2 || !policy.allowsLabel("ci-signing") || policy.allowsLabel("prod-signing") {
+		t.Errorf("unexpected policy %+v", policy)
+	}
+
+	for _, content := range []string{
+		`{"operations": ["export"]}`,
+		`{"key_labels": ["["]}`,
+		`{"labels": ["ci-*"]}`,
+	} {
+		if _, err := LoadAgentPolicy(write(content)); err == nil {
+			t.Errorf("expected error for %s", content)
+		}
+	}
+}
@@ -626,8 +626,17 @@ var MechanismNameToID = map[string]uint{
 	"CKM_AES_CFB1":                       pkcs11.CKM_AES_CFB1,
 	"CKM_AES_KEY_WRAP":                   pkcs11.CKM_AES_KEY_WRAP,
 	"CKM_AES_KEY_WRAP_PAD":               pkcs11.CKM_AES_KEY_WRAP_PAD,
+	"CKM_AES_KEY_WRAP_KWP":               0x0000210B,
 	"CKM_RSA_PKCS_TPM_1_1":               pkcs11.CKM_RSA_PKCS_TPM_1_1,
 	"CKM_RSA_PKCS_OAEP_TPM_1_1":          pkcs11.CKM_RSA_PKCS_OAEP_TPM_1_1,
+	"CKM_SP800_108_COUNTER_KDF":          0x000003AC,
+	"CKM_SP800_108_FEEDBACK_KDF":         0x000003AD,
+	"CKM_SP800_108_DOUBLE_PIPELINE_KDF":  0x000003AE,
+	"CKM_HKDF_DERIVE":                    0x0000402A,
+	"CKM_HKDF_DATA":                      0x0000402B,
+	"CKM_HKDF_KEY_GEN":                   0x0000402C,
+	"CKM_EC_EDWARDS_KEY_PAIR_GEN":        0x00001055,
+	"CKM_EDDSA":                          0x00001057,
 	"CKM_VENDOR_DEFINED":                 pkcs11.CKM_VENDOR_DEFINED,
 	"CKM_YUBICO_AES_CCM_WRAP":            0xD9554204,
 }
@@ -692,6 +701,8 @@ var KeyTypeNameToID = map[string]uint{
 	"CKK_SHA3_256_HMAC":  pkcs11.CKK_SHA3_256_HMAC,
 	"CKK_SHA3_384_HMAC":  pkcs11.CKK_SHA3_384_HMAC,
 	"CKK_SHA3_512_HMAC":  pkcs11.CKK_SHA3_512_HMAC,
+	"CKK_EC_EDWARDS":               0x00000040,
+	"CKK_HKDF":                     0x00000042,
 	"CKK_VENDOR_DEFINED":           pkcs11.CKK_VENDOR_DEFINED,
 	"CKK_YUBICO_AES128_CCM_WRAP":   0xD955421D,
 	"CKK_YUBICO_AES192_CCM_WRAP":   0xD9554229,
@@ -752,3 +763,48 @@ func init() {
 		CertTypeIDToName[id] = name
 	}
 }
+
+// MGFNameToID maps mask generation function names to CKG_* constants.
+var MGFNameToID = map[string]uint{
+	"CKG_MGF1_SHA1":     pkcs11.CKG_MGF1_SHA1,
+	"CKG_MGF1_SHA224":   pkcs11.CKG_MGF1_SHA224,
+	"CKG_MGF1_SHA256":   pkcs11.CKG_MGF1_SHA256,
+	"CKG_MGF1_SHA384":   pkcs11.CKG_MGF1_SHA384,
+	"CKG_MGF1_SHA512":   pkcs11.CKG_MGF1_SHA512,
+	"CKG_MGF1_SHA3_224": 0x00000006,
+	"CKG_MGF1_SHA3_256": 0x00000007,
+	"CKG_MGF1_SHA3_384": 0x00000008,
+	"CKG_MGF1_SHA3_512": 0x00000009,
+}
+
+// MGFEnum provides enum resolution for mask generation function names.
+var MGFEnum = &Pkcs11Enum{Mapping: MGFNameToID, Prefix: "CKG_"}
+
+// KDFNameToID maps key derivation function names to CKD_* constants.
+var KDFNameToID = map[string]uint{
+	"CKD_NULL":                 pkcs11.CKD_NULL,
+	"CKD_SHA1_KDF":             pkcs11.CKD_SHA1_KDF,
+	"CKD_SHA1_KDF_ASN1":        pkcs11.CKD_SHA1_KDF_ASN1,
+	"CKD_SHA1_KDF_CONCATENATE": pkcs11.CKD_SHA1_KDF_CONCATENATE,
+	"CKD_SHA224_KDF":           pkcs11.CKD_SHA224_KDF,
+	"CKD_SHA256_KDF":           pkcs11.CKD_SHA256_KDF,
+	"CKD_SHA384_KDF":           pkcs11.CKD_SHA384_KDF,
+	"CKD_SHA512_KDF":           pkcs11.CKD_SHA512_KDF,
+	"CKD_CPDIVERSIFY_KDF":      pkcs11.CKD_CPDIVERSIFY_KDF,
+	"CKD_SHA3_224_KDF":         pkcs11.CKD_SHA3_224_KDF,
+	"CKD_SHA3_256_KDF":         pkcs11.CKD_SHA3_256_KDF,
+	"CKD_SHA3_384_KDF":         pkcs11.CKD_SHA3_384_KDF,
+	"CKD_SHA3_512_KDF":         pkcs11.CKD_SHA3_512_KDF,
+}
+
+// KDFEnum provides enum resolution for key derivation function names.
+var KDFEnum = &Pkcs11Enum{Mapping: KDFNameToID, Prefix: "CKD_"}
+
+// UserTypeNameToID maps user type names to CKU_* constants.
+var UserTypeNameToID = map[string]uint{
+	"CKU_SO":   pkcs11.CKU_SO,
+	"CKU_USER": pkcs11.CKU_USER,
+}
+
+// UserTypeEnum provides enum resolution for user type names.
+var UserTypeEnum = &Pkcs11Enum{Mapping: UserTypeNameToID, Prefix: "CKU_"}
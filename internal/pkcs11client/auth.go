@@ -0,0 +1,162 @@
+package pkcs11client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// keyPinKey is the context key of the PIN set by WithKeyPin.
+type keyPinKey struct{}
+
+// WithKeyPin returns a context that makes operations with keys requiring a
+// context-specific login (CKA_ALWAYS_AUTHENTICATE) log in with pin instead of
+// the user PIN of the configuration.
+func WithKeyPin(ctx context.Context, pin string) context.Context {
+	return context.WithValue(ctx, keyPinKey{}, pin)
+}
+
+// securityOfficerKey is the context key of the soSession set by AsSecurityOfficer.
+type securityOfficerKey struct{}
+
+// AsSecurityOfficer returns a context that makes client operations use a
+// session logged in as Security Officer (CKU_SO) with soPin, or with the SO
+// PIN of the configuration if soPin is empty. This is required e.g. to set
+// CKA_TRUSTED. The SO only sees public objects, and user sessions are closed
+// while the SO is logged in, so operations with the SO session wait for
+// operations with user sessions to finish and vice versa.
+//
+// The operations with the context share a single SO session, which is opened
+// on first use and kept until end is called, so that e.g. all calls of a
+// resource operation log in only once. Operations with user sessions wait
+// until then.
+func AsSecurityOfficer(ctx context.Context, soPin string) (soCtx context.Context, end func()) {
+	s := &soSession{pin: soPin}
+	return context.WithValue(ctx, securityOfficerKey{}, s), s.end
+}
+
+// soSession is the session shared by the operations of a context returned by
+// AsSecurityOfficer.
+type soSession struct {
+	pin   string
+	mu    sync.Mutex
+	pool  *SessionPool // pool of the open session, nil if none is open
+	sh    pkcs11.SessionHandle
+	inUse bool
+	ended bool
+}
+
+// get returns the open SO session, or opens one in pool with pin.
+func (s *soSession) get(ctx context.Context, pool *SessionPool, pin string) (pkcs11.SessionHandle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.ended:
+		return 0, errors.New("the Security Officer session has already ended")
+	case s.inUse:
+		return 0, errors.New("the Security Officer session is already in use")
+	case s.pool != nil && s.pool != pool:
+		return 0, errors.New("the Security Officer session belongs to a different token")
+	}
+
+	if s.pool == nil {
+		sh, err := pool.GetSO(ctx, pin)
+		if err != nil {
+			return 0, err
+		}
+		s.pool, s.sh = pool, sh
+	}
+	s.inUse = true
+	return s.sh, nil
+}
+
+// put keeps the session open for further operations, unless end was called
+// while it was in use.
+func (s *soSession) put(pkcs11.SessionHandle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inUse = false
+	if s.ended {
+		s.close()
+	}
+}
+
+// discard closes the session, e.g. because it became invalid. The next
+// operation opens a new one.
+func (s *soSession) discard(pkcs11.SessionHandle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inUse = false
+	s.close()
+}
+
+// end closes the session once it is no longer in use.
+func (s *soSession) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+	if !s.inUse {
+		s.close()
+	}
+}
+
+// close logs out and closes the open session, if any. The caller must hold s.mu.
+func (s *soSession) close() {
+	if s.pool != nil {
+		s.pool.PutSO(s.sh)
+		s.pool = nil
+	}
+}
+
+// keyPin returns the PIN for a context-specific login.
+func (c *Client) keyPin(ctx context.Context) string {
+	if pin, ok := ctx.Value(keyPinKey{}).(string); ok && pin != "" {
+		return pin
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config.Pin
+}
+
+// alwaysAuthenticate reports whether key has CKA_ALWAYS_AUTHENTICATE set,
+// i.e. every operation with it requires a context-specific login. Keys whose
+// attribute cannot be read are assumed not to require one.
+func (c *Client) alwaysAuthenticate(sh pkcs11.SessionHandle, key pkcs11.ObjectHandle) bool {
+	attrs, err := c.ctx.GetAttributeValue(sh, key, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ALWAYS_AUTHENTICATE, nil),
+	})
+	if err != nil || len(attrs) == 0 {
+		return false
+	}
+	return BytesToBool(attrs[0].Value)
+}
+
+// runAuthenticated runs op, which initializes an operation with key in sh and
+// performs it. op calls login right after the initialization, which performs
+// a context-specific login if key requires one. If the key does not report
+// CKA_ALWAYS_AUTHENTICATE but the operation fails with
+// CKR_USER_NOT_LOGGED_IN, op is run again with a login, provided that it is
+// repeatable, i.e. does not consume a stream.
+func (c *Client) runAuthenticated(ctx context.Context, sh pkcs11.SessionHandle, key pkcs11.ObjectHandle, repeatable bool, op func(login func() error) error) error {
+	login := func() error {
+		if err := c.ctx.Login(sh, pkcs11.CKU_CONTEXT_SPECIFIC, c.keyPin(ctx)); err != nil {
+			return fmt.Errorf("context-specific login for key %d: %w", key, wrapError("Login", err))
+		}
+		return nil
+	}
+	if c.alwaysAuthenticate(sh, key) {
+		return op(login)
+	}
+
+	err := op(func() error { return nil })
+	if repeatable && errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN)) {
+		// Some tokens require a context-specific login without reporting
+		// CKA_ALWAYS_AUTHENTICATE. If the session itself is not logged in,
+		// the login fails and the session is replaced as usual.
+		err = op(login)
+	}
+	return err
+}
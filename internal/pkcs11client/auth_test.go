@@ -0,0 +1,313 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// createAlwaysAuthenticateKey creates a private key that requires a context-specific login.
+func createAlwaysAuthenticateKey(t *testing.T, client *Client) pkcs11.ObjectHandle {
+	t.Helper()
+	key, err := client.CreateObject(t.Context(), []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "piv-signing-key"),
+		pkcs11.NewAttribute(pkcs11.CKA_ALWAYS_AUTHENTICATE, true),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+	return key
+}
+
+func TestSign_AlwaysAuthenticate(t *testing.T) {
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+	key := createAlwaysAuthenticateKey(t, client)
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	if _, err := client.Sign(t.Context(), mech, key, []byte("data")); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if _, err := client.SignReader(t.Context(), mech, key, bytes.NewReader([]byte("data"))); err != nil {
+		t.Fatalf("SignReader failed: %v", err)
+	}
+}
+
+func TestDecrypt_AlwaysAuthenticate(t *testing.T) {
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+	key := createAlwaysAuthenticateKey(t, client)
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}
+	plaintext, err := client.Decrypt(t.Context(), mech, key, []byte("atad"))
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if string(plaintext) != "data" {
+		t.Errorf("unexpected plaintext %q", plaintext)
+	}
+
+	var out bytes.Buffer
+	if err := client.DecryptStream(t.Context(), mech, key, bytes.NewReader([]byte("atad")), &out); err != nil {
+		t.Fatalf("DecryptStream failed: %v", err)
+	}
+	if out.String() != "data" {
+		t.Errorf("unexpected plaintext %q", out.String())
+	}
+}
+
+func TestSign_AlwaysAuthenticateKeyPin(t *testing.T) {
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+	mock.KeyPin = "key-pin"
+	key := createAlwaysAuthenticateKey(t, client)
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	_, err := client.Sign(t.Context(), mech, key, []byte("data"))
+	if !errors.Is(err, pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)) {
+		t.Fatalf("expected CKR_PIN_INCORRECT with the user PIN, got: %v", err)
+	}
+
+	// The failed login must not leave the operation active in pooled sessions
+	for i := 0; i < client.pool.Size()+1; i++ {
+		if _, err := client.Sign(WithKeyPin(t.Context(), "key-pin"), mech, key, []byte("data")); err != nil {
+			t.Fatalf("Sign with key PIN failed: %v", err)
+		}
+	}
+}
+
+// hiddenAttributeContext fails to read CKA_ALWAYS_AUTHENTICATE, like tokens
+// that enforce a context-specific login without reporting the attribute.
+type hiddenAttributeContext struct {
+	*MockContext
+}
+
+func (h *hiddenAttributeContext) GetAttributeValue(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle, temp []*pkcs11.Attribute) ([]*pkcs11.Attribute, error) {
+	for _, attr := range temp {
+		if attr.Type == pkcs11.CKA_ALWAYS_AUTHENTICATE {
+			return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_TYPE_INVALID)
+		}
+	}
+	return h.MockContext.GetAttributeValue(sh, oh, temp)
+}
+
+func TestSign_AlwaysAuthenticateNotReported(t *testing.T) {
+	client, err := NewClientWithContext(&hiddenAttributeContext{NewMockContext("test-token")}, Config{
+		TokenLabel: "test-token",
+		Pin:        "1234",
+		PoolSize:   1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+	key := createAlwaysAuthenticateKey(t, client)
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	if _, err := client.Sign(t.Context(), mech, key, []byte("data")); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	// Streams cannot be repeated after the token reported the missing login
+	_, err = client.SignReader(t.Context(), mech, key, bytes.NewReader([]byte("data")))
+	if !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN)) {
+		t.Fatalf("expected CKR_USER_NOT_LOGGED_IN, got: %v", err)
+	}
+}
+
+// newSOTestClient returns a client with a user and SO PIN configured.
+func newSOTestClient(t *testing.T) (*Client, *MockContext) {
+	t.Helper()
+	mock := NewMockContext("test-token")
+	mock.slots[0].userPin = "1234"
+	mock.slots[0].soPin = "5678"
+	client, err := NewClientWithContext(mock, Config{
+		TokenLabel: "test-token",
+		Pin:        "1234",
+		SoPin:      "5678",
+		PoolSize:   2,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return client, mock
+}
+
+func TestAsSecurityOfficer_Trusted(t *testing.T) {
+	client, _ := newSOTestClient(t)
+	defer client.Close()
+	ctx := t.Context()
+
+	// Log in a user session first, which the SO session has to replace
+	if _, err := client.GenerateRandom(ctx, 8); err != nil {
+		t.Fatalf("GenerateRandom failed: %v", err)
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "kek"),
+		pkcs11.NewAttribute(pkcs11.CKA_TRUSTED, true),
+	}
+	if _, err := client.CreateObject(ctx, template); !errors.Is(err, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_READ_ONLY)) {
+		t.Fatalf("expected CKR_ATTRIBUTE_READ_ONLY as user, got: %v", err)
+	}
+
+	soCtx, endSO := AsSecurityOfficer(ctx, "")
+	key, err := client.CreateObject(soCtx, template)
+	if err != nil {
+		t.Fatalf("CreateObject as SO failed: %v", err)
+	}
+	if err := client.SetAttributeValue(soCtx, key, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "kek-renamed"),
+	}); err != nil {
+		t.Fatalf("SetAttributeValue as SO failed: %v", err)
+	}
+	endSO()
+
+	// User sessions log in again after the SO logged out
+	if _, err := client.FindObjectByLabelAndClass(ctx, "kek-renamed", pkcs11.CKO_SECRET_KEY); err != nil {
+		t.Fatalf("FindObjectByLabelAndClass as user failed: %v", err)
+	}
+}
+
+func TestAsSecurityOfficer_WaitsForUserSessions(t *testing.T) {
+	client, mock := newSOTestClient(t)
+	defer client.Close()
+	ctx := t.Context()
+
+	sh, err := client.pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		soCtx, endSO := AsSecurityOfficer(ctx, "")
+		defer endSO()
+		_, err := client.GenerateRandom(soCtx, 8)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("SO operation did not wait for the user session, got: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	client.pool.Put(sh)
+	if err := <-done; err != nil {
+		t.Fatalf("GenerateRandom as SO failed: %v", err)
+	}
+	if n := mock.OpenSessionCount(); n != 0 {
+		t.Errorf("expected the SO session and idle user sessions to be closed, got %d open sessions", n)
+	}
+}
+
+func TestAsSecurityOfficer_Errors(t *testing.T) {
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	soCtx, endSO := AsSecurityOfficer(t.Context(), "")
+	defer endSO()
+	_, err := client.GenerateRandom(soCtx, 8)
+	if err == nil || !strings.Contains(err.Error(), "so_pin") {
+		t.Fatalf("expected error about the missing SO PIN, got: %v", err)
+	}
+
+	soClient, _ := newSOTestClient(t)
+	defer soClient.Close()
+	wrongCtx, endWrong := AsSecurityOfficer(t.Context(), "0000")
+	defer endWrong()
+	_, err = soClient.GenerateRandom(wrongCtx, 8)
+	if !errors.Is(err, ErrPinIncorrect) {
+		t.Fatalf("expected ErrPinIncorrect, got: %v", err)
+	}
+	// The failed login releases the pool for user sessions
+	if _, err := soClient.GenerateRandom(t.Context(), 8); err != nil {
+		t.Fatalf("GenerateRandom as user failed: %v", err)
+	}
+}
+
+// soLoginCounter counts the logins as Security Officer.
+type soLoginCounter struct {
+	*MockContext
+	soLogins atomic.Int32
+}
+
+func (c *soLoginCounter) Login(sh pkcs11.SessionHandle, userType uint, pin string) error {
+	if userType == pkcs11.CKU_SO {
+		c.soLogins.Add(1)
+	}
+	return c.MockContext.Login(sh, userType, pin)
+}
+
+func TestAsSecurityOfficer_SharesSession(t *testing.T) {
+	mock := NewMockContext("test-token")
+	mock.slots[0].userPin = "1234"
+	mock.slots[0].soPin = "5678"
+	counter := &soLoginCounter{MockContext: mock}
+	client, err := NewClientWithContext(counter, Config{
+		TokenLabel: "test-token",
+		Pin:        "1234",
+		SoPin:      "5678",
+		PoolSize:   2,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	soCtx, endSO := AsSecurityOfficer(t.Context(), "")
+	for i := 0; i < 3; i++ {
+		if _, err := client.GenerateRandom(soCtx, 8); err != nil {
+			t.Fatalf("GenerateRandom as SO failed: %v", err)
+		}
+	}
+	if n := counter.soLogins.Load(); n != 1 {
+		t.Errorf("expected a single SO login, got %d", n)
+	}
+	if n := mock.OpenSessionCount(); n != 1 {
+		t.Errorf("expected the SO session to stay open, got %d open sessions", n)
+	}
+
+	endSO()
+	if n := mock.OpenSessionCount(); n != 0 {
+		t.Errorf("expected the SO session to be closed, got %d open sessions", n)
+	}
+	if _, err := client.GenerateRandom(t.Context(), 8); err != nil {
+		t.Fatalf("GenerateRandom as user failed: %v", err)
+	}
+}
+
+func TestAsSecurityOfficer_WaitRespectsContext(t *testing.T) {
+	client, _ := newSOTestClient(t)
+	defer client.Close()
+
+	sh, err := client.pool.Get(t.Context())
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer client.pool.Put(sh)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	soCtx, endSO := AsSecurityOfficer(ctx, "")
+	defer endSO()
+	if _, err := client.GenerateRandom(soCtx, 8); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	// The abandoned wait does not keep user sessions from being handed out
+	other, err := client.pool.Get(t.Context())
+	if err != nil {
+		t.Fatalf("Get after abandoned SO wait failed: %v", err)
+	}
+	client.pool.Put(other)
+}
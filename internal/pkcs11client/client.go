@@ -1,6 +1,8 @@
 package pkcs11client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"runtime"
 	"strings"
@@ -21,10 +23,15 @@ type Pkcs11Context interface {
 	GetMechanismInfo(slotID uint, m []*pkcs11.Mechanism) (pkcs11.MechanismInfo, error)
 	OpenSession(slotID uint, flags uint) (pkcs11.SessionHandle, error)
 	CloseSession(sh pkcs11.SessionHandle) error
+	GetSessionInfo(sh pkcs11.SessionHandle) (pkcs11.SessionInfo, error)
 	Login(sh pkcs11.SessionHandle, userType uint, pin string) error
 	Logout(sh pkcs11.SessionHandle) error
+	InitToken(slotID uint, soPin string, label string) error
+	InitPIN(sh pkcs11.SessionHandle, pin string) error
+	SetPIN(sh pkcs11.SessionHandle, oldPin string, newPin string) error
 	CreateObject(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error)
 	DestroyObject(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle) error
+	CopyObject(sh pkcs11.SessionHandle, o pkcs11.ObjectHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error)
 	FindObjectsInit(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) error
 	FindObjects(sh pkcs11.SessionHandle, max int) ([]pkcs11.ObjectHandle, bool, error)
 	FindObjectsFinal(sh pkcs11.SessionHandle) error
@@ -37,10 +44,25 @@ type Pkcs11Context interface {
 	DeriveKey(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, baseKey pkcs11.ObjectHandle, a []*pkcs11.Attribute) (pkcs11.ObjectHandle, error)
 	EncryptInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error
 	Encrypt(sh pkcs11.SessionHandle, message []byte) ([]byte, error)
+	EncryptUpdate(sh pkcs11.SessionHandle, plain []byte) ([]byte, error)
+	EncryptFinal(sh pkcs11.SessionHandle) ([]byte, error)
 	DecryptInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error
 	Decrypt(sh pkcs11.SessionHandle, cipher []byte) ([]byte, error)
+	DecryptUpdate(sh pkcs11.SessionHandle, cipher []byte) ([]byte, error)
+	DecryptFinal(sh pkcs11.SessionHandle) ([]byte, error)
 	SignInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error
 	Sign(sh pkcs11.SessionHandle, message []byte) ([]byte, error)
+	SignUpdate(sh pkcs11.SessionHandle, message []byte) error
+	SignFinal(sh pkcs11.SessionHandle) ([]byte, error)
+	VerifyInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, key pkcs11.ObjectHandle) error
+	Verify(sh pkcs11.SessionHandle, data []byte, signature []byte) error
+	DigestInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism) error
+	Digest(sh pkcs11.SessionHandle, message []byte) ([]byte, error)
+	DigestUpdate(sh pkcs11.SessionHandle, message []byte) error
+	DigestKey(sh pkcs11.SessionHandle, key pkcs11.ObjectHandle) error
+	DigestFinal(sh pkcs11.SessionHandle) ([]byte, error)
+	SeedRandom(sh pkcs11.SessionHandle, seed []byte) error
+	GenerateRandom(sh pkcs11.SessionHandle, length int) ([]byte, error)
 }
 
 // Config holds configuration for creating a Client.
@@ -53,7 +75,27 @@ type Config struct {
 	SlotID            *uint
 	Pin               string
 	SoPin             string
-	PoolSize          int
+	// ProtectedAuthPath makes the client log in through the protected
+	// authentication path of the token (CKF_PROTECTED_AUTHENTICATION_PATH),
+	// e.g. a PIN pad, by calling C_Login without PIN. Pin must be empty; if
+	// SoPin is empty, the SO logs in through the protected path as well.
+	ProtectedAuthPath bool
+	// PoolSize is the maximum number of concurrent sessions. If zero, it is
+	// derived from the maximum number of R/W sessions of the token.
+	PoolSize int
+	// DeferTokenResolution allows creating a client when no token matches the
+	// filters yet, e.g. because it is initialized later by the pkcs11_token
+	// resource. The token is then resolved on first use.
+	DeferTokenResolution bool
+	// Retry controls retries of transient errors. If nil, operations are not
+	// retried, except once with a new session after a session error.
+	Retry *RetryPolicy
+	// Host runs the module in a module host process instead of the provider
+	// process if not nil.
+	Host *ModuleHost
+	// Agent accesses the module of a remote agent instead of loading the
+	// module at ModulePath if not nil.
+	Agent *AgentConfig
 }
 
 // HasTokenFilters returns true if any token-based filter is set in the config.
@@ -64,20 +106,22 @@ func HasTokenFilters(cfg Config) bool {
 
 // Client manages a connection to a PKCS#11 module.
 type Client struct {
-	ctx    Pkcs11Context
-	config Config
-	slotID uint
-	pool   *SessionPool
-	mu     sync.Mutex
+	ctx     Pkcs11Context
+	config  Config
+	slotID  uint
+	pool    *SessionPool // nil until the token is resolved
+	release func() error // finalizes the module, nil once the client is closed
+	mu      sync.Mutex
 }
 
-// NewClient creates a Client from a real pkcs11.Ctx loaded from the module path.
+// NewClient creates a Client for the module at the module path, or of the
+// agent. The module is shared with the other clients of the process that use it.
 func NewClient(cfg Config) (*Client, error) {
-	ctx := pkcs11.New(cfg.ModulePath)
-	if ctx == nil {
-		return nil, fmt.Errorf("pkcs11: failed to load module %q", cfg.ModulePath)
+	ctx, release, err := modules.acquire(cfg)
+	if err != nil {
+		return nil, err
 	}
-	return NewClientWithContext(ctx, cfg)
+	return newClient(ctx, cfg, release)
 }
 
 // NewClientWithContext creates a Client using a provided Pkcs11Context (useful for testing).
@@ -85,23 +129,24 @@ func NewClientWithContext(ctx Pkcs11Context, cfg Config) (*Client, error) {
 	if err := ctx.Initialize(); err != nil {
 		return nil, wrapError("Initialize", err)
 	}
+	return newClient(ctx, cfg, func() error {
+		return wrapError("Finalize", ctx.Finalize())
+	})
+}
 
-	slotID, err := resolveSlot(ctx, cfg)
-	if err != nil {
-		ctx.Finalize()
-		return nil, err
-	}
-
-	poolSize := cfg.PoolSize
-	if poolSize <= 0 {
-		poolSize = 5
-	}
-
+// newClient creates a Client for an initialized module. release is called
+// once when the client is closed, or right away if creating it fails.
+func newClient(ctx Pkcs11Context, cfg Config, release func() error) (*Client, error) {
 	c := &Client{
-		ctx:    ctx,
-		config: cfg,
-		slotID: slotID,
-		pool:   NewSessionPool(ctx, slotID, cfg.Pin, poolSize),
+		ctx:     ctx,
+		config:  cfg,
+		release: release,
+	}
+	if err := c.resolveToken(); err != nil {
+		if !cfg.DeferTokenResolution || !errors.Is(err, ErrSlotNotFound) {
+			release()
+			return nil, err
+		}
 	}
 
 	// Ensure sessions are closed when the client is garbage collected.
@@ -114,52 +159,269 @@ func NewClientWithContext(ctx Pkcs11Context, cfg Config) (*Client, error) {
 	return c, nil
 }
 
-// Close releases all sessions and finalizes the PKCS#11 module.
+// Close releases all sessions and finalizes the PKCS#11 module, unless it is
+// shared with other clients that are still open.
 func (c *Client) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.pool.CloseAll()
-	return wrapError("Finalize", c.ctx.Finalize())
+	if c.pool != nil {
+		c.pool.CloseAll()
+	}
+	if c.release == nil {
+		return nil
+	}
+	release := c.release
+	c.release = nil
+	return release()
 }
 
-// SlotID returns the resolved slot ID.
+// SlotID returns the resolved slot ID, or 0 if the token has not been resolved yet.
 func (c *Client) SlotID() uint {
 	return c.slotID
 }
 
+// ensureToken resolves the token on first use if resolution was deferred.
+func (c *Client) ensureToken() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pool != nil {
+		return nil
+	}
+	return c.resolveToken()
+}
+
+// resolveToken resolves the slot from the config and creates the session pool.
+// The caller must hold c.mu or have exclusive access to c.
+func (c *Client) resolveToken() error {
+	slotID, err := resolveSlot(c.ctx, c.config)
+	if err != nil {
+		return err
+	}
+	if c.config.ProtectedAuthPath {
+		info, err := c.ctx.GetTokenInfo(slotID)
+		if err != nil {
+			return wrapError("GetTokenInfo", err)
+		}
+		if info.Flags&pkcs11.CKF_PROTECTED_AUTHENTICATION_PATH == 0 {
+			return fmt.Errorf("token in slot %d has no protected authentication path", slotID)
+		}
+	}
+
+	poolSize := c.config.PoolSize
+	if poolSize <= 0 {
+		poolSize = DefaultPoolSize
+		if info, err := c.ctx.GetTokenInfo(slotID); err == nil {
+			poolSize = poolSizeFor(info)
+		}
+	}
+
+	c.slotID = slotID
+	c.pool = NewSessionPool(c.ctx, slotID, c.config.Pin, poolSize)
+	c.pool.protectedAuthPath = c.config.ProtectedAuthPath
+	return nil
+}
+
 // Context returns the underlying Pkcs11Context.
 func (c *Client) Context() Pkcs11Context {
 	return c.ctx
 }
 
-// withSession executes fn with a session from the pool. If a session error occurs,
-// the session is discarded and the operation retried once with a fresh session.
-func (c *Client) withSession(fn func(sh pkcs11.SessionHandle) error) error {
-	sh, err := c.pool.Get()
+// withSession executes fn with a session from the pool and retries it on
+// transient errors according to the retry policy. fn must be safe to repeat.
+func (c *Client) withSession(ctx context.Context, fn func(sh pkcs11.SessionHandle) error) error {
+	return c.withRetry(ctx, repeatAlways, fn)
+}
+
+// withSessionOnce executes fn at most once with a session from the pool, for
+// operations that must not be repeated, e.g. because they consume a stream.
+// Only failures before fn is called are retried.
+func (c *Client) withSessionOnce(ctx context.Context, fn func(sh pkcs11.SessionHandle) error) error {
+	return c.withRetry(ctx, nil, fn)
+}
+
+// withObjectCreation executes fn, which creates objects from templates, with
+// a session from the pool. Transient errors are only retried if the failed
+// attempt did not create an object, see noObjectsCreated.
+func (c *Client) withObjectCreation(ctx context.Context, templates [][]*pkcs11.Attribute, fn func(sh pkcs11.SessionHandle) error) error {
+	return c.withRetry(ctx, c.noObjectsCreated(templates...), fn)
+}
+
+// sessionSource obtains and releases the sessions used by attempt.
+type sessionSource struct {
+	get     func(ctx context.Context) (pkcs11.SessionHandle, error)
+	put     func(sh pkcs11.SessionHandle)
+	discard func(sh pkcs11.SessionHandle)
+}
+
+// sessions returns the source of sessions for ctx: the user sessions of the
+// pool or, if ctx was created by AsSecurityOfficer, its session logged in as
+// Security Officer.
+func (c *Client) sessions(ctx context.Context) (sessionSource, error) {
+	so, ok := ctx.Value(securityOfficerKey{}).(*soSession)
+	if !ok {
+		return sessionSource{get: c.pool.Get, put: c.pool.Put, discard: c.pool.Discard}, nil
+	}
+	soPin, err := c.soPin(so.pin)
 	if err != nil {
-		return err
+		return sessionSource{}, err
+	}
+	return sessionSource{
+		get: func(ctx context.Context) (pkcs11.SessionHandle, error) {
+			return so.get(ctx, c.pool, soPin)
+		},
+		put:     so.put,
+		discard: so.discard,
+	}, nil
+}
+
+// attempt executes fn with a session from the pool. If a session error
+// occurs, the session is discarded and fn retried once with a fresh session,
+// provided that repeatable is not nil and reports that fn may be repeated.
+// Waiting for a session and fn itself are abandoned once ctx is done.
+// executed reports whether fn was called.
+func (c *Client) attempt(ctx context.Context, fn func(sh pkcs11.SessionHandle) error, repeatable func(ctx context.Context) bool) (executed bool, err error) {
+	if err := c.ensureToken(); err != nil {
+		return false, err
+	}
+	src, err := c.sessions(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	sh, err := src.get(ctx)
+	if err != nil {
+		return false, err
 	}
 
-	err = fn(sh)
+	completed, err := c.runInSession(ctx, src, sh, fn)
+	if !completed {
+		return true, err
+	}
+	if err != nil && connectionLost(err) {
+		// The session is gone; withRetry decides whether to repeat fn
+		src.discard(sh)
+		return true, err
+	}
 	if err != nil && isSessionError(err) {
-		// Discard the bad session and retry once
-		c.ctx.CloseSession(sh)
-		sh, err = c.pool.Get()
+		// Discard the bad session and retry once if fn may be repeated
+		src.discard(sh)
+		if repeatable == nil || !repeatable(ctx) {
+			return true, err
+		}
+		sh, err = src.get(ctx)
 		if err != nil {
-			return err
+			return false, err
+		}
+		completed, err = c.runInSession(ctx, src, sh, fn)
+		if !completed {
+			return true, err
 		}
-		err = fn(sh)
 	}
 
-	if err != nil {
-		// Still return session to pool even on non-session errors
-		c.pool.Put(sh)
-		return err
+	// Return the session to the pool even on non-session errors
+	src.put(sh)
+	return true, err
+}
+
+// runInSession calls fn with sh, which is bound to ctx in the middlewares
+// while fn runs. If ctx is done before fn returns, the session is discarded
+// once fn has returned and completed is false.
+func (c *Client) runInSession(ctx context.Context, src sessionSource, sh pkcs11.SessionHandle, fn func(sh pkcs11.SessionHandle) error) (completed bool, err error) {
+	return interruptible(ctx, func() error {
+		defer bindSession(c.ctx, sh, ctx)()
+		return fn(sh)
+	}, func() {
+		src.discard(sh)
+	})
+}
+
+// call executes fn, which uses the module without a pooled session, and
+// abandons it once ctx is done.
+func (c *Client) call(ctx context.Context, fn func() error) error {
+	_, err := interruptible(ctx, fn, nil)
+	return err
+}
+
+// interruptible calls fn and waits until it returns or ctx is done. PKCS#11
+// calls cannot be cancelled, so in the latter case fn keeps running in the
+// background and cleanup, if not nil, is called once it has returned, or
+// right away if ctx was done before fn was started. completed reports whether
+// fn returned before ctx was done.
+func interruptible(ctx context.Context, fn func() error, cleanup func()) (completed bool, err error) {
+	if err := ctx.Err(); err != nil {
+		if cleanup != nil {
+			cleanup()
+		}
+		return false, err
+	}
+	if ctx.Done() == nil {
+		// The context can never be cancelled
+		return true, fn()
 	}
 
-	c.pool.Put(sh)
-	return nil
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return true, err
+	case <-ctx.Done():
+		abandoned.begin()
+		go func() {
+			<-done
+			if cleanup != nil {
+				cleanup()
+			}
+			abandoned.end()
+		}()
+		return false, fmt.Errorf("%w: %w", ErrOperationAbandoned, context.Cause(ctx))
+	}
+}
+
+// abandoned tracks the calls that interruptible abandoned but that are still
+// running. They may still use memory passed to the module, such as marshaled
+// mechanism parameters, so releasing it is deferred until they have returned.
+var abandoned abandonedCalls
+
+type abandonedCalls struct {
+	mu       sync.Mutex
+	running  int
+	releases []func()
+}
+
+func (a *abandonedCalls) begin() {
+	a.mu.Lock()
+	a.running++
+	a.mu.Unlock()
+}
+
+func (a *abandonedCalls) end() {
+	a.mu.Lock()
+	a.running--
+	var releases []func()
+	if a.running == 0 {
+		releases, a.releases = a.releases, nil
+	}
+	a.mu.Unlock()
+	for _, release := range releases {
+		release()
+	}
+}
+
+// release calls fn right away if no abandoned call is running, or once all
+// abandoned calls have returned.
+func (a *abandonedCalls) release(fn func()) {
+	a.mu.Lock()
+	if a.running > 0 {
+		a.releases = append(a.releases, fn)
+		a.mu.Unlock()
+		return
+	}
+	a.mu.Unlock()
+	fn()
 }
 
 // tokenMatches checks whether a token's info matches all non-empty filter fields in the config.
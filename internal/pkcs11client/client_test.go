@@ -1,7 +1,14 @@
 package pkcs11client
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/miekg/pkcs11"
 )
@@ -131,6 +138,7 @@ func TestNewClientWithContext_MultipleFiltersPartialMismatch(t *testing.T) {
 }
 
 func TestCreateAndFindObject(t *testing.T) {
+	ctx := t.Context()
 	client, _ := newTestClient("test-token")
 	defer client.Close()
 
@@ -140,13 +148,13 @@ func TestCreateAndFindObject(t *testing.T) {
 		pkcs11.NewAttribute(pkcs11.CKA_VALUE, []byte("hello")),
 	}
 
-	handle, err := client.CreateObject(attrs)
+	handle, err := client.CreateObject(ctx, attrs)
 	if err != nil {
 		t.Fatalf("CreateObject failed: %v", err)
 	}
 
 	// Find by label and class
-	found, err := client.FindObjectByLabelAndClass("test-data", pkcs11.CKO_DATA)
+	found, err := client.FindObjectByLabelAndClass(ctx, "test-data", pkcs11.CKO_DATA)
 	if err != nil {
 		t.Fatalf("FindObjectByLabelAndClass failed: %v", err)
 	}
@@ -155,7 +163,7 @@ func TestCreateAndFindObject(t *testing.T) {
 	}
 
 	// Get attributes
-	result, err := client.GetObjectAttributes(handle, []uint{pkcs11.CKA_VALUE})
+	result, err := client.GetObjectAttributes(ctx, handle, []uint{pkcs11.CKA_VALUE})
 	if err != nil {
 		t.Fatalf("GetObjectAttributes failed: %v", err)
 	}
@@ -165,6 +173,7 @@ func TestCreateAndFindObject(t *testing.T) {
 }
 
 func TestDestroyObject(t *testing.T) {
+	ctx := t.Context()
 	client, _ := newTestClient("test-token")
 	defer client.Close()
 
@@ -173,23 +182,83 @@ func TestDestroyObject(t *testing.T) {
 		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "to-delete"),
 	}
 
-	handle, err := client.CreateObject(attrs)
+	handle, err := client.CreateObject(ctx, attrs)
 	if err != nil {
 		t.Fatalf("CreateObject failed: %v", err)
 	}
 
-	err = client.DestroyObject(handle)
+	err = client.DestroyObject(ctx, handle)
 	if err != nil {
 		t.Fatalf("DestroyObject failed: %v", err)
 	}
 
-	_, err = client.FindObjectByLabelAndClass("to-delete", pkcs11.CKO_DATA)
+	_, err = client.FindObjectByLabelAndClass(ctx, "to-delete", pkcs11.CKO_DATA)
 	if err != ErrObjectNotFound {
 		t.Errorf("expected ErrObjectNotFound, got %v", err)
 	}
 }
 
+func TestCopyObject(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	handle, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "original"),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	copied, err := client.CopyObject(ctx, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "verify-only"),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, false),
+	})
+	if err != nil {
+		t.Fatalf("CopyObject failed: %v", err)
+	}
+
+	found, err := client.FindObjectByLabelAndClass(ctx, "verify-only", pkcs11.CKO_SECRET_KEY)
+	if err != nil || found != copied {
+		t.Fatalf("expected to find copy %v, got %v (err: %v)", copied, found, err)
+	}
+	attrs, err := client.GetObjectAttributes(ctx, copied, []uint{pkcs11.CKA_SIGN, pkcs11.CKA_VERIFY})
+	if err != nil {
+		t.Fatalf("GetObjectAttributes failed: %v", err)
+	}
+	if BytesToBool(attrs[pkcs11.CKA_SIGN]) || !BytesToBool(attrs[pkcs11.CKA_VERIFY]) {
+		t.Error("expected copy to keep CKA_VERIFY and override CKA_SIGN")
+	}
+
+	if _, err := client.FindObjectByLabelAndClass(ctx, "original", pkcs11.CKO_SECRET_KEY); err != nil {
+		t.Errorf("expected original to be unchanged: %v", err)
+	}
+}
+
+func TestCopyObject_NotCopyable(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	handle, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_COPYABLE, false),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	_, err = client.CopyObject(ctx, handle, nil)
+	if !errors.Is(err, pkcs11.Error(pkcs11.CKR_ACTION_PROHIBITED)) {
+		t.Errorf("expected CKR_ACTION_PROHIBITED, got %v", err)
+	}
+}
+
 func TestSetAttributeValue(t *testing.T) {
+	ctx := t.Context()
 	client, _ := newTestClient("test-token")
 	defer client.Close()
 
@@ -199,19 +268,19 @@ func TestSetAttributeValue(t *testing.T) {
 		pkcs11.NewAttribute(pkcs11.CKA_VALUE, []byte("original")),
 	}
 
-	handle, err := client.CreateObject(attrs)
+	handle, err := client.CreateObject(ctx, attrs)
 	if err != nil {
 		t.Fatalf("CreateObject failed: %v", err)
 	}
 
-	err = client.SetAttributeValue(handle, []*pkcs11.Attribute{
+	err = client.SetAttributeValue(ctx, handle, []*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_VALUE, []byte("updated")),
 	})
 	if err != nil {
 		t.Fatalf("SetAttributeValue failed: %v", err)
 	}
 
-	result, err := client.GetObjectAttributes(handle, []uint{pkcs11.CKA_VALUE})
+	result, err := client.GetObjectAttributes(ctx, handle, []uint{pkcs11.CKA_VALUE})
 	if err != nil {
 		t.Fatalf("GetObjectAttributes failed: %v", err)
 	}
@@ -221,10 +290,11 @@ func TestSetAttributeValue(t *testing.T) {
 }
 
 func TestGetSlotList(t *testing.T) {
+	ctx := t.Context()
 	client, _ := newTestClient("test-token")
 	defer client.Close()
 
-	slots, err := client.GetSlotList(true)
+	slots, err := client.GetSlotList(ctx, true)
 	if err != nil {
 		t.Fatalf("GetSlotList failed: %v", err)
 	}
@@ -237,10 +307,11 @@ func TestGetSlotList(t *testing.T) {
 }
 
 func TestGetTokenInfo(t *testing.T) {
+	ctx := t.Context()
 	client, _ := newTestClient("test-token")
 	defer client.Close()
 
-	info, err := client.GetTokenInfo()
+	info, err := client.GetTokenInfo(ctx)
 	if err != nil {
 		t.Fatalf("GetTokenInfo failed: %v", err)
 	}
@@ -250,10 +321,11 @@ func TestGetTokenInfo(t *testing.T) {
 }
 
 func TestFindOneObject_NotFound(t *testing.T) {
+	ctx := t.Context()
 	client, _ := newTestClient("test-token")
 	defer client.Close()
 
-	_, err := client.FindOneObject([]*pkcs11.Attribute{
+	_, err := client.FindOneObject(ctx, []*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "nonexistent"),
 	})
 	if err != ErrObjectNotFound {
@@ -296,3 +368,652 @@ func TestAttributeConversions(t *testing.T) {
 		t.Error("hex round-trip failed")
 	}
 }
+
+func TestVerify(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS, nil)}
+	sig, err := client.Sign(ctx, mech, 1, []byte("data"))
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	valid, err := client.Verify(ctx, mech, 1, []byte("data"), sig)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !valid {
+		t.Error("expected signature to be valid")
+	}
+
+	valid, err = client.Verify(ctx, mech, 1, []byte("data"), []byte("tampered"))
+	if err != nil {
+		t.Fatalf("expected no error for invalid signature, got: %v", err)
+	}
+	if valid {
+		t.Error("expected signature to be invalid")
+	}
+}
+
+func TestVerify_Error(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	mock.VerifyErr = pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS, nil)}
+	if _, err := client.Verify(ctx, mech, 1, []byte("data"), []byte("mock-signature")); err == nil {
+		t.Fatal("expected error when verification cannot be performed")
+	}
+}
+
+func TestDigest(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256, nil)}
+	digest, err := client.Digest(ctx, mech, []byte("hello world"))
+	if err != nil {
+		t.Fatalf("Digest failed: %v", err)
+	}
+	expected := sha256.Sum256([]byte("hello world"))
+	if !bytes.Equal(digest, expected[:]) {
+		t.Errorf("expected %x, got %x", expected, digest)
+	}
+}
+
+func TestDigestReader(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	// Larger than a single chunk to exercise multiple C_DigestUpdate calls
+	data := bytes.Repeat([]byte("0123456789abcdef"), streamChunkSize/8)
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256, nil)}
+	digest, err := client.DigestReader(ctx, mech, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DigestReader failed: %v", err)
+	}
+	expected := sha256.Sum256(data)
+	if !bytes.Equal(digest, expected[:]) {
+		t.Errorf("expected %x, got %x", expected, digest)
+	}
+}
+
+func TestDigestReader_ReadErrorReleasesSession(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256, nil)}
+	readErr := errors.New("read failed")
+	if _, err := client.DigestReader(ctx, mech, iotest.ErrReader(readErr)); !errors.Is(err, readErr) {
+		t.Fatalf("expected read error, got: %v", err)
+	}
+
+	// The pooled session must not be left with an active digest operation
+	if _, err := client.Digest(ctx, mech, []byte("data")); err != nil {
+		t.Fatalf("Digest after failed stream: %v", err)
+	}
+}
+
+func TestEncryptDecryptStream(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), streamChunkSize/8)
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_CBC_PAD, nil)}
+
+	var ciphertext bytes.Buffer
+	if err := client.EncryptStream(ctx, mech, 1, bytes.NewReader(data), &ciphertext); err != nil {
+		t.Fatalf("EncryptStream failed: %v", err)
+	}
+	single, err := client.Encrypt(ctx, mech, 1, data)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if !bytes.Equal(ciphertext.Bytes(), single) {
+		t.Error("expected streamed ciphertext to match single-part ciphertext")
+	}
+
+	var plaintext bytes.Buffer
+	if err := client.DecryptStream(ctx, mech, 1, &ciphertext, &plaintext); err != nil {
+		t.Fatalf("DecryptStream failed: %v", err)
+	}
+	if !bytes.Equal(plaintext.Bytes(), data) {
+		t.Error("expected decrypted stream to match original data")
+	}
+}
+
+func TestSignReader(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS, nil)}
+	data := bytes.Repeat([]byte("x"), 3*streamChunkSize+1)
+	sig, err := client.SignReader(ctx, mech, 1, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("SignReader failed: %v", err)
+	}
+	if string(sig) != "mock-signature" {
+		t.Errorf("unexpected signature %q", sig)
+	}
+
+	readErr := errors.New("read failed")
+	if _, err := client.SignReader(ctx, mech, 1, iotest.ErrReader(readErr)); !errors.Is(err, readErr) {
+		t.Fatalf("expected read error, got: %v", err)
+	}
+	// The pooled session must not be left with an active sign operation
+	if _, err := client.Sign(ctx, mech, 1, []byte("data")); err != nil {
+		t.Fatalf("Sign after failed stream: %v", err)
+	}
+}
+
+func TestEncryptStream_WriteErrorReleasesSession(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_CBC_PAD, nil)}
+	if err := client.EncryptStream(ctx, mech, 1, strings.NewReader("data"), failingWriter{}); err == nil {
+		t.Fatal("expected write error")
+	}
+	if _, err := client.Encrypt(ctx, mech, 1, []byte("data")); err != nil {
+		t.Fatalf("Encrypt after failed stream: %v", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestDigestKey(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	keyValue := []byte("0123456789abcdef")
+	key, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, keyValue),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256, nil)}
+	digest, err := client.DigestKey(ctx, mech, key)
+	if err != nil {
+		t.Fatalf("DigestKey failed: %v", err)
+	}
+	expected := sha256.Sum256(keyValue)
+	if !bytes.Equal(digest, expected[:]) {
+		t.Errorf("expected %x, got %x", expected, digest)
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_ECB_ENCRYPT_DATA, nil)}
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "derived"),
+	}
+	handle, err := client.DeriveKey(ctx, mech, 1, template)
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+
+	found, err := client.FindObjectByLabelAndClass(ctx, "derived", pkcs11.CKO_SECRET_KEY)
+	if err != nil {
+		t.Fatalf("FindObjectByLabelAndClass failed: %v", err)
+	}
+	if found != handle {
+		t.Errorf("expected handle %v, got %v", handle, found)
+	}
+
+	mock.DeriveKeyErr = pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	if _, err := client.DeriveKey(ctx, mech, 1, template); err == nil {
+		t.Fatal("expected error when derivation fails")
+	}
+}
+
+func TestResolveKeyRef(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	handle, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "second"),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	ref := &KeyRef{Label: "second", Class: pkcs11.CKO_SECRET_KEY}
+	if err := client.ResolveKeyRef(ctx, ref); err != nil {
+		t.Fatalf("ResolveKeyRef failed: %v", err)
+	}
+	if ref.Handle != handle {
+		t.Errorf("expected handle %v, got %v", handle, ref.Handle)
+	}
+
+	if err := client.ResolveKeyRef(ctx, &KeyRef{Label: "missing", Class: pkcs11.CKO_SECRET_KEY}); err == nil {
+		t.Fatal("expected error for missing key")
+	}
+}
+
+func TestGenerateRandom(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	random, err := client.GenerateRandom(ctx, 32)
+	if err != nil {
+		t.Fatalf("GenerateRandom failed: %v", err)
+	}
+	if len(random) != 32 {
+		t.Errorf("expected 32 bytes, got %d", len(random))
+	}
+
+	mock.RandomErr = pkcs11.Error(pkcs11.CKR_RANDOM_NO_RNG)
+	if _, err := client.GenerateRandom(ctx, 32); err == nil {
+		t.Fatal("expected error when token has no RNG")
+	}
+}
+
+func TestSeedRandom(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	if err := client.SeedRandom(ctx, []byte("entropy")); err != nil {
+		t.Fatalf("SeedRandom failed: %v", err)
+	}
+	if len(mock.Seeds) != 1 || string(mock.Seeds[0]) != "entropy" {
+		t.Errorf("expected seed to be passed to the token, got %q", mock.Seeds)
+	}
+
+	mock.RandomErr = pkcs11.Error(pkcs11.CKR_RANDOM_SEED_NOT_SUPPORTED)
+	if err := client.SeedRandom(ctx, []byte("entropy")); err == nil {
+		t.Fatal("expected error when seeding is not supported")
+	}
+}
+
+func TestRandomString(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	const alphabet = "abcdefghij"
+	s, err := client.RandomString(ctx, 64, alphabet)
+	if err != nil {
+		t.Fatalf("RandomString failed: %v", err)
+	}
+	if len(s) != 64 {
+		t.Errorf("expected 64 characters, got %d", len(s))
+	}
+	for _, ch := range s {
+		if !strings.ContainsRune(alphabet, ch) {
+			t.Errorf("unexpected character %q", ch)
+		}
+	}
+
+	if _, err := client.RandomString(ctx, 8, "aa"); err == nil {
+		t.Error("expected error for alphabet with duplicate characters")
+	}
+	if _, err := client.RandomString(ctx, 8, "a"); err == nil {
+		t.Error("expected error for alphabet with a single character")
+	}
+}
+
+func TestNewClientWithContext_DeferTokenResolution(t *testing.T) {
+	ctx := t.Context()
+	mock := NewMockContext("test-token")
+	mock.AddUninitializedSlot(1)
+	cfg := Config{
+		TokenLabel:           "new-token",
+		Pin:                  "1234",
+		SoPin:                "5678",
+		PoolSize:             2,
+		DeferTokenResolution: true,
+	}
+	client, err := NewClientWithContext(mock, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.GetTokenInfo(ctx); !errors.Is(err, ErrSlotNotFound) {
+		t.Fatalf("expected ErrSlotNotFound before initialization, got %v", err)
+	}
+
+	if err := client.InitToken(ctx, 1, "", "new-token"); err != nil {
+		t.Fatalf("InitToken failed: %v", err)
+	}
+	if err := client.InitPIN(ctx, 1, "", "1234"); err != nil {
+		t.Fatalf("InitPIN failed: %v", err)
+	}
+
+	info, err := client.GetTokenInfo(ctx)
+	if err != nil {
+		t.Fatalf("GetTokenInfo failed: %v", err)
+	}
+	if client.SlotID() != 1 || info.Label != "new-token" {
+		t.Errorf("expected token new-token in slot 1, got %q in slot %d", info.Label, client.SlotID())
+	}
+	if info.Flags&pkcs11.CKF_USER_PIN_INITIALIZED == 0 {
+		t.Error("expected user PIN to be initialized")
+	}
+}
+
+func TestInitToken_ClosesPooledSessions(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	if _, err := client.GenerateRandom(ctx, 8); err != nil {
+		t.Fatalf("GenerateRandom failed: %v", err)
+	}
+	if err := client.InitToken(ctx, 0, "5678", "test-token"); err != nil {
+		t.Fatalf("InitToken failed: %v", err)
+	}
+	if len(mock.sessions) != 0 {
+		t.Errorf("expected no open sessions, got %d", len(mock.sessions))
+	}
+
+	// Re-initialization requires the current SO PIN
+	if err := client.InitToken(ctx, 0, "0000", "test-token"); err == nil {
+		t.Fatal("expected error for wrong SO PIN")
+	}
+	if err := client.InitPIN(ctx, 0, "0000", "1234"); !errors.Is(err, ErrPinIncorrect) {
+		t.Fatalf("expected ErrPinIncorrect, got %v", err)
+	}
+}
+
+func TestInitPIN_WaitsForSessionsInUse(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+	mock.slots[0].soPin = "5678"
+
+	sh, err := client.pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- client.InitPIN(ctx, 0, "5678", "4321")
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("InitPIN returned while a user session is in use: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	client.pool.Put(sh)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("InitPIN failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("InitPIN did not return after the session was put back")
+	}
+	if mock.slots[0].userPin != "4321" {
+		t.Errorf("expected user PIN to be set, got %q", mock.slots[0].userPin)
+	}
+}
+
+func TestInitToken_WaitsForSessionsInUse(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	sh, err := client.pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- client.InitToken(ctx, 0, "5678", "test-token")
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("InitToken returned while a session is in use: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	client.pool.Put(sh)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("InitToken failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("InitToken did not return after the session was put back")
+	}
+	if n := mock.OpenSessionCount(); n != 0 {
+		t.Errorf("expected no open sessions, got %d", n)
+	}
+}
+
+func TestInitToken_InvalidArguments(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	if err := client.InitToken(ctx, 0, "", "test-token"); err == nil {
+		t.Error("expected error without SO PIN")
+	}
+	if err := client.InitToken(ctx, 0, "5678", strings.Repeat("x", 33)); err == nil {
+		t.Error("expected error for label longer than 32 bytes")
+	}
+}
+
+func TestSetPIN_User(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+	mock.slots[0].userPin = "1234"
+
+	if _, err := client.GenerateRandom(ctx, 8); err != nil {
+		t.Fatalf("GenerateRandom failed: %v", err)
+	}
+	if err := client.SetPIN(ctx, pkcs11.CKU_USER, "", "4321"); err != nil {
+		t.Fatalf("SetPIN failed: %v", err)
+	}
+	if mock.slots[0].userPin != "4321" {
+		t.Errorf("expected user PIN to be changed, got %q", mock.slots[0].userPin)
+	}
+
+	// The pool must log in again with the new PIN
+	if _, err := client.GenerateRandom(ctx, 8); err != nil {
+		t.Fatalf("GenerateRandom after PIN change failed: %v", err)
+	}
+	for sh, sess := range mock.sessions {
+		if !sess.loggedIn {
+			t.Errorf("expected session %d to be logged in with the new PIN", sh)
+		}
+	}
+
+	if err := client.SetPIN(ctx, pkcs11.CKU_USER, "1234", "0000"); err == nil {
+		t.Fatal("expected error for wrong old PIN")
+	}
+}
+
+func TestSetPIN_SO(t *testing.T) {
+	ctx := t.Context()
+	mock := NewMockContext("test-token")
+	mock.slots[0].soPin = "5678"
+	cfg := Config{
+		TokenLabel: "test-token",
+		Pin:        "1234",
+		SoPin:      "5678",
+		PoolSize:   2,
+	}
+	client, err := NewClientWithContext(mock, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.GenerateRandom(ctx, 8); err != nil {
+		t.Fatalf("GenerateRandom failed: %v", err)
+	}
+	if err := client.SetPIN(ctx, pkcs11.CKU_SO, "", "8765"); err != nil {
+		t.Fatalf("SetPIN failed: %v", err)
+	}
+	if mock.slots[0].soPin != "8765" {
+		t.Errorf("expected SO PIN to be changed, got %q", mock.slots[0].soPin)
+	}
+
+	// The configured SO PIN is updated for later SO operations
+	if err := client.InitPIN(ctx, 0, "", "1111"); err != nil {
+		t.Fatalf("InitPIN with rotated SO PIN failed: %v", err)
+	}
+
+	if err := client.SetPIN(ctx, pkcs11.CKU_CONTEXT_SPECIFIC, "", "0000"); err == nil {
+		t.Fatal("expected error for unsupported user type")
+	}
+}
+
+func TestWithSession_CancelledContext(t *testing.T) {
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if _, err := client.GenerateRandom(ctx, 8); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+	if n := mock.OpenSessionCount(); n != 0 {
+		t.Errorf("expected no session to be opened, got %d", n)
+	}
+}
+
+func TestWithSession_TimeoutWaitingForSession(t *testing.T) {
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	// Hold all sessions of the pool
+	for i := 0; i < client.pool.Size(); i++ {
+		if _, err := client.pool.Get(t.Context()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.GenerateRandom(ctx, 8); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got: %v", err)
+	}
+}
+
+func TestWithSession_AbandonsHungCall(t *testing.T) {
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	key, err := client.CreateObject(t.Context(), []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "key"),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	unblock := make(chan struct{})
+	mock.SignFunc = func(_ *pkcs11.Mechanism, _ []byte) ([]byte, error) {
+		<-unblock
+		return []byte("late-signature"), nil
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	_, err = client.Sign(ctx, mech, key, []byte("data"))
+	if !errors.Is(err, ErrOperationAbandoned) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected abandoned operation, got: %v", err)
+	}
+
+	// The session of the abandoned call is discarded once the call returns
+	close(unblock)
+	deadline := time.Now().Add(time.Second)
+	for mock.OpenSessionCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session of the abandoned call was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mock.SignFunc = nil
+	for i := 0; i < client.pool.Size(); i++ {
+		if _, err := client.Sign(t.Context(), mech, key, []byte("data")); err != nil {
+			t.Fatalf("Sign after abandoned call failed: %v", err)
+		}
+	}
+}
+
+func TestNewMechanism_FreedAfterAbandonedCall(t *testing.T) {
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	key, err := client.CreateObject(t.Context(), []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "key"),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	mech, freeMech, err := NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS_PSS, &MechanismParams{
+		PSS: &PSSParams{Hash: pkcs11.CKM_SHA256},
+	})
+	if err != nil {
+		t.Fatalf("NewMechanism failed: %v", err)
+	}
+	unblock := make(chan struct{})
+	mock.SignFunc = func(m *pkcs11.Mechanism, _ []byte) ([]byte, error) {
+		<-unblock
+		if _, ok := mechanismParams.Load(m); !ok {
+			t.Error("mechanism was freed while the call was running")
+		}
+		return []byte("late-signature"), nil
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Sign(ctx, mech, key, []byte("data")); !errors.Is(err, ErrOperationAbandoned) {
+		t.Fatalf("expected abandoned operation, got: %v", err)
+	}
+	freeMech()
+	if _, ok := mechanismParams.Load(mech[0]); !ok {
+		t.Fatal("mechanism was freed before the abandoned call returned")
+	}
+
+	close(unblock)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := mechanismParams.Load(mech[0]); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("mechanism was not freed after the abandoned call returned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
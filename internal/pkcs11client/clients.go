@@ -0,0 +1,85 @@
+package pkcs11client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultToken is the name of the token configured at the top level of the
+// provider configuration, as opposed to the entries of the tokens map.
+const DefaultToken = ""
+
+// Clients holds one Client per configured token. Each client has its own
+// session pool; tokens that are accessed through the same module share a
+// single loaded and initialized module, also with other provider instances.
+type Clients struct {
+	clients map[string]*Client
+}
+
+// NewClients creates a client for each named token configuration. The
+// configuration of the default token, if any, is registered as DefaultToken.
+// Every module is wrapped with middlewares, see Chain.
+func NewClients(configs map[string]Config, middlewares ...Middleware) (*Clients, error) {
+	return newClients(modules, configs, middlewares...)
+}
+
+// newClients creates the clients with the modules of registry.
+func newClients(registry *moduleRegistry, configs map[string]Config, middlewares ...Middleware) (*Clients, error) {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cs := &Clients{clients: make(map[string]*Client, len(configs))}
+	for _, name := range names {
+		cfg := configs[name]
+		ctx, release, err := registry.acquire(cfg)
+		if err != nil {
+			cs.Close()
+			return nil, err
+		}
+
+		client, err := newClient(Chain(ctx, middlewares...), cfg, release)
+		if err != nil {
+			cs.Close()
+			if name == DefaultToken {
+				return nil, err
+			}
+			return nil, fmt.Errorf("token %q: %w", name, err)
+		}
+		cs.clients[name] = client
+	}
+	return cs, nil
+}
+
+// Get returns the client of the named token, or of the default token if name is empty.
+func (cs *Clients) Get(name string) (*Client, error) {
+	if client, ok := cs.clients[name]; ok {
+		return client, nil
+	}
+
+	var names []string
+	for n := range cs.clients {
+		if n != DefaultToken {
+			names = append(names, fmt.Sprintf("%q", n))
+		}
+	}
+	sort.Strings(names)
+	configured := "none"
+	if len(names) > 0 {
+		configured = strings.Join(names, ", ")
+	}
+	if name == DefaultToken {
+		return nil, fmt.Errorf("no default token is configured, select one of the configured tokens: %s", configured)
+	}
+	return nil, fmt.Errorf("token %q is not configured, configured tokens: %s", name, configured)
+}
+
+// Close closes all clients.
+func (cs *Clients) Close() {
+	for _, client := range cs.clients {
+		client.Close()
+	}
+}
@@ -0,0 +1,131 @@
+package pkcs11client
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewClients_SharesModule(t *testing.T) {
+	mock := NewMockContext("source")
+	mock.AddUninitializedSlot(1)
+	slotID := uint(1)
+
+	loads := 0
+	clients, err := newClients(newModuleRegistry(func(path string) (Pkcs11Context, error) {
+		loads++
+		return mock, nil
+	}), map[string]Config{
+		DefaultToken:  {ModulePath: "a.so", TokenLabel: "source", PoolSize: 2},
+		"destination": {ModulePath: "a.so", SlotID: &slotID, PoolSize: 2},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if loads != 1 {
+		t.Errorf("expected module to be loaded once, got %d", loads)
+	}
+
+	source, err := clients.Get("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	destination, err := clients.Get("destination")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if source.SlotID() != 0 || destination.SlotID() != 1 {
+		t.Errorf("expected slots 0 and 1, got %d and %d", source.SlotID(), destination.SlotID())
+	}
+	if source.pool == destination.pool {
+		t.Error("expected each token to have its own session pool")
+	}
+
+	source.Close()
+	if !mock.initialized {
+		t.Error("module finalized while still in use by another token")
+	}
+	clients.Close()
+	if mock.initialized {
+		t.Error("expected module to be finalized after closing all tokens")
+	}
+}
+
+func TestNewClients_SeparateModules(t *testing.T) {
+	mocks := map[string]*MockContext{
+		"a.so": NewMockContext("a"),
+		"b.so": NewMockContext("b"),
+	}
+
+	clients, err := newClients(newModuleRegistry(func(path string) (Pkcs11Context, error) {
+		return mocks[path], nil
+	}), map[string]Config{
+		"a": {ModulePath: "a.so", TokenLabel: "a", PoolSize: 2},
+		"b": {ModulePath: "b.so", TokenLabel: "b", PoolSize: 2},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer clients.Close()
+
+	for name, mock := range mocks {
+		if !mock.initialized {
+			t.Errorf("expected module %s to be initialized", name)
+		}
+	}
+}
+
+func TestNewClients_ErrorNamesToken(t *testing.T) {
+	mock := NewMockContext("a")
+	_, err := newClients(newModuleRegistry(func(path string) (Pkcs11Context, error) {
+		return mock, nil
+	}), map[string]Config{
+		"a":       {ModulePath: "a.so", TokenLabel: "a", PoolSize: 2},
+		"missing": {ModulePath: "a.so", TokenLabel: "nonexistent", PoolSize: 2},
+	})
+	if err == nil {
+		t.Fatal("expected error for nonexistent token")
+	}
+	if !strings.Contains(err.Error(), `token "missing"`) {
+		t.Errorf("expected error to name the token, got: %v", err)
+	}
+	if mock.initialized {
+		t.Error("expected module to be finalized after failure")
+	}
+}
+
+func TestNewClients_LoadError(t *testing.T) {
+	loadErr := errors.New("load failed")
+	_, err := newClients(newModuleRegistry(func(path string) (Pkcs11Context, error) {
+		return nil, loadErr
+	}), map[string]Config{
+		DefaultToken: {ModulePath: "a.so", TokenLabel: "a"},
+	})
+	if !errors.Is(err, loadErr) {
+		t.Errorf("expected load error, got: %v", err)
+	}
+}
+
+func TestClients_Get(t *testing.T) {
+	mock := NewMockContext("a")
+	clients, err := newClients(newModuleRegistry(func(path string) (Pkcs11Context, error) {
+		return mock, nil
+	}), map[string]Config{
+		"a": {ModulePath: "a.so", TokenLabel: "a", PoolSize: 2},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer clients.Close()
+
+	if _, err := clients.Get("a"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := clients.Get("b"); err == nil || !strings.Contains(err.Error(), `"a"`) {
+		t.Errorf("expected error listing configured tokens, got: %v", err)
+	}
+	if _, err := clients.Get(""); err == nil || !strings.Contains(err.Error(), "no default token") {
+		t.Errorf("expected error for missing default token, got: %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package pkcs11client
+
+/*
+#include <stdlib.h>
+#include <string.h>
+
+// Mirrors of PKCS#11 parameter structures for which miekg/pkcs11 has no
+// helper. Field pointers must reference C memory, so they are built here.
+typedef unsigned long ck_ulong;
+typedef unsigned char ck_byte;
+typedef unsigned char ck_bbool;
+
+typedef struct {
+	ck_ulong ulDataLen;
+	ck_byte *pNonce;
+	ck_ulong ulNonceLen;
+	ck_byte *pAAD;
+	ck_ulong ulAADLen;
+	ck_ulong ulMACLen;
+} ck_ccm_params;
+
+typedef struct {
+	ck_ulong hashAlg;
+	ck_ulong mgf;
+	ck_ulong source;
+	void *pSourceData;
+	ck_ulong ulSourceDataLen;
+} ck_rsa_pkcs_oaep_params;
+
+typedef struct {
+	ck_bbool bExtract;
+	ck_bbool bExpand;
+	ck_ulong prfHashMechanism;
+	ck_ulong ulSaltType;
+	ck_byte *pSalt;
+	ck_ulong ulSaltLen;
+	ck_ulong hSaltKey;
+	ck_byte *pInfo;
+	ck_ulong ulInfoLen;
+} ck_hkdf_params;
+
+typedef struct {
+	ck_ulong type;
+	void *pValue;
+	ck_ulong ulValueLen;
+} ck_prf_data_param;
+
+typedef struct {
+	ck_bbool bLittleEndian;
+	ck_ulong ulWidthInBits;
+} ck_sp800_108_counter_format;
+
+typedef struct {
+	ck_ulong dkmLengthMethod;
+	ck_bbool bLittleEndian;
+	ck_ulong ulWidthInBits;
+} ck_sp800_108_dkm_length_format;
+
+typedef struct {
+	ck_ulong prfType;
+	ck_ulong ulNumberOfDataParams;
+	ck_prf_data_param *pDataParams;
+	ck_ulong ulAdditionalDerivedKeys;
+	void *pAdditionalDerivedKeys;
+} ck_sp800_108_kdf_params;
+
+typedef struct {
+	ck_ulong prfType;
+	ck_ulong ulNumberOfDataParams;
+	ck_prf_data_param *pDataParams;
+	ck_ulong ulIVLen;
+	ck_byte *pIV;
+	ck_ulong ulAdditionalDerivedKeys;
+	void *pAdditionalDerivedKeys;
+} ck_sp800_108_feedback_kdf_params;
+
+typedef struct {
+	ck_byte *pData;
+	ck_ulong ulLen;
+} ck_key_derivation_string_data;
+
+typedef struct {
+	ck_byte iv[8];
+	ck_byte *pData;
+	ck_ulong length;
+} ck_des_cbc_encrypt_data_params;
+
+typedef struct {
+	ck_byte iv[16];
+	ck_byte *pData;
+	ck_ulong length;
+} ck_aes_cbc_encrypt_data_params;
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS#11 v3.0 constants used in derivation parameter structures.
+const (
+	ckfHKDFSaltNull = 0x00000001
+	ckfHKDFSaltData = 0x00000002
+
+	ckSP800108IterationVariable = 0x00000001
+	ckSP800108OptionalCounter   = 0x00000002
+	ckSP800108DKMLength         = 0x00000003
+	ckSP800108ByteArray         = 0x00000004
+
+	ckSP800108DKMLengthSumOfKeys = 0x00000001
+)
+
+// cArena tracks C allocations referenced from a marshaled parameter structure.
+type cArena []unsafe.Pointer
+
+// alloc copies b into C memory. Empty slices yield a nil pointer.
+func (a *cArena) alloc(b []byte) (*C.ck_byte, C.ck_ulong) {
+	if len(b) == 0 {
+		return nil, 0
+	}
+	p := C.CBytes(b)
+	*a = append(*a, p)
+	return (*C.ck_byte)(p), C.ck_ulong(len(b))
+}
+
+// allocStruct copies a C structure of the given size into C memory.
+func (a *cArena) allocStruct(p unsafe.Pointer, size uintptr) unsafe.Pointer {
+	dst := a.malloc(size)
+	C.memcpy(dst, p, C.size_t(size))
+	return dst
+}
+
+// malloc allocates size bytes of C memory.
+func (a *cArena) malloc(size uintptr) unsafe.Pointer {
+	p := C.malloc(C.size_t(size))
+	*a = append(*a, p)
+	return p
+}
+
+// free releases all allocations held by the arena.
+func (a *cArena) free() {
+	for _, p := range *a {
+		C.free(p)
+	}
+	*a = nil
+}
+
+// structBytes returns a copy of the memory backing a C parameter structure.
+func structBytes(p unsafe.Pointer, size uintptr) []byte {
+	return C.GoBytes(p, C.int(size))
+}
+
+func cBool(b bool) C.ck_bbool {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// marshalCCMParams encodes CK_CCM_PARAMS.
+func marshalCCMParams(p *CCMParams) ([]byte, func()) {
+	arena := &cArena{}
+	params := C.ck_ccm_params{
+		ulDataLen: C.ck_ulong(p.DataLen),
+		ulMACLen:  C.ck_ulong(p.MACLen),
+	}
+	params.pNonce, params.ulNonceLen = arena.alloc(p.Nonce)
+	params.pAAD, params.ulAADLen = arena.alloc(p.AAD)
+	return structBytes(unsafe.Pointer(&params), unsafe.Sizeof(params)), arena.free
+}
+
+// marshalOAEPParams encodes CK_RSA_PKCS_OAEP_PARAMS. CKZ_DATA_SPECIFIED is
+// the only source type defined, so it is also passed without source data,
+// as a NULL pointer and length 0.
+func marshalOAEPParams(hash, mgf uint, sourceData []byte) ([]byte, func()) {
+	arena := &cArena{}
+	params := C.ck_rsa_pkcs_oaep_params{
+		hashAlg: C.ck_ulong(hash),
+		mgf:     C.ck_ulong(mgf),
+		source:  C.ck_ulong(pkcs11.CKZ_DATA_SPECIFIED),
+	}
+	data, length := arena.alloc(sourceData)
+	params.pSourceData, params.ulSourceDataLen = unsafe.Pointer(data), length
+	return structBytes(unsafe.Pointer(&params), unsafe.Sizeof(params)), arena.free
+}
+
+// marshalHKDFParams encodes CK_HKDF_PARAMS. The salt type is derived from
+// whether salt data is present.
+func marshalHKDFParams(p *HKDFParams) ([]byte, func()) {
+	arena := &cArena{}
+	params := C.ck_hkdf_params{
+		bExtract:         cBool(p.Extract),
+		bExpand:          cBool(p.Expand),
+		prfHashMechanism: C.ck_ulong(p.PRF),
+		ulSaltType:       ckfHKDFSaltNull,
+	}
+	if len(p.Salt) > 0 {
+		params.ulSaltType = ckfHKDFSaltData
+		params.pSalt, params.ulSaltLen = arena.alloc(p.Salt)
+	}
+	params.pInfo, params.ulInfoLen = arena.alloc(p.Info)
+	return structBytes(unsafe.Pointer(&params), unsafe.Sizeof(params)), arena.free
+}
+
+// marshalSP800108Params encodes CK_SP800_108_KDF_PARAMS, or
+// CK_SP800_108_FEEDBACK_KDF_PARAMS in feedback mode. The PRF input is laid
+// out as recommended by NIST SP 800-108: [i] || fixed_input || [L] in counter
+// mode, and K(i-1) || [i] || fixed_input || [L] in feedback and double pipeline
+// mode, where [i] is omitted if CounterBits is 0.
+func marshalSP800108Params(p *SP800108Params, counterMode, feedbackMode bool) ([]byte, func()) {
+	arena := &cArena{}
+
+	var dataParams []C.ck_prf_data_param
+	counter := C.ck_sp800_108_counter_format{ulWidthInBits: C.ck_ulong(p.CounterBits)}
+	counterParam := func(dataType C.ck_ulong) C.ck_prf_data_param {
+		return C.ck_prf_data_param{
+			_type:      dataType,
+			pValue:     arena.allocStruct(unsafe.Pointer(&counter), unsafe.Sizeof(counter)),
+			ulValueLen: C.ck_ulong(unsafe.Sizeof(counter)),
+		}
+	}
+	if counterMode {
+		dataParams = append(dataParams, counterParam(ckSP800108IterationVariable))
+	} else {
+		dataParams = append(dataParams, C.ck_prf_data_param{_type: ckSP800108IterationVariable})
+		if p.CounterBits > 0 {
+			dataParams = append(dataParams, counterParam(ckSP800108OptionalCounter))
+		}
+	}
+	if len(p.FixedInput) > 0 {
+		data, length := arena.alloc(p.FixedInput)
+		dataParams = append(dataParams, C.ck_prf_data_param{
+			_type:      ckSP800108ByteArray,
+			pValue:     unsafe.Pointer(data),
+			ulValueLen: length,
+		})
+	}
+	dkm := C.ck_sp800_108_dkm_length_format{
+		dkmLengthMethod: ckSP800108DKMLengthSumOfKeys,
+		ulWidthInBits:   C.ck_ulong(p.DKMLengthBits),
+	}
+	dataParams = append(dataParams, C.ck_prf_data_param{
+		_type:      ckSP800108DKMLength,
+		pValue:     arena.allocStruct(unsafe.Pointer(&dkm), unsafe.Sizeof(dkm)),
+		ulValueLen: C.ck_ulong(unsafe.Sizeof(dkm)),
+	})
+
+	paramSize := unsafe.Sizeof(C.ck_prf_data_param{})
+	array := arena.malloc(paramSize * uintptr(len(dataParams)))
+	copy(unsafe.Slice((*C.ck_prf_data_param)(array), len(dataParams)), dataParams)
+
+	if feedbackMode {
+		params := C.ck_sp800_108_feedback_kdf_params{
+			prfType:              C.ck_ulong(p.PRF),
+			ulNumberOfDataParams: C.ck_ulong(len(dataParams)),
+			pDataParams:          (*C.ck_prf_data_param)(array),
+		}
+		params.pIV, params.ulIVLen = arena.alloc(p.IV)
+		return structBytes(unsafe.Pointer(&params), unsafe.Sizeof(params)), arena.free
+	}
+	params := C.ck_sp800_108_kdf_params{
+		prfType:              C.ck_ulong(p.PRF),
+		ulNumberOfDataParams: C.ck_ulong(len(dataParams)),
+		pDataParams:          (*C.ck_prf_data_param)(array),
+	}
+	return structBytes(unsafe.Pointer(&params), unsafe.Sizeof(params)), arena.free
+}
+
+// marshalKeyDerivationStringData encodes CK_KEY_DERIVATION_STRING_DATA.
+func marshalKeyDerivationStringData(data []byte) ([]byte, func()) {
+	arena := &cArena{}
+	var params C.ck_key_derivation_string_data
+	params.pData, params.ulLen = arena.alloc(data)
+	return structBytes(unsafe.Pointer(&params), unsafe.Sizeof(params)), arena.free
+}
+
+// marshalCBCEncryptDataParams encodes CK_DES_CBC_ENCRYPT_DATA_PARAMS (8-byte IV)
+// or CK_AES_CBC_ENCRYPT_DATA_PARAMS (16-byte IV), which is also used by the
+// Camellia, ARIA and SEED variants.
+func marshalCBCEncryptDataParams(iv, data []byte) ([]byte, func(), error) {
+	arena := &cArena{}
+	switch len(iv) {
+	case 8:
+		var params C.ck_des_cbc_encrypt_data_params
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(&params.iv[0])), 8), iv)
+		params.pData, params.length = arena.alloc(data)
+		return structBytes(unsafe.Pointer(&params), unsafe.Sizeof(params)), arena.free, nil
+	case 16:
+		var params C.ck_aes_cbc_encrypt_data_params
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(&params.iv[0])), 16), iv)
+		params.pData, params.length = arena.alloc(data)
+		return structBytes(unsafe.Pointer(&params), unsafe.Sizeof(params)), arena.free, nil
+	}
+	return nil, nil, fmt.Errorf("%w: CBC encrypt data IV must be 8 or 16 bytes, got %d", ErrMechanismInvalid, len(iv))
+}
+
+// marshalObjectHandle encodes a CK_OBJECT_HANDLE parameter.
+func marshalObjectHandle(handle uint) []byte {
+	h := C.ck_ulong(handle)
+	return structBytes(unsafe.Pointer(&h), unsafe.Sizeof(h))
+}
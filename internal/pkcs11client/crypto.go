@@ -1,13 +1,20 @@
 package pkcs11client
 
 import (
+	"context"
+	"errors"
+	"io"
+
 	"github.com/miekg/pkcs11"
 )
 
+// streamChunkSize is the amount of data passed to each C_*Update call when streaming.
+const streamChunkSize = 64 * 1024
+
 // Encrypt encrypts plaintext using the specified key and mechanism.
-func (c *Client) Encrypt(mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle, plaintext []byte) ([]byte, error) {
+func (c *Client) Encrypt(ctx context.Context, mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle, plaintext []byte) ([]byte, error) {
 	var ciphertext []byte
-	err := c.withSession(func(sh pkcs11.SessionHandle) error {
+	err := c.withSession(ctx, func(sh pkcs11.SessionHandle) error {
 		if err := c.ctx.EncryptInit(sh, mechanism, key); err != nil {
 			return wrapError("EncryptInit", err)
 		}
@@ -15,33 +22,217 @@ func (c *Client) Encrypt(mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle,
 		ciphertext, encErr = c.ctx.Encrypt(sh, plaintext)
 		return wrapError("Encrypt", encErr)
 	})
-	return ciphertext, err
+	if err != nil {
+		return nil, err
+	}
+	return ciphertext, nil
 }
 
-// Decrypt decrypts ciphertext using the specified key and mechanism.
-func (c *Client) Decrypt(mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle, ciphertext []byte) ([]byte, error) {
+// Decrypt decrypts ciphertext using the specified key and mechanism. Keys
+// that require it are logged in to with a context-specific login, see WithKeyPin.
+func (c *Client) Decrypt(ctx context.Context, mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle, ciphertext []byte) ([]byte, error) {
 	var plaintext []byte
-	err := c.withSession(func(sh pkcs11.SessionHandle) error {
-		if err := c.ctx.DecryptInit(sh, mechanism, key); err != nil {
-			return wrapError("DecryptInit", err)
+	err := c.withSession(ctx, func(sh pkcs11.SessionHandle) error {
+		return c.runAuthenticated(ctx, sh, key, true, func(login func() error) error {
+			if err := c.ctx.DecryptInit(sh, mechanism, key); err != nil {
+				return wrapError("DecryptInit", err)
+			}
+			if err := login(); err != nil {
+				c.ctx.DecryptFinal(sh)
+				return err
+			}
+			var decErr error
+			plaintext, decErr = c.ctx.Decrypt(sh, ciphertext)
+			return wrapError("Decrypt", decErr)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// Sign signs data using the specified key and mechanism. Keys that require
+// it are logged in to with a context-specific login, see WithKeyPin.
+func (c *Client) Sign(ctx context.Context, mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle, data []byte) ([]byte, error) {
+	var signature []byte
+	err := c.withSession(ctx, func(sh pkcs11.SessionHandle) error {
+		return c.runAuthenticated(ctx, sh, key, true, func(login func() error) error {
+			if err := c.ctx.SignInit(sh, mechanism, key); err != nil {
+				return wrapError("SignInit", err)
+			}
+			if err := login(); err != nil {
+				c.ctx.SignFinal(sh)
+				return err
+			}
+			var signErr error
+			signature, signErr = c.ctx.Sign(sh, data)
+			return wrapError("Sign", signErr)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signature, nil
+}
+
+// EncryptStream encrypts everything read from r using C_EncryptUpdate and
+// C_EncryptFinal and writes the ciphertext to w, so neither the plaintext nor
+// the ciphertext needs to fit into memory or into a single token call.
+func (c *Client) EncryptStream(ctx context.Context, mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle, r io.Reader, w io.Writer) error {
+	return c.withSessionOnce(ctx, func(sh pkcs11.SessionHandle) error {
+		if err := c.ctx.EncryptInit(sh, mechanism, key); err != nil {
+			return wrapError("EncryptInit", err)
+		}
+		err := streamChunks(ctx, r, func(chunk []byte) error {
+			out, err := c.ctx.EncryptUpdate(sh, chunk)
+			if err != nil {
+				return wrapError("EncryptUpdate", err)
+			}
+			_, err = w.Write(out)
+			return err
+		}, func() {
+			c.ctx.EncryptFinal(sh)
+		})
+		if err != nil {
+			return err
 		}
-		var decErr error
-		plaintext, decErr = c.ctx.Decrypt(sh, ciphertext)
-		return wrapError("Decrypt", decErr)
+		out, err := c.ctx.EncryptFinal(sh)
+		if err != nil {
+			return wrapError("EncryptFinal", err)
+		}
+		_, err = w.Write(out)
+		return err
 	})
-	return plaintext, err
 }
 
-// Sign signs data using the specified key and mechanism.
-func (c *Client) Sign(mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle, data []byte) ([]byte, error) {
+// DecryptStream decrypts everything read from r using C_DecryptUpdate and
+// C_DecryptFinal and writes the plaintext to w.
+func (c *Client) DecryptStream(ctx context.Context, mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle, r io.Reader, w io.Writer) error {
+	return c.withSessionOnce(ctx, func(sh pkcs11.SessionHandle) error {
+		return c.runAuthenticated(ctx, sh, key, false, func(login func() error) error {
+			if err := c.ctx.DecryptInit(sh, mechanism, key); err != nil {
+				return wrapError("DecryptInit", err)
+			}
+			if err := login(); err != nil {
+				c.ctx.DecryptFinal(sh)
+				return err
+			}
+			err := streamChunks(ctx, r, func(chunk []byte) error {
+				out, err := c.ctx.DecryptUpdate(sh, chunk)
+				if err != nil {
+					return wrapError("DecryptUpdate", err)
+				}
+				_, err = w.Write(out)
+				return err
+			}, func() {
+				c.ctx.DecryptFinal(sh)
+			})
+			if err != nil {
+				return err
+			}
+			out, err := c.ctx.DecryptFinal(sh)
+			if err != nil {
+				return wrapError("DecryptFinal", err)
+			}
+			_, err = w.Write(out)
+			return err
+		})
+	})
+}
+
+// SignReader signs everything read from r using C_SignUpdate and C_SignFinal,
+// so the input does not need to fit into memory. The mechanism must support
+// multi-part signing, e.g. CKM_SHA256_RSA_PKCS or CKM_ECDSA_SHA256.
+func (c *Client) SignReader(ctx context.Context, mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle, r io.Reader) ([]byte, error) {
 	var signature []byte
-	err := c.withSession(func(sh pkcs11.SessionHandle) error {
-		if err := c.ctx.SignInit(sh, mechanism, key); err != nil {
-			return wrapError("SignInit", err)
+	err := c.withSessionOnce(ctx, func(sh pkcs11.SessionHandle) error {
+		return c.runAuthenticated(ctx, sh, key, false, func(login func() error) error {
+			if err := c.ctx.SignInit(sh, mechanism, key); err != nil {
+				return wrapError("SignInit", err)
+			}
+			if err := login(); err != nil {
+				c.ctx.SignFinal(sh)
+				return err
+			}
+			err := streamChunks(ctx, r, func(chunk []byte) error {
+				return wrapError("SignUpdate", c.ctx.SignUpdate(sh, chunk))
+			}, func() {
+				c.ctx.SignFinal(sh)
+			})
+			if err != nil {
+				return err
+			}
+			var signErr error
+			signature, signErr = c.ctx.SignFinal(sh)
+			return wrapError("SignFinal", signErr)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signature, nil
+}
+
+// Verify verifies a signature over data using the specified key and mechanism.
+// An invalid signature is reported as false rather than as an error; errors are
+// returned only if the verification could not be performed.
+func (c *Client) Verify(ctx context.Context, mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle, data, signature []byte) (bool, error) {
+	valid := false
+	err := c.withSession(ctx, func(sh pkcs11.SessionHandle) error {
+		if err := c.ctx.VerifyInit(sh, mechanism, key); err != nil {
+			return wrapError("VerifyInit", err)
 		}
-		var signErr error
-		signature, signErr = c.ctx.Sign(sh, data)
-		return wrapError("Sign", signErr)
+		verifyErr := c.ctx.Verify(sh, data, signature)
+		if isSignatureInvalid(verifyErr) {
+			return nil
+		}
+		if verifyErr != nil {
+			return wrapError("Verify", verifyErr)
+		}
+		valid = true
+		return nil
 	})
-	return signature, err
+	if err != nil {
+		return false, err
+	}
+	return valid, nil
+}
+
+// isSignatureInvalid returns true if err reports a signature that does not match the data.
+func isSignatureInvalid(err error) bool {
+	var p11err pkcs11.Error
+	if !errors.As(err, &p11err) {
+		return false
+	}
+	return p11err == pkcs11.CKR_SIGNATURE_INVALID || p11err == pkcs11.CKR_SIGNATURE_LEN_RANGE
+}
+
+// streamChunks passes everything read from r to update in chunks of at most
+// streamChunkSize bytes, stopping early once ctx is done. On error, abort is
+// called to terminate the active operation so that the session can be
+// reused. A failed C_*Update call has already terminated it, but a read or
+// write error or a cancellation has not.
+func streamChunks(ctx context.Context, r io.Reader, update func(chunk []byte) error, abort func()) error {
+	buf := make([]byte, streamChunkSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			abort()
+			return err
+		}
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			if err := update(buf[:n]); err != nil {
+				abort()
+				return err
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			return nil
+		}
+		if readErr != nil {
+			abort()
+			return readErr
+		}
+	}
 }
@@ -0,0 +1,72 @@
+package pkcs11client
+
+import (
+	"context"
+	"io"
+
+	"github.com/miekg/pkcs11"
+)
+
+// Digest hashes data in a single C_Digest call.
+func (c *Client) Digest(ctx context.Context, mechanism []*pkcs11.Mechanism, data []byte) ([]byte, error) {
+	var digest []byte
+	err := c.withSession(ctx, func(sh pkcs11.SessionHandle) error {
+		if err := c.ctx.DigestInit(sh, mechanism); err != nil {
+			return wrapError("DigestInit", err)
+		}
+		var digestErr error
+		digest, digestErr = c.ctx.Digest(sh, data)
+		return wrapError("Digest", digestErr)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+// DigestReader hashes everything read from r using C_DigestUpdate and C_DigestFinal,
+// so the input does not need to fit into memory.
+func (c *Client) DigestReader(ctx context.Context, mechanism []*pkcs11.Mechanism, r io.Reader) ([]byte, error) {
+	var digest []byte
+	err := c.withSessionOnce(ctx, func(sh pkcs11.SessionHandle) error {
+		if err := c.ctx.DigestInit(sh, mechanism); err != nil {
+			return wrapError("DigestInit", err)
+		}
+		err := streamChunks(ctx, r, func(chunk []byte) error {
+			return wrapError("DigestUpdate", c.ctx.DigestUpdate(sh, chunk))
+		}, func() {
+			c.ctx.DigestFinal(sh)
+		})
+		if err != nil {
+			return err
+		}
+		var digestErr error
+		digest, digestErr = c.ctx.DigestFinal(sh)
+		return wrapError("DigestFinal", digestErr)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+// DigestKey hashes the value of a secret key on the token via C_DigestKey,
+// yielding a fingerprint of the key without extracting it.
+func (c *Client) DigestKey(ctx context.Context, mechanism []*pkcs11.Mechanism, key pkcs11.ObjectHandle) ([]byte, error) {
+	var digest []byte
+	err := c.withSession(ctx, func(sh pkcs11.SessionHandle) error {
+		if err := c.ctx.DigestInit(sh, mechanism); err != nil {
+			return wrapError("DigestInit", err)
+		}
+		if err := c.ctx.DigestKey(sh, key); err != nil {
+			return wrapError("DigestKey", err)
+		}
+		var digestErr error
+		digest, digestErr = c.ctx.DigestFinal(sh)
+		return wrapError("DigestFinal", digestErr)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
@@ -0,0 +1,197 @@
+package pkcs11client
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/miekg/pkcs11"
+)
+
+var (
+	oidECPublicKey = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidX25519      = asn1.ObjectIdentifier{1, 3, 101, 110}
+	oidX448        = asn1.ObjectIdentifier{1, 3, 101, 111}
+	oidEd25519     = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// curveInfo describes a named curve that can be used with CKM_ECDH1_DERIVE.
+type curveInfo struct {
+	name       string
+	fieldBytes int  // Length of a field element in bytes
+	montgomery bool // Points are a single coordinate without a format byte
+}
+
+var curveInfos = map[string]curveInfo{
+	"1.2.840.10045.3.1.7":   {"secp256r1", 32, false},
+	"1.3.132.0.33":          {"secp224r1", 28, false},
+	"1.3.132.0.34":          {"secp384r1", 48, false},
+	"1.3.132.0.35":          {"secp521r1", 66, false},
+	"1.3.132.0.10":          {"secp256k1", 32, false},
+	"1.3.36.3.3.2.8.1.1.7":  {"brainpoolP256r1", 32, false},
+	"1.3.36.3.3.2.8.1.1.11": {"brainpoolP384r1", 48, false},
+	"1.3.36.3.3.2.8.1.1.13": {"brainpoolP512r1", 64, false},
+	oidX25519.String():      {"curve25519", 32, true},
+	oidX448.String():        {"curve448", 56, true},
+}
+
+// curveNameToOID maps the printable curve names accepted in CKA_EC_PARAMS by
+// PKCS#11 v3.0 for Montgomery and Edwards curves to their OIDs.
+var curveNameToOID = map[string]asn1.ObjectIdentifier{
+	"curve25519":   oidX25519,
+	"curve448":     oidX448,
+	"edwards25519": oidEd25519,
+}
+
+// ECPublicKey is a peer EC public key as used with CKM_ECDH1_DERIVE.
+type ECPublicKey struct {
+	// Curve is the named curve of the key, or nil if it was given as a bare point.
+	Curve asn1.ObjectIdentifier
+	// Point is the EC point without DER wrapping: an uncompressed or compressed
+	// point for Weierstrass curves, or the u-coordinate for Montgomery curves.
+	Point []byte
+}
+
+// ParseECPublicKey parses a peer EC public key given as a PEM-encoded public
+// key or certificate, a DER-encoded SubjectPublicKeyInfo, a DER-encoded EC
+// point as stored in CKA_EC_POINT, or a raw EC point. Bare points carry no
+// curve and are checked against the private key by ECDHPublicData.
+func ParseECPublicKey(data []byte) (*ECPublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		switch block.Type {
+		case "PUBLIC KEY":
+			return parseSubjectPublicKeyInfo(block.Bytes)
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate: %w", err)
+			}
+			return parseSubjectPublicKeyInfo(cert.RawSubjectPublicKeyInfo)
+		}
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("empty public key")
+	}
+	if data[0] == 0x30 {
+		// A Montgomery u-coordinate may start with 0x30 as well
+		if key, err := parseSubjectPublicKeyInfo(data); err == nil {
+			return key, nil
+		}
+	}
+	return &ECPublicKey{Point: data}, nil
+}
+
+// parseSubjectPublicKeyInfo extracts the curve and point from a DER-encoded SubjectPublicKeyInfo.
+func parseSubjectPublicKeyInfo(der []byte) (*ECPublicKey, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	rest, err := asn1.Unmarshal(der, &spki)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SubjectPublicKeyInfo: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("invalid SubjectPublicKeyInfo: trailing data")
+	}
+
+	key := &ECPublicKey{Point: spki.PublicKey.RightAlign()}
+	switch {
+	case spki.Algorithm.Algorithm.Equal(oidECPublicKey):
+		if _, err := asn1.Unmarshal(spki.Algorithm.Parameters.FullBytes, &key.Curve); err != nil {
+			return nil, errors.New("only EC public keys on named curves are supported")
+		}
+	case spki.Algorithm.Algorithm.Equal(oidX25519), spki.Algorithm.Algorithm.Equal(oidX448):
+		key.Curve = spki.Algorithm.Algorithm
+	default:
+		return nil, fmt.Errorf("unsupported public key algorithm %s", spki.Algorithm.Algorithm)
+	}
+	return key, nil
+}
+
+// parseECParams returns the named curve encoded in a CKA_EC_PARAMS value,
+// which is either a DER-encoded OID or a DER-encoded printable curve name.
+func parseECParams(params []byte) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	if rest, err := asn1.Unmarshal(params, &oid); err == nil && len(rest) == 0 {
+		return oid, nil
+	}
+	var name string
+	if rest, err := asn1.Unmarshal(params, &name); err == nil && len(rest) == 0 {
+		if oid, ok := curveNameToOID[name]; ok {
+			return oid, nil
+		}
+		return nil, fmt.Errorf("unsupported curve %q", name)
+	}
+	return nil, errors.New("only named curves are supported in CKA_EC_PARAMS")
+}
+
+// pointFor returns the bare point of key on the given curve, removing the DER
+// OCTET STRING wrapping of CKA_EC_POINT if present, and checks its length.
+func (k *ECPublicKey) pointFor(curve curveInfo) ([]byte, error) {
+	if validPointLength(k.Point, curve) {
+		return k.Point, nil
+	}
+	var unwrapped []byte
+	if rest, err := asn1.Unmarshal(k.Point, &unwrapped); err == nil && len(rest) == 0 && validPointLength(unwrapped, curve) {
+		return unwrapped, nil
+	}
+	return nil, fmt.Errorf("%w: public key is not a valid %s point (%d bytes)", ErrCurveMismatch, curve.name, len(k.Point))
+}
+
+func validPointLength(point []byte, curve curveInfo) bool {
+	if curve.montgomery {
+		return len(point) == curve.fieldBytes
+	}
+	switch {
+	case len(point) == 1+2*curve.fieldBytes:
+		return point[0] == 0x04
+	case len(point) == 1+curve.fieldBytes:
+		return point[0] == 0x02 || point[0] == 0x03
+	}
+	return false
+}
+
+// ECDHPublicData checks that peer is on the curve of the private key and
+// returns the public data for CK_ECDH1_DERIVE_PARAMS. The point is passed
+// without DER wrapping, as required by PKCS#11, unless derEncode is set for
+// modules that expect the CKA_EC_POINT encoding instead.
+func (c *Client) ECDHPublicData(ctx context.Context, privateKey pkcs11.ObjectHandle, peer *ECPublicKey, derEncode bool) ([]byte, error) {
+	attrs, err := c.GetObjectAttributes(ctx, privateKey, []uint{pkcs11.CKA_EC_PARAMS})
+	if err != nil {
+		return nil, err
+	}
+	if len(attrs[pkcs11.CKA_EC_PARAMS]) == 0 {
+		return nil, errors.New("private key has no CKA_EC_PARAMS")
+	}
+	oid, err := parseECParams(attrs[pkcs11.CKA_EC_PARAMS])
+	if err != nil {
+		return nil, err
+	}
+	curve, ok := curveInfos[oid.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported curve %s", oid)
+	}
+	if peer.Curve != nil && !peer.Curve.Equal(oid) {
+		peerName := peer.Curve.String()
+		if info, ok := curveInfos[peerName]; ok {
+			peerName = info.name
+		}
+		return nil, fmt.Errorf("%w: private key is on %s, peer public key is on %s", ErrCurveMismatch, curve.name, peerName)
+	}
+
+	point, err := peer.pointFor(curve)
+	if err != nil {
+		return nil, err
+	}
+	if derEncode {
+		return asn1.Marshal(point)
+	}
+	return point, nil
+}
@@ -0,0 +1,133 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+var oidP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+
+func newECPrivateKey(t *testing.T, client *Client, curve asn1.ObjectIdentifier) pkcs11.ObjectHandle {
+	ctx := t.Context()
+	t.Helper()
+	params, err := asn1.Marshal(curve)
+	if err != nil {
+		t.Fatalf("failed to encode curve: %v", err)
+	}
+	handle, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+	return handle
+}
+
+func TestParseECPublicKey_Formats(t *testing.T) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := key.PublicKey().Bytes()
+	spki, err := x509.MarshalPKIXPublicKey(key.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: spki})
+
+	for name, input := range map[string][]byte{"pem": pemKey, "spki": spki} {
+		parsed, err := ParseECPublicKey(input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if !parsed.Curve.Equal(oidP256) {
+			t.Errorf("%s: expected curve %s, got %s", name, oidP256, parsed.Curve)
+		}
+		if !bytes.Equal(parsed.Point, point) {
+			t.Errorf("%s: expected point %x, got %x", name, point, parsed.Point)
+		}
+	}
+
+	parsed, err := ParseECPublicKey(point)
+	if err != nil {
+		t.Fatalf("raw: unexpected error: %v", err)
+	}
+	if parsed.Curve != nil {
+		t.Errorf("raw: expected no curve, got %s", parsed.Curve)
+	}
+
+	if _, err := ParseECPublicKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1}})); err == nil {
+		t.Error("expected error for unsupported PEM block")
+	}
+}
+
+func TestECDHPublicData(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	privateKey := newECPrivateKey(t, client, oidP256)
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := key.PublicKey().Bytes()
+	derPoint, _ := asn1.Marshal(point)
+
+	// Raw and DER-encoded points are both accepted and passed without wrapping
+	for _, input := range [][]byte{point, derPoint} {
+		data, err := client.ECDHPublicData(ctx, privateKey, &ECPublicKey{Point: input}, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(data, point) {
+			t.Errorf("expected %x, got %x", point, data)
+		}
+	}
+
+	data, err := client.ECDHPublicData(ctx, privateKey, &ECPublicKey{Curve: oidP256, Point: point}, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(data, derPoint) {
+		t.Errorf("expected DER-encoded point %x, got %x", derPoint, data)
+	}
+}
+
+func TestECDHPublicData_CurveMismatch(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	privateKey := newECPrivateKey(t, client, oidP256)
+	key, err := ecdh.P384().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(key.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := ParseECPublicKey(spki)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := client.ECDHPublicData(ctx, privateKey, peer, false); !errors.Is(err, ErrCurveMismatch) {
+		t.Errorf("expected ErrCurveMismatch for SPKI on another curve, got %v", err)
+	}
+	// A bare point is rejected by its length
+	if _, err := client.ECDHPublicData(ctx, privateKey, &ECPublicKey{Point: key.PublicKey().Bytes()}, false); !errors.Is(err, ErrCurveMismatch) {
+		t.Errorf("expected ErrCurveMismatch for raw point on another curve, got %v", err)
+	}
+}
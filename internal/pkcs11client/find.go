@@ -1,15 +1,16 @@
 package pkcs11client
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/miekg/pkcs11"
 )
 
 // FindObjects searches for objects matching the given template and returns up to maxResults handles.
-func (c *Client) FindObjects(template []*pkcs11.Attribute, maxResults int) ([]pkcs11.ObjectHandle, error) {
+func (c *Client) FindObjects(ctx context.Context, template []*pkcs11.Attribute, maxResults int) ([]pkcs11.ObjectHandle, error) {
 	var handles []pkcs11.ObjectHandle
-	err := c.withSession(func(sh pkcs11.SessionHandle) error {
+	err := c.withSession(ctx, func(sh pkcs11.SessionHandle) error {
 		if err := c.ctx.FindObjectsInit(sh, template); err != nil {
 			return wrapError("FindObjectsInit", err)
 		}
@@ -31,13 +32,16 @@ func (c *Client) FindObjects(template []*pkcs11.Attribute, maxResults int) ([]pk
 		}
 		return nil
 	})
-	return handles, err
+	if err != nil {
+		return nil, err
+	}
+	return handles, nil
 }
 
 // FindOneObject finds exactly one object matching the template.
 // Returns ErrObjectNotFound if none match, ErrMultipleObjects if more than one matches.
-func (c *Client) FindOneObject(template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
-	handles, err := c.FindObjects(template, 2)
+func (c *Client) FindOneObject(ctx context.Context, template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	handles, err := c.FindObjects(ctx, template, 2)
 	if err != nil {
 		return 0, err
 	}
@@ -51,20 +55,30 @@ func (c *Client) FindOneObject(template []*pkcs11.Attribute) (pkcs11.ObjectHandl
 }
 
 // FindObjectByLabelAndClass finds an object by its CKA_LABEL and CKA_CLASS.
-func (c *Client) FindObjectByLabelAndClass(label string, class uint) (pkcs11.ObjectHandle, error) {
+func (c *Client) FindObjectByLabelAndClass(ctx context.Context, label string, class uint) (pkcs11.ObjectHandle, error) {
 	template := []*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
 		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
 	}
-	return c.FindOneObject(template)
+	return c.FindOneObject(ctx, template)
 }
 
 // FindObjectByLabelIDClass finds an object by CKA_LABEL, CKA_ID, and CKA_CLASS.
-func (c *Client) FindObjectByLabelIDClass(label string, id []byte, class uint) (pkcs11.ObjectHandle, error) {
+func (c *Client) FindObjectByLabelIDClass(ctx context.Context, label string, id []byte, class uint) (pkcs11.ObjectHandle, error) {
 	template := []*pkcs11.Attribute{
 		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
 		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
 		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
 	}
-	return c.FindOneObject(template)
+	return c.FindOneObject(ctx, template)
+}
+
+// ResolveKeyRef looks up the key referenced by ref and stores its handle in ref.
+func (c *Client) ResolveKeyRef(ctx context.Context, ref *KeyRef) error {
+	handle, err := c.FindKey(ctx, ref.Label, ref.Class)
+	if err != nil {
+		return err
+	}
+	ref.Handle = handle
+	return nil
 }
@@ -0,0 +1,907 @@
+package pkcs11client
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// ModuleHostCommand is the first argument of the provider executable when it
+// runs as module host. The second argument is the module path.
+const ModuleHostCommand = "pkcs11-module-host"
+
+// hostExitTimeout is how long a module host may take to exit after its
+// connection was closed before it is killed.
+const hostExitTimeout = 10 * time.Second
+
+// sessionGenerationShift is the bit position of the host process generation
+// in the session handles returned by a hostContext. Session handles of the
+// module must fit into the bits below it.
+const sessionGenerationShift = bits.UintSize / 2
+
+// ErrModuleCrashed is returned for operations that were running or requested
+// while the module host process exited unexpectedly, e.g. because the module
+// crashed. The next operation starts a new module host.
+var ErrModuleCrashed = errors.New("pkcs11: module process exited unexpectedly")
+
+// ModuleHost configures running a module in a child process, the module host,
+// instead of loading it into the provider process. A crash of the module then
+// fails the running operations with ErrModuleCrashed instead of terminating
+// the provider, and the process environment is isolated from other modules.
+// The child process is the provider executable, which must serve the module
+// with ServeModuleHost when started with ModuleHostCommand.
+type ModuleHost struct {
+	// Env holds additional environment variables of the module host as
+	// KEY=value. Modules with the same path and environment share a host.
+	Env []string
+}
+
+// hostRequest is a call to a method of the Pkcs11Context of a module host.
+// Only the fields used by the method are set.
+type hostRequest struct {
+	Method       string
+	Slot         uint
+	Session      pkcs11.SessionHandle
+	Object       pkcs11.ObjectHandle
+	Key          pkcs11.ObjectHandle
+	Flags        uint // session flags or user type
+	Count        int
+	TokenPresent bool
+	PIN          string
+	NewPIN       string
+	Label        string
+	Template     []*pkcs11.Attribute
+	Template2    []*pkcs11.Attribute
+	Mechanism    []hostMechanism
+	Data         []byte
+	Data2        []byte
+}
+
+// hostResponse is the result of a hostRequest. Code is the return value of
+// the module, Err the message of errors that are no return value.
+type hostResponse struct {
+	Code          uint
+	Err           string
+	Slots         []uint
+	SlotInfo      pkcs11.SlotInfo
+	TokenInfo     pkcs11.TokenInfo
+	MechanismInfo pkcs11.MechanismInfo
+	SessionInfo   pkcs11.SessionInfo
+	Mechanisms    []uint
+	Session       pkcs11.SessionHandle
+	Object        pkcs11.ObjectHandle
+	Object2       pkcs11.ObjectHandle
+	Objects       []pkcs11.ObjectHandle
+	More          bool
+	Template      []*pkcs11.Attribute
+	Data          []byte
+}
+
+// hostMechanism is a mechanism sent to a module host. Parameters that contain
+// pointers are sent as Params, from which the host marshals them again.
+type hostMechanism struct {
+	ID        uint
+	Parameter []byte
+	Params    *MechanismParams
+}
+
+// hostCall and hostReply frame requests and responses on the connection, so
+// that concurrent calls can be answered in any order.
+type hostCall struct {
+	ID      uint64
+	Request hostRequest
+}
+
+type hostReply struct {
+	ID       uint64
+	Response hostResponse
+}
+
+// requestCaller sends requests for the calls of a Pkcs11Context and returns
+// the responses with the error they report.
+type requestCaller interface {
+	call(req hostRequest) (hostResponse, error)
+	callSession(sh pkcs11.SessionHandle, req hostRequest) (hostResponse, error)
+}
+
+// requestContext implements the calls of Pkcs11Context other than Initialize
+// and Finalize by sending them as hostRequest to caller, the reverse of
+// handleHostRequest.
+type requestContext struct {
+	caller requestCaller
+}
+
+// hostDialer starts a module host and returns the connection to it and a
+// function that waits for it to exit once the connection is closed.
+type hostDialer func() (io.ReadWriteCloser, func() error, error)
+
+// hostContext is a Pkcs11Context that forwards the calls to a module host or
+// an agent. If the host exits unexpectedly or the connection is lost, the
+// running calls fail with lost, i.e. ErrModuleCrashed or
+// ErrAgentUnavailable, and the next call starts a new host or connects again.
+// The new host is initialized again if the module was initialized. Session
+// handles of a previous host or connection are invalid, so that the session
+// pool replaces them.
+type hostContext struct {
+	requestContext
+	dial        hostDialer
+	lost        error      // returned when the connection is lost
+	mu          sync.Mutex // guards the fields below and serializes starting hosts
+	proc        *hostProcess
+	generation  uint
+	initialized bool
+}
+
+func newHostContext(dial hostDialer, lost error) *hostContext {
+	h := &hostContext{dial: dial, lost: lost}
+	h.requestContext = requestContext{caller: h}
+	return h
+}
+
+// startModuleHost returns a dialer that starts the provider executable as
+// module host of the module at path with the additional environment env. The
+// connection uses dedicated pipes, since modules may write to stdout.
+func startModuleHost(path string, env []string) hostDialer {
+	return func() (io.ReadWriteCloser, func() error, error) {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, nil, fmt.Errorf("starting module host: %w", err)
+		}
+		hostIn, requests, err := os.Pipe()
+		if err != nil {
+			return nil, nil, fmt.Errorf("starting module host: %w", err)
+		}
+		responses, hostOut, err := os.Pipe()
+		if err != nil {
+			hostIn.Close()
+			requests.Close()
+			return nil, nil, fmt.Errorf("starting module host: %w", err)
+		}
+
+		cmd := exec.Command(exe, ModuleHostCommand, path)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.ExtraFiles = []*os.File{hostIn, hostOut} // file descriptors 3 and 4
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		err = cmd.Start()
+		hostIn.Close()
+		hostOut.Close()
+		if err != nil {
+			requests.Close()
+			responses.Close()
+			return nil, nil, fmt.Errorf("starting module host: %w", err)
+		}
+
+		wait := func() error {
+			exited := make(chan error, 1)
+			go func() { exited <- cmd.Wait() }()
+			select {
+			case err := <-exited:
+				return err
+			case <-time.After(hostExitTimeout):
+				cmd.Process.Kill()
+				return <-exited
+			}
+		}
+		return &pipeConn{Reader: responses, WriteCloser: requests, read: responses}, wait, nil
+	}
+}
+
+// pipeConn joins the two pipes of a connection.
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+	read io.Closer
+}
+
+func (c *pipeConn) Close() error {
+	return errors.Join(c.WriteCloser.Close(), c.read.Close())
+}
+
+// ServeModuleHost loads the module at path and serves it to the provider
+// process that started this process as module host, until the provider
+// closes the connection. Errors loading the module are returned to the calls
+// of the provider.
+func ServeModuleHost(path string) error {
+	in := os.NewFile(3, "module-host-in")
+	out := os.NewFile(4, "module-host-out")
+	if in == nil || out == nil {
+		return errors.New("pkcs11: the module host must be started by the provider")
+	}
+
+	ctx, err := loadModule(path)
+	return serveModule(&pipeConn{Reader: in, WriteCloser: out, read: in}, func(req hostRequest) hostResponse {
+		if err != nil {
+			return hostResponse{Err: err.Error()}
+		}
+		return handleHostRequest(ctx, req)
+	})
+}
+
+// serveModule answers the calls read from conn with handle until conn is
+// closed. Calls are handled concurrently.
+func serveModule(conn io.ReadWriteCloser, handle func(req hostRequest) hostResponse) error {
+	defer conn.Close()
+	dec := gob.NewDecoder(conn)
+	enc := gob.NewEncoder(conn)
+	var encMu sync.Mutex
+
+	for {
+		var call hostCall
+		if err := dec.Decode(&call); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			resp := handle(call.Request)
+			encMu.Lock()
+			defer encMu.Unlock()
+			enc.Encode(hostReply{ID: call.ID, Response: resp})
+		}()
+	}
+}
+
+// hostProcess is a running module host.
+type hostProcess struct {
+	generation uint
+	conn       io.ReadWriteCloser
+	wait       func() error
+	enc        *gob.Encoder
+	encMu      sync.Mutex
+	mu         sync.Mutex // guards the fields below
+	pending    map[uint64]chan hostResponse
+	nextID     uint64
+	closed     bool
+	stopOnce   sync.Once
+	exitErr    error
+}
+
+func newHostProcess(generation uint, conn io.ReadWriteCloser, wait func() error) *hostProcess {
+	p := &hostProcess{
+		generation: generation,
+		conn:       conn,
+		wait:       wait,
+		enc:        gob.NewEncoder(conn),
+		pending:    make(map[uint64]chan hostResponse),
+	}
+	go p.read()
+	return p
+}
+
+// read dispatches the responses until the connection is closed, then fails
+// the pending calls.
+func (p *hostProcess) read() {
+	dec := gob.NewDecoder(p.conn)
+	for {
+		var reply hostReply
+		if err := dec.Decode(&reply); err != nil {
+			break
+		}
+		p.mu.Lock()
+		ch := p.pending[reply.ID]
+		delete(p.pending, reply.ID)
+		p.mu.Unlock()
+		if ch != nil {
+			ch <- reply.Response
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for id, ch := range p.pending {
+		close(ch)
+		delete(p.pending, id)
+	}
+}
+
+// call sends req and waits for the response. ok is false if the connection
+// was lost before the response arrived.
+func (p *hostProcess) call(req hostRequest) (resp hostResponse, ok bool) {
+	ch := make(chan hostResponse, 1)
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return resp, false
+	}
+	p.nextID++
+	id := p.nextID
+	p.pending[id] = ch
+	p.mu.Unlock()
+
+	p.encMu.Lock()
+	err := p.enc.Encode(hostCall{ID: id, Request: req})
+	p.encMu.Unlock()
+	if err != nil {
+		// Make read fail the pending calls
+		p.conn.Close()
+	}
+	resp, ok = <-ch
+	return resp, ok
+}
+
+// stop closes the connection and waits for the host to exit. It returns the
+// exit error of the host.
+func (p *hostProcess) stop() error {
+	p.stopOnce.Do(func() {
+		p.conn.Close()
+		p.exitErr = p.wait()
+	})
+	return p.exitErr
+}
+
+// process returns the running module host, starting a new one if there is
+// none. A new host is initialized if the module was initialized.
+func (h *hostContext) process() (*hostProcess, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.proc != nil {
+		return h.proc, nil
+	}
+
+	conn, wait, err := h.dial()
+	if err != nil {
+		return nil, err
+	}
+	h.generation++
+	p := newHostProcess(h.generation, conn, wait)
+	if h.initialized {
+		resp, ok := p.call(hostRequest{Method: "Initialize"})
+		if !ok {
+			return nil, h.lostError(p.stop())
+		}
+		if err := responseError(resp); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+			p.stop()
+			return nil, err
+		}
+	}
+	h.proc = p
+	return p, nil
+}
+
+// crashed forgets the module host p after its connection was lost and
+// returns the error for the calls that were running.
+func (h *hostContext) crashed(p *hostProcess) error {
+	h.mu.Lock()
+	if h.proc == p {
+		h.proc = nil
+	}
+	h.mu.Unlock()
+	return h.lostError(p.stop())
+}
+
+// lostError returns the error for a lost connection, with the exit error of
+// the module host, if any.
+func (h *hostContext) lostError(exitErr error) error {
+	if exitErr == nil {
+		return h.lost
+	}
+	return fmt.Errorf("%w (%v)", h.lost, exitErr)
+}
+
+// responseError returns the error reported in resp, if any.
+func responseError(resp hostResponse) error {
+	if resp.Code != pkcs11.CKR_OK {
+		return pkcs11.Error(resp.Code)
+	}
+	if resp.Err != "" {
+		return errors.New(resp.Err)
+	}
+	return nil
+}
+
+// call forwards req to the module host. Calls fail with
+// CKR_CRYPTOKI_NOT_INITIALIZED until the module is initialized, so that no
+// host is started for them.
+func (h *hostContext) call(req hostRequest) (hostResponse, error) {
+	return h.do(req, false)
+}
+
+// callSession forwards req for the session sh to the module host. Sessions
+// of a previous host are invalid.
+func (h *hostContext) callSession(sh pkcs11.SessionHandle, req hostRequest) (hostResponse, error) {
+	req.Session = sh
+	return h.do(req, true)
+}
+
+func (h *hostContext) do(req hostRequest, inSession bool) (hostResponse, error) {
+	h.mu.Lock()
+	initialized := h.initialized
+	h.mu.Unlock()
+	if !initialized {
+		return hostResponse{}, pkcs11.Error(pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED)
+	}
+
+	p, err := h.process()
+	if err != nil {
+		return hostResponse{}, err
+	}
+	if inSession {
+		if uint(req.Session)>>sessionGenerationShift != p.generation {
+			return hostResponse{}, pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+		}
+		req.Session &= 1<<sessionGenerationShift - 1
+	}
+
+	resp, ok := p.call(req)
+	if !ok {
+		return resp, h.crashed(p)
+	}
+	if resp.Session != 0 {
+		resp.Session |= pkcs11.SessionHandle(p.generation << sessionGenerationShift)
+	}
+	return resp, responseError(resp)
+}
+
+// toHostMechanisms converts mechanisms for sending them to a module host.
+func toHostMechanisms(mechs []*pkcs11.Mechanism) []hostMechanism {
+	result := make([]hostMechanism, len(mechs))
+	for i, m := range mechs {
+		result[i] = hostMechanism{ID: m.Mechanism, Parameter: m.Parameter}
+		if params, ok := mechanismParams.Load(m); ok {
+			result[i].Params = params.(*MechanismParams)
+		}
+	}
+	return result
+}
+
+// fromHostMechanisms converts mechanisms received by a module host. The
+// returned function frees the marshaled parameters.
+func fromHostMechanisms(mechs []hostMechanism) ([]*pkcs11.Mechanism, func(), error) {
+	result := make([]*pkcs11.Mechanism, 0, len(mechs))
+	var frees []func()
+	free := func() {
+		for _, f := range frees {
+			f()
+		}
+	}
+	for _, m := range mechs {
+		if m.Params == nil {
+			result = append(result, pkcs11.NewMechanism(m.ID, m.Parameter))
+			continue
+		}
+		mech, f, err := NewMechanism(m.ID, m.Params)
+		if err != nil {
+			free()
+			return nil, nil, err
+		}
+		result = append(result, mech...)
+		frees = append(frees, f)
+	}
+	return result, free, nil
+}
+
+// handleHostRequest calls the method of ctx requested by req.
+func handleHostRequest(ctx Pkcs11Context, req hostRequest) hostResponse {
+	var resp hostResponse
+	mechs, free, err := fromHostMechanisms(req.Mechanism)
+	if err != nil {
+		resp.Err = err.Error()
+		return resp
+	}
+	defer free()
+
+	switch req.Method {
+	case "Initialize":
+		err = ctx.Initialize()
+	case "Finalize":
+		err = ctx.Finalize()
+	case "GetSlotList":
+		resp.Slots, err = ctx.GetSlotList(req.TokenPresent)
+	case "GetSlotInfo":
+		resp.SlotInfo, err = ctx.GetSlotInfo(req.Slot)
+	case "GetTokenInfo":
+		resp.TokenInfo, err = ctx.GetTokenInfo(req.Slot)
+	case "GetMechanismList":
+		var list []*pkcs11.Mechanism
+		list, err = ctx.GetMechanismList(req.Slot)
+		for _, m := range list {
+			resp.Mechanisms = append(resp.Mechanisms, m.Mechanism)
+		}
+	case "GetMechanismInfo":
+		resp.MechanismInfo, err = ctx.GetMechanismInfo(req.Slot, mechs)
+	case "OpenSession":
+		resp.Session, err = ctx.OpenSession(req.Slot, req.Flags)
+	case "CloseSession":
+		err = ctx.CloseSession(req.Session)
+	case "GetSessionInfo":
+		resp.SessionInfo, err = ctx.GetSessionInfo(req.Session)
+	case "Login":
+		err = ctx.Login(req.Session, req.Flags, req.PIN)
+	case "Logout":
+		err = ctx.Logout(req.Session)
+	case "InitToken":
+		err = ctx.InitToken(req.Slot, req.PIN, req.Label)
+	case "InitPIN":
+		err = ctx.InitPIN(req.Session, req.PIN)
+	case "SetPIN":
+		err = ctx.SetPIN(req.Session, req.PIN, req.NewPIN)
+	case "CreateObject":
+		resp.Object, err = ctx.CreateObject(req.Session, req.Template)
+	case "DestroyObject":
+		err = ctx.DestroyObject(req.Session, req.Object)
+	case "CopyObject":
+		resp.Object, err = ctx.CopyObject(req.Session, req.Object, req.Template)
+	case "FindObjectsInit":
+		err = ctx.FindObjectsInit(req.Session, req.Template)
+	case "FindObjects":
+		resp.Objects, resp.More, err = ctx.FindObjects(req.Session, req.Count)
+	case "FindObjectsFinal":
+		err = ctx.FindObjectsFinal(req.Session)
+	case "GetAttributeValue":
+		resp.Template, err = ctx.GetAttributeValue(req.Session, req.Object, req.Template)
+	case "SetAttributeValue":
+		err = ctx.SetAttributeValue(req.Session, req.Object, req.Template)
+	case "GenerateKeyPair":
+		resp.Object, resp.Object2, err = ctx.GenerateKeyPair(req.Session, mechs, req.Template, req.Template2)
+	case "GenerateKey":
+		resp.Object, err = ctx.GenerateKey(req.Session, mechs, req.Template)
+	case "WrapKey":
+		resp.Data, err = ctx.WrapKey(req.Session, mechs, req.Key, req.Object)
+	case "UnwrapKey":
+		resp.Object, err = ctx.UnwrapKey(req.Session, mechs, req.Key, req.Data, req.Template)
+	case "DeriveKey":
+		resp.Object, err = ctx.DeriveKey(req.Session, mechs, req.Key, req.Template)
+	case "EncryptInit":
+		err = ctx.EncryptInit(req.Session, mechs, req.Key)
+	case "Encrypt":
+		resp.Data, err = ctx.Encrypt(req.Session, req.Data)
+	case "EncryptUpdate":
+		resp.Data, err = ctx.EncryptUpdate(req.Session, req.Data)
+	case "EncryptFinal":
+		resp.Data, err = ctx.EncryptFinal(req.Session)
+	case "DecryptInit":
+		err = ctx.DecryptInit(req.Session, mechs, req.Key)
+	case "Decrypt":
+		resp.Data, err = ctx.Decrypt(req.Session, req.Data)
+	case "DecryptUpdate":
+		resp.Data, err = ctx.DecryptUpdate(req.Session, req.Data)
+	case "DecryptFinal":
+		resp.Data, err = ctx.DecryptFinal(req.Session)
+	case "SignInit":
+		err = ctx.SignInit(req.Session, mechs, req.Key)
+	case "Sign":
+		resp.Data, err = ctx.Sign(req.Session, req.Data)
+	case "SignUpdate":
+		err = ctx.SignUpdate(req.Session, req.Data)
+	case "SignFinal":
+		resp.Data, err = ctx.SignFinal(req.Session)
+	case "VerifyInit":
+		err = ctx.VerifyInit(req.Session, mechs, req.Key)
+	case "Verify":
+		err = ctx.Verify(req.Session, req.Data, req.Data2)
+	case "DigestInit":
+		err = ctx.DigestInit(req.Session, mechs)
+	case "Digest":
+		resp.Data, err = ctx.Digest(req.Session, req.Data)
+	case "DigestUpdate":
+		err = ctx.DigestUpdate(req.Session, req.Data)
+	case "DigestKey":
+		err = ctx.DigestKey(req.Session, req.Key)
+	case "DigestFinal":
+		resp.Data, err = ctx.DigestFinal(req.Session)
+	case "SeedRandom":
+		err = ctx.SeedRandom(req.Session, req.Data)
+	case "GenerateRandom":
+		resp.Data, err = ctx.GenerateRandom(req.Session, req.Count)
+	default:
+		err = fmt.Errorf("pkcs11: unknown module host method %q", req.Method)
+	}
+	resp.setError(err)
+	return resp
+}
+
+// setError reports err in resp, as return value if it is one.
+func (resp *hostResponse) setError(err error) {
+	var p11err pkcs11.Error
+	switch {
+	case errors.As(err, &p11err):
+		resp.Code = uint(p11err)
+	case err != nil:
+		resp.Err = err.Error()
+	}
+}
+
+// Initialize starts the module host and initializes the module.
+func (h *hostContext) Initialize(opts ...pkcs11.InitializeOption) error {
+	if len(opts) > 0 {
+		return errors.New("pkcs11: module hosts do not support initialize options")
+	}
+	p, err := h.process()
+	if err != nil {
+		return err
+	}
+	resp, ok := p.call(hostRequest{Method: "Initialize"})
+	if !ok {
+		return h.crashed(p)
+	}
+	err = responseError(resp)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil || errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		h.initialized = true
+		return err
+	}
+	if !h.initialized && h.proc == p {
+		// Do not keep a host without initialized module
+		h.proc = nil
+		p.stop()
+	}
+	return err
+}
+
+// Finalize finalizes the module and stops the module host. A crash of the
+// module while finalizing is ignored, since the host exits anyway.
+func (h *hostContext) Finalize() error {
+	h.mu.Lock()
+	p := h.proc
+	initialized := h.initialized
+	h.proc = nil
+	h.initialized = false
+	h.mu.Unlock()
+	if !initialized {
+		return pkcs11.Error(pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED)
+	}
+	if p == nil {
+		return nil
+	}
+
+	resp, ok := p.call(hostRequest{Method: "Finalize"})
+	p.stop()
+	if !ok {
+		return nil
+	}
+	return responseError(resp)
+}
+
+func (c requestContext) GetSlotList(tokenPresent bool) ([]uint, error) {
+	resp, err := c.caller.call(hostRequest{Method: "GetSlotList", TokenPresent: tokenPresent})
+	return resp.Slots, err
+}
+
+func (c requestContext) GetSlotInfo(slotID uint) (pkcs11.SlotInfo, error) {
+	resp, err := c.caller.call(hostRequest{Method: "GetSlotInfo", Slot: slotID})
+	return resp.SlotInfo, err
+}
+
+func (c requestContext) GetTokenInfo(slotID uint) (pkcs11.TokenInfo, error) {
+	resp, err := c.caller.call(hostRequest{Method: "GetTokenInfo", Slot: slotID})
+	return resp.TokenInfo, err
+}
+
+func (c requestContext) GetMechanismList(slotID uint) ([]*pkcs11.Mechanism, error) {
+	resp, err := c.caller.call(hostRequest{Method: "GetMechanismList", Slot: slotID})
+	if err != nil {
+		return nil, err
+	}
+	mechs := make([]*pkcs11.Mechanism, len(resp.Mechanisms))
+	for i, id := range resp.Mechanisms {
+		mechs[i] = pkcs11.NewMechanism(id, nil)
+	}
+	return mechs, nil
+}
+
+func (c requestContext) GetMechanismInfo(slotID uint, m []*pkcs11.Mechanism) (pkcs11.MechanismInfo, error) {
+	resp, err := c.caller.call(hostRequest{Method: "GetMechanismInfo", Slot: slotID, Mechanism: toHostMechanisms(m)})
+	return resp.MechanismInfo, err
+}
+
+func (c requestContext) OpenSession(slotID uint, flags uint) (pkcs11.SessionHandle, error) {
+	resp, err := c.caller.call(hostRequest{Method: "OpenSession", Slot: slotID, Flags: flags})
+	return resp.Session, err
+}
+
+func (c requestContext) CloseSession(sh pkcs11.SessionHandle) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "CloseSession"})
+	return err
+}
+
+func (c requestContext) GetSessionInfo(sh pkcs11.SessionHandle) (pkcs11.SessionInfo, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "GetSessionInfo"})
+	return resp.SessionInfo, err
+}
+
+func (c requestContext) Login(sh pkcs11.SessionHandle, userType uint, pin string) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "Login", Flags: userType, PIN: pin})
+	return err
+}
+
+func (c requestContext) Logout(sh pkcs11.SessionHandle) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "Logout"})
+	return err
+}
+
+func (c requestContext) InitToken(slotID uint, soPin string, label string) error {
+	_, err := c.caller.call(hostRequest{Method: "InitToken", Slot: slotID, PIN: soPin, Label: label})
+	return err
+}
+
+func (c requestContext) InitPIN(sh pkcs11.SessionHandle, pin string) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "InitPIN", PIN: pin})
+	return err
+}
+
+func (c requestContext) SetPIN(sh pkcs11.SessionHandle, oldPin string, newPin string) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "SetPIN", PIN: oldPin, NewPIN: newPin})
+	return err
+}
+
+func (c requestContext) CreateObject(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "CreateObject", Template: temp})
+	return resp.Object, err
+}
+
+func (c requestContext) DestroyObject(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "DestroyObject", Object: oh})
+	return err
+}
+
+func (c requestContext) CopyObject(sh pkcs11.SessionHandle, o pkcs11.ObjectHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "CopyObject", Object: o, Template: temp})
+	return resp.Object, err
+}
+
+func (c requestContext) FindObjectsInit(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "FindObjectsInit", Template: temp})
+	return err
+}
+
+func (c requestContext) FindObjects(sh pkcs11.SessionHandle, max int) ([]pkcs11.ObjectHandle, bool, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "FindObjects", Count: max})
+	return resp.Objects, resp.More, err
+}
+
+func (c requestContext) FindObjectsFinal(sh pkcs11.SessionHandle) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "FindObjectsFinal"})
+	return err
+}
+
+func (c requestContext) GetAttributeValue(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle, temp []*pkcs11.Attribute) ([]*pkcs11.Attribute, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "GetAttributeValue", Object: oh, Template: temp})
+	return resp.Template, err
+}
+
+func (c requestContext) SetAttributeValue(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle, temp []*pkcs11.Attribute) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "SetAttributeValue", Object: oh, Template: temp})
+	return err
+}
+
+func (c requestContext) GenerateKeyPair(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, public, private []*pkcs11.Attribute) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "GenerateKeyPair", Mechanism: toHostMechanisms(m), Template: public, Template2: private})
+	return resp.Object, resp.Object2, err
+}
+
+func (c requestContext) GenerateKey(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "GenerateKey", Mechanism: toHostMechanisms(m), Template: temp})
+	return resp.Object, err
+}
+
+func (c requestContext) WrapKey(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, wrappingKey, key pkcs11.ObjectHandle) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "WrapKey", Mechanism: toHostMechanisms(m), Key: wrappingKey, Object: key})
+	return resp.Data, err
+}
+
+func (c requestContext) UnwrapKey(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, unwrappingKey pkcs11.ObjectHandle, wrappedKey []byte, a []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "UnwrapKey", Mechanism: toHostMechanisms(m), Key: unwrappingKey, Data: wrappedKey, Template: a})
+	return resp.Object, err
+}
+
+func (c requestContext) DeriveKey(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, baseKey pkcs11.ObjectHandle, a []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "DeriveKey", Mechanism: toHostMechanisms(m), Key: baseKey, Template: a})
+	return resp.Object, err
+}
+
+func (c requestContext) EncryptInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "EncryptInit", Mechanism: toHostMechanisms(m), Key: o})
+	return err
+}
+
+func (c requestContext) Encrypt(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "Encrypt", Data: message})
+	return resp.Data, err
+}
+
+func (c requestContext) EncryptUpdate(sh pkcs11.SessionHandle, plain []byte) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "EncryptUpdate", Data: plain})
+	return resp.Data, err
+}
+
+func (c requestContext) EncryptFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "EncryptFinal"})
+	return resp.Data, err
+}
+
+func (c requestContext) DecryptInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "DecryptInit", Mechanism: toHostMechanisms(m), Key: o})
+	return err
+}
+
+func (c requestContext) Decrypt(sh pkcs11.SessionHandle, cipher []byte) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "Decrypt", Data: cipher})
+	return resp.Data, err
+}
+
+func (c requestContext) DecryptUpdate(sh pkcs11.SessionHandle, cipher []byte) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "DecryptUpdate", Data: cipher})
+	return resp.Data, err
+}
+
+func (c requestContext) DecryptFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "DecryptFinal"})
+	return resp.Data, err
+}
+
+func (c requestContext) SignInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "SignInit", Mechanism: toHostMechanisms(m), Key: o})
+	return err
+}
+
+func (c requestContext) Sign(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "Sign", Data: message})
+	return resp.Data, err
+}
+
+func (c requestContext) SignUpdate(sh pkcs11.SessionHandle, message []byte) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "SignUpdate", Data: message})
+	return err
+}
+
+func (c requestContext) SignFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "SignFinal"})
+	return resp.Data, err
+}
+
+func (c requestContext) VerifyInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, key pkcs11.ObjectHandle) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "VerifyInit", Mechanism: toHostMechanisms(m), Key: key})
+	return err
+}
+
+func (c requestContext) Verify(sh pkcs11.SessionHandle, data []byte, signature []byte) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "Verify", Data: data, Data2: signature})
+	return err
+}
+
+func (c requestContext) DigestInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "DigestInit", Mechanism: toHostMechanisms(m)})
+	return err
+}
+
+func (c requestContext) Digest(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "Digest", Data: message})
+	return resp.Data, err
+}
+
+func (c requestContext) DigestUpdate(sh pkcs11.SessionHandle, message []byte) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "DigestUpdate", Data: message})
+	return err
+}
+
+func (c requestContext) DigestKey(sh pkcs11.SessionHandle, key pkcs11.ObjectHandle) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "DigestKey", Key: key})
+	return err
+}
+
+func (c requestContext) DigestFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "DigestFinal"})
+	return resp.Data, err
+}
+
+func (c requestContext) SeedRandom(sh pkcs11.SessionHandle, seed []byte) error {
+	_, err := c.caller.callSession(sh, hostRequest{Method: "SeedRandom", Data: seed})
+	return err
+}
+
+func (c requestContext) GenerateRandom(sh pkcs11.SessionHandle, length int) ([]byte, error) {
+	resp, err := c.caller.callSession(sh, hostRequest{Method: "GenerateRandom", Count: length})
+	return resp.Data, err
+}
@@ -0,0 +1,285 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestMain(m *testing.M) {
+	// The test binary serves as module host in TestStartModuleHost
+	if len(os.Args) == 3 && os.Args[1] == ModuleHostCommand {
+		if err := ServeModuleHost(os.Args[2]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// mockHosts serves a new mock module in-process for every started module host.
+type mockHosts struct {
+	mu      sync.Mutex
+	started int
+	server  net.Conn // of the last started host
+	exitErr error    // reported by the last started host after crash
+	// crashOnFinalize makes the hosts exit while finalizing the module.
+	crashOnFinalize bool
+}
+
+// crashingFinalize closes the connection of its module host in Finalize.
+type crashingFinalize struct {
+	*MockContext
+	conn net.Conn
+}
+
+func (c *crashingFinalize) Finalize() error {
+	return c.conn.Close()
+}
+
+func (m *mockHosts) dial() (io.ReadWriteCloser, func() error, error) {
+	client, server := net.Pipe()
+	var ctx Pkcs11Context = NewMockContext("host-token")
+	if m.crashOnFinalize {
+		ctx = &crashingFinalize{MockContext: ctx.(*MockContext), conn: server}
+	}
+	done := make(chan struct{})
+	go func() {
+		serveModule(server, func(req hostRequest) hostResponse {
+			return handleHostRequest(ctx, req)
+		})
+		close(done)
+	}()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started++
+	m.server = server
+	m.exitErr = nil
+	return client, func() error {
+		<-done
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.exitErr
+	}, nil
+}
+
+// crash terminates the last started host.
+func (m *mockHosts) crash() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.exitErr = errors.New("signal: segmentation fault")
+	m.server.Close()
+}
+
+func (m *mockHosts) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.started
+}
+
+func newHostTestClient(t *testing.T, hosts *mockHosts) *Client {
+	t.Helper()
+	client, err := NewClientWithContext(newHostContext(hosts.dial, ErrModuleCrashed), Config{
+		TokenLabel: "host-token",
+		Pin:        "1234",
+		PoolSize:   2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return client
+}
+
+func TestModuleHost_Operations(t *testing.T) {
+	hosts := &mockHosts{}
+	client := newHostTestClient(t, hosts)
+	defer client.Close()
+
+	handle, err := client.CreateObject(t.Context(), []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "hosted"),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, []byte("data")),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+	handles, err := client.FindObjects(t.Context(), []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "hosted"),
+	}, 10)
+	if err != nil {
+		t.Fatalf("FindObjects failed: %v", err)
+	}
+	if len(handles) != 1 || handles[0] != handle {
+		t.Errorf("expected to find object %d, got %v", handle, handles)
+	}
+
+	if random, err := client.GenerateRandom(t.Context(), 16); err != nil || len(random) != 16 {
+		t.Errorf("GenerateRandom returned %x, %v", random, err)
+	}
+	// Return values of the module are passed through
+	if err := client.DestroyObject(t.Context(), 4711); !errors.Is(err, pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)) {
+		t.Errorf("expected CKR_OBJECT_HANDLE_INVALID, got: %v", err)
+	}
+	if hosts.count() != 1 {
+		t.Errorf("expected one module host, got %d", hosts.count())
+	}
+}
+
+func TestModuleHost_Crash(t *testing.T) {
+	hosts := &mockHosts{}
+	client := newHostTestClient(t, hosts)
+	defer client.Close()
+
+	if _, err := client.GenerateRandom(t.Context(), 8); err != nil {
+		t.Fatalf("GenerateRandom failed: %v", err)
+	}
+
+	// The session is replaced and the operation runs again in a new host
+	hosts.crash()
+	if _, err := client.GenerateRandom(t.Context(), 8); err != nil {
+		t.Fatalf("GenerateRandom failed after crash: %v", err)
+	}
+	if hosts.count() != 2 {
+		t.Errorf("expected a new module host, got %d hosts", hosts.count())
+	}
+}
+
+func TestModuleHost_StaleSession(t *testing.T) {
+	hosts := &mockHosts{}
+	h := newHostContext(hosts.dial, ErrModuleCrashed)
+	if err := h.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Finalize()
+
+	sh, err := h.OpenSession(0, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts.crash()
+	_, err = h.GetSessionInfo(sh)
+	if !errors.Is(err, ErrModuleCrashed) || !strings.Contains(err.Error(), "segmentation fault") {
+		t.Fatalf("expected ErrModuleCrashed with exit status, got: %v", err)
+	}
+	if !connectionLost(err) || !DefaultRetryPolicy().retryable(err) {
+		t.Errorf("expected crash to be a lost connection and to be retryable")
+	}
+
+	// The new host is initialized and has its own sessions
+	if _, err := h.GetSlotList(true); err != nil {
+		t.Fatalf("GetSlotList failed: %v", err)
+	}
+	if _, err := h.GetSessionInfo(sh); !errors.Is(err, pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)) {
+		t.Errorf("expected CKR_SESSION_HANDLE_INVALID for session of the crashed host, got: %v", err)
+	}
+	newSh, err := h.OpenSession(0, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newSh == sh {
+		t.Errorf("expected session handles of hosts to differ")
+	}
+}
+
+func TestModuleHost_FinalizeCrash(t *testing.T) {
+	hosts := &mockHosts{crashOnFinalize: true}
+	h := newHostContext(hosts.dial, ErrModuleCrashed)
+	if err := h.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Finalize(); err != nil {
+		t.Errorf("expected crash while finalizing to be ignored, got: %v", err)
+	}
+
+	// Calls after finalizing do not start a host
+	if _, err := h.GetSlotList(true); !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED)) {
+		t.Errorf("expected CKR_CRYPTOKI_NOT_INITIALIZED, got: %v", err)
+	}
+	if hosts.count() != 1 {
+		t.Errorf("expected one module host, got %d", hosts.count())
+	}
+}
+
+func TestModuleHost_MechanismParams(t *testing.T) {
+	gcm := &GCMParams{IV: []byte("0123456789ab"), AAD: []byte("aad"), TagBits: 96}
+	mech, free, err := NewMechanism(pkcs11.CKM_AES_GCM, &MechanismParams{GCM: gcm})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parameters with pointers are sent typed and marshaled again by the host
+	sent := toHostMechanisms(mech)
+	if len(sent) != 1 || sent[0].Params == nil || !bytes.Equal(sent[0].Params.GCM.IV, gcm.IV) {
+		t.Fatalf("expected GCM parameters to be sent, got %+v", sent)
+	}
+	received, freeReceived, err := fromHostMechanisms(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || received[0].Mechanism != pkcs11.CKM_AES_GCM {
+		t.Errorf("unexpected mechanisms %+v", received)
+	}
+	freeReceived()
+
+	free()
+	if sent := toHostMechanisms(mech); sent[0].Params != nil {
+		t.Errorf("expected parameters to be forgotten once freed")
+	}
+}
+
+func TestModuleRegistry_ModuleHosts(t *testing.T) {
+	hosts := &mockHosts{}
+	registry := newModuleRegistry(func(path string) (Pkcs11Context, error) {
+		t.Fatal("module loaded into the process")
+		return nil, nil
+	})
+	registry.dial = func(path string, env []string) hostDialer {
+		return hosts.dial
+	}
+
+	_, releaseA, err := registry.acquire(Config{ModulePath: "a.so", Host: &ModuleHost{Env: []string{"A=1", "B=2"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, releaseB, err := registry.acquire(Config{ModulePath: "a.so", Host: &ModuleHost{Env: []string{"B=2", "A=1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, releaseC, err := registry.acquire(Config{ModulePath: "a.so", Host: &ModuleHost{Env: []string{"A=2"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hosts.count() != 2 {
+		t.Errorf("expected one module host per environment, got %d", hosts.count())
+	}
+
+	for _, release := range []func() error{releaseA, releaseB, releaseC} {
+		if err := release(); err != nil {
+			t.Errorf("release failed: %v", err)
+		}
+	}
+	if len(registry.modules) != 0 {
+		t.Errorf("expected modules to be unregistered, got %d", len(registry.modules))
+	}
+}
+
+func TestStartModuleHost(t *testing.T) {
+	h := newHostContext(startModuleHost("/nonexistent/module.so", []string{"PKCS11_TEST=1"}), ErrModuleCrashed)
+	err := h.Initialize()
+	if err == nil || !strings.Contains(err.Error(), "failed to load module") {
+		t.Fatalf("expected error loading the module in the host, got: %v", err)
+	}
+	if err := h.Finalize(); !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED)) {
+		t.Errorf("expected CKR_CRYPTOKI_NOT_INITIALIZED, got: %v", err)
+	}
+}
@@ -1,26 +1,49 @@
 package pkcs11client
 
 import (
+	"context"
+
 	"github.com/miekg/pkcs11"
 )
 
 // GenerateKeyPair generates a key pair using an arbitrary mechanism and attribute templates.
-func (c *Client) GenerateKeyPair(mechanism []*pkcs11.Mechanism, pubAttrs, privAttrs []*pkcs11.Attribute) (pub, priv pkcs11.ObjectHandle, err error) {
-	err = c.withSession(func(sh pkcs11.SessionHandle) error {
+func (c *Client) GenerateKeyPair(ctx context.Context, mechanism []*pkcs11.Mechanism, pubAttrs, privAttrs []*pkcs11.Attribute) (pub, priv pkcs11.ObjectHandle, err error) {
+	var pubHandle, privHandle pkcs11.ObjectHandle
+	err = c.withObjectCreation(ctx, [][]*pkcs11.Attribute{pubAttrs, privAttrs}, func(sh pkcs11.SessionHandle) error {
 		var genErr error
-		pub, priv, genErr = c.ctx.GenerateKeyPair(sh, mechanism, pubAttrs, privAttrs)
+		pubHandle, privHandle, genErr = c.ctx.GenerateKeyPair(sh, mechanism, pubAttrs, privAttrs)
 		return wrapError("GenerateKeyPair", genErr)
 	})
-	return
+	if err != nil {
+		return 0, 0, err
+	}
+	return pubHandle, privHandle, nil
 }
 
 // GenerateSymmetricKey generates a symmetric key (AES, DES3, Generic Secret) on the token.
-func (c *Client) GenerateSymmetricKey(mechanism []*pkcs11.Mechanism, attrs []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+func (c *Client) GenerateSymmetricKey(ctx context.Context, mechanism []*pkcs11.Mechanism, attrs []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
 	var handle pkcs11.ObjectHandle
-	err := c.withSession(func(sh pkcs11.SessionHandle) error {
+	err := c.withObjectCreation(ctx, [][]*pkcs11.Attribute{attrs}, func(sh pkcs11.SessionHandle) error {
 		var genErr error
 		handle, genErr = c.ctx.GenerateKey(sh, mechanism, attrs)
 		return wrapError("GenerateKey", genErr)
 	})
-	return handle, err
+	if err != nil {
+		return 0, err
+	}
+	return handle, nil
+}
+
+// DeriveKey derives a new key from a base key using the specified mechanism and template.
+func (c *Client) DeriveKey(ctx context.Context, mechanism []*pkcs11.Mechanism, baseKey pkcs11.ObjectHandle, attrs []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	var handle pkcs11.ObjectHandle
+	err := c.withObjectCreation(ctx, [][]*pkcs11.Attribute{attrs}, func(sh pkcs11.SessionHandle) error {
+		var deriveErr error
+		handle, deriveErr = c.ctx.DeriveKey(sh, mechanism, baseKey, attrs)
+		return wrapError("DeriveKey", deriveErr)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return handle, nil
 }
@@ -0,0 +1,302 @@
+package pkcs11client
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// MechanismParams holds typed parameters for a PKCS#11 mechanism.
+// At most one field may be set. NewMechanism marshals it into the
+// CK_*_PARAMS structure expected by the module.
+type MechanismParams struct {
+	Raw            []byte                // Pre-encoded parameter bytes, passed through unchanged
+	IV             []byte                // Initialization vector (e.g. CKM_AES_CBC, CKM_AES_CBC_PAD)
+	GCM            *GCMParams            // CK_GCM_PARAMS
+	CCM            *CCMParams            // CK_CCM_PARAMS
+	OAEP           *OAEPParams           // CK_RSA_PKCS_OAEP_PARAMS
+	PSS            *PSSParams            // CK_RSA_PKCS_PSS_PARAMS
+	ECDH1          *ECDH1Params          // CK_ECDH1_DERIVE_PARAMS
+	HKDF           *HKDFParams           // CK_HKDF_PARAMS
+	SP800108       *SP800108Params       // CK_SP800_108_KDF_PARAMS, CK_SP800_108_FEEDBACK_KDF_PARAMS
+	StringData     []byte                // CK_KEY_DERIVATION_STRING_DATA (e.g. CKM_AES_ECB_ENCRYPT_DATA)
+	CBCEncryptData *CBCEncryptDataParams // CK_AES_CBC_ENCRYPT_DATA_PARAMS, CK_DES_CBC_ENCRYPT_DATA_PARAMS
+	Key            *KeyRef               // CK_OBJECT_HANDLE (e.g. CKM_CONCATENATE_BASE_AND_KEY)
+}
+
+// GCMParams describes CK_GCM_PARAMS for CKM_AES_GCM.
+type GCMParams struct {
+	IV      []byte
+	AAD     []byte
+	TagBits uint
+}
+
+// CCMParams describes CK_CCM_PARAMS for CKM_AES_CCM.
+type CCMParams struct {
+	DataLen uint
+	Nonce   []byte
+	AAD     []byte
+	MACLen  uint
+}
+
+// OAEPParams describes CK_RSA_PKCS_OAEP_PARAMS for CKM_RSA_PKCS_OAEP.
+// The source is always CKZ_DATA_SPECIFIED, with empty source data if
+// SourceData is empty.
+type OAEPParams struct {
+	Hash       uint
+	MGF        uint
+	SourceData []byte
+}
+
+// PSSParams describes CK_RSA_PKCS_PSS_PARAMS for the CKM_*RSA_PKCS_PSS mechanisms.
+type PSSParams struct {
+	Hash uint
+	MGF  uint
+	// SaltLen is the salt length in bytes. Nil defaults to the hash length.
+	SaltLen *uint
+}
+
+// ECDH1Params describes CK_ECDH1_DERIVE_PARAMS for CKM_ECDH1_DERIVE and CKM_ECDH1_COFACTOR_DERIVE.
+type ECDH1Params struct {
+	KDF        uint
+	SharedData []byte
+	PublicData []byte
+}
+
+// HKDFParams describes CK_HKDF_PARAMS for CKM_HKDF_DERIVE and CKM_HKDF_DATA.
+// The salt is passed as CKF_HKDF_SALT_DATA when non-empty, CKF_HKDF_SALT_NULL otherwise.
+type HKDFParams struct {
+	PRF     uint // Digest mechanism (e.g. CKM_SHA256)
+	Extract bool
+	Expand  bool
+	Salt    []byte
+	Info    []byte
+}
+
+// SP800108Params describes the NIST SP 800-108 KDF parameters for
+// CKM_SP800_108_COUNTER_KDF, CKM_SP800_108_FEEDBACK_KDF and
+// CKM_SP800_108_DOUBLE_PIPELINE_KDF.
+type SP800108Params struct {
+	PRF           uint   // PRF mechanism (e.g. CKM_SHA256_HMAC, CKM_AES_CMAC)
+	CounterBits   uint   // Width of the counter [i]; 0 omits it outside of counter mode
+	FixedInput    []byte // Label || 0x00 || Context
+	DKMLengthBits uint   // Width of the derived key length [L]
+	IV            []byte // Initial feedback value (feedback mode only)
+}
+
+// CBCEncryptDataParams describes the CK_*_CBC_ENCRYPT_DATA_PARAMS structures.
+// The IV length selects the structure: 8 bytes for DES, 16 bytes for AES,
+// Camellia, ARIA and SEED.
+type CBCEncryptDataParams struct {
+	IV   []byte
+	Data []byte
+}
+
+// KeyRef references a key on the token by label or PKCS#11 URI and class.
+// Handle must be resolved via Client.ResolveKeyRef before the mechanism is built.
+type KeyRef struct {
+	Label  string
+	Class  uint
+	Handle pkcs11.ObjectHandle
+}
+
+// hashInfo describes the MGF1 variant and output length belonging to a digest mechanism.
+type hashInfo struct {
+	mgf    uint
+	length uint
+}
+
+var hashInfos = map[uint]hashInfo{
+	pkcs11.CKM_SHA_1:    {MGFNameToID["CKG_MGF1_SHA1"], 20},
+	pkcs11.CKM_SHA224:   {MGFNameToID["CKG_MGF1_SHA224"], 28},
+	pkcs11.CKM_SHA256:   {MGFNameToID["CKG_MGF1_SHA256"], 32},
+	pkcs11.CKM_SHA384:   {MGFNameToID["CKG_MGF1_SHA384"], 48},
+	pkcs11.CKM_SHA512:   {MGFNameToID["CKG_MGF1_SHA512"], 64},
+	pkcs11.CKM_SHA3_224: {MGFNameToID["CKG_MGF1_SHA3_224"], 28},
+	pkcs11.CKM_SHA3_256: {MGFNameToID["CKG_MGF1_SHA3_256"], 32},
+	pkcs11.CKM_SHA3_384: {MGFNameToID["CKG_MGF1_SHA3_384"], 48},
+	pkcs11.CKM_SHA3_512: {MGFNameToID["CKG_MGF1_SHA3_512"], 64},
+}
+
+// DefaultMGF returns the MGF1 variant matching the given digest mechanism.
+func DefaultMGF(hash uint) (uint, bool) {
+	info, ok := hashInfos[hash]
+	return info.mgf, ok
+}
+
+// HashLength returns the output length in bytes of the given digest mechanism.
+func HashLength(hash uint) (uint, bool) {
+	info, ok := hashInfos[hash]
+	return info.length, ok
+}
+
+// mechanismParams maps the mechanisms built by NewMechanism to their typed
+// parameters until they are freed. Marshaled parameters may contain pointers,
+// so module hosts marshal them again in their own process.
+var mechanismParams sync.Map // *pkcs11.Mechanism -> *MechanismParams
+
+// NewMechanism builds a single-element mechanism list for use with the Client
+// methods. The returned function releases memory held by the marshaled
+// parameters and must be called once the Client method has returned. If the
+// method abandoned a call that is still running in the module, e.g. after a
+// timeout, the memory is released once the call has returned.
+func NewMechanism(mechanismID uint, params *MechanismParams) ([]*pkcs11.Mechanism, func(), error) {
+	mech, free, err := newMechanism(mechanismID, params)
+	if err != nil || params == nil {
+		return mech, free, err
+	}
+	mechanismParams.Store(mech[0], params)
+	return mech, func() {
+		abandoned.release(func() {
+			mechanismParams.Delete(mech[0])
+			free()
+		})
+	}, nil
+}
+
+// newMechanism implements NewMechanism.
+func newMechanism(mechanismID uint, params *MechanismParams) ([]*pkcs11.Mechanism, func(), error) {
+	noop := func() {}
+	if params == nil {
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, nil)}, noop, nil
+	}
+
+	set := 0
+	for _, isSet := range []bool{
+		params.Raw != nil, params.IV != nil, params.GCM != nil, params.CCM != nil,
+		params.OAEP != nil, params.PSS != nil, params.ECDH1 != nil, params.HKDF != nil,
+		params.SP800108 != nil, params.StringData != nil, params.CBCEncryptData != nil,
+		params.Key != nil,
+	} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, nil, fmt.Errorf("%w: at most one parameter type may be set", ErrMechanismInvalid)
+	}
+
+	switch {
+	case params.Raw != nil:
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, params.Raw)}, noop, nil
+
+	case params.IV != nil:
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, params.IV)}, noop, nil
+
+	case params.GCM != nil:
+		tagBits := params.GCM.TagBits
+		if tagBits == 0 {
+			tagBits = 128
+		}
+		gcm := pkcs11.NewGCMParams(params.GCM.IV, params.GCM.AAD, int(tagBits))
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, gcm)}, gcm.Free, nil
+
+	case params.CCM != nil:
+		macLen := params.CCM.MACLen
+		if macLen == 0 {
+			macLen = 16
+		}
+		ccm := *params.CCM
+		ccm.MACLen = macLen
+		raw, free := marshalCCMParams(&ccm)
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, raw)}, free, nil
+
+	case params.OAEP != nil:
+		mgf, err := resolveMGF(params.OAEP.Hash, params.OAEP.MGF)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw, free := marshalOAEPParams(params.OAEP.Hash, mgf, params.OAEP.SourceData)
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, raw)}, free, nil
+
+	case params.PSS != nil:
+		mgf, err := resolveMGF(params.PSS.Hash, params.PSS.MGF)
+		if err != nil {
+			return nil, nil, err
+		}
+		saltLen, _ := HashLength(params.PSS.Hash)
+		if params.PSS.SaltLen != nil {
+			saltLen = *params.PSS.SaltLen
+		}
+		pss := pkcs11.NewPSSParams(params.PSS.Hash, mgf, saltLen)
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, pss)}, noop, nil
+
+	case params.ECDH1 != nil:
+		if len(params.ECDH1.PublicData) == 0 {
+			return nil, nil, fmt.Errorf("%w: ECDH1 public data must not be empty", ErrMechanismInvalid)
+		}
+		kdf := params.ECDH1.KDF
+		if kdf == 0 {
+			kdf = pkcs11.CKD_NULL
+		}
+		ecdh := pkcs11.NewECDH1DeriveParams(kdf, params.ECDH1.SharedData, params.ECDH1.PublicData)
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, ecdh)}, noop, nil
+
+	case params.HKDF != nil:
+		if !params.HKDF.Extract && !params.HKDF.Expand {
+			return nil, nil, fmt.Errorf("%w: HKDF requires extract, expand or both", ErrMechanismInvalid)
+		}
+		raw, free := marshalHKDFParams(params.HKDF)
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, raw)}, free, nil
+
+	case params.SP800108 != nil:
+		kdf := *params.SP800108
+		counterMode := mechanismID == MechanismNameToID["CKM_SP800_108_COUNTER_KDF"]
+		feedbackMode := mechanismID == MechanismNameToID["CKM_SP800_108_FEEDBACK_KDF"]
+		if counterMode && kdf.CounterBits == 0 {
+			kdf.CounterBits = 32
+		}
+		if kdf.DKMLengthBits == 0 {
+			kdf.DKMLengthBits = 32
+		}
+		if len(kdf.IV) > 0 && !feedbackMode {
+			return nil, nil, fmt.Errorf("%w: an IV is only supported in feedback mode", ErrMechanismInvalid)
+		}
+		raw, free := marshalSP800108Params(&kdf, counterMode, feedbackMode)
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, raw)}, free, nil
+
+	case params.StringData != nil:
+		raw, free := marshalKeyDerivationStringData(params.StringData)
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, raw)}, free, nil
+
+	case params.CBCEncryptData != nil:
+		raw, free, err := marshalCBCEncryptDataParams(params.CBCEncryptData.IV, params.CBCEncryptData.Data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, raw)}, free, nil
+
+	case params.Key != nil:
+		if params.Key.Handle == 0 {
+			return nil, nil, fmt.Errorf("%w: key %q has not been resolved", ErrMechanismInvalid, params.Key.Label)
+		}
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, marshalObjectHandle(uint(params.Key.Handle)))}, noop, nil
+	}
+
+	return []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, nil)}, noop, nil
+}
+
+// resolveMGF returns mgf if set, otherwise the MGF1 variant matching hash.
+func resolveMGF(hash, mgf uint) (uint, error) {
+	if mgf != 0 {
+		return mgf, nil
+	}
+	if m, ok := DefaultMGF(hash); ok {
+		return m, nil
+	}
+	return 0, fmt.Errorf("%w: no default MGF for hash %s, set mgf explicitly", ErrMechanismInvalid, MechanismEnum.Format(hash))
+}
+
+// PlaintextLen returns the message length for a CCM ciphertext of the given
+// length, which carries the MAC appended to the encrypted message.
+func (p *CCMParams) PlaintextLen(ciphertextLen int) uint {
+	macLen := p.MACLen
+	if macLen == 0 {
+		macLen = 16
+	}
+	if uint(ciphertextLen) < macLen {
+		return 0
+	}
+	return uint(ciphertextLen) - macLen
+}
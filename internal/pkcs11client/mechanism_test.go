@@ -0,0 +1,335 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"unsafe"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestNewMechanism_NilParams(t *testing.T) {
+	mech, free, err := NewMechanism(pkcs11.CKM_AES_ECB, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer free()
+
+	if len(mech) != 1 || mech[0].Mechanism != pkcs11.CKM_AES_ECB {
+		t.Fatalf("unexpected mechanism: %+v", mech)
+	}
+	if mech[0].Parameter != nil {
+		t.Errorf("expected no parameter, got %x", mech[0].Parameter)
+	}
+}
+
+func TestNewMechanism_IV(t *testing.T) {
+	iv := []byte("0123456789abcdef")
+	mech, free, err := NewMechanism(pkcs11.CKM_AES_CBC, &MechanismParams{IV: iv})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer free()
+
+	if !bytes.Equal(mech[0].Parameter, iv) {
+		t.Errorf("expected IV as parameter, got %x", mech[0].Parameter)
+	}
+}
+
+func TestNewMechanism_MultipleParamsRejected(t *testing.T) {
+	_, _, err := NewMechanism(pkcs11.CKM_AES_GCM, &MechanismParams{
+		IV:  []byte{1},
+		GCM: &GCMParams{IV: []byte{1}},
+	})
+	if !errors.Is(err, ErrMechanismInvalid) {
+		t.Fatalf("expected ErrMechanismInvalid, got %v", err)
+	}
+}
+
+func TestNewMechanism_GCM(t *testing.T) {
+	mech, free, err := NewMechanism(pkcs11.CKM_AES_GCM, &MechanismParams{
+		GCM: &GCMParams{IV: make([]byte, 12)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer free()
+
+	if mech[0].Mechanism != pkcs11.CKM_AES_GCM {
+		t.Errorf("expected CKM_AES_GCM, got 0x%x", mech[0].Mechanism)
+	}
+	// CK_GCM_PARAMS contains pointers and is marshaled by the module wrapper
+	// when the operation is initialized.
+	if mech[0].Parameter != nil {
+		t.Errorf("expected deferred parameter marshaling, got %x", mech[0].Parameter)
+	}
+}
+
+func TestNewMechanism_CCM(t *testing.T) {
+	if unsafe.Sizeof(uint(0)) != 8 {
+		t.Skip("struct layout check assumes 64-bit CK_ULONG")
+	}
+	mech, free, err := NewMechanism(pkcs11.CKM_AES_CCM, &MechanismParams{
+		CCM: &CCMParams{DataLen: 32, Nonce: make([]byte, 13)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer free()
+
+	p := mech[0].Parameter
+	if len(p) != 48 {
+		t.Fatalf("expected 48-byte CK_CCM_PARAMS, got %d", len(p))
+	}
+	field := func(i int) uint64 { return binary.LittleEndian.Uint64(p[i*8:]) }
+	if field(0) != 32 {
+		t.Errorf("expected ulDataLen 32, got %d", field(0))
+	}
+	if field(2) != 13 {
+		t.Errorf("expected ulNonceLen 13, got %d", field(2))
+	}
+	if field(3) != 0 || field(4) != 0 {
+		t.Errorf("expected empty AAD, got pointer 0x%x length %d", field(3), field(4))
+	}
+	if field(5) != 16 {
+		t.Errorf("expected default ulMACLen 16, got %d", field(5))
+	}
+}
+
+func TestNewMechanism_OAEP(t *testing.T) {
+	if unsafe.Sizeof(uint(0)) != 8 {
+		t.Skip("struct layout check assumes 64-bit CK_ULONG")
+	}
+	for _, label := range [][]byte{nil, []byte("label")} {
+		mech, free, err := NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, &MechanismParams{
+			OAEP: &OAEPParams{Hash: pkcs11.CKM_SHA256, SourceData: label},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		p := mech[0].Parameter
+		if len(p) != 40 {
+			t.Fatalf("expected 40-byte CK_RSA_PKCS_OAEP_PARAMS, got %d", len(p))
+		}
+		field := func(i int) uint64 { return binary.LittleEndian.Uint64(p[i*8:]) }
+		if field(0) != pkcs11.CKM_SHA256 || field(1) != pkcs11.CKG_MGF1_SHA256 {
+			t.Errorf("expected SHA-256 with MGF1-SHA256, got hashAlg 0x%x mgf 0x%x", field(0), field(1))
+		}
+		// CKZ_DATA_SPECIFIED is the only valid source, also without label
+		if field(2) != pkcs11.CKZ_DATA_SPECIFIED {
+			t.Errorf("label %q: expected source CKZ_DATA_SPECIFIED, got 0x%x", label, field(2))
+		}
+		if label == nil && (field(3) != 0 || field(4) != 0) {
+			t.Errorf("expected no source data, got pointer 0x%x length %d", field(3), field(4))
+		}
+		if label != nil && (field(3) == 0 || field(4) != uint64(len(label))) {
+			t.Errorf("expected source data of length %d, got pointer 0x%x length %d", len(label), field(3), field(4))
+		}
+		free()
+	}
+}
+
+func TestNewMechanism_PSSDefaults(t *testing.T) {
+	mech, free, err := NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS_PSS, &MechanismParams{
+		PSS: &PSSParams{Hash: pkcs11.CKM_SHA256},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer free()
+
+	expected := pkcs11.NewPSSParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, 32)
+	if !bytes.Equal(mech[0].Parameter, expected) {
+		t.Errorf("expected %x, got %x", expected, mech[0].Parameter)
+	}
+}
+
+func TestNewMechanism_PSSZeroSaltLen(t *testing.T) {
+	var saltLen uint
+	mech, free, err := NewMechanism(pkcs11.CKM_SHA256_RSA_PKCS_PSS, &MechanismParams{
+		PSS: &PSSParams{Hash: pkcs11.CKM_SHA256, SaltLen: &saltLen},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer free()
+
+	expected := pkcs11.NewPSSParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, 0)
+	if !bytes.Equal(mech[0].Parameter, expected) {
+		t.Errorf("expected %x, got %x", expected, mech[0].Parameter)
+	}
+}
+
+func TestNewMechanism_PSSUnknownHashNeedsMGF(t *testing.T) {
+	_, _, err := NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, &MechanismParams{
+		PSS: &PSSParams{Hash: pkcs11.CKM_MD5},
+	})
+	if !errors.Is(err, ErrMechanismInvalid) {
+		t.Fatalf("expected ErrMechanismInvalid, got %v", err)
+	}
+}
+
+func TestNewMechanism_ECDH1RequiresPublicData(t *testing.T) {
+	_, _, err := NewMechanism(pkcs11.CKM_ECDH1_DERIVE, &MechanismParams{
+		ECDH1: &ECDH1Params{},
+	})
+	if !errors.Is(err, ErrMechanismInvalid) {
+		t.Fatalf("expected ErrMechanismInvalid, got %v", err)
+	}
+}
+
+func TestNewMechanism_HKDF(t *testing.T) {
+	if unsafe.Sizeof(uint(0)) != 8 {
+		t.Skip("struct layout check assumes 64-bit CK_ULONG")
+	}
+	mech, free, err := NewMechanism(MechanismNameToID["CKM_HKDF_DERIVE"], &MechanismParams{
+		HKDF: &HKDFParams{PRF: pkcs11.CKM_SHA256, Extract: true, Expand: true, Salt: []byte("salt"), Info: []byte("info")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer free()
+
+	p := mech[0].Parameter
+	if len(p) != 64 {
+		t.Fatalf("expected 64-byte CK_HKDF_PARAMS, got %d", len(p))
+	}
+	if p[0] != 1 || p[1] != 1 {
+		t.Errorf("expected bExtract and bExpand to be set, got %d %d", p[0], p[1])
+	}
+	field := func(i int) uint64 { return binary.LittleEndian.Uint64(p[i*8:]) }
+	if field(1) != pkcs11.CKM_SHA256 {
+		t.Errorf("expected prfHashMechanism CKM_SHA256, got 0x%x", field(1))
+	}
+	if field(2) != ckfHKDFSaltData || field(4) != 4 {
+		t.Errorf("expected salt type CKF_HKDF_SALT_DATA with 4 bytes, got %d with %d bytes", field(2), field(4))
+	}
+	if field(7) != 4 {
+		t.Errorf("expected ulInfoLen 4, got %d", field(7))
+	}
+}
+
+func TestNewMechanism_HKDFRequiresExtractOrExpand(t *testing.T) {
+	_, _, err := NewMechanism(MechanismNameToID["CKM_HKDF_DERIVE"], &MechanismParams{
+		HKDF: &HKDFParams{PRF: pkcs11.CKM_SHA256},
+	})
+	if !errors.Is(err, ErrMechanismInvalid) {
+		t.Fatalf("expected ErrMechanismInvalid, got %v", err)
+	}
+}
+
+func TestNewMechanism_SP800108Counter(t *testing.T) {
+	if unsafe.Sizeof(uint(0)) != 8 {
+		t.Skip("struct layout check assumes 64-bit CK_ULONG")
+	}
+	mech, free, err := NewMechanism(MechanismNameToID["CKM_SP800_108_COUNTER_KDF"], &MechanismParams{
+		SP800108: &SP800108Params{PRF: pkcs11.CKM_SHA256_HMAC, FixedInput: []byte("label")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer free()
+
+	p := mech[0].Parameter
+	if len(p) != 40 {
+		t.Fatalf("expected 40-byte CK_SP800_108_KDF_PARAMS, got %d", len(p))
+	}
+	field := func(i int) uint64 { return binary.LittleEndian.Uint64(p[i*8:]) }
+	if field(0) != pkcs11.CKM_SHA256_HMAC {
+		t.Errorf("expected prfType CKM_SHA256_HMAC, got 0x%x", field(0))
+	}
+	// Counter, fixed input and DKM length
+	if field(1) != 3 {
+		t.Errorf("expected 3 data params, got %d", field(1))
+	}
+}
+
+func TestNewMechanism_SP800108IVRequiresFeedbackMode(t *testing.T) {
+	_, _, err := NewMechanism(MechanismNameToID["CKM_SP800_108_COUNTER_KDF"], &MechanismParams{
+		SP800108: &SP800108Params{PRF: pkcs11.CKM_SHA256_HMAC, IV: []byte{1}},
+	})
+	if !errors.Is(err, ErrMechanismInvalid) {
+		t.Fatalf("expected ErrMechanismInvalid, got %v", err)
+	}
+
+	mech, free, err := NewMechanism(MechanismNameToID["CKM_SP800_108_FEEDBACK_KDF"], &MechanismParams{
+		SP800108: &SP800108Params{PRF: pkcs11.CKM_SHA256_HMAC, IV: []byte{1}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in feedback mode: %v", err)
+	}
+	defer free()
+	if unsafe.Sizeof(uint(0)) == 8 && len(mech[0].Parameter) != 56 {
+		t.Errorf("expected 56-byte CK_SP800_108_FEEDBACK_KDF_PARAMS, got %d", len(mech[0].Parameter))
+	}
+}
+
+func TestNewMechanism_CBCEncryptData(t *testing.T) {
+	mech, free, err := NewMechanism(pkcs11.CKM_AES_CBC_ENCRYPT_DATA, &MechanismParams{
+		CBCEncryptData: &CBCEncryptDataParams{IV: make([]byte, 16), Data: make([]byte, 32)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer free()
+	if unsafe.Sizeof(uint(0)) == 8 && len(mech[0].Parameter) != 32 {
+		t.Errorf("expected 32-byte CK_AES_CBC_ENCRYPT_DATA_PARAMS, got %d", len(mech[0].Parameter))
+	}
+
+	_, _, err = NewMechanism(pkcs11.CKM_AES_CBC_ENCRYPT_DATA, &MechanismParams{
+		CBCEncryptData: &CBCEncryptDataParams{IV: make([]byte, 12), Data: make([]byte, 32)},
+	})
+	if !errors.Is(err, ErrMechanismInvalid) {
+		t.Fatalf("expected ErrMechanismInvalid for 12-byte IV, got %v", err)
+	}
+}
+
+func TestNewMechanism_StringData(t *testing.T) {
+	mech, free, err := NewMechanism(pkcs11.CKM_AES_ECB_ENCRYPT_DATA, &MechanismParams{
+		StringData: make([]byte, 16),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer free()
+	if unsafe.Sizeof(uint(0)) == 8 && len(mech[0].Parameter) != 16 {
+		t.Errorf("expected 16-byte CK_KEY_DERIVATION_STRING_DATA, got %d", len(mech[0].Parameter))
+	}
+}
+
+func TestNewMechanism_UnresolvedKeyRejected(t *testing.T) {
+	_, _, err := NewMechanism(pkcs11.CKM_CONCATENATE_BASE_AND_KEY, &MechanismParams{
+		Key: &KeyRef{Label: "other", Class: pkcs11.CKO_SECRET_KEY},
+	})
+	if !errors.Is(err, ErrMechanismInvalid) {
+		t.Fatalf("expected ErrMechanismInvalid, got %v", err)
+	}
+
+	mech, free, err := NewMechanism(pkcs11.CKM_CONCATENATE_BASE_AND_KEY, &MechanismParams{
+		Key: &KeyRef{Label: "other", Class: pkcs11.CKO_SECRET_KEY, Handle: 7},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer free()
+	if unsafe.Sizeof(uint(0)) == 8 && binary.LittleEndian.Uint64(mech[0].Parameter) != 7 {
+		t.Errorf("expected object handle 7 as parameter, got %x", mech[0].Parameter)
+	}
+}
+
+func TestCCMParams_PlaintextLen(t *testing.T) {
+	p := &CCMParams{}
+	if got := p.PlaintextLen(48); got != 32 {
+		t.Errorf("expected 32 with default MAC length, got %d", got)
+	}
+	p.MACLen = 8
+	if got := p.PlaintextLen(40); got != 32 {
+		t.Errorf("expected 32 with 8-byte MAC, got %d", got)
+	}
+	if got := p.PlaintextLen(4); got != 0 {
+		t.Errorf("expected 0 for short ciphertext, got %d", got)
+	}
+}
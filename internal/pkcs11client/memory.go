@@ -0,0 +1,832 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// MemoryModulePrefix selects the in-memory token emulator instead of a
+// PKCS#11 module: the module path "memory:" keeps the token in memory only,
+// "memory:<file>" also stores it in file.
+const MemoryModulePrefix = "memory:"
+
+const (
+	memorySlotID       = 0
+	memoryManufacturer = "terraform-provider-pkcs11"
+	memoryMinPinLen    = 4
+	memoryMaxPinLen    = 64
+)
+
+// MemoryContext is a Pkcs11Context that emulates a token in pure Go, for
+// developing and testing configurations without an HSM. It has a single slot
+// whose token starts uninitialized, as with SoftHSM, and implements the
+// mechanisms in memoryMechanisms. Objects follow the attribute policies of
+// PKCS#11: sensitive and unextractable key material cannot be read, private
+// objects require a user login, and attributes can only change as the
+// specification allows.
+//
+// If file is set, the token and its token objects are loaded from it on
+// C_Initialize and written back after every change, so that they survive
+// provider restarts. The file holds PINs and keys in plain text.
+type MemoryContext struct {
+	file string
+
+	mu          sync.Mutex // guards the fields below
+	initialized bool
+	token       memoryToken
+	objects     map[pkcs11.ObjectHandle]*memoryObject
+	nextObject  pkcs11.ObjectHandle
+	sessions    map[pkcs11.SessionHandle]*memorySession
+	nextSession pkcs11.SessionHandle
+	loggedIn    bool // the login state is shared by all sessions
+	userType    uint
+}
+
+// memoryToken is the state of the emulated token.
+type memoryToken struct {
+	Label       string `json:"label"`
+	Initialized bool   `json:"initialized"`
+	SOPin       string `json:"so_pin"`
+	UserPin     string `json:"user_pin,omitempty"` // empty until C_InitPIN
+}
+
+// memoryState is the content of the file of a MemoryContext.
+type memoryState struct {
+	Token      memoryToken                             `json:"token"`
+	Objects    map[pkcs11.ObjectHandle]map[uint][]byte `json:"objects"`
+	NextObject pkcs11.ObjectHandle                     `json:"next_object"`
+}
+
+// memoryObject is an object of the emulated token with its attributes in
+// their PKCS#11 encoding.
+type memoryObject struct {
+	attrs   map[uint][]byte
+	session pkcs11.SessionHandle // session owning a session object, 0 for token objects
+}
+
+func (o *memoryObject) flag(t uint) bool  { return BytesToBool(o.attrs[t]) }
+func (o *memoryObject) ulong(t uint) uint { return BytesToUlong(o.attrs[t]) }
+
+// sensitive reports whether the attribute t holds key material that must not
+// leave the token.
+func (o *memoryObject) sensitive(t uint) bool {
+	class := o.ulong(pkcs11.CKA_CLASS)
+	if class != pkcs11.CKO_SECRET_KEY && class != pkcs11.CKO_PRIVATE_KEY {
+		return false
+	}
+	return memorySensitiveAttrs[t] && (o.flag(pkcs11.CKA_SENSITIVE) || !o.flag(pkcs11.CKA_EXTRACTABLE))
+}
+
+// memorySession is an open session of a MemoryContext.
+type memorySession struct {
+	rw      bool
+	finding bool                  // a search is active
+	found   []pkcs11.ObjectHandle // remaining results of the search
+	ops     map[memoryOpKind]*memoryOp
+}
+
+// NewMemoryContext returns an emulated token that is persisted in file, or
+// only kept in memory if file is empty.
+func NewMemoryContext(file string) *MemoryContext {
+	return &MemoryContext{
+		file:    file,
+		objects: make(map[pkcs11.ObjectHandle]*memoryObject),
+	}
+}
+
+func (m *MemoryContext) Initialize(...pkcs11.InitializeOption) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.initialized {
+		return pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)
+	}
+	if err := m.load(); err != nil {
+		return err
+	}
+	m.sessions = make(map[pkcs11.SessionHandle]*memorySession)
+	m.initialized = true
+	return nil
+}
+
+func (m *MemoryContext) Finalize() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.initialized {
+		return pkcs11.Error(pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED)
+	}
+	for sh := range m.sessions {
+		m.closeSession(sh)
+	}
+	m.initialized = false
+	return nil
+}
+
+// load reads the token from the file, if any. A missing file leaves the
+// token uninitialized.
+func (m *MemoryContext) load() error {
+	if m.file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("pkcs11: loading memory token: %w", err)
+	}
+	var state memoryState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("pkcs11: loading memory token from %s: %w", m.file, err)
+	}
+	m.token = state.Token
+	m.nextObject = state.NextObject
+	m.objects = make(map[pkcs11.ObjectHandle]*memoryObject, len(state.Objects))
+	for oh, attrs := range state.Objects {
+		m.objects[oh] = &memoryObject{attrs: attrs}
+	}
+	return nil
+}
+
+// save writes the token and its token objects to the file, if any. The
+// caller must hold m.mu.
+func (m *MemoryContext) save() error {
+	if m.file == "" {
+		return nil
+	}
+	state := memoryState{
+		Token:      m.token,
+		Objects:    make(map[pkcs11.ObjectHandle]map[uint][]byte),
+		NextObject: m.nextObject,
+	}
+	for oh, obj := range m.objects {
+		if obj.session == 0 {
+			state.Objects[oh] = obj.attrs
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("pkcs11: storing memory token: %w", err)
+	}
+
+	// Replace the file atomically, so that a crash leaves the previous state
+	tmp, err := os.CreateTemp(filepath.Dir(m.file), filepath.Base(m.file)+".*")
+	if err != nil {
+		return fmt.Errorf("pkcs11: storing memory token: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.file)
+	}
+	if err != nil {
+		return fmt.Errorf("pkcs11: storing memory token: %w", err)
+	}
+	return nil
+}
+
+// checkSlot returns an error unless slotID is the slot of the emulator. The
+// caller must hold m.mu.
+func (m *MemoryContext) checkSlot(slotID uint) error {
+	if !m.initialized {
+		return pkcs11.Error(pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED)
+	}
+	if slotID != memorySlotID {
+		return pkcs11.Error(pkcs11.CKR_SLOT_ID_INVALID)
+	}
+	return nil
+}
+
+func (m *MemoryContext) GetSlotList(tokenPresent bool) ([]uint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.initialized {
+		return nil, pkcs11.Error(pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED)
+	}
+	return []uint{memorySlotID}, nil
+}
+
+func (m *MemoryContext) GetSlotInfo(slotID uint) (pkcs11.SlotInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkSlot(slotID); err != nil {
+		return pkcs11.SlotInfo{}, err
+	}
+	return pkcs11.SlotInfo{
+		SlotDescription: "In-memory token emulator",
+		ManufacturerID:  memoryManufacturer,
+		Flags:           pkcs11.CKF_TOKEN_PRESENT,
+	}, nil
+}
+
+func (m *MemoryContext) GetTokenInfo(slotID uint) (pkcs11.TokenInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkSlot(slotID); err != nil {
+		return pkcs11.TokenInfo{}, err
+	}
+
+	flags := uint(pkcs11.CKF_RNG | pkcs11.CKF_LOGIN_REQUIRED)
+	if m.token.Initialized {
+		flags |= pkcs11.CKF_TOKEN_INITIALIZED
+	}
+	if m.token.UserPin != "" {
+		flags |= pkcs11.CKF_USER_PIN_INITIALIZED
+	}
+	var rwSessions uint
+	for _, sess := range m.sessions {
+		if sess.rw {
+			rwSessions++
+		}
+	}
+	return pkcs11.TokenInfo{
+		Label:              m.token.Label,
+		ManufacturerID:     memoryManufacturer,
+		Model:              "Memory",
+		SerialNumber:       "0000000000000000",
+		Flags:              flags,
+		MaxSessionCount:    pkcs11.CK_EFFECTIVELY_INFINITE,
+		SessionCount:       uint(len(m.sessions)),
+		MaxRwSessionCount:  pkcs11.CK_EFFECTIVELY_INFINITE,
+		RwSessionCount:     rwSessions,
+		MaxPinLen:          memoryMaxPinLen,
+		MinPinLen:          memoryMinPinLen,
+		TotalPublicMemory:  pkcs11.CK_UNAVAILABLE_INFORMATION,
+		FreePublicMemory:   pkcs11.CK_UNAVAILABLE_INFORMATION,
+		TotalPrivateMemory: pkcs11.CK_UNAVAILABLE_INFORMATION,
+		FreePrivateMemory:  pkcs11.CK_UNAVAILABLE_INFORMATION,
+		HardwareVersion:    pkcs11.Version{Major: 1},
+		FirmwareVersion:    pkcs11.Version{Major: 1},
+	}, nil
+}
+
+func (m *MemoryContext) GetMechanismList(slotID uint) ([]*pkcs11.Mechanism, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkSlot(slotID); err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(memoryMechanisms))
+	for id := range memoryMechanisms {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	mechs := make([]*pkcs11.Mechanism, len(ids))
+	for i, id := range ids {
+		mechs[i] = pkcs11.NewMechanism(id, nil)
+	}
+	return mechs, nil
+}
+
+func (m *MemoryContext) GetMechanismInfo(slotID uint, mechs []*pkcs11.Mechanism) (pkcs11.MechanismInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkSlot(slotID); err != nil {
+		return pkcs11.MechanismInfo{}, err
+	}
+	if len(mechs) != 1 {
+		return pkcs11.MechanismInfo{}, pkcs11.Error(pkcs11.CKR_ARGUMENTS_BAD)
+	}
+	mech, ok := memoryMechanisms[mechs[0].Mechanism]
+	if !ok {
+		return pkcs11.MechanismInfo{}, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+	}
+	return mech.info, nil
+}
+
+func (m *MemoryContext) OpenSession(slotID uint, flags uint) (pkcs11.SessionHandle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkSlot(slotID); err != nil {
+		return 0, err
+	}
+	if flags&pkcs11.CKF_SERIAL_SESSION == 0 {
+		return 0, pkcs11.Error(pkcs11.CKR_SESSION_PARALLEL_NOT_SUPPORTED)
+	}
+	rw := flags&pkcs11.CKF_RW_SESSION != 0
+	if !rw && m.loggedIn && m.userType == pkcs11.CKU_SO {
+		return 0, pkcs11.Error(pkcs11.CKR_SESSION_READ_WRITE_SO_EXISTS)
+	}
+	m.nextSession++
+	m.sessions[m.nextSession] = &memorySession{rw: rw, ops: make(map[memoryOpKind]*memoryOp)}
+	return m.nextSession, nil
+}
+
+func (m *MemoryContext) CloseSession(sh pkcs11.SessionHandle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.session(sh); err != nil {
+		return err
+	}
+	m.closeSession(sh)
+	return nil
+}
+
+// closeSession destroys the session objects of sh and closes it. Closing the
+// last session logs out. The caller must hold m.mu.
+func (m *MemoryContext) closeSession(sh pkcs11.SessionHandle) {
+	for oh, obj := range m.objects {
+		if obj.session == sh {
+			delete(m.objects, oh)
+		}
+	}
+	delete(m.sessions, sh)
+	if len(m.sessions) == 0 {
+		m.loggedIn = false
+	}
+}
+
+// session returns the open session sh. The caller must hold m.mu.
+func (m *MemoryContext) session(sh pkcs11.SessionHandle) (*memorySession, error) {
+	if !m.initialized {
+		return nil, pkcs11.Error(pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED)
+	}
+	sess, ok := m.sessions[sh]
+	if !ok {
+		return nil, pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	}
+	return sess, nil
+}
+
+func (m *MemoryContext) GetSessionInfo(sh pkcs11.SessionHandle) (pkcs11.SessionInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return pkcs11.SessionInfo{}, err
+	}
+
+	info := pkcs11.SessionInfo{SlotID: memorySlotID, Flags: pkcs11.CKF_SERIAL_SESSION}
+	if sess.rw {
+		info.Flags |= pkcs11.CKF_RW_SESSION
+	}
+	switch {
+	case m.loggedIn && m.userType == pkcs11.CKU_SO:
+		info.State = pkcs11.CKS_RW_SO_FUNCTIONS
+	case m.loggedIn && sess.rw:
+		info.State = pkcs11.CKS_RW_USER_FUNCTIONS
+	case m.loggedIn:
+		info.State = pkcs11.CKS_RO_USER_FUNCTIONS
+	case sess.rw:
+		info.State = pkcs11.CKS_RW_PUBLIC_SESSION
+	default:
+		info.State = pkcs11.CKS_RO_PUBLIC_SESSION
+	}
+	return info, nil
+}
+
+// user reports whether the normal user is logged in. The caller must hold m.mu.
+func (m *MemoryContext) user() bool {
+	return m.loggedIn && m.userType == pkcs11.CKU_USER
+}
+
+func (m *MemoryContext) Login(sh pkcs11.SessionHandle, userType uint, pin string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+
+	var expected string
+	switch userType {
+	case pkcs11.CKU_CONTEXT_SPECIFIC:
+		return m.loginContextSpecific(sess, pin)
+	case pkcs11.CKU_SO:
+		expected = m.token.SOPin
+	case pkcs11.CKU_USER:
+		expected = m.token.UserPin
+	default:
+		return pkcs11.Error(pkcs11.CKR_USER_TYPE_INVALID)
+	}
+	if m.loggedIn {
+		if m.userType == userType {
+			return pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)
+		}
+		return pkcs11.Error(pkcs11.CKR_USER_ANOTHER_ALREADY_LOGGED_IN)
+	}
+	if !m.token.Initialized {
+		return pkcs11.Error(pkcs11.CKR_TOKEN_NOT_RECOGNIZED)
+	}
+	if userType == pkcs11.CKU_SO {
+		for _, s := range m.sessions {
+			if !s.rw {
+				return pkcs11.Error(pkcs11.CKR_SESSION_READ_ONLY_EXISTS)
+			}
+		}
+	} else if expected == "" {
+		return pkcs11.Error(pkcs11.CKR_USER_PIN_NOT_INITIALIZED)
+	}
+	if pin != expected {
+		return pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)
+	}
+	m.loggedIn = true
+	m.userType = userType
+	return nil
+}
+
+// loginContextSpecific authorizes the active operations of sess that use a
+// key with CKA_ALWAYS_AUTHENTICATE. The caller must hold m.mu.
+func (m *MemoryContext) loginContextSpecific(sess *memorySession, pin string) error {
+	if !m.user() {
+		return pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN)
+	}
+	var authorized bool
+	for _, op := range sess.ops {
+		if op.key != nil && op.key.flag(pkcs11.CKA_ALWAYS_AUTHENTICATE) {
+			if pin != m.token.UserPin {
+				return pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)
+			}
+			op.authorized = true
+			authorized = true
+		}
+	}
+	if !authorized {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	return nil
+}
+
+func (m *MemoryContext) Logout(sh pkcs11.SessionHandle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.session(sh); err != nil {
+		return err
+	}
+	if !m.loggedIn {
+		return pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN)
+	}
+	m.loggedIn = false
+	for _, sess := range m.sessions {
+		clear(sess.ops)
+		sess.finding, sess.found = false, nil
+	}
+	return nil
+}
+
+func validMemoryPin(pin string) bool {
+	return len(pin) >= memoryMinPinLen && len(pin) <= memoryMaxPinLen
+}
+
+func (m *MemoryContext) InitToken(slotID uint, soPin string, label string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkSlot(slotID); err != nil {
+		return err
+	}
+	if len(m.sessions) > 0 {
+		return pkcs11.Error(pkcs11.CKR_SESSION_EXISTS)
+	}
+	if m.token.Initialized && soPin != m.token.SOPin {
+		return pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)
+	}
+	if !validMemoryPin(soPin) {
+		return pkcs11.Error(pkcs11.CKR_PIN_LEN_RANGE)
+	}
+	m.token = memoryToken{
+		Label:       strings.TrimRight(label, " "),
+		Initialized: true,
+		SOPin:       soPin,
+	}
+	m.objects = make(map[pkcs11.ObjectHandle]*memoryObject)
+	return m.save()
+}
+
+func (m *MemoryContext) InitPIN(sh pkcs11.SessionHandle, pin string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+	if !m.loggedIn || m.userType != pkcs11.CKU_SO {
+		return pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN)
+	}
+	if !sess.rw {
+		return pkcs11.Error(pkcs11.CKR_SESSION_READ_ONLY)
+	}
+	if !validMemoryPin(pin) {
+		return pkcs11.Error(pkcs11.CKR_PIN_LEN_RANGE)
+	}
+	m.token.UserPin = pin
+	return m.save()
+}
+
+func (m *MemoryContext) SetPIN(sh pkcs11.SessionHandle, oldPin string, newPin string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+	if !sess.rw {
+		return pkcs11.Error(pkcs11.CKR_SESSION_READ_ONLY)
+	}
+
+	// The PIN of the logged in user is changed, or the user PIN if nobody is
+	pin := &m.token.UserPin
+	if m.loggedIn && m.userType == pkcs11.CKU_SO {
+		pin = &m.token.SOPin
+	} else if *pin == "" {
+		return pkcs11.Error(pkcs11.CKR_USER_PIN_NOT_INITIALIZED)
+	}
+	if oldPin != *pin {
+		return pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)
+	}
+	if !validMemoryPin(newPin) {
+		return pkcs11.Error(pkcs11.CKR_PIN_LEN_RANGE)
+	}
+	*pin = newPin
+	return m.save()
+}
+
+// object returns the object oh if it is visible in the current login state.
+// The caller must hold m.mu.
+func (m *MemoryContext) object(oh pkcs11.ObjectHandle) (*memoryObject, error) {
+	obj, ok := m.objects[oh]
+	if !ok || (obj.flag(pkcs11.CKA_PRIVATE) && !m.user()) {
+		return nil, pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	return obj, nil
+}
+
+// key returns the key oh, or invalid as error if there is no such key. The
+// caller must hold m.mu.
+func (m *MemoryContext) key(oh pkcs11.ObjectHandle, invalid uint) (*memoryObject, error) {
+	obj, err := m.object(oh)
+	if err != nil {
+		return nil, pkcs11.Error(invalid)
+	}
+	switch obj.ulong(pkcs11.CKA_CLASS) {
+	case pkcs11.CKO_SECRET_KEY, pkcs11.CKO_PUBLIC_KEY, pkcs11.CKO_PRIVATE_KEY:
+		return obj, nil
+	}
+	return nil, pkcs11.Error(invalid)
+}
+
+// checkWrite returns an error if sess may not modify obj. The caller must
+// hold m.mu.
+func checkWrite(sess *memorySession, obj *memoryObject) error {
+	if obj.session == 0 && !sess.rw {
+		return pkcs11.Error(pkcs11.CKR_SESSION_READ_ONLY)
+	}
+	return nil
+}
+
+// commit stores the token if obj is a token object. The caller must hold m.mu.
+func (m *MemoryContext) commit(obj *memoryObject) error {
+	if obj.session != 0 {
+		return nil
+	}
+	return m.save()
+}
+
+func (m *MemoryContext) CreateObject(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	attrs, err := memoryTemplate(temp)
+	if err != nil {
+		return 0, err
+	}
+	if err := newMemoryObject(attrs, memoryOrigin{mechanism: pkcs11.CK_UNAVAILABLE_INFORMATION}); err != nil {
+		return 0, err
+	}
+	return m.addNew(sh, attrs)
+}
+
+// addObject stores the attributes of a new object, checked by
+// newMemoryObject, if the session may create it. The caller must hold m.mu.
+func (m *MemoryContext) addObject(sh pkcs11.SessionHandle, sess *memorySession, attrs map[uint][]byte) (pkcs11.ObjectHandle, *memoryObject, error) {
+	if err := m.checkCreate(sess, attrs); err != nil {
+		return 0, nil, err
+	}
+	obj := &memoryObject{attrs: attrs}
+	if !BytesToBool(attrs[pkcs11.CKA_TOKEN]) {
+		obj.session = sh
+	}
+	m.nextObject++
+	m.objects[m.nextObject] = obj
+	return m.nextObject, obj, nil
+}
+
+// checkCreate returns an error if sess may not create an object with attrs.
+// The caller must hold m.mu.
+func (m *MemoryContext) checkCreate(sess *memorySession, attrs map[uint][]byte) error {
+	if BytesToBool(attrs[pkcs11.CKA_TOKEN]) && !sess.rw {
+		return pkcs11.Error(pkcs11.CKR_SESSION_READ_ONLY)
+	}
+	if BytesToBool(attrs[pkcs11.CKA_PRIVATE]) && !m.user() {
+		return pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN)
+	}
+	// Only the Security Officer may mark keys as trusted
+	if BytesToBool(attrs[pkcs11.CKA_TRUSTED]) && !(m.loggedIn && m.userType == pkcs11.CKU_SO) {
+		return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_READ_ONLY)
+	}
+	return nil
+}
+
+func (m *MemoryContext) DestroyObject(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+	obj, err := m.object(oh)
+	if err != nil {
+		return err
+	}
+	if err := checkWrite(sess, obj); err != nil {
+		return err
+	}
+	if !obj.flag(pkcs11.CKA_DESTROYABLE) {
+		return pkcs11.Error(pkcs11.CKR_ACTION_PROHIBITED)
+	}
+	delete(m.objects, oh)
+	return m.commit(obj)
+}
+
+func (m *MemoryContext) CopyObject(sh pkcs11.SessionHandle, o pkcs11.ObjectHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return 0, err
+	}
+	obj, err := m.object(o)
+	if err != nil {
+		return 0, err
+	}
+	if !obj.flag(pkcs11.CKA_COPYABLE) {
+		return 0, pkcs11.Error(pkcs11.CKR_ACTION_PROHIBITED)
+	}
+	changes, err := memoryTemplate(temp)
+	if err != nil {
+		return 0, err
+	}
+	attrs := make(map[uint][]byte, len(obj.attrs))
+	for t, v := range obj.attrs {
+		attrs[t] = bytes.Clone(v)
+	}
+	for t, v := range changes {
+		if err := checkChange(obj, t, v, true); err != nil {
+			return 0, err
+		}
+		attrs[t] = v
+	}
+
+	oh, copied, err := m.addObject(sh, sess, attrs)
+	if err != nil {
+		return 0, err
+	}
+	return oh, m.commit(copied)
+}
+
+func (m *MemoryContext) FindObjectsInit(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+	if sess.finding {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_ACTIVE)
+	}
+
+	var found []pkcs11.ObjectHandle
+	for oh := range m.objects {
+		obj, err := m.object(oh)
+		if err != nil {
+			continue
+		}
+		if slices.ContainsFunc(temp, func(a *pkcs11.Attribute) bool {
+			v, ok := obj.attrs[a.Type]
+			return !ok || obj.sensitive(a.Type) || !bytes.Equal(v, a.Value)
+		}) {
+			continue
+		}
+		found = append(found, oh)
+	}
+	slices.Sort(found)
+	sess.finding, sess.found = true, found
+	return nil
+}
+
+func (m *MemoryContext) FindObjects(sh pkcs11.SessionHandle, max int) ([]pkcs11.ObjectHandle, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return nil, false, err
+	}
+	if !sess.finding {
+		return nil, false, pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	n := min(max, len(sess.found))
+	result := sess.found[:n:n]
+	sess.found = sess.found[n:]
+	return result, false, nil
+}
+
+func (m *MemoryContext) FindObjectsFinal(sh pkcs11.SessionHandle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+	if !sess.finding {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	sess.finding, sess.found = false, nil
+	return nil
+}
+
+func (m *MemoryContext) GetAttributeValue(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle, temp []*pkcs11.Attribute) ([]*pkcs11.Attribute, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.session(sh); err != nil {
+		return nil, err
+	}
+	obj, err := m.object(oh)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*pkcs11.Attribute, len(temp))
+	for i, a := range temp {
+		v, ok := obj.attrs[a.Type]
+		switch {
+		case !ok:
+			return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_TYPE_INVALID)
+		case obj.sensitive(a.Type):
+			return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_SENSITIVE)
+		}
+		result[i] = &pkcs11.Attribute{Type: a.Type, Value: bytes.Clone(v)}
+	}
+	return result, nil
+}
+
+func (m *MemoryContext) SetAttributeValue(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle, temp []*pkcs11.Attribute) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+	obj, err := m.object(oh)
+	if err != nil {
+		return err
+	}
+	if err := checkWrite(sess, obj); err != nil {
+		return err
+	}
+	if !obj.flag(pkcs11.CKA_MODIFIABLE) {
+		return pkcs11.Error(pkcs11.CKR_ACTION_PROHIBITED)
+	}
+	changes, err := memoryTemplate(temp)
+	if err != nil {
+		return err
+	}
+	for t, v := range changes {
+		if err := checkChange(obj, t, v, false); err != nil {
+			return err
+		}
+	}
+	if BytesToBool(changes[pkcs11.CKA_TRUSTED]) && !(m.loggedIn && m.userType == pkcs11.CKU_SO) {
+		return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_READ_ONLY)
+	}
+	for t, v := range changes {
+		obj.attrs[t] = v
+	}
+	return m.commit(obj)
+}
+
+func (m *MemoryContext) SeedRandom(sh pkcs11.SessionHandle, seed []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.session(sh)
+	return err
+}
+
+func (m *MemoryContext) GenerateRandom(sh pkcs11.SessionHandle, length int) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.session(sh); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, length)
+	rand.Read(buf)
+	return buf, nil
+}
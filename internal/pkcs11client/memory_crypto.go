@@ -0,0 +1,1581 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha3"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+)
+
+// memoryOpKind identifies the kinds of operations a session can run at the
+// same time.
+type memoryOpKind int
+
+const (
+	memoryEncrypt memoryOpKind = iota
+	memoryDecrypt
+	memorySign
+	memoryVerify
+	memoryDigest
+)
+
+// memoryOp is an active cryptographic operation of a session. Multi-part
+// operations buffer their input and process it in the final call.
+type memoryOp struct {
+	mech       uint
+	params     *MechanismParams
+	key        *memoryObject
+	data       []byte
+	digest     hash.Hash
+	authorized bool // context-specific login done for CKA_ALWAYS_AUTHENTICATE keys
+}
+
+// memoryMechanism describes a mechanism implemented by the emulator.
+type memoryMechanism struct {
+	info    pkcs11.MechanismInfo
+	keyType uint        // type of the keys used; CKK_GENERIC_SECRET accepts any secret key
+	hash    crypto.Hash // digest computed, signed or used as PRF
+	pss     bool        // RSA PSS signatures
+}
+
+// fits reports whether key can be used with the mechanism.
+func (mech memoryMechanism) fits(key *memoryObject) bool {
+	if mech.keyType == pkcs11.CKK_GENERIC_SECRET {
+		return key.ulong(pkcs11.CKA_CLASS) == pkcs11.CKO_SECRET_KEY
+	}
+	if mech.keyType == pkcs11.CKK_AES && key.ulong(pkcs11.CKA_CLASS) != pkcs11.CKO_SECRET_KEY {
+		return false
+	}
+	return key.ulong(pkcs11.CKA_KEY_TYPE) == mech.keyType
+}
+
+// Mechanisms missing from miekg/pkcs11
+var (
+	ckmAESKeyWrapKWP      = MechanismNameToID["CKM_AES_KEY_WRAP_KWP"]
+	ckmHKDFDerive         = MechanismNameToID["CKM_HKDF_DERIVE"]
+	ckmHKDFData           = MechanismNameToID["CKM_HKDF_DATA"]
+	ckmSP800108Counter    = MechanismNameToID["CKM_SP800_108_COUNTER_KDF"]
+	ckmSP800108Feedback   = MechanismNameToID["CKM_SP800_108_FEEDBACK_KDF"]
+	rfc3394IV             = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+	rfc5649ICV            = []byte{0xA6, 0x59, 0x59, 0xA6}
+	errMemoryParamInvalid = pkcs11.Error(pkcs11.CKR_MECHANISM_PARAM_INVALID)
+)
+
+// memoryHashes lists the digests with the mechanisms built on them. Zero
+// entries have no such mechanism.
+var memoryHashes = []struct {
+	hash                                  crypto.Hash
+	digest, rsa, pss, ecdsa, hmac, ckdKDF uint
+}{
+	{crypto.SHA1, pkcs11.CKM_SHA_1, pkcs11.CKM_SHA1_RSA_PKCS, pkcs11.CKM_SHA1_RSA_PKCS_PSS, pkcs11.CKM_ECDSA_SHA1, pkcs11.CKM_SHA_1_HMAC, pkcs11.CKD_SHA1_KDF},
+	{crypto.SHA224, pkcs11.CKM_SHA224, pkcs11.CKM_SHA224_RSA_PKCS, pkcs11.CKM_SHA224_RSA_PKCS_PSS, pkcs11.CKM_ECDSA_SHA224, pkcs11.CKM_SHA224_HMAC, pkcs11.CKD_SHA224_KDF},
+	{crypto.SHA256, pkcs11.CKM_SHA256, pkcs11.CKM_SHA256_RSA_PKCS, pkcs11.CKM_SHA256_RSA_PKCS_PSS, pkcs11.CKM_ECDSA_SHA256, pkcs11.CKM_SHA256_HMAC, pkcs11.CKD_SHA256_KDF},
+	{crypto.SHA384, pkcs11.CKM_SHA384, pkcs11.CKM_SHA384_RSA_PKCS, pkcs11.CKM_SHA384_RSA_PKCS_PSS, pkcs11.CKM_ECDSA_SHA384, pkcs11.CKM_SHA384_HMAC, pkcs11.CKD_SHA384_KDF},
+	{crypto.SHA512, pkcs11.CKM_SHA512, pkcs11.CKM_SHA512_RSA_PKCS, pkcs11.CKM_SHA512_RSA_PKCS_PSS, pkcs11.CKM_ECDSA_SHA512, pkcs11.CKM_SHA512_HMAC, pkcs11.CKD_SHA512_KDF},
+	{crypto.SHA3_224, pkcs11.CKM_SHA3_224, pkcs11.CKM_SHA3_224_RSA_PKCS, pkcs11.CKM_SHA3_224_RSA_PKCS_PSS, 0, pkcs11.CKM_SHA3_224_HMAC, pkcs11.CKD_SHA3_224_KDF},
+	{crypto.SHA3_256, pkcs11.CKM_SHA3_256, pkcs11.CKM_SHA3_256_RSA_PKCS, pkcs11.CKM_SHA3_256_RSA_PKCS_PSS, 0, pkcs11.CKM_SHA3_256_HMAC, pkcs11.CKD_SHA3_256_KDF},
+	{crypto.SHA3_384, pkcs11.CKM_SHA3_384, pkcs11.CKM_SHA3_384_RSA_PKCS, pkcs11.CKM_SHA3_384_RSA_PKCS_PSS, 0, pkcs11.CKM_SHA3_384_HMAC, pkcs11.CKD_SHA3_384_KDF},
+	{crypto.SHA3_512, pkcs11.CKM_SHA3_512, pkcs11.CKM_SHA3_512_RSA_PKCS, pkcs11.CKM_SHA3_512_RSA_PKCS_PSS, 0, pkcs11.CKM_SHA3_512_HMAC, pkcs11.CKD_SHA3_512_KDF},
+}
+
+// memoryHash returns the hash of a digest mechanism.
+func memoryHash(digest uint) (crypto.Hash, bool) {
+	for _, h := range memoryHashes {
+		if h.digest == digest {
+			return h.hash, true
+		}
+	}
+	return 0, false
+}
+
+// memoryMechanisms are the mechanisms of the emulator. Key sizes are in bytes
+// for secret keys and in bits for RSA and EC keys.
+var memoryMechanisms = func() map[uint]memoryMechanism {
+	const (
+		cipherFlags = pkcs11.CKF_ENCRYPT | pkcs11.CKF_DECRYPT | pkcs11.CKF_WRAP | pkcs11.CKF_UNWRAP
+		signFlags   = pkcs11.CKF_SIGN | pkcs11.CKF_VERIFY
+		ecFlags     = pkcs11.CKF_EC_F_P | pkcs11.CKF_EC_NAMEDCURVE | pkcs11.CKF_EC_UNCOMPRESS
+	)
+	info := func(minSize, maxSize, flags uint) pkcs11.MechanismInfo {
+		return pkcs11.MechanismInfo{MinKeySize: minSize, MaxKeySize: maxSize, Flags: flags}
+	}
+	aes := func(flags uint) memoryMechanism {
+		return memoryMechanism{info: info(16, 32, flags), keyType: pkcs11.CKK_AES}
+	}
+	rsa := func(flags uint, h crypto.Hash, pss bool) memoryMechanism {
+		return memoryMechanism{info: info(1024, 4096, flags), keyType: pkcs11.CKK_RSA, hash: h, pss: pss}
+	}
+	ec := func(flags uint, h crypto.Hash) memoryMechanism {
+		return memoryMechanism{info: info(224, 521, flags|ecFlags), keyType: pkcs11.CKK_EC, hash: h}
+	}
+	secret := func(flags uint, h crypto.Hash) memoryMechanism {
+		return memoryMechanism{info: info(1, 512, flags), keyType: pkcs11.CKK_GENERIC_SECRET, hash: h}
+	}
+
+	mechs := map[uint]memoryMechanism{
+		pkcs11.CKM_AES_KEY_GEN:            aes(pkcs11.CKF_GENERATE),
+		pkcs11.CKM_GENERIC_SECRET_KEY_GEN: secret(pkcs11.CKF_GENERATE, 0),
+		pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN:  rsa(pkcs11.CKF_GENERATE_KEY_PAIR, 0, false),
+		pkcs11.CKM_EC_KEY_PAIR_GEN:        ec(pkcs11.CKF_GENERATE_KEY_PAIR, 0),
+
+		pkcs11.CKM_AES_ECB:          aes(cipherFlags),
+		pkcs11.CKM_AES_CBC:          aes(cipherFlags),
+		pkcs11.CKM_AES_CBC_PAD:      aes(cipherFlags),
+		pkcs11.CKM_AES_GCM:          aes(cipherFlags),
+		pkcs11.CKM_AES_KEY_WRAP:     aes(cipherFlags),
+		pkcs11.CKM_AES_KEY_WRAP_PAD: aes(cipherFlags),
+		ckmAESKeyWrapKWP:            aes(cipherFlags),
+		pkcs11.CKM_RSA_PKCS:         rsa(cipherFlags|signFlags, 0, false),
+		pkcs11.CKM_RSA_PKCS_OAEP:    rsa(cipherFlags, 0, false),
+		pkcs11.CKM_RSA_PKCS_PSS:     rsa(signFlags, 0, true),
+		pkcs11.CKM_ECDSA:            ec(signFlags, 0),
+
+		pkcs11.CKM_ECDH1_DERIVE:              ec(pkcs11.CKF_DERIVE, 0),
+		pkcs11.CKM_ECDH1_COFACTOR_DERIVE:     ec(pkcs11.CKF_DERIVE, 0),
+		ckmHKDFDerive:                        secret(pkcs11.CKF_DERIVE, 0),
+		ckmHKDFData:                          secret(pkcs11.CKF_DERIVE, 0),
+		ckmSP800108Counter:                   secret(pkcs11.CKF_DERIVE, 0),
+		ckmSP800108Feedback:                  secret(pkcs11.CKF_DERIVE, 0),
+		pkcs11.CKM_CONCATENATE_BASE_AND_KEY:  secret(pkcs11.CKF_DERIVE, 0),
+		pkcs11.CKM_CONCATENATE_BASE_AND_DATA: secret(pkcs11.CKF_DERIVE, 0),
+		pkcs11.CKM_CONCATENATE_DATA_AND_BASE: secret(pkcs11.CKF_DERIVE, 0),
+		pkcs11.CKM_XOR_BASE_AND_DATA:         secret(pkcs11.CKF_DERIVE, 0),
+		pkcs11.CKM_AES_ECB_ENCRYPT_DATA:      aes(pkcs11.CKF_DERIVE),
+		pkcs11.CKM_AES_CBC_ENCRYPT_DATA:      aes(pkcs11.CKF_DERIVE),
+	}
+	for _, h := range memoryHashes {
+		mechs[h.digest] = memoryMechanism{info: info(0, 0, pkcs11.CKF_DIGEST), hash: h.hash}
+		mechs[h.rsa] = rsa(signFlags, h.hash, false)
+		mechs[h.pss] = rsa(signFlags, h.hash, true)
+		mechs[h.hmac] = secret(signFlags, h.hash)
+		if h.ecdsa != 0 {
+			mechs[h.ecdsa] = ec(signFlags, h.hash)
+		}
+	}
+	return mechs
+}()
+
+// memoryMechanismFor returns the mechanism of mechs if it supports the
+// function flag.
+func memoryMechanismFor(mechs []*pkcs11.Mechanism, flag uint) (memoryMechanism, error) {
+	if len(mechs) != 1 {
+		return memoryMechanism{}, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+	}
+	mech, ok := memoryMechanisms[mechs[0].Mechanism]
+	if !ok || mech.info.Flags&flag == 0 {
+		return memoryMechanism{}, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+	}
+	return mech, nil
+}
+
+// memoryParams returns the typed parameters of mech as given to
+// NewMechanism, or its raw parameter if it was built otherwise.
+func memoryParams(mech *pkcs11.Mechanism) *MechanismParams {
+	if params, ok := mechanismParams.Load(mech); ok {
+		return params.(*MechanismParams)
+	}
+	return &MechanismParams{Raw: mech.Parameter}
+}
+
+// iv returns the initialization vector of params.
+func memoryIV(params *MechanismParams) []byte {
+	if params.IV != nil {
+		return params.IV
+	}
+	return params.Raw
+}
+
+// initOp starts an operation of kind in sh. Operations other than digests
+// use key, which must permit usage.
+func (m *MemoryContext) initOp(sh pkcs11.SessionHandle, kind memoryOpKind, mechs []*pkcs11.Mechanism, key pkcs11.ObjectHandle, flag, usage uint) error {
+	mech, err := memoryMechanismFor(mechs, flag)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return err
+	}
+	if sess.ops[kind] != nil {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_ACTIVE)
+	}
+	op := &memoryOp{mech: mechs[0].Mechanism, params: memoryParams(mechs[0])}
+	if kind == memoryDigest {
+		op.digest = mech.hash.New()
+	} else {
+		if op.key, err = m.key(key, pkcs11.CKR_KEY_HANDLE_INVALID); err != nil {
+			return err
+		}
+		if !op.key.flag(usage) {
+			return pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+		}
+		if !mech.fits(op.key) {
+			return pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+		}
+	}
+	sess.ops[kind] = op
+	return nil
+}
+
+// activeOp returns the active operation of kind in sh. Operations with keys
+// that require a context-specific login are terminated without it. The
+// caller must hold m.mu.
+func (m *MemoryContext) activeOp(sh pkcs11.SessionHandle, kind memoryOpKind) (*memoryOp, error) {
+	sess, err := m.session(sh)
+	if err != nil {
+		return nil, err
+	}
+	op := sess.ops[kind]
+	if op == nil {
+		return nil, pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	if op.key != nil && op.key.flag(pkcs11.CKA_ALWAYS_AUTHENTICATE) && !op.authorized {
+		delete(sess.ops, kind)
+		return nil, pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN)
+	}
+	return op, nil
+}
+
+// updateOp buffers data for the operation of kind in sh.
+func (m *MemoryContext) updateOp(sh pkcs11.SessionHandle, kind memoryOpKind, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	op, err := m.activeOp(sh, kind)
+	if err != nil {
+		return err
+	}
+	if op.digest != nil {
+		op.digest.Write(data)
+	} else {
+		op.data = append(op.data, data...)
+	}
+	return nil
+}
+
+// finishOp ends the operation of kind in sh by running it on the buffered
+// input followed by data.
+func (m *MemoryContext) finishOp(sh pkcs11.SessionHandle, kind memoryOpKind, data []byte, run func(*memoryOp, []byte) ([]byte, error)) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	op, err := m.activeOp(sh, kind)
+	if err != nil {
+		return nil, err
+	}
+	delete(m.sessions[sh].ops, kind)
+	return run(op, append(op.data, data...))
+}
+
+func (m *MemoryContext) EncryptInit(sh pkcs11.SessionHandle, mechs []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error {
+	return m.initOp(sh, memoryEncrypt, mechs, o, pkcs11.CKF_ENCRYPT, pkcs11.CKA_ENCRYPT)
+}
+
+func (m *MemoryContext) Encrypt(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
+	return m.finishOp(sh, memoryEncrypt, message, memoryEncryptData)
+}
+
+func (m *MemoryContext) EncryptUpdate(sh pkcs11.SessionHandle, plain []byte) ([]byte, error) {
+	return nil, m.updateOp(sh, memoryEncrypt, plain)
+}
+
+func (m *MemoryContext) EncryptFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	return m.finishOp(sh, memoryEncrypt, nil, memoryEncryptData)
+}
+
+func (m *MemoryContext) DecryptInit(sh pkcs11.SessionHandle, mechs []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error {
+	return m.initOp(sh, memoryDecrypt, mechs, o, pkcs11.CKF_DECRYPT, pkcs11.CKA_DECRYPT)
+}
+
+func (m *MemoryContext) Decrypt(sh pkcs11.SessionHandle, cipher []byte) ([]byte, error) {
+	return m.finishOp(sh, memoryDecrypt, cipher, memoryDecryptData)
+}
+
+func (m *MemoryContext) DecryptUpdate(sh pkcs11.SessionHandle, cipher []byte) ([]byte, error) {
+	return nil, m.updateOp(sh, memoryDecrypt, cipher)
+}
+
+func (m *MemoryContext) DecryptFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	return m.finishOp(sh, memoryDecrypt, nil, memoryDecryptData)
+}
+
+func (m *MemoryContext) SignInit(sh pkcs11.SessionHandle, mechs []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error {
+	return m.initOp(sh, memorySign, mechs, o, pkcs11.CKF_SIGN, pkcs11.CKA_SIGN)
+}
+
+func (m *MemoryContext) Sign(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
+	return m.finishOp(sh, memorySign, message, memorySignData)
+}
+
+func (m *MemoryContext) SignUpdate(sh pkcs11.SessionHandle, message []byte) error {
+	return m.updateOp(sh, memorySign, message)
+}
+
+func (m *MemoryContext) SignFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	return m.finishOp(sh, memorySign, nil, memorySignData)
+}
+
+func (m *MemoryContext) VerifyInit(sh pkcs11.SessionHandle, mechs []*pkcs11.Mechanism, key pkcs11.ObjectHandle) error {
+	return m.initOp(sh, memoryVerify, mechs, key, pkcs11.CKF_VERIFY, pkcs11.CKA_VERIFY)
+}
+
+func (m *MemoryContext) Verify(sh pkcs11.SessionHandle, data []byte, signature []byte) error {
+	_, err := m.finishOp(sh, memoryVerify, data, func(op *memoryOp, data []byte) ([]byte, error) {
+		return nil, memoryVerifyData(op, data, signature)
+	})
+	return err
+}
+
+func (m *MemoryContext) DigestInit(sh pkcs11.SessionHandle, mechs []*pkcs11.Mechanism) error {
+	return m.initOp(sh, memoryDigest, mechs, 0, pkcs11.CKF_DIGEST, 0)
+}
+
+func (m *MemoryContext) Digest(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
+	return m.finishOp(sh, memoryDigest, message, memoryDigestData)
+}
+
+func (m *MemoryContext) DigestUpdate(sh pkcs11.SessionHandle, message []byte) error {
+	return m.updateOp(sh, memoryDigest, message)
+}
+
+func (m *MemoryContext) DigestKey(sh pkcs11.SessionHandle, key pkcs11.ObjectHandle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	op, err := m.activeOp(sh, memoryDigest)
+	if err != nil {
+		return err
+	}
+	obj, err := m.key(key, pkcs11.CKR_KEY_HANDLE_INVALID)
+	if err != nil {
+		return err
+	}
+	if obj.ulong(pkcs11.CKA_CLASS) != pkcs11.CKO_SECRET_KEY {
+		return pkcs11.Error(pkcs11.CKR_KEY_INDIGESTIBLE)
+	}
+	op.digest.Write(obj.attrs[pkcs11.CKA_VALUE])
+	return nil
+}
+
+func (m *MemoryContext) DigestFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	return m.finishOp(sh, memoryDigest, nil, memoryDigestData)
+}
+
+func memoryDigestData(op *memoryOp, data []byte) ([]byte, error) {
+	op.digest.Write(data)
+	return op.digest.Sum(nil), nil
+}
+
+// memorySum returns the hash of data, or data itself if h is zero.
+func memorySum(h crypto.Hash, data []byte) []byte {
+	if h == 0 {
+		return data
+	}
+	d := h.New()
+	d.Write(data)
+	return d.Sum(nil)
+}
+
+func memoryEncryptData(op *memoryOp, data []byte) ([]byte, error) {
+	switch memoryMechanisms[op.mech].keyType {
+	case pkcs11.CKK_AES:
+		block, err := aes.NewCipher(op.key.attrs[pkcs11.CKA_VALUE])
+		if err != nil {
+			return nil, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+		}
+		return memoryAESEncrypt(op.mech, op.params, block, data)
+
+	case pkcs11.CKK_RSA:
+		pub, err := memoryRSAPublicKey(op.key.attrs)
+		if err != nil {
+			return nil, err
+		}
+		if op.mech == pkcs11.CKM_RSA_PKCS {
+			if len(data) > pub.Size()-11 {
+				return nil, pkcs11.Error(pkcs11.CKR_DATA_LEN_RANGE)
+			}
+			return rsa.EncryptPKCS1v15(rand.Reader, pub, data)
+		}
+		h, label, err := memoryOAEP(op.params)
+		if err != nil {
+			return nil, err
+		}
+		out, err := rsa.EncryptOAEP(h.New(), rand.Reader, pub, data, label)
+		if err != nil {
+			return nil, pkcs11.Error(pkcs11.CKR_DATA_LEN_RANGE)
+		}
+		return out, nil
+	}
+	return nil, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+}
+
+func memoryDecryptData(op *memoryOp, data []byte) ([]byte, error) {
+	switch memoryMechanisms[op.mech].keyType {
+	case pkcs11.CKK_AES:
+		block, err := aes.NewCipher(op.key.attrs[pkcs11.CKA_VALUE])
+		if err != nil {
+			return nil, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+		}
+		return memoryAESDecrypt(op.mech, op.params, block, data)
+
+	case pkcs11.CKK_RSA:
+		priv, err := memoryRSAPrivateKey(op.key.attrs)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != priv.Size() {
+			return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_LEN_RANGE)
+		}
+		var out []byte
+		if op.mech == pkcs11.CKM_RSA_PKCS {
+			out, err = rsa.DecryptPKCS1v15(nil, priv, data)
+		} else {
+			h, label, paramErr := memoryOAEP(op.params)
+			if paramErr != nil {
+				return nil, paramErr
+			}
+			out, err = rsa.DecryptOAEP(h.New(), nil, priv, data, label)
+		}
+		if err != nil {
+			return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_INVALID)
+		}
+		return out, nil
+	}
+	return nil, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+}
+
+// memoryOAEP returns the hash and label of CK_RSA_PKCS_OAEP_PARAMS. The
+// mask generation function must use the same hash.
+func memoryOAEP(params *MechanismParams) (crypto.Hash, []byte, error) {
+	oaep := params.OAEP
+	if oaep == nil {
+		return 0, nil, errMemoryParamInvalid
+	}
+	h, ok := memoryHash(oaep.Hash)
+	mgf, _ := DefaultMGF(oaep.Hash)
+	if !ok || (oaep.MGF != 0 && oaep.MGF != mgf) {
+		return 0, nil, errMemoryParamInvalid
+	}
+	return h, oaep.SourceData, nil
+}
+
+func validAESKeyLen(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+func memoryAESEncrypt(mech uint, params *MechanismParams, block cipher.Block, data []byte) ([]byte, error) {
+	switch mech {
+	case pkcs11.CKM_AES_ECB, pkcs11.CKM_AES_ECB_ENCRYPT_DATA:
+		if len(data)%aes.BlockSize != 0 {
+			return nil, pkcs11.Error(pkcs11.CKR_DATA_LEN_RANGE)
+		}
+		out := make([]byte, len(data))
+		for i := 0; i < len(data); i += aes.BlockSize {
+			block.Encrypt(out[i:], data[i:])
+		}
+		return out, nil
+
+	case pkcs11.CKM_AES_CBC, pkcs11.CKM_AES_CBC_PAD:
+		iv := memoryIV(params)
+		if len(iv) != aes.BlockSize {
+			return nil, errMemoryParamInvalid
+		}
+		if mech == pkcs11.CKM_AES_CBC_PAD {
+			n := aes.BlockSize - len(data)%aes.BlockSize
+			data = append(bytes.Clone(data), bytes.Repeat([]byte{byte(n)}, n)...)
+		} else if len(data)%aes.BlockSize != 0 {
+			return nil, pkcs11.Error(pkcs11.CKR_DATA_LEN_RANGE)
+		}
+		out := make([]byte, len(data))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, data)
+		return out, nil
+
+	case pkcs11.CKM_AES_GCM:
+		aead, gcm, err := memoryGCM(block, params)
+		if err != nil {
+			return nil, err
+		}
+		return aead.Seal(nil, gcm.IV, data, gcm.AAD), nil
+
+	case pkcs11.CKM_AES_KEY_WRAP:
+		iv := memoryIV(params)
+		if iv == nil {
+			iv = rfc3394IV
+		}
+		if len(iv) != 8 {
+			return nil, errMemoryParamInvalid
+		}
+		if len(data) < 16 || len(data)%8 != 0 {
+			return nil, pkcs11.Error(pkcs11.CKR_DATA_LEN_RANGE)
+		}
+		return aesKeyWrap(block, iv, data), nil
+
+	case pkcs11.CKM_AES_KEY_WRAP_PAD, ckmAESKeyWrapKWP:
+		if len(data) == 0 {
+			return nil, pkcs11.Error(pkcs11.CKR_DATA_LEN_RANGE)
+		}
+		iv := binary.BigEndian.AppendUint32(bytes.Clone(rfc5649ICV), uint32(len(data)))
+		padded := append(bytes.Clone(data), make([]byte, (8-len(data)%8)%8)...)
+		if len(padded) == 8 {
+			out := append(iv, padded...)
+			block.Encrypt(out, out)
+			return out, nil
+		}
+		return aesKeyWrap(block, iv, padded), nil
+	}
+	return nil, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+}
+
+func memoryAESDecrypt(mech uint, params *MechanismParams, block cipher.Block, data []byte) ([]byte, error) {
+	switch mech {
+	case pkcs11.CKM_AES_ECB:
+		if len(data)%aes.BlockSize != 0 {
+			return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_LEN_RANGE)
+		}
+		out := make([]byte, len(data))
+		for i := 0; i < len(data); i += aes.BlockSize {
+			block.Decrypt(out[i:], data[i:])
+		}
+		return out, nil
+
+	case pkcs11.CKM_AES_CBC, pkcs11.CKM_AES_CBC_PAD:
+		iv := memoryIV(params)
+		if len(iv) != aes.BlockSize {
+			return nil, errMemoryParamInvalid
+		}
+		if len(data)%aes.BlockSize != 0 || (mech == pkcs11.CKM_AES_CBC_PAD && len(data) == 0) {
+			return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_LEN_RANGE)
+		}
+		out := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+		if mech == pkcs11.CKM_AES_CBC {
+			return out, nil
+		}
+		n := int(out[len(out)-1])
+		if n == 0 || n > aes.BlockSize || !bytes.Equal(out[len(out)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+			return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_INVALID)
+		}
+		return out[:len(out)-n], nil
+
+	case pkcs11.CKM_AES_GCM:
+		aead, gcm, err := memoryGCM(block, params)
+		if err != nil {
+			return nil, err
+		}
+		out, err := aead.Open(nil, gcm.IV, data, gcm.AAD)
+		if err != nil {
+			return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_INVALID)
+		}
+		return out, nil
+
+	case pkcs11.CKM_AES_KEY_WRAP:
+		iv := memoryIV(params)
+		if iv == nil {
+			iv = rfc3394IV
+		}
+		if len(iv) != 8 {
+			return nil, errMemoryParamInvalid
+		}
+		if len(data) < 24 || len(data)%8 != 0 {
+			return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_LEN_RANGE)
+		}
+		a, out := aesKeyUnwrap(block, data)
+		if subtle.ConstantTimeCompare(a, iv) != 1 {
+			return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_INVALID)
+		}
+		return out, nil
+
+	case pkcs11.CKM_AES_KEY_WRAP_PAD, ckmAESKeyWrapKWP:
+		if len(data) < 16 || len(data)%8 != 0 {
+			return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_LEN_RANGE)
+		}
+		var a, out []byte
+		if len(data) == 16 {
+			buf := make([]byte, 16)
+			block.Decrypt(buf, data)
+			a, out = buf[:8], buf[8:]
+		} else {
+			a, out = aesKeyUnwrap(block, data)
+		}
+		n := int(binary.BigEndian.Uint32(a[4:]))
+		if !bytes.Equal(a[:4], rfc5649ICV) || n > len(out) || n <= len(out)-8 ||
+			!bytes.Equal(out[n:], make([]byte, len(out)-n)) {
+			return nil, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_INVALID)
+		}
+		return out[:n], nil
+	}
+	return nil, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+}
+
+// memoryGCM returns the AEAD for CK_GCM_PARAMS. Nonces other than 96 bits
+// are only supported with 128-bit tags.
+func memoryGCM(block cipher.Block, params *MechanismParams) (cipher.AEAD, *GCMParams, error) {
+	gcm := params.GCM
+	if gcm == nil || len(gcm.IV) == 0 {
+		return nil, nil, errMemoryParamInvalid
+	}
+	tagBits := gcm.TagBits
+	if tagBits == 0 {
+		tagBits = 128
+	}
+	var aead cipher.AEAD
+	var err error
+	switch {
+	case tagBits%8 != 0:
+		return nil, nil, errMemoryParamInvalid
+	case len(gcm.IV) == 12:
+		aead, err = cipher.NewGCMWithTagSize(block, int(tagBits/8))
+	case tagBits == 128:
+		aead, err = cipher.NewGCMWithNonceSize(block, len(gcm.IV))
+	default:
+		return nil, nil, errMemoryParamInvalid
+	}
+	if err != nil {
+		return nil, nil, errMemoryParamInvalid
+	}
+	return aead, gcm, nil
+}
+
+// aesKeyWrap implements the wrapping function W of RFC 3394 for plain of at
+// least two 64-bit blocks.
+func aesKeyWrap(block cipher.Block, iv, plain []byte) []byte {
+	n := len(plain) / 8
+	a := bytes.Clone(iv)
+	r := bytes.Clone(plain)
+	buf := make([]byte, 16)
+	for j := range 6 {
+		for i := range n {
+			copy(buf, a)
+			copy(buf[8:], r[i*8:])
+			block.Encrypt(buf, buf)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(buf)^uint64(n*j+i+1))
+			copy(r[i*8:], buf[8:])
+		}
+	}
+	return append(a, r...)
+}
+
+// aesKeyUnwrap implements the unwrapping function W⁻¹ of RFC 3394 and
+// returns the integrity check value with the plaintext.
+func aesKeyUnwrap(block cipher.Block, wrapped []byte) ([]byte, []byte) {
+	n := len(wrapped)/8 - 1
+	a := bytes.Clone(wrapped[:8])
+	r := bytes.Clone(wrapped[8:])
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			binary.BigEndian.PutUint64(buf, binary.BigEndian.Uint64(a)^uint64(n*j+i+1))
+			copy(buf[8:], r[i*8:])
+			block.Decrypt(buf, buf)
+			copy(a, buf)
+			copy(r[i*8:], buf[8:])
+		}
+	}
+	return a, r
+}
+
+func memorySignData(op *memoryOp, data []byte) ([]byte, error) {
+	mech := memoryMechanisms[op.mech]
+	switch mech.keyType {
+	case pkcs11.CKK_GENERIC_SECRET:
+		mac := hmac.New(mech.hash.New, op.key.attrs[pkcs11.CKA_VALUE])
+		mac.Write(data)
+		return mac.Sum(nil), nil
+
+	case pkcs11.CKK_EC:
+		priv, err := memoryECPrivateKey(op.key.attrs)
+		if err != nil {
+			return nil, err
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, priv, memorySum(mech.hash, data))
+		if err != nil {
+			return nil, pkcs11.Error(pkcs11.CKR_FUNCTION_FAILED)
+		}
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+
+	case pkcs11.CKK_RSA:
+		priv, err := memoryRSAPrivateKey(op.key.attrs)
+		if err != nil {
+			return nil, err
+		}
+		if mech.pss {
+			h, opts, err := memoryPSS(op.params, mech.hash)
+			if err != nil {
+				return nil, err
+			}
+			if opts.SaltLength == rsa.PSSSaltLengthAuto {
+				return nil, errMemoryParamInvalid
+			}
+			digest := memorySum(mech.hash, data)
+			if len(digest) != h.Size() {
+				return nil, pkcs11.Error(pkcs11.CKR_DATA_LEN_RANGE)
+			}
+			return rsa.SignPSS(rand.Reader, priv, h, digest, opts)
+		}
+		// Without hash, the data is the DigestInfo to sign
+		if mech.hash == 0 && len(data) > priv.Size()-11 {
+			return nil, pkcs11.Error(pkcs11.CKR_DATA_LEN_RANGE)
+		}
+		return rsa.SignPKCS1v15(nil, priv, mech.hash, memorySum(mech.hash, data))
+	}
+	return nil, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+}
+
+func memoryVerifyData(op *memoryOp, data, signature []byte) error {
+	mech := memoryMechanisms[op.mech]
+	switch mech.keyType {
+	case pkcs11.CKK_GENERIC_SECRET:
+		mac := hmac.New(mech.hash.New, op.key.attrs[pkcs11.CKA_VALUE])
+		mac.Write(data)
+		expected := mac.Sum(nil)
+		if len(signature) != len(expected) {
+			return pkcs11.Error(pkcs11.CKR_SIGNATURE_LEN_RANGE)
+		}
+		if !hmac.Equal(signature, expected) {
+			return pkcs11.Error(pkcs11.CKR_SIGNATURE_INVALID)
+		}
+		return nil
+
+	case pkcs11.CKK_EC:
+		pub, err := memoryECPublicKey(op.key.attrs)
+		if err != nil {
+			return err
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return pkcs11.Error(pkcs11.CKR_SIGNATURE_LEN_RANGE)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, memorySum(mech.hash, data), r, s) {
+			return pkcs11.Error(pkcs11.CKR_SIGNATURE_INVALID)
+		}
+		return nil
+
+	case pkcs11.CKK_RSA:
+		pub, err := memoryRSAPublicKey(op.key.attrs)
+		if err != nil {
+			return err
+		}
+		if len(signature) != pub.Size() {
+			return pkcs11.Error(pkcs11.CKR_SIGNATURE_LEN_RANGE)
+		}
+		if mech.pss {
+			h, opts, paramErr := memoryPSS(op.params, mech.hash)
+			if paramErr != nil {
+				return paramErr
+			}
+			err = rsa.VerifyPSS(pub, h, memorySum(mech.hash, data), signature, opts)
+		} else {
+			err = rsa.VerifyPKCS1v15(pub, mech.hash, memorySum(mech.hash, data), signature)
+		}
+		if err != nil {
+			return pkcs11.Error(pkcs11.CKR_SIGNATURE_INVALID)
+		}
+		return nil
+	}
+	return pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+}
+
+// memoryPSS returns the hash and options of CK_RSA_PKCS_PSS_PARAMS, whose
+// hash must match the hash of the mechanism, if any, and the mask generation
+// function. A nil salt length defaults to the hash length like in
+// NewMechanism. crypto/rsa reads a salt length of 0 as "auto", so signing
+// without salt is not supported and verifying accepts any salt length.
+func memoryPSS(params *MechanismParams, mechHash crypto.Hash) (crypto.Hash, *rsa.PSSOptions, error) {
+	pss := params.PSS
+	if pss == nil {
+		return 0, nil, errMemoryParamInvalid
+	}
+	h, ok := memoryHash(pss.Hash)
+	mgf, _ := DefaultMGF(pss.Hash)
+	if !ok || (mechHash != 0 && h != mechHash) || (pss.MGF != 0 && pss.MGF != mgf) {
+		return 0, nil, errMemoryParamInvalid
+	}
+	saltLen := h.Size()
+	if pss.SaltLen != nil {
+		saltLen = int(*pss.SaltLen)
+	}
+	return h, &rsa.PSSOptions{SaltLength: saltLen, Hash: h}, nil
+}
+
+// memoryCurves are the curves of EC keys.
+var memoryCurves = []struct {
+	oid   asn1.ObjectIdentifier
+	curve elliptic.Curve
+}{
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 33}, elliptic.P224()},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}, elliptic.P256()},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 34}, elliptic.P384()},
+	{asn1.ObjectIdentifier{1, 3, 132, 0, 35}, elliptic.P521()},
+}
+
+// memoryCurve returns the curve in CKA_EC_PARAMS.
+func memoryCurve(attrs map[uint][]byte) (elliptic.Curve, curveInfo, error) {
+	params, ok := attrs[pkcs11.CKA_EC_PARAMS]
+	if !ok {
+		return nil, curveInfo{}, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+	}
+	oid, err := parseECParams(params)
+	if err != nil {
+		return nil, curveInfo{}, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	for _, c := range memoryCurves {
+		if c.oid.Equal(oid) {
+			return c.curve, curveInfos[oid.String()], nil
+		}
+	}
+	return nil, curveInfo{}, pkcs11.Error(pkcs11.CKR_CURVE_NOT_SUPPORTED)
+}
+
+// memoryECParams returns CKA_EC_PARAMS for curve.
+func memoryECParams(curve elliptic.Curve) ([]byte, error) {
+	for _, c := range memoryCurves {
+		if c.curve == curve {
+			return asn1.Marshal(c.oid)
+		}
+	}
+	return nil, pkcs11.Error(pkcs11.CKR_CURVE_NOT_SUPPORTED)
+}
+
+// memoryECPublicKey returns the EC public key with CKA_EC_PARAMS and
+// CKA_EC_POINT, which must be an uncompressed point.
+func memoryECPublicKey(attrs map[uint][]byte) (*ecdsa.PublicKey, error) {
+	curve, info, err := memoryCurve(attrs)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := attrs[pkcs11.CKA_EC_POINT]
+	if !ok {
+		return nil, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+	}
+	point, err := (&ECPublicKey{Point: value}).pointFor(info)
+	if err != nil {
+		return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	pub, err := ecdsa.ParseUncompressedPublicKey(curve, point)
+	if err != nil {
+		return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	return pub, nil
+}
+
+// memoryECPrivateKey returns the EC private key with CKA_EC_PARAMS and CKA_VALUE.
+func memoryECPrivateKey(attrs map[uint][]byte) (*ecdsa.PrivateKey, error) {
+	curve, _, err := memoryCurve(attrs)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := attrs[pkcs11.CKA_VALUE]
+	if !ok {
+		return nil, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(value) > size {
+		return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	priv, err := ecdsa.ParseRawPrivateKey(curve, append(make([]byte, size-len(value)), value...))
+	if err != nil {
+		return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	return priv, nil
+}
+
+// setMemoryECKey sets the attributes of an EC key pair. pub may be nil.
+func setMemoryECKey(pub, priv map[uint][]byte, key *ecdsa.PrivateKey) error {
+	params, err := memoryECParams(key.Curve)
+	if err != nil {
+		return err
+	}
+	d, err := key.Bytes()
+	if err != nil {
+		return pkcs11.Error(pkcs11.CKR_FUNCTION_FAILED)
+	}
+	priv[pkcs11.CKA_EC_PARAMS] = params
+	priv[pkcs11.CKA_VALUE] = d
+	if pub != nil {
+		point, err := key.PublicKey.Bytes()
+		if err != nil {
+			return pkcs11.Error(pkcs11.CKR_FUNCTION_FAILED)
+		}
+		pub[pkcs11.CKA_EC_PARAMS] = params
+		pub[pkcs11.CKA_EC_POINT], _ = asn1.Marshal(point)
+	}
+	return nil
+}
+
+// memoryRSAPublicKey returns the RSA public key with CKA_MODULUS and
+// CKA_PUBLIC_EXPONENT.
+func memoryRSAPublicKey(attrs map[uint][]byte) (*rsa.PublicKey, error) {
+	n, ok := attrs[pkcs11.CKA_MODULUS]
+	e, ok2 := attrs[pkcs11.CKA_PUBLIC_EXPONENT]
+	if !ok || !ok2 {
+		return nil, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+	}
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n)}
+	exp := new(big.Int).SetBytes(e)
+	if pub.N.Sign() == 0 || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	pub.E = int(exp.Int64())
+	return pub, nil
+}
+
+// memoryRSAPrivateKey returns the RSA private key with the modulus, the
+// exponents and the primes. The CRT values are recomputed.
+func memoryRSAPrivateKey(attrs map[uint][]byte) (*rsa.PrivateKey, error) {
+	pub, err := memoryRSAPublicKey(attrs)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]*big.Int, 3)
+	for i, t := range []uint{pkcs11.CKA_PRIVATE_EXPONENT, pkcs11.CKA_PRIME_1, pkcs11.CKA_PRIME_2} {
+		v, ok := attrs[t]
+		if !ok {
+			return nil, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+		}
+		values[i] = new(big.Int).SetBytes(v)
+	}
+	priv := &rsa.PrivateKey{PublicKey: *pub, D: values[0], Primes: values[1:]}
+	priv.Precompute()
+	if err := priv.Validate(); err != nil {
+		return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	return priv, nil
+}
+
+// setMemoryRSAKey sets the attributes of an RSA key pair. pub may be nil.
+func setMemoryRSAKey(pub, priv map[uint][]byte, key *rsa.PrivateKey) error {
+	if len(key.Primes) != 2 {
+		return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	key.Precompute()
+	n, e := key.N.Bytes(), big.NewInt(int64(key.E)).Bytes()
+	if pub != nil {
+		pub[pkcs11.CKA_MODULUS] = n
+		pub[pkcs11.CKA_PUBLIC_EXPONENT] = e
+	}
+	priv[pkcs11.CKA_MODULUS] = n
+	priv[pkcs11.CKA_PUBLIC_EXPONENT] = e
+	priv[pkcs11.CKA_PRIVATE_EXPONENT] = key.D.Bytes()
+	priv[pkcs11.CKA_PRIME_1] = key.Primes[0].Bytes()
+	priv[pkcs11.CKA_PRIME_2] = key.Primes[1].Bytes()
+	priv[pkcs11.CKA_EXPONENT_1] = key.Precomputed.Dp.Bytes()
+	priv[pkcs11.CKA_EXPONENT_2] = key.Precomputed.Dq.Bytes()
+	priv[pkcs11.CKA_COEFFICIENT] = key.Precomputed.Qinv.Bytes()
+	return nil
+}
+
+// memoryDefaultUlong sets the CK_ULONG attribute t to v unless attrs has it
+// and returns its value.
+func memoryDefaultUlong(attrs map[uint][]byte, t, v uint) uint {
+	if _, ok := attrs[t]; !ok {
+		attrs[t] = UlongToBytes(v)
+	}
+	return BytesToUlong(attrs[t])
+}
+
+// addNew stores a new object checked by newMemoryObject.
+func (m *MemoryContext) addNew(sh pkcs11.SessionHandle, attrs map[uint][]byte) (pkcs11.ObjectHandle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return 0, err
+	}
+	oh, obj, err := m.addObject(sh, sess, attrs)
+	if err != nil {
+		return 0, err
+	}
+	return oh, m.commit(obj)
+}
+
+func (m *MemoryContext) GenerateKey(sh pkcs11.SessionHandle, mechs []*pkcs11.Mechanism, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	mech, err := memoryMechanismFor(mechs, pkcs11.CKF_GENERATE)
+	if err != nil {
+		return 0, err
+	}
+	attrs, err := memoryTemplate(temp)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := attrs[pkcs11.CKA_VALUE]; ok {
+		return 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+	}
+	class := memoryDefaultUlong(attrs, pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY)
+	keyType := memoryDefaultUlong(attrs, pkcs11.CKA_KEY_TYPE, mech.keyType)
+	if class != pkcs11.CKO_SECRET_KEY || (keyType == pkcs11.CKK_AES) != (mech.keyType == pkcs11.CKK_AES) {
+		return 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+	}
+	valueLen, ok := attrs[pkcs11.CKA_VALUE_LEN]
+	if !ok {
+		return 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+	}
+	n := BytesToUlong(valueLen)
+	if n < mech.info.MinKeySize || n > mech.info.MaxKeySize || (keyType == pkcs11.CKK_AES && !validAESKeyLen(int(n))) {
+		return 0, pkcs11.Error(pkcs11.CKR_KEY_SIZE_RANGE)
+	}
+	attrs[pkcs11.CKA_VALUE] = make([]byte, n)
+	rand.Read(attrs[pkcs11.CKA_VALUE])
+
+	origin := memoryOrigin{local: true, mechanism: mechs[0].Mechanism, alwaysSensitive: true, neverExtractable: true}
+	if err := newMemoryObject(attrs, origin); err != nil {
+		return 0, err
+	}
+	return m.addNew(sh, attrs)
+}
+
+func (m *MemoryContext) GenerateKeyPair(sh pkcs11.SessionHandle, mechs []*pkcs11.Mechanism, public, private []*pkcs11.Attribute) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	mech, err := memoryMechanismFor(mechs, pkcs11.CKF_GENERATE_KEY_PAIR)
+	if err != nil {
+		return 0, 0, err
+	}
+	pub, err := memoryTemplate(public)
+	if err != nil {
+		return 0, 0, err
+	}
+	priv, err := memoryTemplate(private)
+	if err != nil {
+		return 0, 0, err
+	}
+	if memoryDefaultUlong(pub, pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY) != pkcs11.CKO_PUBLIC_KEY ||
+		memoryDefaultUlong(priv, pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY) != pkcs11.CKO_PRIVATE_KEY ||
+		memoryDefaultUlong(pub, pkcs11.CKA_KEY_TYPE, mech.keyType) != mech.keyType ||
+		memoryDefaultUlong(priv, pkcs11.CKA_KEY_TYPE, mech.keyType) != mech.keyType {
+		return 0, 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+	}
+	// The key material is generated
+	for _, t := range []uint{pkcs11.CKA_MODULUS, pkcs11.CKA_EC_POINT} {
+		if _, ok := pub[t]; ok {
+			return 0, 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+		}
+	}
+	for t := range memorySensitiveAttrs {
+		if _, ok := priv[t]; ok {
+			return 0, 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+		}
+	}
+
+	switch mech.keyType {
+	case pkcs11.CKK_RSA:
+		bits, ok := pub[pkcs11.CKA_MODULUS_BITS]
+		if !ok {
+			return 0, 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+		}
+		n := BytesToUlong(bits)
+		if n < mech.info.MinKeySize || n > mech.info.MaxKeySize {
+			return 0, 0, pkcs11.Error(pkcs11.CKR_KEY_SIZE_RANGE)
+		}
+		// crypto/rsa always uses the public exponent 65537
+		if e, ok := pub[pkcs11.CKA_PUBLIC_EXPONENT]; ok && new(big.Int).SetBytes(e).Cmp(big.NewInt(65537)) != 0 {
+			return 0, 0, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+		}
+		key, err := rsa.GenerateKey(rand.Reader, int(n))
+		if err != nil {
+			return 0, 0, pkcs11.Error(pkcs11.CKR_FUNCTION_FAILED)
+		}
+		if err := setMemoryRSAKey(pub, priv, key); err != nil {
+			return 0, 0, err
+		}
+
+	case pkcs11.CKK_EC:
+		params, ok := pub[pkcs11.CKA_EC_PARAMS]
+		if !ok {
+			return 0, 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+		}
+		if p, ok := priv[pkcs11.CKA_EC_PARAMS]; ok && !bytes.Equal(p, params) {
+			return 0, 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+		}
+		curve, _, err := memoryCurve(pub)
+		if err != nil {
+			return 0, 0, err
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return 0, 0, pkcs11.Error(pkcs11.CKR_FUNCTION_FAILED)
+		}
+		if err := setMemoryECKey(pub, priv, key); err != nil {
+			return 0, 0, err
+		}
+		// Keep the encoding of the template, e.g. a curve name
+		pub[pkcs11.CKA_EC_PARAMS], priv[pkcs11.CKA_EC_PARAMS] = params, params
+	}
+
+	origin := memoryOrigin{local: true, mechanism: mechs[0].Mechanism, alwaysSensitive: true, neverExtractable: true}
+	if err := newMemoryObject(pub, origin); err != nil {
+		return 0, 0, err
+	}
+	if err := newMemoryObject(priv, origin); err != nil {
+		return 0, 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := m.checkCreate(sess, pub); err != nil {
+		return 0, 0, err
+	}
+	if err := m.checkCreate(sess, priv); err != nil {
+		return 0, 0, err
+	}
+	pubHandle, pubObj, _ := m.addObject(sh, sess, pub)
+	privHandle, privObj, _ := m.addObject(sh, sess, priv)
+	if err := m.commit(pubObj); err != nil {
+		return 0, 0, err
+	}
+	return pubHandle, privHandle, m.commit(privObj)
+}
+
+func (m *MemoryContext) WrapKey(sh pkcs11.SessionHandle, mechs []*pkcs11.Mechanism, wrappingKey, key pkcs11.ObjectHandle) ([]byte, error) {
+	mech, err := memoryMechanismFor(mechs, pkcs11.CKF_WRAP)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.session(sh); err != nil {
+		return nil, err
+	}
+	wrapping, err := m.key(wrappingKey, pkcs11.CKR_WRAPPING_KEY_HANDLE_INVALID)
+	if err != nil {
+		return nil, err
+	}
+	if !wrapping.flag(pkcs11.CKA_WRAP) {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+	if !mech.fits(wrapping) {
+		return nil, pkcs11.Error(pkcs11.CKR_WRAPPING_KEY_TYPE_INCONSISTENT)
+	}
+	wrapped, err := m.key(key, pkcs11.CKR_KEY_HANDLE_INVALID)
+	if err != nil {
+		return nil, err
+	}
+	if !wrapped.flag(pkcs11.CKA_EXTRACTABLE) {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_UNEXTRACTABLE)
+	}
+	if wrapped.flag(pkcs11.CKA_WRAP_WITH_TRUSTED) && !wrapping.flag(pkcs11.CKA_TRUSTED) {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_NOT_WRAPPABLE)
+	}
+
+	// Secret keys are wrapped as their value, private keys as PKCS#8
+	var plain []byte
+	switch wrapped.ulong(pkcs11.CKA_CLASS) {
+	case pkcs11.CKO_SECRET_KEY:
+		plain = wrapped.attrs[pkcs11.CKA_VALUE]
+	case pkcs11.CKO_PRIVATE_KEY:
+		var priv any
+		if wrapped.ulong(pkcs11.CKA_KEY_TYPE) == pkcs11.CKK_EC {
+			priv, err = memoryECPrivateKey(wrapped.attrs)
+		} else {
+			priv, err = memoryRSAPrivateKey(wrapped.attrs)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if plain, err = x509.MarshalPKCS8PrivateKey(priv); err != nil {
+			return nil, pkcs11.Error(pkcs11.CKR_KEY_NOT_WRAPPABLE)
+		}
+	default:
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_NOT_WRAPPABLE)
+	}
+	out, err := memoryEncryptData(&memoryOp{mech: mechs[0].Mechanism, params: memoryParams(mechs[0]), key: wrapping}, plain)
+	if errors.Is(err, pkcs11.Error(pkcs11.CKR_DATA_LEN_RANGE)) {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_SIZE_RANGE)
+	}
+	return out, err
+}
+
+func (m *MemoryContext) UnwrapKey(sh pkcs11.SessionHandle, mechs []*pkcs11.Mechanism, unwrappingKey pkcs11.ObjectHandle, wrappedKey []byte, a []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	mech, err := memoryMechanismFor(mechs, pkcs11.CKF_UNWRAP)
+	if err != nil {
+		return 0, err
+	}
+	attrs, err := memoryTemplate(a)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return 0, err
+	}
+	unwrapping, err := m.key(unwrappingKey, pkcs11.CKR_UNWRAPPING_KEY_HANDLE_INVALID)
+	if err != nil {
+		return 0, err
+	}
+	if !unwrapping.flag(pkcs11.CKA_UNWRAP) {
+		return 0, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+	if !mech.fits(unwrapping) {
+		return 0, pkcs11.Error(pkcs11.CKR_UNWRAPPING_KEY_TYPE_INCONSISTENT)
+	}
+	plain, err := memoryDecryptData(&memoryOp{mech: mechs[0].Mechanism, params: memoryParams(mechs[0]), key: unwrapping}, wrappedKey)
+	switch {
+	case errors.Is(err, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_INVALID)):
+		return 0, pkcs11.Error(pkcs11.CKR_WRAPPED_KEY_INVALID)
+	case errors.Is(err, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_LEN_RANGE)):
+		return 0, pkcs11.Error(pkcs11.CKR_WRAPPED_KEY_LEN_RANGE)
+	case err != nil:
+		return 0, err
+	}
+	if err := setUnwrappedKey(attrs, plain); err != nil {
+		return 0, err
+	}
+	if err := newMemoryObject(attrs, memoryOrigin{mechanism: pkcs11.CK_UNAVAILABLE_INFORMATION}); err != nil {
+		return 0, err
+	}
+	oh, obj, err := m.addObject(sh, sess, attrs)
+	if err != nil {
+		return 0, err
+	}
+	return oh, m.commit(obj)
+}
+
+// setUnwrappedKey sets the key material of an unwrapped key from plain, the
+// value of a secret key or the PKCS#8 encoding of a private key.
+func setUnwrappedKey(attrs map[uint][]byte, plain []byte) error {
+	class, ok := attrs[pkcs11.CKA_CLASS]
+	if !ok {
+		return pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+	}
+	if _, ok := attrs[pkcs11.CKA_VALUE]; ok {
+		return pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+	}
+
+	switch BytesToUlong(class) {
+	case pkcs11.CKO_SECRET_KEY:
+		// Mechanisms without padding may have padded the value
+		if v, ok := attrs[pkcs11.CKA_VALUE_LEN]; ok {
+			n := BytesToUlong(v)
+			if n > uint(len(plain)) {
+				return pkcs11.Error(pkcs11.CKR_WRAPPED_KEY_INVALID)
+			}
+			plain = plain[:n]
+		}
+		attrs[pkcs11.CKA_VALUE] = plain
+		return nil
+
+	case pkcs11.CKO_PRIVATE_KEY:
+		key, err := x509.ParsePKCS8PrivateKey(plain)
+		if err != nil {
+			return pkcs11.Error(pkcs11.CKR_WRAPPED_KEY_INVALID)
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			if memoryDefaultUlong(attrs, pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA) != pkcs11.CKK_RSA {
+				return pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+			}
+			return setMemoryRSAKey(nil, attrs, key)
+		case *ecdsa.PrivateKey:
+			if memoryDefaultUlong(attrs, pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC) != pkcs11.CKK_EC {
+				return pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+			}
+			return setMemoryECKey(nil, attrs, key)
+		}
+		return pkcs11.Error(pkcs11.CKR_WRAPPED_KEY_INVALID)
+	}
+	return pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+}
+
+func (m *MemoryContext) DeriveKey(sh pkcs11.SessionHandle, mechs []*pkcs11.Mechanism, baseKey pkcs11.ObjectHandle, a []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	mech, err := memoryMechanismFor(mechs, pkcs11.CKF_DERIVE)
+	if err != nil {
+		return 0, err
+	}
+	attrs, err := memoryTemplate(a)
+	if err != nil {
+		return 0, err
+	}
+	id, params := mechs[0].Mechanism, memoryParams(mechs[0])
+
+	// CKM_HKDF_DATA derives data objects, all others secret keys
+	var length int
+	if v, ok := attrs[pkcs11.CKA_VALUE_LEN]; ok {
+		length = int(BytesToUlong(v))
+	}
+	if id == ckmHKDFData {
+		if memoryDefaultUlong(attrs, pkcs11.CKA_CLASS, pkcs11.CKO_DATA) != pkcs11.CKO_DATA {
+			return 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+		}
+		delete(attrs, pkcs11.CKA_VALUE_LEN)
+	} else {
+		if memoryDefaultUlong(attrs, pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY) != pkcs11.CKO_SECRET_KEY {
+			return 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+		}
+		memoryDefaultUlong(attrs, pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET)
+	}
+	if _, ok := attrs[pkcs11.CKA_VALUE]; ok {
+		return 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, err := m.session(sh)
+	if err != nil {
+		return 0, err
+	}
+	base, err := m.key(baseKey, pkcs11.CKR_KEY_HANDLE_INVALID)
+	if err != nil {
+		return 0, err
+	}
+	if !base.flag(pkcs11.CKA_DERIVE) {
+		return 0, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+	if !mech.fits(base) {
+		return 0, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+	origin := memoryOrigin{
+		mechanism:        id,
+		alwaysSensitive:  base.flag(pkcs11.CKA_ALWAYS_SENSITIVE),
+		neverExtractable: base.flag(pkcs11.CKA_NEVER_EXTRACTABLE),
+	}
+
+	var value []byte
+	switch id {
+	case pkcs11.CKM_ECDH1_DERIVE, pkcs11.CKM_ECDH1_COFACTOR_DERIVE:
+		value, err = memoryECDH(params.ECDH1, base, length)
+	case ckmHKDFDerive, ckmHKDFData:
+		value, err = memoryHKDF(params.HKDF, base.attrs[pkcs11.CKA_VALUE], length)
+	case ckmSP800108Counter, ckmSP800108Feedback:
+		value, err = memorySP800108(id == ckmSP800108Counter, params.SP800108, base.attrs[pkcs11.CKA_VALUE], length)
+	case pkcs11.CKM_CONCATENATE_BASE_AND_KEY:
+		handle := BytesToUlong(params.Raw)
+		if params.Key != nil {
+			handle = uint(params.Key.Handle)
+		}
+		other, keyErr := m.key(pkcs11.ObjectHandle(handle), pkcs11.CKR_MECHANISM_PARAM_INVALID)
+		if keyErr != nil || other.ulong(pkcs11.CKA_CLASS) != pkcs11.CKO_SECRET_KEY {
+			return 0, errMemoryParamInvalid
+		}
+		value = append(bytes.Clone(base.attrs[pkcs11.CKA_VALUE]), other.attrs[pkcs11.CKA_VALUE]...)
+		origin.alwaysSensitive = origin.alwaysSensitive && other.flag(pkcs11.CKA_ALWAYS_SENSITIVE)
+		origin.neverExtractable = origin.neverExtractable && other.flag(pkcs11.CKA_NEVER_EXTRACTABLE)
+	default:
+		value, err = memoryDeriveWithData(id, params, base.attrs[pkcs11.CKA_VALUE])
+	}
+	if err != nil {
+		return 0, err
+	}
+	if length > 0 {
+		if length > len(value) {
+			return 0, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+		}
+		value = value[:length]
+	}
+	attrs[pkcs11.CKA_VALUE] = value
+
+	if err := newMemoryObject(attrs, origin); err != nil {
+		return 0, err
+	}
+	oh, obj, err := m.addObject(sh, sess, attrs)
+	if err != nil {
+		return 0, err
+	}
+	return oh, m.commit(obj)
+}
+
+// memoryDeriveWithData derives a key from the base key value and the data
+// in the mechanism parameters.
+func memoryDeriveWithData(id uint, params *MechanismParams, value []byte) ([]byte, error) {
+	data := params.StringData
+	if id == pkcs11.CKM_AES_CBC_ENCRYPT_DATA {
+		if params.CBCEncryptData == nil {
+			return nil, errMemoryParamInvalid
+		}
+		data = params.CBCEncryptData.Data
+	}
+	if data == nil {
+		return nil, errMemoryParamInvalid
+	}
+
+	switch id {
+	case pkcs11.CKM_CONCATENATE_BASE_AND_DATA:
+		return append(bytes.Clone(value), data...), nil
+	case pkcs11.CKM_CONCATENATE_DATA_AND_BASE:
+		return append(bytes.Clone(data), value...), nil
+	case pkcs11.CKM_XOR_BASE_AND_DATA:
+		out := make([]byte, min(len(value), len(data)))
+		subtle.XORBytes(out, value, data)
+		return out, nil
+	case pkcs11.CKM_AES_ECB_ENCRYPT_DATA, pkcs11.CKM_AES_CBC_ENCRYPT_DATA:
+		block, err := aes.NewCipher(value)
+		if err != nil {
+			return nil, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+		}
+		mech, cbcParams := uint(pkcs11.CKM_AES_ECB), params
+		if id == pkcs11.CKM_AES_CBC_ENCRYPT_DATA {
+			mech, cbcParams = pkcs11.CKM_AES_CBC, &MechanismParams{IV: params.CBCEncryptData.IV}
+		}
+		out, err := memoryAESEncrypt(mech, cbcParams, block, data)
+		if err != nil {
+			return nil, errMemoryParamInvalid
+		}
+		return out, nil
+	}
+	return nil, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+}
+
+// memoryECDH computes the ECDH shared secret of the private key base and the
+// public data of params, and applies the ANSI X9.63 KDF of params to it. The
+// curves of the emulator have cofactor 1, so the cofactor variant is the same.
+func memoryECDH(params *ECDH1Params, base *memoryObject, length int) ([]byte, error) {
+	if params == nil {
+		return nil, errMemoryParamInvalid
+	}
+	if base.ulong(pkcs11.CKA_CLASS) != pkcs11.CKO_PRIVATE_KEY {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_TYPE_INCONSISTENT)
+	}
+	priv, err := memoryECPrivateKey(base.attrs)
+	if err != nil {
+		return nil, err
+	}
+	_, info, _ := memoryCurve(base.attrs)
+	point, err := (&ECPublicKey{Point: params.PublicData}).pointFor(info)
+	if err != nil {
+		return nil, errMemoryParamInvalid
+	}
+	ecdhPriv, err := priv.ECDH()
+	if err != nil {
+		return nil, pkcs11.Error(pkcs11.CKR_CURVE_NOT_SUPPORTED)
+	}
+	peer, err := ecdhPriv.Curve().NewPublicKey(point)
+	if err != nil {
+		return nil, errMemoryParamInvalid
+	}
+	z, err := ecdhPriv.ECDH(peer)
+	if err != nil {
+		return nil, errMemoryParamInvalid
+	}
+
+	if params.KDF == 0 || params.KDF == pkcs11.CKD_NULL {
+		if len(params.SharedData) > 0 {
+			return nil, errMemoryParamInvalid
+		}
+		return z, nil
+	}
+	var h crypto.Hash
+	for _, info := range memoryHashes {
+		if info.ckdKDF == params.KDF {
+			h = info.hash
+		}
+	}
+	if h == 0 {
+		return nil, errMemoryParamInvalid
+	}
+	if length == 0 {
+		length = h.Size()
+	}
+	var out []byte
+	for counter := uint32(1); len(out) < length; counter++ {
+		d := h.New()
+		d.Write(z)
+		d.Write(binary.BigEndian.AppendUint32(nil, counter))
+		d.Write(params.SharedData)
+		out = d.Sum(out)
+	}
+	return out[:length], nil
+}
+
+// memoryHKDF derives length bytes with HKDF (RFC 5869), or the hash length
+// if length is zero.
+func memoryHKDF(params *HKDFParams, secret []byte, length int) ([]byte, error) {
+	if params == nil || (!params.Extract && !params.Expand) {
+		return nil, errMemoryParamInvalid
+	}
+	h, ok := memoryHash(params.PRF)
+	if !ok {
+		return nil, errMemoryParamInvalid
+	}
+	if length == 0 {
+		length = h.Size()
+	}
+	key := secret
+	if params.Extract {
+		prk, err := hkdf.Extract(h.New, secret, params.Salt)
+		if err != nil || !params.Expand {
+			return prk, err
+		}
+		key = prk
+	}
+	out, err := hkdf.Expand(h.New, key, string(params.Info), length)
+	if err != nil {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_SIZE_RANGE)
+	}
+	return out, nil
+}
+
+// memorySP800108 derives length bytes with the KDF in counter or feedback
+// mode of NIST SP 800-108 with an HMAC PRF. Each PRF input is
+// K(i-1) || [i] || fixed input || [L] with K(0) = IV, where K(i-1) is only
+// used in feedback mode and [i] may be omitted there.
+func memorySP800108(counterMode bool, params *SP800108Params, secret []byte, length int) ([]byte, error) {
+	if params == nil {
+		return nil, errMemoryParamInvalid
+	}
+	var h crypto.Hash
+	for _, info := range memoryHashes {
+		if info.hmac == params.PRF {
+			h = info.hash
+		}
+	}
+	if length == 0 {
+		return nil, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+	}
+	counterBits, dkmBits := params.CounterBits, params.DKMLengthBits
+	if counterMode && counterBits == 0 {
+		counterBits = 32
+	}
+	if dkmBits == 0 {
+		dkmBits = 32
+	}
+	if h == 0 || counterBits%8 != 0 || counterBits > 32 || dkmBits%8 != 0 || dkmBits > 32 || (counterMode && len(params.IV) > 0) {
+		return nil, errMemoryParamInvalid
+	}
+	encode := func(v uint64, bits uint) []byte {
+		return binary.BigEndian.AppendUint64(nil, v)[8-bits/8:]
+	}
+
+	var out []byte
+	k := params.IV
+	for i := uint64(1); len(out) < length; i++ {
+		mac := hmac.New(h.New, secret)
+		if !counterMode {
+			mac.Write(k)
+		}
+		mac.Write(encode(i, counterBits))
+		mac.Write(params.FixedInput)
+		mac.Write(encode(uint64(length)*8, dkmBits))
+		k = mac.Sum(nil)
+		out = append(out, k...)
+	}
+	return out[:length], nil
+}
@@ -0,0 +1,365 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/miekg/pkcs11"
+)
+
+// memoryOrigin describes how a new object came into existence, which
+// determines CKA_LOCAL, CKA_KEY_GEN_MECHANISM, CKA_ALWAYS_SENSITIVE and
+// CKA_NEVER_EXTRACTABLE of keys.
+type memoryOrigin struct {
+	local            bool // generated on the token
+	mechanism        uint // mechanism that generated or derived the key
+	alwaysSensitive  bool // the key material has never been known outside the token
+	neverExtractable bool // the key material has never been extractable
+}
+
+// memoryAttrTypes maps attribute types to their encoding, so that templates
+// with values of the wrong size are rejected.
+var memoryAttrTypes = func() map[uint]AttrType {
+	types := make(map[uint]AttrType, len(ObjectAttrs))
+	for _, def := range ObjectAttrs {
+		types[def.Type] = def.AttrType
+	}
+	return types
+}()
+
+// memorySensitiveAttrs hold key material that cannot be read from sensitive
+// or unextractable keys.
+var memorySensitiveAttrs = map[uint]bool{
+	pkcs11.CKA_VALUE:            true,
+	pkcs11.CKA_PRIVATE_EXPONENT: true,
+	pkcs11.CKA_PRIME_1:          true,
+	pkcs11.CKA_PRIME_2:          true,
+	pkcs11.CKA_EXPONENT_1:       true,
+	pkcs11.CKA_EXPONENT_2:       true,
+	pkcs11.CKA_COEFFICIENT:      true,
+}
+
+// memoryComputedAttrs are set by the token and cannot be given in templates.
+var memoryComputedAttrs = []uint{
+	pkcs11.CKA_LOCAL,
+	pkcs11.CKA_KEY_GEN_MECHANISM,
+	pkcs11.CKA_ALWAYS_SENSITIVE,
+	pkcs11.CKA_NEVER_EXTRACTABLE,
+}
+
+// memoryFixedAttrs cannot be changed once an object exists. The values of
+// keys and certificates are fixed as well.
+var memoryFixedAttrs = map[uint]bool{
+	pkcs11.CKA_CLASS:            true,
+	pkcs11.CKA_KEY_TYPE:         true,
+	pkcs11.CKA_CERTIFICATE_TYPE: true,
+	pkcs11.CKA_VALUE_LEN:        true,
+	pkcs11.CKA_MODULUS:          true,
+	pkcs11.CKA_MODULUS_BITS:     true,
+	pkcs11.CKA_PUBLIC_EXPONENT:  true,
+	pkcs11.CKA_PRIVATE_EXPONENT: true,
+	pkcs11.CKA_PRIME_1:          true,
+	pkcs11.CKA_PRIME_2:          true,
+	pkcs11.CKA_EXPONENT_1:       true,
+	pkcs11.CKA_EXPONENT_2:       true,
+	pkcs11.CKA_COEFFICIENT:      true,
+	pkcs11.CKA_EC_PARAMS:        true,
+	pkcs11.CKA_EC_POINT:         true,
+}
+
+// memoryCopyAttrs can only be changed while copying an object.
+var memoryCopyAttrs = map[uint]bool{
+	pkcs11.CKA_TOKEN:       true,
+	pkcs11.CKA_PRIVATE:     true,
+	pkcs11.CKA_MODIFIABLE:  true,
+	pkcs11.CKA_COPYABLE:    true,
+	pkcs11.CKA_DESTROYABLE: true,
+}
+
+// Attributes valid for the supported object classes and key types. Vendor
+// defined attributes are valid for all objects.
+var (
+	memoryCommonAttrs = []uint{
+		pkcs11.CKA_CLASS, pkcs11.CKA_TOKEN, pkcs11.CKA_PRIVATE, pkcs11.CKA_MODIFIABLE,
+		pkcs11.CKA_LABEL, pkcs11.CKA_COPYABLE, pkcs11.CKA_DESTROYABLE,
+	}
+	memoryKeyAttrs = []uint{
+		pkcs11.CKA_KEY_TYPE, pkcs11.CKA_ID, pkcs11.CKA_START_DATE, pkcs11.CKA_END_DATE,
+		pkcs11.CKA_DERIVE, pkcs11.CKA_LOCAL, pkcs11.CKA_KEY_GEN_MECHANISM,
+	}
+	memoryClassAttrs = map[uint][]uint{
+		pkcs11.CKO_DATA: {pkcs11.CKA_APPLICATION, pkcs11.CKA_OBJECT_ID, pkcs11.CKA_VALUE},
+		pkcs11.CKO_CERTIFICATE: {
+			pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKA_TRUSTED, pkcs11.CKA_CERTIFICATE_CATEGORY,
+			pkcs11.CKA_CHECK_VALUE, pkcs11.CKA_START_DATE, pkcs11.CKA_END_DATE,
+			pkcs11.CKA_PUBLIC_KEY_INFO, pkcs11.CKA_SUBJECT, pkcs11.CKA_ID, pkcs11.CKA_ISSUER,
+			pkcs11.CKA_SERIAL_NUMBER, pkcs11.CKA_VALUE, pkcs11.CKA_URL,
+			pkcs11.CKA_HASH_OF_SUBJECT_PUBLIC_KEY, pkcs11.CKA_HASH_OF_ISSUER_PUBLIC_KEY,
+			pkcs11.CKA_JAVA_MIDP_SECURITY_DOMAIN, pkcs11.CKA_NAME_HASH_ALGORITHM,
+			pkcs11.CKA_OWNER, pkcs11.CKA_AC_ISSUER, pkcs11.CKA_ATTR_TYPES,
+		},
+		pkcs11.CKO_PUBLIC_KEY: {
+			pkcs11.CKA_SUBJECT, pkcs11.CKA_ENCRYPT, pkcs11.CKA_VERIFY, pkcs11.CKA_VERIFY_RECOVER,
+			pkcs11.CKA_WRAP, pkcs11.CKA_TRUSTED, pkcs11.CKA_PUBLIC_KEY_INFO,
+		},
+		pkcs11.CKO_PRIVATE_KEY: {
+			pkcs11.CKA_SUBJECT, pkcs11.CKA_SENSITIVE, pkcs11.CKA_DECRYPT, pkcs11.CKA_SIGN,
+			pkcs11.CKA_SIGN_RECOVER, pkcs11.CKA_UNWRAP, pkcs11.CKA_EXTRACTABLE,
+			pkcs11.CKA_ALWAYS_SENSITIVE, pkcs11.CKA_NEVER_EXTRACTABLE, pkcs11.CKA_WRAP_WITH_TRUSTED,
+			pkcs11.CKA_ALWAYS_AUTHENTICATE, pkcs11.CKA_PUBLIC_KEY_INFO,
+		},
+		pkcs11.CKO_SECRET_KEY: {
+			pkcs11.CKA_SENSITIVE, pkcs11.CKA_ENCRYPT, pkcs11.CKA_DECRYPT, pkcs11.CKA_SIGN,
+			pkcs11.CKA_VERIFY, pkcs11.CKA_WRAP, pkcs11.CKA_UNWRAP, pkcs11.CKA_EXTRACTABLE,
+			pkcs11.CKA_ALWAYS_SENSITIVE, pkcs11.CKA_NEVER_EXTRACTABLE, pkcs11.CKA_CHECK_VALUE,
+			pkcs11.CKA_WRAP_WITH_TRUSTED, pkcs11.CKA_TRUSTED, pkcs11.CKA_VALUE, pkcs11.CKA_VALUE_LEN,
+		},
+	}
+	// Public and private keys are limited to the key types with mechanisms
+	memoryKeyTypeAttrs = map[[2]uint][]uint{
+		{pkcs11.CKO_PUBLIC_KEY, pkcs11.CKK_RSA}: {
+			pkcs11.CKA_MODULUS, pkcs11.CKA_MODULUS_BITS, pkcs11.CKA_PUBLIC_EXPONENT,
+		},
+		{pkcs11.CKO_PRIVATE_KEY, pkcs11.CKK_RSA}: {
+			pkcs11.CKA_MODULUS, pkcs11.CKA_PUBLIC_EXPONENT, pkcs11.CKA_PRIVATE_EXPONENT,
+			pkcs11.CKA_PRIME_1, pkcs11.CKA_PRIME_2, pkcs11.CKA_EXPONENT_1, pkcs11.CKA_EXPONENT_2,
+			pkcs11.CKA_COEFFICIENT,
+		},
+		{pkcs11.CKO_PUBLIC_KEY, pkcs11.CKK_EC}:  {pkcs11.CKA_EC_PARAMS, pkcs11.CKA_EC_POINT},
+		{pkcs11.CKO_PRIVATE_KEY, pkcs11.CKK_EC}: {pkcs11.CKA_EC_PARAMS, pkcs11.CKA_VALUE},
+	}
+)
+
+// isMemoryKey reports whether class is a key class.
+func isMemoryKey(class uint) bool {
+	return class == pkcs11.CKO_SECRET_KEY || class == pkcs11.CKO_PUBLIC_KEY || class == pkcs11.CKO_PRIVATE_KEY
+}
+
+// memoryAllowed reports whether objects of class and keyType have the attribute t.
+func memoryAllowed(class, keyType, t uint) bool {
+	switch {
+	case t >= pkcs11.CKA_VENDOR_DEFINED,
+		slices.Contains(memoryCommonAttrs, t),
+		slices.Contains(memoryClassAttrs[class], t),
+		isMemoryKey(class) && slices.Contains(memoryKeyAttrs, t):
+		return true
+	}
+	return slices.Contains(memoryKeyTypeAttrs[[2]uint{class, keyType}], t)
+}
+
+// memoryTemplate converts a template into an attribute map. Boolean and
+// CK_ULONG values must have the size of their C type.
+func memoryTemplate(temp []*pkcs11.Attribute) (map[uint][]byte, error) {
+	attrs := make(map[uint][]byte, len(temp))
+	for _, a := range temp {
+		if _, dup := attrs[a.Type]; dup {
+			return nil, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+		}
+		switch memoryAttrTypes[a.Type] {
+		case AttrTypeBool:
+			if len(a.Value) != 1 {
+				return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+			}
+		case AttrTypeUlong:
+			if len(a.Value) != len(UlongToBytes(0)) {
+				return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+			}
+		}
+		attrs[a.Type] = append([]byte{}, a.Value...)
+	}
+	return attrs, nil
+}
+
+// newMemoryObject checks the attributes of a new object against the
+// attribute policy and completes them with defaults, which follow SoftHSM:
+// keys are sensitive, unextractable and usable for all functions but key
+// derivation unless the template says otherwise.
+func newMemoryObject(attrs map[uint][]byte, origin memoryOrigin) error {
+	for _, t := range memoryComputedAttrs {
+		if _, ok := attrs[t]; ok {
+			return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_READ_ONLY)
+		}
+	}
+	classValue, ok := attrs[pkcs11.CKA_CLASS]
+	if !ok {
+		return pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+	}
+	class := BytesToUlong(classValue)
+	if _, ok := memoryClassAttrs[class]; !ok {
+		return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	}
+	var keyType uint
+	if isMemoryKey(class) {
+		value, ok := attrs[pkcs11.CKA_KEY_TYPE]
+		if !ok {
+			return pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+		}
+		keyType = BytesToUlong(value)
+		if class != pkcs11.CKO_SECRET_KEY && memoryKeyTypeAttrs[[2]uint{class, keyType}] == nil {
+			return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+		}
+	}
+	for t := range attrs {
+		if !memoryAllowed(class, keyType, t) {
+			return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_TYPE_INVALID)
+		}
+	}
+	if _, ok := attrs[pkcs11.CKA_CERTIFICATE_TYPE]; class == pkcs11.CKO_CERTIFICATE && !ok {
+		return pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+	}
+	if isMemoryKey(class) {
+		if err := checkMemoryKeyValue(class, keyType, attrs); err != nil {
+			return err
+		}
+	}
+
+	yes, no, empty := BoolToBytes(true), BoolToBytes(false), []byte{}
+	defaults := map[uint][]byte{
+		pkcs11.CKA_TOKEN:       no,
+		pkcs11.CKA_PRIVATE:     BoolToBytes(class == pkcs11.CKO_PRIVATE_KEY || class == pkcs11.CKO_SECRET_KEY),
+		pkcs11.CKA_MODIFIABLE:  yes,
+		pkcs11.CKA_COPYABLE:    yes,
+		pkcs11.CKA_DESTROYABLE: yes,
+		pkcs11.CKA_LABEL:       empty,
+	}
+	switch class {
+	case pkcs11.CKO_DATA:
+		defaults[pkcs11.CKA_APPLICATION] = empty
+		defaults[pkcs11.CKA_OBJECT_ID] = empty
+		defaults[pkcs11.CKA_VALUE] = empty
+	case pkcs11.CKO_CERTIFICATE:
+		defaults[pkcs11.CKA_TRUSTED] = no
+		defaults[pkcs11.CKA_CERTIFICATE_CATEGORY] = UlongToBytes(0)
+		defaults[pkcs11.CKA_ID] = empty
+		defaults[pkcs11.CKA_START_DATE] = empty
+		defaults[pkcs11.CKA_END_DATE] = empty
+		defaults[pkcs11.CKA_VALUE] = empty
+	case pkcs11.CKO_PUBLIC_KEY:
+		defaults[pkcs11.CKA_SUBJECT] = empty
+		defaults[pkcs11.CKA_ENCRYPT] = yes
+		defaults[pkcs11.CKA_VERIFY] = yes
+		defaults[pkcs11.CKA_VERIFY_RECOVER] = no
+		defaults[pkcs11.CKA_WRAP] = yes
+		defaults[pkcs11.CKA_TRUSTED] = no
+	case pkcs11.CKO_PRIVATE_KEY:
+		defaults[pkcs11.CKA_SUBJECT] = empty
+		defaults[pkcs11.CKA_SENSITIVE] = yes
+		defaults[pkcs11.CKA_DECRYPT] = yes
+		defaults[pkcs11.CKA_SIGN] = yes
+		defaults[pkcs11.CKA_SIGN_RECOVER] = no
+		defaults[pkcs11.CKA_UNWRAP] = yes
+		defaults[pkcs11.CKA_EXTRACTABLE] = no
+		defaults[pkcs11.CKA_WRAP_WITH_TRUSTED] = no
+		defaults[pkcs11.CKA_ALWAYS_AUTHENTICATE] = no
+	case pkcs11.CKO_SECRET_KEY:
+		for _, t := range []uint{
+			pkcs11.CKA_SENSITIVE, pkcs11.CKA_ENCRYPT, pkcs11.CKA_DECRYPT, pkcs11.CKA_SIGN,
+			pkcs11.CKA_VERIFY, pkcs11.CKA_WRAP, pkcs11.CKA_UNWRAP,
+		} {
+			defaults[t] = yes
+		}
+		defaults[pkcs11.CKA_EXTRACTABLE] = no
+		defaults[pkcs11.CKA_WRAP_WITH_TRUSTED] = no
+		defaults[pkcs11.CKA_TRUSTED] = no
+	}
+	if isMemoryKey(class) {
+		defaults[pkcs11.CKA_ID] = empty
+		defaults[pkcs11.CKA_START_DATE] = empty
+		defaults[pkcs11.CKA_END_DATE] = empty
+		defaults[pkcs11.CKA_DERIVE] = no
+	}
+	for t, v := range defaults {
+		if _, ok := attrs[t]; !ok {
+			attrs[t] = v
+		}
+	}
+
+	if isMemoryKey(class) {
+		attrs[pkcs11.CKA_LOCAL] = BoolToBytes(origin.local)
+		attrs[pkcs11.CKA_KEY_GEN_MECHANISM] = UlongToBytes(origin.mechanism)
+	}
+	if class == pkcs11.CKO_PRIVATE_KEY || class == pkcs11.CKO_SECRET_KEY {
+		attrs[pkcs11.CKA_ALWAYS_SENSITIVE] = BoolToBytes(origin.alwaysSensitive && BytesToBool(attrs[pkcs11.CKA_SENSITIVE]))
+		attrs[pkcs11.CKA_NEVER_EXTRACTABLE] = BoolToBytes(origin.neverExtractable && !BytesToBool(attrs[pkcs11.CKA_EXTRACTABLE]))
+	}
+	return nil
+}
+
+// checkMemoryKeyValue checks the key material of a new key and sets the
+// attributes that follow from it.
+func checkMemoryKeyValue(class, keyType uint, attrs map[uint][]byte) error {
+	switch class {
+	case pkcs11.CKO_SECRET_KEY:
+		value, ok := attrs[pkcs11.CKA_VALUE]
+		if !ok {
+			return pkcs11.Error(pkcs11.CKR_TEMPLATE_INCOMPLETE)
+		}
+		if len(value) == 0 || (keyType == pkcs11.CKK_AES && !validAESKeyLen(len(value))) {
+			return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+		}
+		valueLen := UlongToBytes(uint(len(value)))
+		if v, ok := attrs[pkcs11.CKA_VALUE_LEN]; ok && !bytes.Equal(v, valueLen) {
+			return pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+		}
+		attrs[pkcs11.CKA_VALUE_LEN] = valueLen
+
+	case pkcs11.CKO_PUBLIC_KEY:
+		if keyType == pkcs11.CKK_EC {
+			_, err := memoryECPublicKey(attrs)
+			return err
+		}
+		pub, err := memoryRSAPublicKey(attrs)
+		if err != nil {
+			return err
+		}
+		bits := UlongToBytes(uint(pub.N.BitLen()))
+		if v, ok := attrs[pkcs11.CKA_MODULUS_BITS]; ok && !bytes.Equal(v, bits) {
+			return pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
+		}
+		attrs[pkcs11.CKA_MODULUS_BITS] = bits
+
+	case pkcs11.CKO_PRIVATE_KEY:
+		if keyType == pkcs11.CKK_EC {
+			_, err := memoryECPrivateKey(attrs)
+			return err
+		}
+		priv, err := memoryRSAPrivateKey(attrs)
+		if err != nil {
+			return err
+		}
+		for t, v := range map[uint][]byte{
+			pkcs11.CKA_EXPONENT_1:  priv.Precomputed.Dp.Bytes(),
+			pkcs11.CKA_EXPONENT_2:  priv.Precomputed.Dq.Bytes(),
+			pkcs11.CKA_COEFFICIENT: priv.Precomputed.Qinv.Bytes(),
+		} {
+			if _, ok := attrs[t]; !ok {
+				attrs[t] = v
+			}
+		}
+	}
+	return nil
+}
+
+// checkChange returns an error unless the attribute t of obj may be set to
+// value, by C_SetAttributeValue or, if copying, by C_CopyObject.
+func checkChange(obj *memoryObject, t uint, value []byte, copying bool) error {
+	class := obj.ulong(pkcs11.CKA_CLASS)
+	if !memoryAllowed(class, obj.ulong(pkcs11.CKA_KEY_TYPE), t) {
+		return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_TYPE_INVALID)
+	}
+	old := obj.attrs[t]
+	switch {
+	case copying && memoryCopyAttrs[t]:
+		return nil
+	case memoryFixedAttrs[t], memoryCopyAttrs[t], slices.Contains(memoryComputedAttrs, t),
+		t == pkcs11.CKA_VALUE && class != pkcs11.CKO_DATA:
+		return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_READ_ONLY)
+
+	// Keys can only become more protected
+	case t == pkcs11.CKA_SENSITIVE && BytesToBool(old) && !BytesToBool(value),
+		t == pkcs11.CKA_EXTRACTABLE && !BytesToBool(old) && BytesToBool(value),
+		t == pkcs11.CKA_WRAP_WITH_TRUSTED && BytesToBool(old) && !BytesToBool(value):
+		return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_READ_ONLY)
+	}
+	return nil
+}
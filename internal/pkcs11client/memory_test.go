@@ -0,0 +1,595 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+// newMemoryTestClient returns a client of an initialized emulated token
+// persisted in file, or only kept in memory if file is empty.
+func newMemoryTestClient(t *testing.T, file string) (*Client, *MemoryContext) {
+	t.Helper()
+	mem := NewMemoryContext(file)
+	slotID := uint(0)
+	client, err := NewClientWithContext(mem, Config{SlotID: &slotID, Pin: "1234", SoPin: "12345678", PoolSize: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	ctx := t.Context()
+	info, err := client.GetTokenInfo(ctx)
+	if err != nil {
+		t.Fatalf("GetTokenInfo failed: %v", err)
+	}
+	if info.Flags&pkcs11.CKF_TOKEN_INITIALIZED == 0 {
+		if err := client.InitToken(ctx, slotID, "", "memory"); err != nil {
+			t.Fatalf("InitToken failed: %v", err)
+		}
+		if err := client.InitPIN(ctx, slotID, "", ""); err != nil {
+			t.Fatalf("InitPIN failed: %v", err)
+		}
+	}
+	return client, mem
+}
+
+// newMemoryTestMechanism builds the mechanism id with params.
+func newMemoryTestMechanism(t *testing.T, id uint, params *MechanismParams) []*pkcs11.Mechanism {
+	t.Helper()
+	mech, free, err := NewMechanism(id, params)
+	if err != nil {
+		t.Fatalf("NewMechanism failed: %v", err)
+	}
+	t.Cleanup(free)
+	return mech
+}
+
+// createMemorySecretKey imports an extractable secret key with value.
+func createMemorySecretKey(t *testing.T, client *Client, keyType uint, value []byte, attrs ...*pkcs11.Attribute) pkcs11.ObjectHandle {
+	t.Helper()
+	handle, err := client.CreateObject(t.Context(), append([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, value),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+	}, attrs...))
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+	return handle
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMemoryContext_Token(t *testing.T) {
+	mem := NewMemoryContext("")
+	mem.Initialize()
+	defer mem.Finalize()
+
+	sh, err := mem.OpenSession(0, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Login(sh, pkcs11.CKU_USER, "1234"); !errors.Is(err, pkcs11.Error(pkcs11.CKR_TOKEN_NOT_RECOGNIZED)) {
+		t.Errorf("expected CKR_TOKEN_NOT_RECOGNIZED before initialization, got: %v", err)
+	}
+	if err := mem.InitToken(0, "12345678", "memory"); !errors.Is(err, pkcs11.Error(pkcs11.CKR_SESSION_EXISTS)) {
+		t.Errorf("expected CKR_SESSION_EXISTS, got: %v", err)
+	}
+	mem.CloseSession(sh)
+	if err := mem.InitToken(0, "123", "memory"); !errors.Is(err, pkcs11.Error(pkcs11.CKR_PIN_LEN_RANGE)) {
+		t.Errorf("expected CKR_PIN_LEN_RANGE, got: %v", err)
+	}
+	if err := mem.InitToken(0, "12345678", "memory"); err != nil {
+		t.Fatalf("InitToken failed: %v", err)
+	}
+
+	sh, _ = mem.OpenSession(0, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err := mem.Login(sh, pkcs11.CKU_USER, "1234"); !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_PIN_NOT_INITIALIZED)) {
+		t.Errorf("expected CKR_USER_PIN_NOT_INITIALIZED, got: %v", err)
+	}
+	if err := mem.Login(sh, pkcs11.CKU_SO, "wrong-pin"); !errors.Is(err, pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)) {
+		t.Errorf("expected CKR_PIN_INCORRECT, got: %v", err)
+	}
+	if err := mem.Login(sh, pkcs11.CKU_SO, "12345678"); err != nil {
+		t.Fatalf("SO login failed: %v", err)
+	}
+	if err := mem.InitPIN(sh, "1234"); err != nil {
+		t.Fatalf("InitPIN failed: %v", err)
+	}
+	if err := mem.Login(sh, pkcs11.CKU_USER, "1234"); !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ANOTHER_ALREADY_LOGGED_IN)) {
+		t.Errorf("expected CKR_USER_ANOTHER_ALREADY_LOGGED_IN, got: %v", err)
+	}
+	mem.Logout(sh)
+	if err := mem.Login(sh, pkcs11.CKU_USER, "1234"); err != nil {
+		t.Fatalf("user login failed: %v", err)
+	}
+	info, err := mem.GetSessionInfo(sh)
+	if err != nil || info.State != pkcs11.CKS_RW_USER_FUNCTIONS {
+		t.Errorf("expected CKS_RW_USER_FUNCTIONS, got %d (%v)", info.State, err)
+	}
+	tokenInfo, _ := mem.GetTokenInfo(0)
+	if tokenInfo.Label != "memory" || tokenInfo.Flags&pkcs11.CKF_USER_PIN_INITIALIZED == 0 {
+		t.Errorf("unexpected token info %+v", tokenInfo)
+	}
+}
+
+func TestMemoryContext_SessionObjects(t *testing.T) {
+	_, mem := newMemoryTestClient(t, "")
+
+	flags := uint(pkcs11.CKF_SERIAL_SESSION | pkcs11.CKF_RW_SESSION)
+	creator, err := mem.OpenSession(0, flags)
+	if err != nil {
+		t.Fatalf("OpenSession failed: %v", err)
+	}
+	other, err := mem.OpenSession(0, flags)
+	if err != nil {
+		t.Fatalf("OpenSession failed: %v", err)
+	}
+	defer mem.CloseSession(other)
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "ephemeral"),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, false),
+	}
+	if _, err := mem.CreateObject(creator, template); err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	find := func() int {
+		t.Helper()
+		if err := mem.FindObjectsInit(other, template[:2]); err != nil {
+			t.Fatalf("FindObjectsInit failed: %v", err)
+		}
+		defer mem.FindObjectsFinal(other)
+		handles, _, err := mem.FindObjects(other, 10)
+		if err != nil {
+			t.Fatalf("FindObjects failed: %v", err)
+		}
+		return len(handles)
+	}
+
+	// Session objects are visible to all sessions of the application
+	if n := find(); n != 1 {
+		t.Fatalf("expected the session object in another session, found %d objects", n)
+	}
+	// and destroyed with the session that created them
+	if err := mem.CloseSession(creator); err != nil {
+		t.Fatalf("CloseSession failed: %v", err)
+	}
+	if n := find(); n != 0 {
+		t.Errorf("expected the session object to be destroyed, found %d objects", n)
+	}
+}
+
+func TestMemoryContext_AES(t *testing.T) {
+	client, _ := newMemoryTestClient(t, "")
+	ctx := t.Context()
+
+	key, err := client.GenerateSymmetricKey(ctx, newMemoryTestMechanism(t, pkcs11.CKM_AES_KEY_GEN, nil), []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+	})
+	if err != nil {
+		t.Fatalf("GenerateSymmetricKey failed: %v", err)
+	}
+	plaintext := []byte("attack at dawn, not at dusk")
+
+	for name, params := range map[uint]*MechanismParams{
+		pkcs11.CKM_AES_CBC_PAD: {IV: make([]byte, 16)},
+		pkcs11.CKM_AES_GCM:     {GCM: &GCMParams{IV: make([]byte, 12), AAD: []byte("header"), TagBits: 96}},
+	} {
+		mech := newMemoryTestMechanism(t, name, params)
+		ciphertext, err := client.Encrypt(ctx, mech, key, plaintext)
+		if err != nil {
+			t.Fatalf("%#x: Encrypt failed: %v", name, err)
+		}
+		decrypted, err := client.Decrypt(ctx, mech, key, ciphertext)
+		if err != nil {
+			t.Fatalf("%#x: Decrypt failed: %v", name, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("%#x: expected %q, got %q", name, plaintext, decrypted)
+		}
+		ciphertext[0] ^= 1
+		if name == pkcs11.CKM_AES_GCM {
+			if _, err := client.Decrypt(ctx, mech, key, ciphertext); !errors.Is(err, pkcs11.Error(pkcs11.CKR_ENCRYPTED_DATA_INVALID)) {
+				t.Errorf("expected CKR_ENCRYPTED_DATA_INVALID for tampered ciphertext, got: %v", err)
+			}
+		}
+	}
+
+	// Test vectors of RFC 3394 section 4.1 and RFC 5649 section 6
+	for _, tc := range []struct {
+		mech                uint
+		kek, data, expected string
+	}{
+		{pkcs11.CKM_AES_KEY_WRAP, "000102030405060708090A0B0C0D0E0F", "00112233445566778899AABBCCDDEEFF",
+			"1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5"},
+		{pkcs11.CKM_AES_KEY_WRAP_PAD, "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8", "c37b7e6492584340bed12207808941155068f738",
+			"138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a"},
+		{ckmAESKeyWrapKWP, "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8", "466f7250617369",
+			"afbeb0f07dfbf5419200f2ccb50bb24f"},
+	} {
+		kek := createMemorySecretKey(t, client, pkcs11.CKK_AES, mustHex(t, tc.kek))
+		mech := newMemoryTestMechanism(t, tc.mech, nil)
+		wrapped, err := client.Encrypt(ctx, mech, kek, mustHex(t, tc.data))
+		if err != nil {
+			t.Fatalf("%#x: Encrypt failed: %v", tc.mech, err)
+		}
+		if !bytes.Equal(wrapped, mustHex(t, tc.expected)) {
+			t.Errorf("%#x: expected %s, got %x", tc.mech, tc.expected, wrapped)
+		}
+		unwrapped, err := client.Decrypt(ctx, mech, kek, wrapped)
+		if err != nil || !bytes.Equal(unwrapped, mustHex(t, tc.data)) {
+			t.Errorf("%#x: expected %s, got %x (%v)", tc.mech, tc.data, unwrapped, err)
+		}
+	}
+}
+
+func TestMemoryContext_RSA(t *testing.T) {
+	client, _ := newMemoryTestClient(t, "")
+	ctx := t.Context()
+
+	pub, priv, err := client.GenerateKeyPair(ctx, newMemoryTestMechanism(t, pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil),
+		[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+		})
+	if err != nil {
+		t.Fatalf("GenerateKeyPair failed: %v", err)
+	}
+	attrs, err := client.GetObjectAttributes(ctx, pub, []uint{pkcs11.CKA_MODULUS, pkcs11.CKA_PUBLIC_EXPONENT})
+	if err != nil {
+		t.Fatalf("GetObjectAttributes failed: %v", err)
+	}
+	goPub := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(attrs[pkcs11.CKA_MODULUS]),
+		E: int(new(big.Int).SetBytes(attrs[pkcs11.CKA_PUBLIC_EXPONENT]).Int64()),
+	}
+
+	oaep := newMemoryTestMechanism(t, pkcs11.CKM_RSA_PKCS_OAEP, &MechanismParams{OAEP: &OAEPParams{Hash: pkcs11.CKM_SHA256, SourceData: []byte("label")}})
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, goPub, []byte("secret"), []byte("label"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := client.Decrypt(ctx, oaep, priv, ciphertext)
+	if err != nil || string(plaintext) != "secret" {
+		t.Errorf("expected OAEP plaintext %q, got %q (%v)", "secret", plaintext, err)
+	}
+
+	data := []byte("message to sign")
+	digest := sha256.Sum256(data)
+	sig, err := client.Sign(ctx, newMemoryTestMechanism(t, pkcs11.CKM_SHA256_RSA_PKCS, nil), priv, data)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := rsa.VerifyPKCS1v15(goPub, crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("PKCS#1 v1.5 signature does not verify: %v", err)
+	}
+
+	pss := newMemoryTestMechanism(t, pkcs11.CKM_SHA256_RSA_PKCS_PSS, &MechanismParams{PSS: &PSSParams{Hash: pkcs11.CKM_SHA256}})
+	sig, err = client.Sign(ctx, pss, priv, data)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := rsa.VerifyPSS(goPub, crypto.SHA256, digest[:], sig, &rsa.PSSOptions{SaltLength: 32}); err != nil {
+		t.Errorf("PSS signature does not verify: %v", err)
+	}
+	if ok, err := client.Verify(ctx, pss, pub, data, sig); !ok || err != nil {
+		t.Errorf("expected PSS signature to verify, got %v (%v)", ok, err)
+	}
+	sig[0] ^= 1
+	if ok, _ := client.Verify(ctx, pss, pub, data, sig); ok {
+		t.Errorf("expected tampered signature not to verify")
+	}
+
+	// The private key was not generated with CKA_ENCRYPT
+	if _, err := client.Encrypt(ctx, oaep, priv, []byte("secret")); !errors.Is(err, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)) {
+		t.Errorf("expected CKR_KEY_FUNCTION_NOT_PERMITTED, got: %v", err)
+	}
+}
+
+func TestMemoryContext_EC(t *testing.T) {
+	client, _ := newMemoryTestClient(t, "")
+	ctx := t.Context()
+
+	params, _ := asn1.Marshal(oidP256)
+	pub, priv, err := client.GenerateKeyPair(ctx, newMemoryTestMechanism(t, pkcs11.CKM_EC_KEY_PAIR_GEN, nil),
+		[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+		})
+	if err != nil {
+		t.Fatalf("GenerateKeyPair failed: %v", err)
+	}
+	attrs, err := client.GetObjectAttributes(ctx, pub, []uint{pkcs11.CKA_EC_POINT})
+	if err != nil {
+		t.Fatalf("GetObjectAttributes failed: %v", err)
+	}
+	var point []byte
+	if _, err := asn1.Unmarshal(attrs[pkcs11.CKA_EC_POINT], &point); err != nil {
+		t.Fatalf("CKA_EC_POINT is not an OCTET STRING: %v", err)
+	}
+	goPub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+	if err != nil {
+		t.Fatalf("invalid public key: %v", err)
+	}
+
+	data := []byte("message to sign")
+	digest := sha256.Sum256(data)
+	sig, err := client.Sign(ctx, newMemoryTestMechanism(t, pkcs11.CKM_ECDSA_SHA256, nil), priv, data)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if len(sig) != 64 || !ecdsa.Verify(goPub, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Errorf("ECDSA signature %x does not verify", sig)
+	}
+
+	// ECDH followed by HKDF, compared with crypto/ecdh and crypto/hkdf
+	peer, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goPubECDH, _ := goPub.ECDH()
+	shared, _ := peer.ECDH(goPubECDH)
+	secret, err := client.DeriveKey(ctx, newMemoryTestMechanism(t, pkcs11.CKM_ECDH1_DERIVE, &MechanismParams{
+		ECDH1: &ECDH1Params{KDF: pkcs11.CKD_NULL, PublicData: peer.PublicKey().Bytes()},
+	}), priv, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+	})
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+	derived, err := client.DeriveKey(ctx, newMemoryTestMechanism(t, ckmHKDFDerive, &MechanismParams{
+		HKDF: &HKDFParams{PRF: pkcs11.CKM_SHA256, Extract: true, Expand: true, Salt: []byte("salt"), Info: []byte("info")},
+	}), secret, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 42),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+	})
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+	value, err := client.GetObjectAttributes(ctx, derived, []uint{pkcs11.CKA_VALUE})
+	if err != nil {
+		t.Fatalf("GetObjectAttributes failed: %v", err)
+	}
+	expected, _ := hkdf.Key(sha256.New, shared, []byte("salt"), "info", 42)
+	if !bytes.Equal(value[pkcs11.CKA_VALUE], expected) {
+		t.Errorf("expected derived key %x, got %x", expected, value[pkcs11.CKA_VALUE])
+	}
+}
+
+func TestMemoryContext_HMACAndDigest(t *testing.T) {
+	client, _ := newMemoryTestClient(t, "")
+	ctx := t.Context()
+
+	value := []byte("0123456789abcdef0123456789abcdef")
+	key := createMemorySecretKey(t, client, pkcs11.CKK_GENERIC_SECRET, value)
+	mech := newMemoryTestMechanism(t, pkcs11.CKM_SHA256_HMAC, nil)
+	data := []byte("authenticated data")
+	mac, err := client.Sign(ctx, mech, key, data)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	expected := hmac.New(sha256.New, value)
+	expected.Write(data)
+	if !bytes.Equal(mac, expected.Sum(nil)) {
+		t.Errorf("expected HMAC %x, got %x", expected.Sum(nil), mac)
+	}
+	if ok, err := client.Verify(ctx, mech, key, data, mac); !ok || err != nil {
+		t.Errorf("expected HMAC to verify, got %v (%v)", ok, err)
+	}
+
+	sha256Mech := newMemoryTestMechanism(t, pkcs11.CKM_SHA256, nil)
+	digest, err := client.Digest(ctx, sha256Mech, data)
+	if sum := sha256.Sum256(data); err != nil || !bytes.Equal(digest, sum[:]) {
+		t.Errorf("expected digest %x, got %x (%v)", sum, digest, err)
+	}
+	digest, err = client.DigestKey(ctx, sha256Mech, key)
+	if sum := sha256.Sum256(value); err != nil || !bytes.Equal(digest, sum[:]) {
+		t.Errorf("expected key digest %x, got %x (%v)", sum, digest, err)
+	}
+}
+
+func TestMemoryContext_WrapUnwrap(t *testing.T) {
+	client, _ := newMemoryTestClient(t, "")
+	ctx := t.Context()
+
+	kek := createMemorySecretKey(t, client, pkcs11.CKK_AES, make([]byte, 32))
+	value := []byte("0123456789abcdef")
+	key := createMemorySecretKey(t, client, pkcs11.CKK_AES, value)
+	mech := newMemoryTestMechanism(t, ckmAESKeyWrapKWP, nil)
+
+	wrapped, err := client.WrapKey(ctx, mech, kek, key)
+	if err != nil {
+		t.Fatalf("WrapKey failed: %v", err)
+	}
+	unwrapped, err := client.UnwrapKey(ctx, mech, kek, wrapped, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+	})
+	if err != nil {
+		t.Fatalf("UnwrapKey failed: %v", err)
+	}
+	attrs, err := client.GetObjectAttributes(ctx, unwrapped, []uint{pkcs11.CKA_VALUE, pkcs11.CKA_LOCAL})
+	if err != nil {
+		t.Fatalf("GetObjectAttributes failed: %v", err)
+	}
+	if !bytes.Equal(attrs[pkcs11.CKA_VALUE], value) || BytesToBool(attrs[pkcs11.CKA_LOCAL]) {
+		t.Errorf("unexpected unwrapped key value %x, local %v", attrs[pkcs11.CKA_VALUE], BytesToBool(attrs[pkcs11.CKA_LOCAL]))
+	}
+
+	// Unwrapping with the wrong key fails the integrity check
+	other := createMemorySecretKey(t, client, pkcs11.CKK_AES, bytes.Repeat([]byte{1}, 32))
+	_, err = client.UnwrapKey(ctx, mech, other, wrapped, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+	})
+	if !errors.Is(err, pkcs11.Error(pkcs11.CKR_WRAPPED_KEY_INVALID)) {
+		t.Errorf("expected CKR_WRAPPED_KEY_INVALID, got: %v", err)
+	}
+
+	// Keys generated without CKA_EXTRACTABLE cannot be wrapped
+	generated, err := client.GenerateSymmetricKey(ctx, newMemoryTestMechanism(t, pkcs11.CKM_AES_KEY_GEN, nil), []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 16),
+	})
+	if err != nil {
+		t.Fatalf("GenerateSymmetricKey failed: %v", err)
+	}
+	if _, err := client.WrapKey(ctx, mech, kek, generated); !errors.Is(err, pkcs11.Error(pkcs11.CKR_KEY_UNEXTRACTABLE)) {
+		t.Errorf("expected CKR_KEY_UNEXTRACTABLE, got: %v", err)
+	}
+}
+
+func TestMemoryContext_AttributePolicy(t *testing.T) {
+	client, mem := newMemoryTestClient(t, "")
+	ctx := t.Context()
+
+	key, err := client.GenerateSymmetricKey(ctx, newMemoryTestMechanism(t, pkcs11.CKM_AES_KEY_GEN, nil), []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 16),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_DESTROYABLE, false),
+	})
+	if err != nil {
+		t.Fatalf("GenerateSymmetricKey failed: %v", err)
+	}
+	if _, err := client.GetObjectAttributes(ctx, key, []uint{pkcs11.CKA_VALUE}); err != nil {
+		t.Errorf("expected value of extractable key to be readable, got: %v", err)
+	}
+
+	for _, tc := range []struct {
+		attr     *pkcs11.Attribute
+		expected uint
+	}{
+		{pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true), pkcs11.CKR_OK},
+		{pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, false), pkcs11.CKR_ATTRIBUTE_READ_ONLY},
+		{pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false), pkcs11.CKR_OK},
+		{pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, true), pkcs11.CKR_ATTRIBUTE_READ_ONLY},
+		{pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32), pkcs11.CKR_ATTRIBUTE_READ_ONLY},
+		{pkcs11.NewAttribute(pkcs11.CKA_LOCAL, false), pkcs11.CKR_ATTRIBUTE_READ_ONLY},
+		{pkcs11.NewAttribute(pkcs11.CKA_LABEL, "renamed"), pkcs11.CKR_OK},
+	} {
+		err := client.SetAttributeValue(ctx, key, []*pkcs11.Attribute{tc.attr})
+		if tc.expected == pkcs11.CKR_OK && err != nil {
+			t.Errorf("setting %#x failed: %v", tc.attr.Type, err)
+		} else if tc.expected != pkcs11.CKR_OK && !errors.Is(err, pkcs11.Error(tc.expected)) {
+			t.Errorf("setting %#x: expected %s, got: %v", tc.attr.Type, pkcs11.Error(tc.expected), err)
+		}
+	}
+
+	// The key is sensitive now, and its origin is still visible
+	attrs, err := client.GetAttributeValue(ctx, key, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)})
+	if !errors.Is(err, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_SENSITIVE)) {
+		t.Errorf("expected CKR_ATTRIBUTE_SENSITIVE, got %v (%v)", attrs, err)
+	}
+	origin, err := client.GetObjectAttributes(ctx, key, []uint{pkcs11.CKA_LOCAL, pkcs11.CKA_ALWAYS_SENSITIVE, pkcs11.CKA_NEVER_EXTRACTABLE})
+	if err != nil || !BytesToBool(origin[pkcs11.CKA_LOCAL]) || BytesToBool(origin[pkcs11.CKA_ALWAYS_SENSITIVE]) || BytesToBool(origin[pkcs11.CKA_NEVER_EXTRACTABLE]) {
+		t.Errorf("unexpected origin attributes %v (%v)", origin, err)
+	}
+	if found, err := client.FindObjects(ctx, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, "renamed")}, 10); err != nil || len(found) != 1 {
+		t.Errorf("expected to find the renamed key, got %v (%v)", found, err)
+	}
+	if err := client.DestroyObject(ctx, key); !errors.Is(err, pkcs11.Error(pkcs11.CKR_ACTION_PROHIBITED)) {
+		t.Errorf("expected CKR_ACTION_PROHIBITED, got: %v", err)
+	}
+
+	// Private objects are only visible to the user
+	client.Close()
+	mem.Initialize()
+	defer mem.Finalize()
+	sh, _ := mem.OpenSession(0, pkcs11.CKF_SERIAL_SESSION)
+	defer mem.CloseSession(sh)
+	if _, err := mem.GetAttributeValue(sh, key, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil)}); !errors.Is(err, pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)) {
+		t.Errorf("expected CKR_OBJECT_HANDLE_INVALID without login, got: %v", err)
+	}
+	_, err = mem.CreateObject(sh, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+	})
+	if !errors.Is(err, pkcs11.Error(pkcs11.CKR_SESSION_READ_ONLY)) {
+		t.Errorf("expected CKR_SESSION_READ_ONLY, got: %v", err)
+	}
+}
+
+func TestMemoryContext_Persistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token.json")
+	client, _ := newMemoryTestClient(t, file)
+	ctx := t.Context()
+
+	for label, token := range map[string]bool{"token-object": true, "session-object": false} {
+		_, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, token),
+		})
+		if err != nil {
+			t.Fatalf("CreateObject failed: %v", err)
+		}
+	}
+	client.Close()
+
+	// A new emulator, e.g. in the next provider process, sees the token objects only
+	client, _ = newMemoryTestClient(t, file)
+	found, err := client.FindObjects(ctx, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA)}, 10)
+	if err != nil {
+		t.Fatalf("FindObjects failed: %v", err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1 object, got %d", len(found))
+	}
+	attrs, err := client.GetObjectAttributes(ctx, found[0], []uint{pkcs11.CKA_LABEL})
+	if err != nil || string(attrs[pkcs11.CKA_LABEL]) != "token-object" {
+		t.Errorf("expected token-object, got %q (%v)", attrs[pkcs11.CKA_LABEL], err)
+	}
+	info, err := client.GetTokenInfo(ctx)
+	if err != nil || info.Label != "memory" {
+		t.Errorf("expected token label memory, got %+v (%v)", info, err)
+	}
+}
+
+func TestLoadModule_Memory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token.json")
+	ctx, err := loadModule(MemoryModulePrefix + file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mem, ok := ctx.(*MemoryContext)
+	if !ok || mem.file != file {
+		t.Errorf("expected memory token in %s, got %#v", file, ctx)
+	}
+}
@@ -0,0 +1,38 @@
+package pkcs11client
+
+import (
+	"context"
+
+	"github.com/miekg/pkcs11"
+)
+
+// Middleware wraps a Pkcs11Context to observe or alter the calls to the
+// module, e.g. to log, trace or inject faults. A middleware that only handles
+// a few calls can embed the next Pkcs11Context and override those methods.
+type Middleware func(next Pkcs11Context) Pkcs11Context
+
+// Chain wraps ctx with middlewares. The first middleware is the outermost
+// one, i.e. it sees each call first and each result last.
+func Chain(ctx Pkcs11Context, middlewares ...Middleware) Pkcs11Context {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		ctx = middlewares[i](ctx)
+	}
+	return ctx
+}
+
+// sessionBinder is implemented by middlewares that use the context of the
+// operation a session is used for, such as Tracing. Middlewares that wrap
+// others forward the binding with bindSession; other middlewares hide the
+// binding from the middlewares they wrap.
+type sessionBinder interface {
+	// bindSession associates ctx with the calls on sh until unbind is called.
+	bindSession(sh pkcs11.SessionHandle, ctx context.Context) (unbind func())
+}
+
+// bindSession binds ctx to the calls on sh in p, if p is a sessionBinder.
+func bindSession(p Pkcs11Context, sh pkcs11.SessionHandle, ctx context.Context) (unbind func()) {
+	if b, ok := p.(sessionBinder); ok {
+		return b.bindSession(sh, ctx)
+	}
+	return func() {}
+}
@@ -0,0 +1,45 @@
+package pkcs11client
+
+import "testing"
+
+// recordingContext records the names of the middlewares that saw a Finalize call.
+type recordingContext struct {
+	Pkcs11Context
+	name  string
+	calls *[]string
+}
+
+func (r *recordingContext) Finalize() error {
+	*r.calls = append(*r.calls, r.name)
+	return r.Pkcs11Context.Finalize()
+}
+
+func TestChain_Order(t *testing.T) {
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next Pkcs11Context) Pkcs11Context {
+			return &recordingContext{Pkcs11Context: next, name: name, calls: &calls}
+		}
+	}
+
+	mock := NewMockContext("test-token")
+	ctx := Chain(mock, record("outer"), record("inner"))
+	if err := ctx.Finalize(); err != nil {
+		t.Fatalf("Finalize failed: %v", err)
+	}
+	if len(calls) != 2 || calls[0] != "outer" || calls[1] != "inner" {
+		t.Errorf("expected [outer inner], got %v", calls)
+	}
+
+	// Calls that are not overridden reach the module unchanged
+	if _, err := ctx.GetSlotList(true); err != nil {
+		t.Errorf("GetSlotList failed: %v", err)
+	}
+}
+
+func TestChain_NoMiddlewares(t *testing.T) {
+	mock := NewMockContext("test-token")
+	if ctx := Chain(mock); ctx != Pkcs11Context(mock) {
+		t.Errorf("expected the context to be returned unchanged")
+	}
+}
@@ -1,7 +1,10 @@
 package pkcs11client
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
+	"hash"
 	"sync"
 	"sync/atomic"
 
@@ -29,6 +32,7 @@ type MockContext struct {
 	InitializeErr       error
 	OpenSessionErr      error
 	LoginErr            error
+	InitTokenErr        error
 	CreateObjectErr     error
 	GenerateKeyPairErr  error
 	GenerateKeyErr      error
@@ -36,25 +40,55 @@ type MockContext struct {
 	GetAttributeErr     error
 	SetAttributeErr     error
 	DestroyObjectErr    error
+	CopyObjectErr       error
 	WrapKeyErr          error
 	UnwrapKeyErr        error
 	DeriveKeyErr        error
 	EncryptErr          error
 	DecryptErr          error
 	SignErr             error
+	VerifyErr           error
+	DigestErr           error
+	RandomErr           error
+
+	Seeds [][]byte // Data passed to SeedRandom
+
+	// MaxSessions makes OpenSession fail with CKR_SESSION_COUNT once that many
+	// sessions are open, if set.
+	MaxSessions int
+
+	// Optional replacements for the fixed single-part Sign and Decrypt results
+	SignFunc    func(mech *pkcs11.Mechanism, data []byte) ([]byte, error)
+	DecryptFunc func(mech *pkcs11.Mechanism, data []byte) ([]byte, error)
+
+	// KeyPin is the PIN expected by context-specific logins, if set.
+	// Otherwise the user PIN of the slot is expected.
+	KeyPin string
+
+	// Optional replacement for GenerateKey, which by default creates an object from the template
+	GenerateKeyFunc func(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error)
 }
 
 type mockSlot struct {
-	info  pkcs11.SlotInfo
-	token *pkcs11.TokenInfo
-	mechs []*pkcs11.Mechanism
+	info    pkcs11.SlotInfo
+	token   *pkcs11.TokenInfo
+	mechs   []*pkcs11.Mechanism
+	soPin   string // checked on CKU_SO login if set
+	userPin string // checked on CKU_USER login if set
 }
 
 type mockSession struct {
 	slotID   uint
 	loggedIn bool
+	userType uint
 	findCtx  []*pkcs11.Attribute // current find template
 	findDone bool
+	digest   hash.Hash           // active digest operation
+	op       string              // active encrypt, decrypt or sign operation
+	opMech   *pkcs11.Mechanism   // mechanism of the active operation
+	opData   []byte              // data passed to the active multi-part operation
+	opKey    pkcs11.ObjectHandle // key of the active decrypt or sign operation
+	opAuthed bool                // context-specific login performed for the active operation
 }
 
 // NewMockContext creates a MockContext with one slot containing a token with the given label.
@@ -98,6 +132,9 @@ func (m *MockContext) Initialize(opts ...pkcs11.InitializeOption) error {
 	if m.InitializeErr != nil {
 		return m.InitializeErr
 	}
+	if m.initialized {
+		return pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)
+	}
 	m.initialized = true
 	return nil
 }
@@ -153,13 +190,40 @@ func (m *MockContext) OpenSession(slotID uint, flags uint) (pkcs11.SessionHandle
 	if m.OpenSessionErr != nil {
 		return 0, m.OpenSessionErr
 	}
-	sh := pkcs11.SessionHandle(m.nextSession.Add(1))
 	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.MaxSessions > 0 && len(m.sessions) >= m.MaxSessions {
+		return 0, pkcs11.Error(pkcs11.CKR_SESSION_COUNT)
+	}
+	sh := pkcs11.SessionHandle(m.nextSession.Add(1))
 	m.sessions[sh] = &mockSession{slotID: slotID}
-	m.mu.Unlock()
 	return sh, nil
 }
 
+func (m *MockContext) GetSessionInfo(sh pkcs11.SessionHandle) (pkcs11.SessionInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[sh]
+	if !ok {
+		return pkcs11.SessionInfo{}, pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	}
+	state := uint(pkcs11.CKS_RW_PUBLIC_SESSION)
+	if sess.loggedIn {
+		state = pkcs11.CKS_RW_USER_FUNCTIONS
+		if sess.userType == pkcs11.CKU_SO {
+			state = pkcs11.CKS_RW_SO_FUNCTIONS
+		}
+	}
+	return pkcs11.SessionInfo{SlotID: sess.slotID, State: state, Flags: pkcs11.CKF_SERIAL_SESSION | pkcs11.CKF_RW_SESSION}, nil
+}
+
+// OpenSessionCount returns the number of open sessions.
+func (m *MockContext) OpenSessionCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
 func (m *MockContext) CloseSession(sh pkcs11.SessionHandle) error {
 	m.mu.Lock()
 	delete(m.sessions, sh)
@@ -177,10 +241,52 @@ func (m *MockContext) Login(sh pkcs11.SessionHandle, userType uint, pin string)
 	if !ok {
 		return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
 	}
+	if userType == pkcs11.CKU_CONTEXT_SPECIFIC {
+		return m.loginContextSpecific(sess, pin)
+	}
 	if sess.loggedIn {
 		return pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)
 	}
+	// The login state is shared by all sessions with the token
+	for _, other := range m.sessions {
+		if other.slotID == sess.slotID && other.loggedIn && other.userType != userType {
+			return pkcs11.Error(pkcs11.CKR_USER_ANOTHER_ALREADY_LOGGED_IN)
+		}
+	}
+	if slot := m.slots[sess.slotID]; slot != nil {
+		expected := slot.userPin
+		if userType == pkcs11.CKU_SO {
+			expected = slot.soPin
+		}
+		if pin == "" && slot.token.Flags&pkcs11.CKF_PROTECTED_AUTHENTICATION_PATH != 0 {
+			// The PIN is entered on the PIN pad of the reader
+			expected = ""
+		}
+		if expected != "" && expected != pin {
+			return pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)
+		}
+	}
 	sess.loggedIn = true
+	sess.userType = userType
+	return nil
+}
+
+// loginContextSpecific authenticates the active operation of sess. The caller must hold m.mu.
+func (m *MockContext) loginContextSpecific(sess *mockSession, pin string) error {
+	if !sess.loggedIn {
+		return pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN)
+	}
+	if sess.op == "" {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	expected := m.KeyPin
+	if slot := m.slots[sess.slotID]; expected == "" && slot != nil {
+		expected = slot.userPin
+	}
+	if expected != "" && expected != pin {
+		return pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)
+	}
+	sess.opAuthed = true
 	return nil
 }
 
@@ -195,10 +301,95 @@ func (m *MockContext) Logout(sh pkcs11.SessionHandle) error {
 	return nil
 }
 
+// AddUninitializedSlot adds a slot holding a token that has not been initialized yet.
+func (m *MockContext) AddUninitializedSlot(slotID uint) {
+	m.slots[slotID] = &mockSlot{
+		info: pkcs11.SlotInfo{
+			SlotDescription: fmt.Sprintf("Mock Slot %d", slotID),
+			ManufacturerID:  "Test",
+			Flags:           pkcs11.CKF_TOKEN_PRESENT,
+		},
+		token: &pkcs11.TokenInfo{
+			ManufacturerID: "Test Manufacturer",
+			Model:          "Mock HSM",
+			SerialNumber:   fmt.Sprintf("%04d", slotID+1),
+			MaxPinLen:      32,
+			MinPinLen:      4,
+		},
+	}
+}
+
+func (m *MockContext) InitToken(slotID uint, soPin string, label string) error {
+	if m.InitTokenErr != nil {
+		return m.InitTokenErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	slot, ok := m.slots[slotID]
+	if !ok {
+		return pkcs11.Error(pkcs11.CKR_SLOT_ID_INVALID)
+	}
+	if slot.token == nil {
+		return pkcs11.Error(pkcs11.CKR_TOKEN_NOT_PRESENT)
+	}
+	for _, sess := range m.sessions {
+		if sess.slotID == slotID {
+			return pkcs11.Error(pkcs11.CKR_SESSION_EXISTS)
+		}
+	}
+	if slot.token.Flags&pkcs11.CKF_TOKEN_INITIALIZED != 0 && slot.soPin != "" && slot.soPin != soPin {
+		return pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)
+	}
+	slot.soPin = soPin
+	slot.userPin = ""
+	slot.token.Label = label
+	slot.token.Flags = (slot.token.Flags | pkcs11.CKF_TOKEN_INITIALIZED) &^ pkcs11.CKF_USER_PIN_INITIALIZED
+	m.objects = make(map[pkcs11.ObjectHandle]*mockObject)
+	return nil
+}
+
+func (m *MockContext) InitPIN(sh pkcs11.SessionHandle, pin string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[sh]
+	if !ok {
+		return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	}
+	if !sess.loggedIn || sess.userType != pkcs11.CKU_SO {
+		return pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN)
+	}
+	slot := m.slots[sess.slotID]
+	slot.userPin = pin
+	slot.token.Flags |= pkcs11.CKF_USER_PIN_INITIALIZED
+	return nil
+}
+
+func (m *MockContext) SetPIN(sh pkcs11.SessionHandle, oldPin string, newPin string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[sh]
+	if !ok {
+		return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	}
+	slot := m.slots[sess.slotID]
+	pin := &slot.userPin
+	if sess.loggedIn && sess.userType == pkcs11.CKU_SO {
+		pin = &slot.soPin
+	}
+	if *pin != "" && *pin != oldPin {
+		return pkcs11.Error(pkcs11.CKR_PIN_INCORRECT)
+	}
+	*pin = newPin
+	return nil
+}
+
 func (m *MockContext) CreateObject(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
 	if m.CreateObjectErr != nil {
 		return 0, m.CreateObjectErr
 	}
+	if err := m.checkTrusted(sh, temp); err != nil {
+		return 0, err
+	}
 	oh := pkcs11.ObjectHandle(m.nextObject.Add(1))
 	attrs := make(map[uint][]byte)
 	for _, a := range temp {
@@ -223,6 +414,31 @@ func (m *MockContext) DestroyObject(sh pkcs11.SessionHandle, oh pkcs11.ObjectHan
 	return nil
 }
 
+func (m *MockContext) CopyObject(sh pkcs11.SessionHandle, o pkcs11.ObjectHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	if m.CopyObjectErr != nil {
+		return 0, m.CopyObjectErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src, ok := m.objects[o]
+	if !ok {
+		return 0, pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	if v, ok := src.attrs[pkcs11.CKA_COPYABLE]; ok && len(v) == 1 && v[0] == 0 {
+		return 0, pkcs11.Error(pkcs11.CKR_ACTION_PROHIBITED)
+	}
+	attrs := make(map[uint][]byte, len(src.attrs))
+	for t, v := range src.attrs {
+		attrs[t] = append([]byte(nil), v...)
+	}
+	for _, a := range temp {
+		attrs[a.Type] = append([]byte(nil), a.Value...)
+	}
+	oh := pkcs11.ObjectHandle(m.nextObject.Add(1))
+	m.objects[oh] = &mockObject{handle: oh, attrs: attrs}
+	return oh, nil
+}
+
 func (m *MockContext) FindObjectsInit(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) error {
 	if m.FindObjectsInitErr != nil {
 		return m.FindObjectsInitErr
@@ -298,6 +514,9 @@ func (m *MockContext) SetAttributeValue(sh pkcs11.SessionHandle, oh pkcs11.Objec
 	if m.SetAttributeErr != nil {
 		return m.SetAttributeErr
 	}
+	if err := m.checkTrusted(sh, temp); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	obj, ok := m.objects[oh]
@@ -310,6 +529,23 @@ func (m *MockContext) SetAttributeValue(sh pkcs11.SessionHandle, oh pkcs11.Objec
 	return nil
 }
 
+// checkTrusted rejects setting CKA_TRUSTED to true outside of SO sessions.
+func (m *MockContext) checkTrusted(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) error {
+	for _, a := range temp {
+		if a.Type != pkcs11.CKA_TRUSTED || !BytesToBool(a.Value) {
+			continue
+		}
+		m.mu.Lock()
+		sess, ok := m.sessions[sh]
+		so := ok && sess.loggedIn && sess.userType == pkcs11.CKU_SO
+		m.mu.Unlock()
+		if !so {
+			return pkcs11.Error(pkcs11.CKR_ATTRIBUTE_READ_ONLY)
+		}
+	}
+	return nil
+}
+
 func (m *MockContext) GenerateKeyPair(sh pkcs11.SessionHandle, mech []*pkcs11.Mechanism, public, private []*pkcs11.Attribute) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
 	if m.GenerateKeyPairErr != nil {
 		return 0, 0, m.GenerateKeyPairErr
@@ -338,6 +574,9 @@ func (m *MockContext) GenerateKey(sh pkcs11.SessionHandle, mech []*pkcs11.Mechan
 	if m.GenerateKeyErr != nil {
 		return 0, m.GenerateKeyErr
 	}
+	if m.GenerateKeyFunc != nil {
+		return m.GenerateKeyFunc(sh, temp)
+	}
 	return m.CreateObject(sh, temp)
 }
 
@@ -366,54 +605,289 @@ func (m *MockContext) EncryptInit(sh pkcs11.SessionHandle, mech []*pkcs11.Mechan
 	if m.EncryptErr != nil {
 		return m.EncryptErr
 	}
-	return nil
+	return m.startOp(sh, "encrypt", mech)
 }
 
 func (m *MockContext) Encrypt(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
 	if m.EncryptErr != nil {
 		return nil, m.EncryptErr
 	}
+	if _, err := m.finishOp(sh, "encrypt"); err != nil {
+		return nil, err
+	}
 	// Simple mock: reverse the bytes
-	result := make([]byte, len(message))
-	for i, b := range message {
-		result[len(message)-1-i] = b
+	return reverseBytes(message), nil
+}
+
+// Multi-part encryption buffers all input and returns the result of Encrypt in EncryptFinal.
+func (m *MockContext) EncryptUpdate(sh pkcs11.SessionHandle, plain []byte) ([]byte, error) {
+	return nil, m.updateOp(sh, "encrypt", plain)
+}
+
+func (m *MockContext) EncryptFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	data, err := m.finishOp(sh, "encrypt")
+	if err != nil {
+		return nil, err
 	}
-	return result, nil
+	return reverseBytes(data), nil
 }
 
 func (m *MockContext) DecryptInit(sh pkcs11.SessionHandle, mech []*pkcs11.Mechanism, key pkcs11.ObjectHandle) error {
 	if m.DecryptErr != nil {
 		return m.DecryptErr
 	}
-	return nil
+	return m.startKeyOp(sh, "decrypt", mech, key)
 }
 
 func (m *MockContext) Decrypt(sh pkcs11.SessionHandle, cipher []byte) ([]byte, error) {
 	if m.DecryptErr != nil {
 		return nil, m.DecryptErr
 	}
+	mech := m.opMechanism(sh)
+	if _, err := m.finishOp(sh, "decrypt"); err != nil {
+		return nil, err
+	}
+	if m.DecryptFunc != nil {
+		return m.DecryptFunc(mech, cipher)
+	}
 	// Simple mock: reverse the bytes (inverse of Encrypt mock)
-	result := make([]byte, len(cipher))
-	for i, b := range cipher {
-		result[len(cipher)-1-i] = b
+	return reverseBytes(cipher), nil
+}
+
+func (m *MockContext) DecryptUpdate(sh pkcs11.SessionHandle, cipher []byte) ([]byte, error) {
+	return nil, m.updateOp(sh, "decrypt", cipher)
+}
+
+func (m *MockContext) DecryptFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	data, err := m.finishOp(sh, "decrypt")
+	if err != nil {
+		return nil, err
 	}
-	return result, nil
+	return reverseBytes(data), nil
 }
 
 func (m *MockContext) SignInit(sh pkcs11.SessionHandle, mech []*pkcs11.Mechanism, key pkcs11.ObjectHandle) error {
 	if m.SignErr != nil {
 		return m.SignErr
 	}
-	return nil
+	return m.startKeyOp(sh, "sign", mech, key)
 }
 
 func (m *MockContext) Sign(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
 	if m.SignErr != nil {
 		return nil, m.SignErr
 	}
+	mech := m.opMechanism(sh)
+	if _, err := m.finishOp(sh, "sign"); err != nil {
+		return nil, err
+	}
+	if m.SignFunc != nil {
+		return m.SignFunc(mech, message)
+	}
 	return []byte("mock-signature"), nil
 }
 
+func (m *MockContext) SignUpdate(sh pkcs11.SessionHandle, message []byte) error {
+	return m.updateOp(sh, "sign", message)
+}
+
+func (m *MockContext) SignFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	if _, err := m.finishOp(sh, "sign"); err != nil {
+		return nil, err
+	}
+	return []byte("mock-signature"), nil
+}
+
+// startOp marks op as the active operation of a session.
+func (m *MockContext) startOp(sh pkcs11.SessionHandle, op string, mech []*pkcs11.Mechanism) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.sessions[sh]
+	if sess == nil {
+		return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	}
+	if sess.op != "" {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_ACTIVE)
+	}
+	sess.op = op
+	sess.opMech = mech[0]
+	sess.opData = nil
+	sess.opKey = 0
+	sess.opAuthed = false
+	return nil
+}
+
+// startKeyOp marks op with key as the active operation of a session.
+func (m *MockContext) startKeyOp(sh pkcs11.SessionHandle, op string, mech []*pkcs11.Mechanism, key pkcs11.ObjectHandle) error {
+	if err := m.startOp(sh, op, mech); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[sh].opKey = key
+	return nil
+}
+
+// checkOpAuth terminates the active operation and fails with
+// CKR_USER_NOT_LOGGED_IN if its key has CKA_ALWAYS_AUTHENTICATE set but no
+// context-specific login was performed. The caller must hold m.mu.
+func (m *MockContext) checkOpAuth(sess *mockSession) error {
+	obj := m.objects[sess.opKey]
+	if obj == nil || sess.opAuthed || !BytesToBool(obj.attrs[pkcs11.CKA_ALWAYS_AUTHENTICATE]) {
+		return nil
+	}
+	sess.op = ""
+	sess.opMech = nil
+	sess.opData = nil
+	return pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN)
+}
+
+// opMechanism returns the mechanism of the active operation of a session.
+func (m *MockContext) opMechanism(sh pkcs11.SessionHandle) *pkcs11.Mechanism {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if sess := m.sessions[sh]; sess != nil {
+		return sess.opMech
+	}
+	return nil
+}
+
+// updateOp buffers data for the active multi-part operation.
+func (m *MockContext) updateOp(sh pkcs11.SessionHandle, op string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.sessions[sh]
+	if sess == nil || sess.op != op {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	if err := m.checkOpAuth(sess); err != nil {
+		return err
+	}
+	sess.opData = append(sess.opData, data...)
+	return nil
+}
+
+// finishOp terminates the active operation and returns the buffered data.
+func (m *MockContext) finishOp(sh pkcs11.SessionHandle, op string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.sessions[sh]
+	if sess == nil || sess.op != op {
+		return nil, pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	if err := m.checkOpAuth(sess); err != nil {
+		return nil, err
+	}
+	data := sess.opData
+	sess.op = ""
+	sess.opMech = nil
+	sess.opData = nil
+	return data, nil
+}
+
+func reverseBytes(b []byte) []byte {
+	result := make([]byte, len(b))
+	for i, v := range b {
+		result[len(b)-1-i] = v
+	}
+	return result
+}
+
+func (m *MockContext) VerifyInit(sh pkcs11.SessionHandle, mech []*pkcs11.Mechanism, key pkcs11.ObjectHandle) error {
+	if m.VerifyErr != nil {
+		return m.VerifyErr
+	}
+	return nil
+}
+
+func (m *MockContext) Verify(sh pkcs11.SessionHandle, data []byte, signature []byte) error {
+	if m.VerifyErr != nil {
+		return m.VerifyErr
+	}
+	// Simple mock: accept the signature produced by Sign
+	if string(signature) != "mock-signature" {
+		return pkcs11.Error(pkcs11.CKR_SIGNATURE_INVALID)
+	}
+	return nil
+}
+
+// Digest operations always use SHA-256, regardless of the mechanism.
+func (m *MockContext) DigestInit(sh pkcs11.SessionHandle, mech []*pkcs11.Mechanism) error {
+	if m.DigestErr != nil {
+		return m.DigestErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.sessions[sh]
+	if sess == nil {
+		return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	}
+	if sess.digest != nil {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_ACTIVE)
+	}
+	sess.digest = sha256.New()
+	return nil
+}
+
+func (m *MockContext) Digest(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
+	if err := m.DigestUpdate(sh, message); err != nil {
+		return nil, err
+	}
+	return m.DigestFinal(sh)
+}
+
+func (m *MockContext) DigestUpdate(sh pkcs11.SessionHandle, message []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.sessions[sh]
+	if sess == nil || sess.digest == nil {
+		return pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	sess.digest.Write(message)
+	return nil
+}
+
+func (m *MockContext) DigestKey(sh pkcs11.SessionHandle, key pkcs11.ObjectHandle) error {
+	m.mu.Lock()
+	obj, ok := m.objects[key]
+	m.mu.Unlock()
+	if !ok {
+		return pkcs11.Error(pkcs11.CKR_KEY_HANDLE_INVALID)
+	}
+	return m.DigestUpdate(sh, obj.attrs[pkcs11.CKA_VALUE])
+}
+
+func (m *MockContext) DigestFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.sessions[sh]
+	if sess == nil || sess.digest == nil {
+		return nil, pkcs11.Error(pkcs11.CKR_OPERATION_NOT_INITIALIZED)
+	}
+	sum := sess.digest.Sum(nil)
+	sess.digest = nil
+	return sum, nil
+}
+
+func (m *MockContext) SeedRandom(sh pkcs11.SessionHandle, seed []byte) error {
+	if m.RandomErr != nil {
+		return m.RandomErr
+	}
+	m.mu.Lock()
+	m.Seeds = append(m.Seeds, append([]byte(nil), seed...))
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MockContext) GenerateRandom(sh pkcs11.SessionHandle, length int) ([]byte, error) {
+	if m.RandomErr != nil {
+		return nil, m.RandomErr
+	}
+	b := make([]byte, length)
+	rand.Read(b)
+	return b, nil
+}
+
 // matchesTemplate checks if an object matches all attributes in a search template.
 func matchesTemplate(obj *mockObject, template []*pkcs11.Attribute) bool {
 	for _, t := range template {
@@ -0,0 +1,155 @@
+package pkcs11client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// modules is the registry of the modules loaded by the process. Provider
+// instances, e.g. of aliased provider configurations, run in the same process
+// and share the modules through it.
+var modules = newModuleRegistry(loadModule)
+
+// loadModule loads the PKCS#11 module at path.
+func loadModule(path string) (Pkcs11Context, error) {
+	if file, ok := strings.CutPrefix(path, MemoryModulePrefix); ok {
+		return NewMemoryContext(file), nil
+	}
+	ctx := pkcs11.New(path)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11: failed to load module %q", path)
+	}
+	return ctx, nil
+}
+
+// unloadModule unloads a module returned by loadModule that could not be
+// initialized. Modules in module hosts and the memory emulator hold no
+// library and are left as they are.
+func unloadModule(ctx Pkcs11Context) {
+	if lib, ok := ctx.(interface{ Destroy() }); ok {
+		lib.Destroy()
+	}
+}
+
+// moduleRegistry shares loaded and initialized modules by path. C_Initialize
+// and C_Finalize affect the whole process, so a module is initialized when the
+// first client acquires it and finalized only when the last one releases it.
+// Modules in module hosts are shared by path and environment, and connections
+// to agents by address and credentials.
+type moduleRegistry struct {
+	load    func(path string) (Pkcs11Context, error)
+	dial    func(path string, env []string) hostDialer
+	agent   func(cfg AgentConfig) (hostDialer, error)
+	mu      sync.Mutex // guards modules and the reference counts
+	modules map[string]*sharedModule
+}
+
+// sharedModule is an initialized module and the number of its users.
+type sharedModule struct {
+	ctx  Pkcs11Context
+	refs int
+	// initializedByRegistry is false if the module was already initialized
+	// by other code in the process, which then also finalizes it.
+	initializedByRegistry bool
+}
+
+func newModuleRegistry(load func(path string) (Pkcs11Context, error)) *moduleRegistry {
+	return &moduleRegistry{
+		load:    load,
+		dial:    startModuleHost,
+		agent:   dialAgent,
+		modules: make(map[string]*sharedModule),
+	}
+}
+
+// acquire returns the module of cfg, loading and initializing it if it is
+// not in use yet, and the function that releases it again. A module that was
+// already initialized by other code in the process is used as is and not
+// finalized by the registry. The module
+// is run in a module host if cfg.Host is set, or accessed through an agent if
+// cfg.Agent is set.
+func (r *moduleRegistry) acquire(cfg Config) (Pkcs11Context, func() error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := moduleKey(cfg)
+	module, ok := r.modules[key]
+	if !ok {
+		ctx, err := r.open(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = ctx.Initialize()
+		if err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+			unloadModule(ctx)
+			return nil, nil, wrapError("Initialize", err)
+		}
+		module = &sharedModule{ctx: ctx, initializedByRegistry: err == nil}
+		r.modules[key] = module
+	}
+	module.refs++
+
+	var once sync.Once
+	release := func() error {
+		var err error
+		once.Do(func() {
+			err = r.release(key, module)
+		})
+		return err
+	}
+	return module.ctx, release, nil
+}
+
+// release unregisters a user of module and finalizes it after the last one,
+// if the registry initialized it.
+func (r *moduleRegistry) release(key string, module *sharedModule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	module.refs--
+	if module.refs > 0 {
+		return nil
+	}
+	delete(r.modules, key)
+	if !module.initializedByRegistry {
+		return nil
+	}
+	err := module.ctx.Finalize()
+	if errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED)) {
+		return nil
+	}
+	return wrapError("Finalize", err)
+}
+
+// open returns the uninitialized module of cfg.
+func (r *moduleRegistry) open(cfg Config) (Pkcs11Context, error) {
+	switch {
+	case cfg.Agent != nil:
+		dial, err := r.agent(*cfg.Agent)
+		if err != nil {
+			return nil, err
+		}
+		return newHostContext(dial, ErrAgentUnavailable), nil
+	case cfg.Host != nil:
+		return newHostContext(r.dial(cfg.ModulePath, cfg.Host.Env), ErrModuleCrashed), nil
+	}
+	return r.load(cfg.ModulePath)
+}
+
+// moduleKey returns the registry key of the module of cfg.
+func moduleKey(cfg Config) string {
+	switch {
+	case cfg.Agent != nil:
+		return strings.Join([]string{"agent", cfg.Agent.Address, cfg.Agent.CAFile, cfg.Agent.CertFile, cfg.Agent.KeyFile}, "\x00")
+	case cfg.Host != nil:
+		env := append([]string(nil), cfg.Host.Env...)
+		sort.Strings(env)
+		return strings.Join(append([]string{cfg.ModulePath, "host"}, env...), "\x00")
+	}
+	return cfg.ModulePath
+}
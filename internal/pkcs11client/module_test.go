@@ -0,0 +1,114 @@
+package pkcs11client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestModuleRegistry_SharedByProviderInstances(t *testing.T) {
+	mock := NewMockContext("a")
+	loads := 0
+	registry := newModuleRegistry(func(path string) (Pkcs11Context, error) {
+		loads++
+		return mock, nil
+	})
+	configs := map[string]Config{
+		DefaultToken: {ModulePath: "a.so", TokenLabel: "a", PoolSize: 2},
+	}
+
+	// Two aliased provider configurations using the same module
+	first, err := newClients(registry, configs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := newClients(registry, configs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loads != 1 {
+		t.Errorf("expected module to be loaded once, got %d", loads)
+	}
+
+	first.Close()
+	if !mock.initialized {
+		t.Fatal("module finalized while still in use by another provider instance")
+	}
+	client, _ := second.Get(DefaultToken)
+	if _, err := client.GenerateRandom(t.Context(), 8); err != nil {
+		t.Fatalf("GenerateRandom failed after closing the other instance: %v", err)
+	}
+
+	second.Close()
+	if mock.initialized {
+		t.Fatal("expected module to be finalized after closing all instances")
+	}
+
+	// A module that is used again after being finalized is initialized again
+	third, err := newClients(registry, configs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer third.Close()
+	if loads != 2 || !mock.initialized {
+		t.Errorf("expected module to be loaded and initialized again, got %d loads", loads)
+	}
+}
+
+func TestModuleRegistry_AlreadyInitialized(t *testing.T) {
+	mock := NewMockContext("a")
+	if err := mock.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	registry := newModuleRegistry(func(path string) (Pkcs11Context, error) {
+		return mock, nil
+	})
+
+	clients, err := newClients(registry, map[string]Config{
+		DefaultToken: {ModulePath: "a.so", TokenLabel: "a", PoolSize: 2},
+	})
+	if err != nil {
+		t.Fatalf("expected CKR_CRYPTOKI_ALREADY_INITIALIZED to be tolerated, got: %v", err)
+	}
+	clients.Close()
+	if len(registry.modules) != 0 {
+		t.Errorf("expected module to be unregistered, got %d modules", len(registry.modules))
+	}
+	// The code that initialized the module also finalizes it
+	if !mock.initialized {
+		t.Error("expected module initialized by other code not to be finalized")
+	}
+}
+
+// destroyableContext counts the calls to Destroy, like a *pkcs11.Ctx unloading
+// its library.
+type destroyableContext struct {
+	*MockContext
+	destroyed int
+}
+
+func (d *destroyableContext) Destroy() {
+	d.destroyed++
+}
+
+func TestModuleRegistry_InitializeFailureUnloadsModule(t *testing.T) {
+	mock := &destroyableContext{MockContext: NewMockContext("a")}
+	mock.InitializeErr = pkcs11.Error(pkcs11.CKR_GENERAL_ERROR)
+	registry := newModuleRegistry(func(path string) (Pkcs11Context, error) {
+		return mock, nil
+	})
+
+	_, err := newClients(registry, map[string]Config{
+		DefaultToken: {ModulePath: "a.so", TokenLabel: "a", PoolSize: 2},
+	})
+	if !errors.Is(err, pkcs11.Error(pkcs11.CKR_GENERAL_ERROR)) {
+		t.Fatalf("expected CKR_GENERAL_ERROR, got: %v", err)
+	}
+	if mock.destroyed != 1 {
+		t.Errorf("expected module to be destroyed once, got %d", mock.destroyed)
+	}
+	if len(registry.modules) != 0 {
+		t.Errorf("expected module not to be registered, got %d modules", len(registry.modules))
+	}
+}
@@ -0,0 +1,71 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ReadPinSource reads a PIN from an RFC 7512 pin-source, which is a file path
+// or a file: URI.
+func ReadPinSource(source string) (string, error) {
+	path := source
+	if strings.HasPrefix(source, "file:") {
+		u, err := url.Parse(source)
+		if err != nil {
+			return "", fmt.Errorf("invalid pin-source %q: %w", source, err)
+		}
+		path = u.Path
+		if path == "" {
+			path = u.Opaque
+		}
+	}
+
+	pin, err := ReadPinFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading pin-source: %w", err)
+	}
+	return pin, nil
+}
+
+// ReadPinFile reads a PIN from a file. A single trailing newline is removed.
+func ReadPinFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	pin := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(pin, "\r"), nil
+}
+
+// RunPinCommand runs command, e.g. the CLI of a password manager, and returns
+// the first line of its standard output as PIN. The first element of command
+// is the program, which is looked up in PATH, the others are its arguments.
+func RunPinCommand(ctx context.Context, command []string) (string, error) {
+	if len(command) == 0 || command[0] == "" {
+		return "", errors.New("PIN command must not be empty")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("running PIN command %q: %w: %s", command[0], err, msg)
+		}
+		return "", fmt.Errorf("running PIN command %q: %w", command[0], err)
+	}
+
+	line, _, _ := strings.Cut(stdout.String(), "\n")
+	pin := strings.TrimSuffix(line, "\r")
+	if pin == "" {
+		return "", fmt.Errorf("PIN command %q printed no PIN", command[0])
+	}
+	return pin, nil
+}
@@ -0,0 +1,86 @@
+package pkcs11client
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestReadPinFile(t *testing.T) {
+	pinFile := filepath.Join(t.TempDir(), "pin")
+	if err := os.WriteFile(pinFile, []byte("1234\r\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pin, err := ReadPinFile(pinFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pin != "1234" {
+		t.Errorf("expected PIN without line ending, got %q", pin)
+	}
+
+	if _, err := ReadPinFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestRunPinCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	ctx := t.Context()
+
+	pin, err := RunPinCommand(ctx, []string{"sh", "-c", `printf '1234\nignored\n'`})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pin != "1234" {
+		t.Errorf("expected first line of output, got %q", pin)
+	}
+
+	_, err = RunPinCommand(ctx, []string{"sh", "-c", "echo locked >&2; exit 1"})
+	if err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("expected error with stderr of the command, got: %v", err)
+	}
+	if _, err := RunPinCommand(ctx, []string{"sh", "-c", "true"}); err == nil {
+		t.Error("expected error for empty output")
+	}
+	if _, err := RunPinCommand(ctx, nil); err == nil {
+		t.Error("expected error for empty command")
+	}
+}
+
+func TestProtectedAuthPath(t *testing.T) {
+	mock := NewMockContext("pinpad-token")
+	mock.slots[0].userPin = "1234"
+	cfg := Config{TokenLabel: "pinpad-token", ProtectedAuthPath: true, PoolSize: 1}
+
+	if _, err := NewClientWithContext(mock, cfg); err == nil {
+		t.Fatal("expected error for token without protected authentication path")
+	}
+
+	mock.slots[0].token.Flags |= pkcs11.CKF_PROTECTED_AUTHENTICATION_PATH
+	client, err := NewClientWithContext(mock, cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer client.Close()
+
+	sh, err := client.pool.Get(t.Context())
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer client.pool.Put(sh)
+	info, err := mock.GetSessionInfo(sh)
+	if err != nil {
+		t.Fatalf("GetSessionInfo failed: %v", err)
+	}
+	if info.State != pkcs11.CKS_RW_USER_FUNCTIONS {
+		t.Errorf("expected session logged in through the PIN pad, got state %d", info.State)
+	}
+}
@@ -0,0 +1,61 @@
+package pkcs11client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/miekg/pkcs11"
+)
+
+// GenerateRandom returns length random bytes from the token RNG via C_GenerateRandom.
+func (c *Client) GenerateRandom(ctx context.Context, length int) ([]byte, error) {
+	var random []byte
+	err := c.withSession(ctx, func(sh pkcs11.SessionHandle) error {
+		var randErr error
+		random, randErr = c.ctx.GenerateRandom(sh, length)
+		return wrapError("GenerateRandom", randErr)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return random, nil
+}
+
+// SeedRandom mixes additional seed material into the token RNG via C_SeedRandom.
+func (c *Client) SeedRandom(ctx context.Context, seed []byte) error {
+	return c.withSession(ctx, func(sh pkcs11.SessionHandle) error {
+		return wrapError("SeedRandom", c.ctx.SeedRandom(sh, seed))
+	})
+}
+
+// RandomString returns length characters drawn uniformly from alphabet using
+// the token RNG. Random bytes that would bias the selection are discarded.
+func (c *Client) RandomString(ctx context.Context, length int, alphabet string) (string, error) {
+	chars := []rune(alphabet)
+	if len(chars) < 2 || len(chars) > 256 {
+		return "", fmt.Errorf("alphabet must contain between 2 and 256 characters, got %d", len(chars))
+	}
+	seen := make(map[rune]bool, len(chars))
+	for _, ch := range chars {
+		if seen[ch] {
+			return "", fmt.Errorf("alphabet contains %q more than once", ch)
+		}
+		seen[ch] = true
+	}
+
+	// Largest multiple of the alphabet size not exceeding 256
+	limit := 256 - 256%len(chars)
+	result := make([]rune, 0, length)
+	for len(result) < length {
+		random, err := c.GenerateRandom(ctx, length-len(result))
+		if err != nil {
+			return "", err
+		}
+		for _, b := range random {
+			if int(b) < limit {
+				result = append(result, chars[int(b)%len(chars)])
+			}
+		}
+	}
+	return string(result), nil
+}
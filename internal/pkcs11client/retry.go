@@ -0,0 +1,163 @@
+package pkcs11client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// Defaults of the retry policy.
+const (
+	DefaultRetryMaxAttempts    = 3
+	DefaultRetryInitialBackoff = 100 * time.Millisecond
+	DefaultRetryMaxBackoff     = 5 * time.Second
+)
+
+// DefaultRetryableErrors are the return values that network HSMs report for
+// transient failures, such as a lost connection to the appliance.
+// CKR_SESSION_COUNT is not among them, as the session pool already waits for
+// sessions to become available.
+var DefaultRetryableErrors = []uint{
+	pkcs11.CKR_DEVICE_ERROR,
+	pkcs11.CKR_DEVICE_MEMORY,
+	pkcs11.CKR_FUNCTION_FAILED,
+}
+
+// RetryPolicy controls how operations that fail with a transient error are
+// retried. Operations that cannot safely be repeated, such as changing a PIN
+// or consuming a stream, are never retried. Operations that create objects
+// are only retried if no object with the label or ID of the template exists
+// after the failed attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	// Values below 2 disable retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It doubles with
+	// every further retry up to MaxBackoff. Half of each delay is random.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// RetryableErrors lists the CKR_* return values considered transient.
+	// Crashes of module hosts (ErrModuleCrashed) and lost connections to
+	// agents (ErrAgentUnavailable) are always transient.
+	RetryableErrors []uint
+}
+
+// DefaultRetryPolicy returns the default values of a retry policy.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     DefaultRetryMaxAttempts,
+		InitialBackoff:  DefaultRetryInitialBackoff,
+		MaxBackoff:      DefaultRetryMaxBackoff,
+		RetryableErrors: DefaultRetryableErrors,
+	}
+}
+
+// retryable reports whether err is one of the retryable return values, a
+// crash of a module host or a lost connection to an agent.
+func (p RetryPolicy) retryable(err error) bool {
+	if connectionLost(err) {
+		return true
+	}
+	var p11err *Pkcs11Error
+	if !errors.As(err, &p11err) {
+		return false
+	}
+	for _, code := range p.RetryableErrors {
+		if uint(p11err.Code) == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the jittered delay before the given retry, starting at 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, p.MaxBackoff)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryPolicy returns the configured retry policy. Without one, operations
+// are attempted once; attempt still repeats them after a session error.
+func (c *Client) retryPolicy() RetryPolicy {
+	if c.config.Retry != nil {
+		return *c.config.Retry
+	}
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+// withRetry executes fn with a session from the pool and repeats it with
+// backoff while it fails with a retryable error and attempts are left.
+// repeatable is asked before repeating fn after it was executed; if it is
+// nil, fn is executed at most once, even after a session error. Attempts
+// that failed before fn was called, e.g. because no session could be opened,
+// are always repeated.
+func (c *Client) withRetry(ctx context.Context, repeatable func(ctx context.Context) bool, fn func(sh pkcs11.SessionHandle) error) error {
+	policy := c.retryPolicy()
+	for attempt := 1; ; attempt++ {
+		executed, err := c.attempt(ctx, fn, repeatable)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return err
+		}
+		if executed && (repeatable == nil || !repeatable(ctx)) {
+			return err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w (giving up retrying: %w)", err, context.Cause(ctx))
+		}
+	}
+}
+
+// repeatAlways is the repeatable function of idempotent operations.
+func repeatAlways(context.Context) bool { return true }
+
+// noObjectsCreated returns a repeatable function for an operation that
+// creates objects from templates. It allows repeating the operation only if
+// no object with the class, label and ID of any template exists, i.e. the
+// failed attempt provably created nothing. Templates without label or ID
+// cannot be checked, so operations using them are not repeated.
+func (c *Client) noObjectsCreated(templates ...[]*pkcs11.Attribute) func(ctx context.Context) bool {
+	return func(ctx context.Context) bool {
+		for _, template := range templates {
+			var query []*pkcs11.Attribute
+			identified := false
+			for _, attr := range template {
+				switch attr.Type {
+				case pkcs11.CKA_LABEL, pkcs11.CKA_ID:
+					if len(attr.Value) == 0 {
+						continue
+					}
+					identified = true
+				case pkcs11.CKA_CLASS:
+				default:
+					continue
+				}
+				query = append(query, attr)
+			}
+			if !identified {
+				return false
+			}
+
+			handles, err := c.FindObjects(ctx, query, 1)
+			if err != nil || len(handles) > 0 {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,400 @@
+package pkcs11client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// newRetryTestClient creates a test client that retries transient errors without noticeable backoff.
+func newRetryTestClient(t *testing.T) (*Client, *MockContext) {
+	t.Helper()
+	client, mock := newTestClient("test-token")
+	t.Cleanup(func() { client.Close() })
+	client.config.Retry = &RetryPolicy{
+		MaxAttempts:     3,
+		InitialBackoff:  time.Millisecond,
+		MaxBackoff:      time.Millisecond,
+		RetryableErrors: DefaultRetryableErrors,
+	}
+	return client, mock
+}
+
+func createTestKey(t *testing.T, client *Client) pkcs11.ObjectHandle {
+	t.Helper()
+	key, err := client.CreateObject(t.Context(), []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "key"),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+	return key
+}
+
+func TestRetry_TransientError(t *testing.T) {
+	client, mock := newRetryTestClient(t)
+	key := createTestKey(t, client)
+
+	var calls atomic.Int32
+	mock.SignFunc = func(_ *pkcs11.Mechanism, _ []byte) ([]byte, error) {
+		if calls.Add(1) == 1 {
+			return nil, pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)
+		}
+		return []byte("signature"), nil
+	}
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	sig, err := client.Sign(t.Context(), mech, key, []byte("data"))
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if string(sig) != "signature" {
+		t.Errorf("unexpected signature %q", sig)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+}
+
+func TestRetry_MaxAttempts(t *testing.T) {
+	client, mock := newRetryTestClient(t)
+	key := createTestKey(t, client)
+
+	var calls atomic.Int32
+	mock.SignFunc = func(_ *pkcs11.Mechanism, _ []byte) ([]byte, error) {
+		calls.Add(1)
+		return nil, pkcs11.Error(pkcs11.CKR_FUNCTION_FAILED)
+	}
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	_, err := client.Sign(t.Context(), mech, key, []byte("data"))
+	if !errors.Is(err, pkcs11.Error(pkcs11.CKR_FUNCTION_FAILED)) {
+		t.Fatalf("expected CKR_FUNCTION_FAILED, got: %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestRetry_NonRetryableError(t *testing.T) {
+	client, mock := newRetryTestClient(t)
+	key := createTestKey(t, client)
+
+	var calls atomic.Int32
+	mock.SignFunc = func(_ *pkcs11.Mechanism, _ []byte) ([]byte, error) {
+		calls.Add(1)
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED)
+	}
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	if _, err := client.Sign(t.Context(), mech, key, []byte("data")); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+}
+
+func TestRetry_Disabled(t *testing.T) {
+	client, mock := newRetryTestClient(t)
+	client.config.Retry.MaxAttempts = 1
+	key := createTestKey(t, client)
+
+	var calls atomic.Int32
+	mock.SignFunc = func(_ *pkcs11.Mechanism, _ []byte) ([]byte, error) {
+		calls.Add(1)
+		return nil, pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)
+	}
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	if _, err := client.Sign(t.Context(), mech, key, []byte("data")); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+}
+
+func TestRetry_NoPolicy(t *testing.T) {
+	client, mock := newRetryTestClient(t)
+	client.config.Retry = nil
+	key := createTestKey(t, client)
+
+	var calls atomic.Int32
+	mock.SignFunc = func(_ *pkcs11.Mechanism, _ []byte) ([]byte, error) {
+		calls.Add(1)
+		return nil, pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)
+	}
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+	if _, err := client.Sign(t.Context(), mech, key, []byte("data")); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 attempt without retry policy, got %d", n)
+	}
+}
+
+func TestRetry_ObjectCreationWithoutTrace(t *testing.T) {
+	client, mock := newRetryTestClient(t)
+
+	var calls atomic.Int32
+	mock.GenerateKeyFunc = func(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+		if calls.Add(1) == 1 {
+			return 0, pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)
+		}
+		return mock.CreateObject(sh, temp)
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "aes"),
+	}
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}
+	if _, err := client.GenerateSymmetricKey(t.Context(), mech, template); err != nil {
+		t.Fatalf("GenerateSymmetricKey failed: %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+
+	handles, err := client.FindObjects(t.Context(), template, 10)
+	if err != nil {
+		t.Fatalf("FindObjects failed: %v", err)
+	}
+	if len(handles) != 1 {
+		t.Errorf("expected exactly one key, got %d", len(handles))
+	}
+}
+
+func TestRetry_ObjectCreationLeftObject(t *testing.T) {
+	client, mock := newRetryTestClient(t)
+
+	// The key is created, but the response is lost
+	var calls atomic.Int32
+	mock.GenerateKeyFunc = func(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+		calls.Add(1)
+		if _, err := mock.CreateObject(sh, temp); err != nil {
+			return 0, err
+		}
+		return 0, pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "aes"),
+	}
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}
+	if _, err := client.GenerateSymmetricKey(t.Context(), mech, template); !errors.Is(err, pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)) {
+		t.Fatalf("expected CKR_DEVICE_ERROR, got: %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+}
+
+func TestRetry_ObjectCreationWithoutLabelOrID(t *testing.T) {
+	client, mock := newRetryTestClient(t)
+
+	var calls atomic.Int32
+	mock.GenerateKeyFunc = func(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+		calls.Add(1)
+		return 0, pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+	}
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}
+	if _, err := client.GenerateSymmetricKey(t.Context(), mech, template); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+}
+
+func TestRetry_ObjectCreationSessionError(t *testing.T) {
+	client, mock := newRetryTestClient(t)
+
+	// The key is created, but the session is gone afterwards
+	var calls atomic.Int32
+	mock.GenerateKeyFunc = func(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+		if calls.Add(1) == 1 {
+			if _, err := mock.CreateObject(sh, temp); err != nil {
+				return 0, err
+			}
+			return 0, pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+		}
+		return mock.CreateObject(sh, temp)
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "aes"),
+	}
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}
+	if _, err := client.GenerateSymmetricKey(t.Context(), mech, template); !errors.Is(err, pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)) {
+		t.Fatalf("expected CKR_SESSION_HANDLE_INVALID, got: %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+}
+
+func TestRetry_ObjectCreationSessionErrorWithoutTrace(t *testing.T) {
+	client, mock := newRetryTestClient(t)
+
+	var calls atomic.Int32
+	mock.GenerateKeyFunc = func(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+		if calls.Add(1) == 1 {
+			return 0, pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+		}
+		return mock.CreateObject(sh, temp)
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "aes"),
+	}
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}
+	if _, err := client.GenerateSymmetricKey(t.Context(), mech, template); err != nil {
+		t.Fatalf("GenerateSymmetricKey failed: %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected 2 attempts, got %d", n)
+	}
+}
+
+func TestRetry_ObjectCreationConnectionLost(t *testing.T) {
+	client, mock := newRetryTestClient(t)
+
+	// The module host executes the call, but crashes before responding
+	var calls atomic.Int32
+	mock.GenerateKeyFunc = func(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+		calls.Add(1)
+		if _, err := mock.CreateObject(sh, temp); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%w: signal: killed", ErrModuleCrashed)
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "aes"),
+	}
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}
+	if _, err := client.GenerateSymmetricKey(t.Context(), mech, template); !errors.Is(err, ErrModuleCrashed) {
+		t.Fatalf("expected ErrModuleCrashed, got: %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+}
+
+// flakyContext fails OpenSession and DigestUpdate with CKR_DEVICE_ERROR a
+// given number of times.
+type flakyContext struct {
+	*MockContext
+	openSessionFailures  atomic.Int32
+	digestUpdateFailures atomic.Int32
+	digestUpdateCalls    atomic.Int32
+}
+
+func (f *flakyContext) OpenSession(slotID uint, flags uint) (pkcs11.SessionHandle, error) {
+	if f.openSessionFailures.Add(-1) >= 0 {
+		return 0, pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)
+	}
+	return f.MockContext.OpenSession(slotID, flags)
+}
+
+func (f *flakyContext) DigestUpdate(sh pkcs11.SessionHandle, message []byte) error {
+	f.digestUpdateCalls.Add(1)
+	if f.digestUpdateFailures.Add(-1) >= 0 {
+		return pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)
+	}
+	return f.MockContext.DigestUpdate(sh, message)
+}
+
+func newFlakyClient(t *testing.T) (*Client, *flakyContext) {
+	t.Helper()
+	flaky := &flakyContext{MockContext: NewMockContext("test-token")}
+	client, err := NewClientWithContext(flaky, Config{
+		TokenLabel: "test-token",
+		Pin:        "1234",
+		PoolSize:   2,
+		Retry: &RetryPolicy{
+			MaxAttempts:     3,
+			InitialBackoff:  time.Millisecond,
+			MaxBackoff:      time.Millisecond,
+			RetryableErrors: DefaultRetryableErrors,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, flaky
+}
+
+func TestRetry_SessionUnavailable(t *testing.T) {
+	client, flaky := newFlakyClient(t)
+
+	// Opening a session fails before the operation is called, so even
+	// operations that cannot be checked for created objects are retried.
+	flaky.openSessionFailures.Store(2)
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+	}
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)}
+	if _, err := client.GenerateSymmetricKey(t.Context(), mech, template); err != nil {
+		t.Fatalf("GenerateSymmetricKey failed: %v", err)
+	}
+
+	handles, err := client.FindObjects(t.Context(), template, 10)
+	if err != nil {
+		t.Fatalf("FindObjects failed: %v", err)
+	}
+	if len(handles) != 1 {
+		t.Errorf("expected exactly one key, got %d", len(handles))
+	}
+}
+
+func TestRetry_StreamNotRepeated(t *testing.T) {
+	client, flaky := newFlakyClient(t)
+	flaky.digestUpdateFailures.Store(1)
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256, nil)}
+	_, err := client.DigestReader(t.Context(), mech, strings.NewReader("data"))
+	if !errors.Is(err, pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)) {
+		t.Fatalf("expected CKR_DEVICE_ERROR, got: %v", err)
+	}
+	if n := flaky.digestUpdateCalls.Load(); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for _, tt := range []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{10, 500 * time.Millisecond, time.Second},
+	} {
+		for range 100 {
+			if d := p.backoff(tt.retry); d < tt.min || d > tt.max {
+				t.Fatalf("backoff(%d) = %s, expected between %s and %s", tt.retry, d, tt.min, tt.max)
+			}
+		}
+	}
+}
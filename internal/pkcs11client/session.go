@@ -1,64 +1,324 @@
 package pkcs11client
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/miekg/pkcs11"
 )
 
-// SessionPool manages a pool of PKCS#11 sessions using a buffered channel.
+const (
+	// DefaultPoolSize is the pool size used when the token does not report
+	// its maximum number of R/W sessions. It matches Terraform's default
+	// parallelism.
+	DefaultPoolSize = 10
+
+	// defaultSessionWait is how long Get keeps retrying while the token
+	// reports CKR_SESSION_COUNT, e.g. because other applications hold its
+	// sessions.
+	defaultSessionWait = 2 * time.Minute
+
+	initialSessionBackoff = 50 * time.Millisecond
+	maxSessionBackoff     = 2 * time.Second
+)
+
+// SessionPool manages a bounded pool of PKCS#11 sessions. At most size
+// sessions are open at a time; Get blocks until one of them is returned
+// when all are in use.
+//
+// The login state is shared by all sessions of an application with a token,
+// so sessions logged in as user and as Security Officer cannot coexist.
+// GetSO therefore waits until no session of the pool is in use.
 type SessionPool struct {
 	ctx    Pkcs11Context
 	slotID uint
 	pin    string
-	pool   chan pkcs11.SessionHandle
+	pinMu  sync.Mutex // guards pin
+	idle   chan pkcs11.SessionHandle
+	open   chan struct{} // holds one element per open session
 	size   int
+	wait   time.Duration // how long to retry on CKR_SESSION_COUNT
+
+	// inUse holds one element per session in use and is filled up completely
+	// by SO sessions. so is held by the SO session and while GetSO waits for
+	// the sessions in use, which keeps Get from handing out further sessions.
+	inUse chan struct{}
+	so    chan struct{}
+
+	// protectedAuthPath makes sessions log in without PIN, which the token
+	// then obtains through its protected authentication path.
+	protectedAuthPath bool
 }
 
 // NewSessionPool creates a new session pool. Sessions are opened lazily on Get().
 func NewSessionPool(ctx Pkcs11Context, slotID uint, pin string, size int) *SessionPool {
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
 	return &SessionPool{
 		ctx:    ctx,
 		slotID: slotID,
 		pin:    pin,
-		pool:   make(chan pkcs11.SessionHandle, size),
+		idle:   make(chan pkcs11.SessionHandle, size),
+		open:   make(chan struct{}, size),
 		size:   size,
+		wait:   defaultSessionWait,
+		inUse:  make(chan struct{}, size),
+		so:     make(chan struct{}, 1),
+	}
+}
+
+// Size returns the maximum number of sessions of the pool.
+func (p *SessionPool) Size() int {
+	return p.size
+}
+
+// Get returns an idle session from the pool, or opens a new one if fewer
+// than the maximum number of sessions are open. Otherwise it blocks until a
+// session is returned or ctx is done. If the token has no session left for
+// us (CKR_SESSION_COUNT), Get waits for a session to be returned or retries
+// with backoff before giving up. Each session obtained from Get must be
+// returned with Put or Discard.
+func (p *SessionPool) Get(ctx context.Context) (pkcs11.SessionHandle, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := p.lockUser(ctx); err != nil {
+		return 0, err
 	}
+	sh, err := p.acquire(ctx)
+	if err != nil {
+		<-p.inUse
+	}
+	return sh, err
 }
 
-// Get returns a session from the pool, or opens a new one if the pool is empty.
-func (p *SessionPool) Get() (pkcs11.SessionHandle, error) {
+// lockUser waits until no SO session is open or waiting and marks a session
+// as in use.
+func (p *SessionPool) lockUser(ctx context.Context) error {
 	select {
-	case sh := <-p.pool:
-		return sh, nil
-	default:
-		return p.openSession()
+	case p.so <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for the Security Officer session: %w", context.Cause(ctx))
+	}
+	defer func() { <-p.so }()
+
+	select {
+	case p.inUse <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for a session: %w", context.Cause(ctx))
+	}
+}
+
+// lockSO waits until no other SO session is open and no session is in use.
+// Get blocks until unlockSO is called.
+func (p *SessionPool) lockSO(ctx context.Context) error {
+	select {
+	case p.so <- struct{}{}:
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for the Security Officer session: %w", context.Cause(ctx))
+	}
+	for i := 0; i < p.size; i++ {
+		select {
+		case p.inUse <- struct{}{}:
+		case <-ctx.Done():
+			for ; i > 0; i-- {
+				<-p.inUse
+			}
+			<-p.so
+			return fmt.Errorf("waiting for the sessions in use: %w", context.Cause(ctx))
+		}
+	}
+	return nil
+}
+
+// unlockSO releases the lock taken by lockSO.
+func (p *SessionPool) unlockSO() {
+	for i := 0; i < p.size; i++ {
+		<-p.inUse
+	}
+	<-p.so
+}
+
+// acquire implements Get.
+func (p *SessionPool) acquire(ctx context.Context) (pkcs11.SessionHandle, error) {
+	var deadline time.Time
+	backoff := initialSessionBackoff
+	for {
+		sh, err := p.get(ctx)
+		if !errors.Is(err, pkcs11.Error(pkcs11.CKR_SESSION_COUNT)) {
+			return sh, err
+		}
+
+		if deadline.IsZero() {
+			deadline = time.Now().Add(p.wait)
+		} else if time.Now().After(deadline) {
+			return 0, fmt.Errorf("no session available after waiting %s: %w", p.wait, err)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case sh := <-p.idle:
+			timer.Stop()
+			if p.valid(sh) {
+				return sh, nil
+			}
+			p.closeIdle(sh)
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, fmt.Errorf("waiting for a session: %w", context.Cause(ctx))
+		}
+		backoff = min(2*backoff, maxSessionBackoff)
+	}
+}
+
+// get returns a valid idle session, or opens a new one once the number of
+// open sessions is below the maximum.
+func (p *SessionPool) get(ctx context.Context) (pkcs11.SessionHandle, error) {
+	for {
+		// Prefer idle sessions over opening new ones
+		select {
+		case sh := <-p.idle:
+			if p.valid(sh) {
+				return sh, nil
+			}
+			p.closeIdle(sh)
+			continue
+		default:
+		}
+
+		select {
+		case sh := <-p.idle:
+			if p.valid(sh) {
+				return sh, nil
+			}
+			p.closeIdle(sh)
+		case p.open <- struct{}{}:
+			sh, err := p.openSession()
+			if err != nil {
+				<-p.open
+				return 0, err
+			}
+			return sh, nil
+		case <-ctx.Done():
+			return 0, fmt.Errorf("waiting for a session: %w", context.Cause(ctx))
+		}
 	}
 }
 
-// Put returns a session to the pool. If the pool is full, the session is closed.
+// valid checks with C_GetSessionInfo that an idle session is still usable and,
+// if sessions log in, still logged in.
+func (p *SessionPool) valid(sh pkcs11.SessionHandle) bool {
+	info, err := p.ctx.GetSessionInfo(sh)
+	if err != nil {
+		return false
+	}
+	if !p.logsIn() {
+		return true
+	}
+	return info.State == pkcs11.CKS_RW_USER_FUNCTIONS || info.State == pkcs11.CKS_RO_USER_FUNCTIONS
+}
+
+// Put returns a session to the pool.
 func (p *SessionPool) Put(sh pkcs11.SessionHandle) {
 	select {
-	case p.pool <- sh:
+	case p.idle <- sh:
 	default:
-		p.ctx.CloseSession(sh)
+		// Only sessions obtained from Get may be returned, so this is unreachable
+		p.closeIdle(sh)
 	}
+	<-p.inUse
 }
 
-// CloseAll drains the pool, logs out, and closes all sessions.
+// Discard closes a session obtained from Get instead of returning it to the
+// pool, e.g. because it became invalid.
+func (p *SessionPool) Discard(sh pkcs11.SessionHandle) {
+	p.closeIdle(sh)
+	<-p.inUse
+}
+
+// closeIdle closes a session that is not in use and frees its place in the pool.
+func (p *SessionPool) closeIdle(sh pkcs11.SessionHandle) {
+	p.ctx.CloseSession(sh)
+	<-p.open
+}
+
+// CloseAll drains the pool, logs out, and closes all idle sessions. Sessions
+// in use are returned to the pool as usual.
 func (p *SessionPool) CloseAll() {
 	for {
 		select {
-		case sh := <-p.pool:
+		case sh := <-p.idle:
 			p.ctx.Logout(sh)
-			p.ctx.CloseSession(sh)
+			p.closeIdle(sh)
 		default:
 			return
 		}
 	}
 }
 
-// openSession opens a new R/W session and logs in with the user PIN.
+// GetSO waits until no session of the pool is in use or ctx is done, closes
+// the idle sessions and opens a session logged in as Security Officer with
+// soPin. Get blocks until the session is returned with PutSO.
+func (p *SessionPool) GetSO(ctx context.Context, soPin string) (pkcs11.SessionHandle, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := p.lockSO(ctx); err != nil {
+		return 0, err
+	}
+	p.CloseAll()
+
+	sh, err := p.ctx.OpenSession(p.slotID, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		p.unlockSO()
+		return 0, wrapError("OpenSession", err)
+	}
+	if err := p.ctx.Login(sh, pkcs11.CKU_SO, soPin); err != nil {
+		p.ctx.CloseSession(sh)
+		p.unlockSO()
+		return 0, loginError(err)
+	}
+	return sh, nil
+}
+
+// PutSO logs out and closes a session obtained from GetSO, so that the pool
+// can log in as user again.
+func (p *SessionPool) PutSO(sh pkcs11.SessionHandle) {
+	p.ctx.Logout(sh)
+	p.ctx.CloseSession(sh)
+	p.unlockSO()
+}
+
+// SetPin replaces the PIN used to log in new sessions and closes the pooled
+// sessions, so that subsequent operations log in again with the new PIN.
+func (p *SessionPool) SetPin(pin string) {
+	p.pinMu.Lock()
+	p.pin = pin
+	p.pinMu.Unlock()
+	p.CloseAll()
+}
+
+func (p *SessionPool) currentPin() string {
+	p.pinMu.Lock()
+	defer p.pinMu.Unlock()
+	return p.pin
+}
+
+// logsIn reports whether sessions log in as user.
+func (p *SessionPool) logsIn() bool {
+	return p.protectedAuthPath || p.currentPin() != ""
+}
+
+// openSession opens a new R/W session and logs in with the user PIN, or
+// without PIN if the protected authentication path is used.
 func (p *SessionPool) openSession() (pkcs11.SessionHandle, error) {
 	flags := uint(pkcs11.CKF_SERIAL_SESSION | pkcs11.CKF_RW_SESSION)
 	sh, err := p.ctx.OpenSession(p.slotID, flags)
@@ -66,8 +326,8 @@ func (p *SessionPool) openSession() (pkcs11.SessionHandle, error) {
 		return 0, wrapError("OpenSession", err)
 	}
 
-	if p.pin != "" {
-		err = p.ctx.Login(sh, pkcs11.CKU_USER, p.pin)
+	if p.logsIn() {
+		err = p.ctx.Login(sh, pkcs11.CKU_USER, p.currentPin())
 		if err != nil {
 			// CKR_USER_ALREADY_LOGGED_IN is OK (another session already logged in)
 			p11err, ok := err.(pkcs11.Error)
@@ -80,3 +340,14 @@ func (p *SessionPool) openSession() (pkcs11.SessionHandle, error) {
 
 	return sh, nil
 }
+
+// poolSizeFor returns the default pool size for a token: its maximum number
+// of R/W sessions, capped at DefaultPoolSize. Tokens that report no limit or
+// no information get DefaultPoolSize.
+func poolSizeFor(info pkcs11.TokenInfo) int {
+	maxRw := info.MaxRwSessionCount
+	if maxRw == pkcs11.CK_EFFECTIVELY_INFINITE || maxRw == pkcs11.CK_UNAVAILABLE_INFORMATION || maxRw >= DefaultPoolSize {
+		return DefaultPoolSize
+	}
+	return int(maxRw)
+}
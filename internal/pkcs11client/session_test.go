@@ -0,0 +1,200 @@
+package pkcs11client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestSessionPool_BlocksAtCapacity(t *testing.T) {
+	ctx := t.Context()
+	mock := NewMockContext("test-token")
+	pool := NewSessionPool(mock, 0, "1234", 2)
+
+	first, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := pool.Get(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := make(chan pkcs11.SessionHandle)
+	go func() {
+		sh, err := pool.Get(ctx)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		got <- sh
+	}()
+
+	select {
+	case <-got:
+		t.Fatal("Get returned a session while all sessions are in use")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pool.Put(first)
+	select {
+	case sh := <-got:
+		if sh != first {
+			t.Errorf("expected returned session %v, got %v", first, sh)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get did not return after a session was put back")
+	}
+
+	if n := mock.OpenSessionCount(); n != 2 {
+		t.Errorf("expected 2 open sessions, got %d", n)
+	}
+}
+
+func TestSessionPool_ReplacesInvalidIdleSession(t *testing.T) {
+	ctx := t.Context()
+	mock := NewMockContext("test-token")
+	pool := NewSessionPool(mock, 0, "1234", 1)
+
+	sh, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.Put(sh)
+
+	// The session is closed behind the pool's back, e.g. by a token reset
+	mock.CloseSession(sh)
+
+	fresh, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fresh == sh {
+		t.Error("expected a new session to replace the invalid one")
+	}
+}
+
+func TestSessionPool_ReplacesLoggedOutIdleSession(t *testing.T) {
+	ctx := t.Context()
+	mock := NewMockContext("test-token")
+	pool := NewSessionPool(mock, 0, "1234", 1)
+
+	sh, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pool.Put(sh)
+	mock.Logout(sh)
+
+	fresh, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := mock.GetSessionInfo(fresh)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.State != pkcs11.CKS_RW_USER_FUNCTIONS {
+		t.Errorf("expected a logged in session, got state %d", info.State)
+	}
+	if n := mock.OpenSessionCount(); n != 1 {
+		t.Errorf("expected the logged out session to be closed, got %d open sessions", n)
+	}
+}
+
+func TestSessionPool_WaitsOnSessionCount(t *testing.T) {
+	ctx := t.Context()
+	mock := NewMockContext("test-token")
+	mock.MaxSessions = 1
+	pool := NewSessionPool(mock, 0, "1234", 3)
+
+	// Another application holds the only session of the token
+	other, err := mock.OpenSession(0, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		mock.CloseSession(other)
+	}()
+
+	if _, err := pool.Get(ctx); err != nil {
+		t.Fatalf("expected Get to wait for a free session, got: %v", err)
+	}
+}
+
+func TestSessionPool_SessionCountTimeout(t *testing.T) {
+	ctx := t.Context()
+	mock := NewMockContext("test-token")
+	mock.MaxSessions = 1
+	pool := NewSessionPool(mock, 0, "1234", 3)
+	pool.wait = 100 * time.Millisecond
+
+	if _, err := mock.OpenSession(0, pkcs11.CKF_SERIAL_SESSION); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := pool.Get(ctx)
+	if !errors.Is(err, pkcs11.Error(pkcs11.CKR_SESSION_COUNT)) {
+		t.Fatalf("expected CKR_SESSION_COUNT, got: %v", err)
+	}
+
+	// The failed attempts must not use up the capacity of the pool
+	mock.MaxSessions = 0
+	for i := 0; i < 3; i++ {
+		if _, err := pool.Get(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestSessionPool_GetSOLoginErrors(t *testing.T) {
+	ctx := t.Context()
+	mock := NewMockContext("test-token")
+	mock.slots[0].soPin = "5678"
+	pool := NewSessionPool(mock, 0, "1234", 1)
+
+	if _, err := pool.GetSO(ctx, "0000"); !errors.Is(err, ErrPinIncorrect) {
+		t.Errorf("expected ErrPinIncorrect for a wrong SO PIN, got: %v", err)
+	}
+
+	// Other login failures are not reported as wrong PIN
+	mock.LoginErr = pkcs11.Error(pkcs11.CKR_USER_ANOTHER_ALREADY_LOGGED_IN)
+	_, err := pool.GetSO(ctx, "5678")
+	if errors.Is(err, ErrPinIncorrect) || !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ANOTHER_ALREADY_LOGGED_IN)) {
+		t.Errorf("expected CKR_USER_ANOTHER_ALREADY_LOGGED_IN, got: %v", err)
+	}
+}
+
+func TestPoolSizeFor(t *testing.T) {
+	tests := []struct {
+		maxRw uint
+		want  int
+	}{
+		{pkcs11.CK_EFFECTIVELY_INFINITE, DefaultPoolSize},
+		{pkcs11.CK_UNAVAILABLE_INFORMATION, DefaultPoolSize},
+		{4, 4},
+		{16, DefaultPoolSize},
+	}
+	for _, tt := range tests {
+		if got := poolSizeFor(pkcs11.TokenInfo{MaxRwSessionCount: tt.maxRw}); got != tt.want {
+			t.Errorf("poolSizeFor(%d) = %d, want %d", tt.maxRw, got, tt.want)
+		}
+	}
+}
+
+func TestNewClient_PoolSizeFromToken(t *testing.T) {
+	mock := NewMockContext("test-token")
+	mock.slots[0].token.MaxRwSessionCount = 4
+
+	client, err := NewClientWithContext(mock, Config{TokenLabel: "test-token", Pin: "1234"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer client.Close()
+
+	if size := client.pool.Size(); size != 4 {
+		t.Errorf("expected pool size 4, got %d", size)
+	}
+}
@@ -0,0 +1,436 @@
+package pkcs11client
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+)
+
+var (
+	_ crypto.Signer    = (*Signer)(nil)
+	_ crypto.Decrypter = (*Decrypter)(nil)
+)
+
+// ckkECEdwards and ckmEDDSA are the PKCS#11 v3.0 constants for Edwards curve
+// keys, which are not defined by the pkcs11 package.
+var (
+	ckkECEdwards = KeyTypeNameToID["CKK_EC_EDWARDS"]
+	ckmEDDSA     = MechanismNameToID["CKM_EDDSA"]
+)
+
+// signerHash describes the digest mechanism and DigestInfo OID belonging to a crypto.Hash.
+type signerHash struct {
+	mechanism uint
+	oid       asn1.ObjectIdentifier
+}
+
+var signerHashes = map[crypto.Hash]signerHash{
+	crypto.SHA1:     {pkcs11.CKM_SHA_1, asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}},
+	crypto.SHA224:   {pkcs11.CKM_SHA224, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 4}},
+	crypto.SHA256:   {pkcs11.CKM_SHA256, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}},
+	crypto.SHA384:   {pkcs11.CKM_SHA384, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}},
+	crypto.SHA512:   {pkcs11.CKM_SHA512, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}},
+	crypto.SHA3_224: {pkcs11.CKM_SHA3_224, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 7}},
+	crypto.SHA3_256: {pkcs11.CKM_SHA3_256, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 8}},
+	crypto.SHA3_384: {pkcs11.CKM_SHA3_384, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 9}},
+	crypto.SHA3_512: {pkcs11.CKM_SHA3_512, asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 10}},
+}
+
+// ecdsaCurves maps the named curves supported by crypto/ecdsa to their OIDs.
+var ecdsaCurves = map[string]elliptic.Curve{
+	"1.3.132.0.33":        elliptic.P224(),
+	"1.2.840.10045.3.1.7": elliptic.P256(),
+	"1.3.132.0.34":        elliptic.P384(),
+	"1.3.132.0.35":        elliptic.P521(),
+}
+
+// KeySelector identifies a private key on the token, and the public key
+// belonging to it, by CKA_LABEL and/or CKA_ID. At least one must be set.
+type KeySelector struct {
+	Label string
+	ID    []byte
+}
+
+// template returns the search template for the object of the given class.
+func (s KeySelector) template(class uint) ([]*pkcs11.Attribute, error) {
+	if s.Label == "" && len(s.ID) == 0 {
+		return nil, errors.New("key selector needs a label or an ID")
+	}
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if s.Label != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, s.Label))
+	}
+	if len(s.ID) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, s.ID))
+	}
+	return template, nil
+}
+
+// tokenKey is a private key on the token along with its decoded public key.
+type tokenKey struct {
+	client  *Client
+	handle  pkcs11.ObjectHandle
+	keyType uint
+	public  crypto.PublicKey
+}
+
+// Public returns the public key belonging to the private key.
+func (k *tokenKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Signer implements crypto.Signer with a private key on the token. RSA keys
+// sign with PKCS#1 v1.5 or, if opts is a *rsa.PSSOptions, with PSS. ECDSA
+// signatures are returned ASN.1 DER-encoded. Ed25519 keys sign the message
+// itself rather than a digest, as with ed25519.PrivateKey.
+type Signer struct {
+	tokenKey
+}
+
+// Decrypter implements crypto.Decrypter with an RSA private key on the token.
+// Ciphertexts are decrypted with PKCS#1 v1.5 padding, or with OAEP if opts is
+// a *rsa.OAEPOptions.
+type Decrypter struct {
+	tokenKey
+}
+
+// Signer returns a crypto.Signer for the RSA, EC or Edwards curve private key
+// identified by sel. The public key is read once, from the private key if it
+// exposes the public components and from the matching public key otherwise.
+func (c *Client) Signer(ctx context.Context, sel KeySelector) (*Signer, error) {
+	key, err := c.findTokenKey(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{tokenKey: *key}, nil
+}
+
+// Decrypter returns a crypto.Decrypter for the RSA private key identified by sel.
+func (c *Client) Decrypter(ctx context.Context, sel KeySelector) (*Decrypter, error) {
+	key, err := c.findTokenKey(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+	if key.keyType != pkcs11.CKK_RSA {
+		return nil, fmt.Errorf("decryption requires an RSA key, got %s", keyTypeName(key.keyType))
+	}
+	return &Decrypter{tokenKey: *key}, nil
+}
+
+// Sign signs digest, or the message itself for Ed25519 keys, with the private key.
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	switch s.keyType {
+	case pkcs11.CKK_RSA:
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			return s.signPSS(digest, pssOpts)
+		}
+		return s.signPKCS1v15(digest, opts.HashFunc())
+	case pkcs11.CKK_EC:
+		return s.signECDSA(digest, opts.HashFunc())
+	case ckkECEdwards:
+		if opts.HashFunc() != crypto.Hash(0) {
+			return nil, errors.New("Ed25519ph is not supported, sign the message with crypto.Hash(0)")
+		}
+		if edOpts, ok := opts.(*ed25519.Options); ok && edOpts.Context != "" {
+			return nil, errors.New("Ed25519ctx is not supported")
+		}
+		return s.sign(ckmEDDSA, nil, digest)
+	}
+	return nil, fmt.Errorf("signing with %s keys is not supported", keyTypeName(s.keyType))
+}
+
+// signPKCS1v15 signs the DigestInfo for digest with CKM_RSA_PKCS. A zero hash
+// signs digest as is, like rsa.SignPKCS1v15.
+func (s *Signer) signPKCS1v15(digest []byte, hash crypto.Hash) ([]byte, error) {
+	if hash == crypto.Hash(0) {
+		return s.sign(pkcs11.CKM_RSA_PKCS, nil, digest)
+	}
+	info, err := signerHashFor(hash, digest)
+	if err != nil {
+		return nil, err
+	}
+	digestInfo, err := asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Digest    []byte
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: info.oid, Parameters: asn1.NullRawValue},
+		Digest:    digest,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.sign(pkcs11.CKM_RSA_PKCS, nil, digestInfo)
+}
+
+// signPSS signs digest with CKM_RSA_PKCS_PSS using MGF1 with the same hash.
+func (s *Signer) signPSS(digest []byte, opts *rsa.PSSOptions) ([]byte, error) {
+	info, err := signerHashFor(opts.Hash, digest)
+	if err != nil {
+		return nil, err
+	}
+	mgf, _ := DefaultMGF(info.mechanism)
+
+	saltLen := opts.SaltLength
+	switch saltLen {
+	case rsa.PSSSaltLengthEqualsHash:
+		saltLen = len(digest)
+	case rsa.PSSSaltLengthAuto:
+		// The largest salt that fits, as chosen by rsa.SignPSS
+		emLen := (s.public.(*rsa.PublicKey).N.BitLen() - 1 + 7) / 8
+		saltLen = emLen - len(digest) - 2
+	}
+	if saltLen < 0 {
+		return nil, fmt.Errorf("invalid PSS salt length %d", opts.SaltLength)
+	}
+
+	pssSaltLen := uint(saltLen)
+	return s.sign(pkcs11.CKM_RSA_PKCS_PSS, &MechanismParams{
+		PSS: &PSSParams{Hash: info.mechanism, MGF: mgf, SaltLen: &pssSaltLen},
+	}, digest)
+}
+
+// signECDSA signs digest with CKM_ECDSA and converts the raw r || s output
+// of the token to an ASN.1 DER-encoded ECDSA-Sig-Value.
+func (s *Signer) signECDSA(digest []byte, hash crypto.Hash) ([]byte, error) {
+	if hash != crypto.Hash(0) {
+		if _, err := signerHashFor(hash, digest); err != nil {
+			return nil, err
+		}
+	}
+	raw, err := s.sign(pkcs11.CKM_ECDSA, nil, digest)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, fmt.Errorf("invalid ECDSA signature length %d", len(raw))
+	}
+	half := len(raw) / 2
+	return asn1.Marshal(struct {
+		R, S *big.Int
+	}{
+		R: new(big.Int).SetBytes(raw[:half]),
+		S: new(big.Int).SetBytes(raw[half:]),
+	})
+}
+
+// sign uses a background context, as crypto.Signer has no way to pass one.
+func (k *tokenKey) sign(mechanismID uint, params *MechanismParams, data []byte) ([]byte, error) {
+	mech, release, err := NewMechanism(mechanismID, params)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return k.client.Sign(context.Background(), mech, k.handle, data)
+}
+
+// Decrypt decrypts ciphertext with the private key. If opts is a
+// *rsa.PKCS1v15DecryptOptions with a SessionKeyLen, a random key of that
+// length is returned when decryption fails, as by rsa.DecryptPKCS1v15SessionKey.
+func (d *Decrypter) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	switch opts := opts.(type) {
+	case nil:
+		return d.decrypt(pkcs11.CKM_RSA_PKCS, nil, ciphertext)
+
+	case *rsa.PKCS1v15DecryptOptions:
+		plaintext, err := d.decrypt(pkcs11.CKM_RSA_PKCS, nil, ciphertext)
+		if opts.SessionKeyLen == 0 {
+			return plaintext, err
+		}
+		if err != nil || len(plaintext) != opts.SessionKeyLen {
+			key := make([]byte, opts.SessionKeyLen)
+			if _, err := io.ReadFull(rand, key); err != nil {
+				return nil, err
+			}
+			return key, nil
+		}
+		return plaintext, nil
+
+	case *rsa.OAEPOptions:
+		info, ok := signerHashes[opts.Hash]
+		if !ok {
+			return nil, fmt.Errorf("unsupported OAEP hash %s", opts.Hash)
+		}
+		mgfHash := opts.MGFHash
+		if mgfHash == crypto.Hash(0) {
+			mgfHash = opts.Hash
+		}
+		mgfInfo, ok := signerHashes[mgfHash]
+		if !ok {
+			return nil, fmt.Errorf("unsupported OAEP MGF1 hash %s", mgfHash)
+		}
+		mgf, _ := DefaultMGF(mgfInfo.mechanism)
+		return d.decrypt(pkcs11.CKM_RSA_PKCS_OAEP, &MechanismParams{
+			OAEP: &OAEPParams{Hash: info.mechanism, MGF: mgf, SourceData: opts.Label},
+		}, ciphertext)
+	}
+	return nil, fmt.Errorf("unsupported decrypter options %T", opts)
+}
+
+// decrypt uses a background context, as crypto.Decrypter has no way to pass one.
+func (d *Decrypter) decrypt(mechanismID uint, params *MechanismParams, ciphertext []byte) ([]byte, error) {
+	mech, release, err := NewMechanism(mechanismID, params)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return d.client.Decrypt(context.Background(), mech, d.handle, ciphertext)
+}
+
+// signerHashFor returns the digest mechanism for hash and checks the digest length.
+func signerHashFor(hash crypto.Hash, digest []byte) (signerHash, error) {
+	info, ok := signerHashes[hash]
+	if !ok {
+		return signerHash{}, fmt.Errorf("unsupported hash %s", hash)
+	}
+	if len(digest) != hash.Size() {
+		return signerHash{}, fmt.Errorf("digest length %d does not match %s", len(digest), hash)
+	}
+	return info, nil
+}
+
+// findTokenKey looks up the private key identified by sel and decodes its public key.
+func (c *Client) findTokenKey(ctx context.Context, sel KeySelector) (*tokenKey, error) {
+	template, err := sel.template(pkcs11.CKO_PRIVATE_KEY)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := c.FindOneObject(ctx, template)
+	if err != nil {
+		return nil, fmt.Errorf("private key: %w", err)
+	}
+	attrs, err := c.GetObjectAttributes(ctx, handle, []uint{pkcs11.CKA_KEY_TYPE})
+	if err != nil {
+		return nil, err
+	}
+	keyType := BytesToUlong(attrs[pkcs11.CKA_KEY_TYPE])
+
+	key := &tokenKey{client: c, handle: handle, keyType: keyType}
+	switch keyType {
+	case pkcs11.CKK_RSA:
+		key.public, err = c.rsaPublicKey(ctx, handle, sel)
+	case pkcs11.CKK_EC:
+		key.public, err = c.ecdsaPublicKey(ctx, handle, sel)
+	case ckkECEdwards:
+		key.public, err = c.ed25519PublicKey(ctx, handle, sel)
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", keyTypeName(keyType))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (c *Client) rsaPublicKey(ctx context.Context, handle pkcs11.ObjectHandle, sel KeySelector) (*rsa.PublicKey, error) {
+	attrs, err := c.publicKeyAttributes(ctx, handle, sel, []uint{pkcs11.CKA_MODULUS, pkcs11.CKA_PUBLIC_EXPONENT})
+	if err != nil {
+		return nil, err
+	}
+	e := new(big.Int).SetBytes(attrs[pkcs11.CKA_PUBLIC_EXPONENT])
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("RSA public exponent out of range")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(attrs[pkcs11.CKA_MODULUS]),
+		E: int(e.Int64()),
+	}, nil
+}
+
+func (c *Client) ecdsaPublicKey(ctx context.Context, handle pkcs11.ObjectHandle, sel KeySelector) (*ecdsa.PublicKey, error) {
+	attrs, err := c.publicKeyAttributes(ctx, handle, sel, []uint{pkcs11.CKA_EC_PARAMS, pkcs11.CKA_EC_POINT})
+	if err != nil {
+		return nil, err
+	}
+	oid, err := parseECParams(attrs[pkcs11.CKA_EC_PARAMS])
+	if err != nil {
+		return nil, err
+	}
+	curve, ok := ecdsaCurves[oid.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported ECDSA curve %s", oid)
+	}
+	point, err := (&ECPublicKey{Point: attrs[pkcs11.CKA_EC_POINT]}).pointFor(curveInfos[oid.String()])
+	if err != nil {
+		return nil, err
+	}
+	if point[0] == 0x04 {
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	}
+	x, y := elliptic.UnmarshalCompressed(curve, point)
+	if x == nil {
+		return nil, errors.New("invalid compressed EC point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func (c *Client) ed25519PublicKey(ctx context.Context, handle pkcs11.ObjectHandle, sel KeySelector) (ed25519.PublicKey, error) {
+	attrs, err := c.publicKeyAttributes(ctx, handle, sel, []uint{pkcs11.CKA_EC_PARAMS, pkcs11.CKA_EC_POINT})
+	if err != nil {
+		return nil, err
+	}
+	oid, err := parseECParams(attrs[pkcs11.CKA_EC_PARAMS])
+	if err != nil {
+		return nil, err
+	}
+	if !oid.Equal(oidEd25519) {
+		return nil, fmt.Errorf("unsupported Edwards curve %s", oid)
+	}
+	point, err := (&ECPublicKey{Point: attrs[pkcs11.CKA_EC_POINT]}).pointFor(curveInfo{"edwards25519", ed25519.PublicKeySize, true})
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.PublicKey(point), nil
+}
+
+// publicKeyAttributes reads attrTypes from the private key, falling back to
+// the matching public key for tokens that do not store the public components
+// with the private key, e.g. CKA_EC_POINT.
+func (c *Client) publicKeyAttributes(ctx context.Context, handle pkcs11.ObjectHandle, sel KeySelector, attrTypes []uint) (map[uint][]byte, error) {
+	if attrs, err := c.GetObjectAttributes(ctx, handle, attrTypes); err == nil && hasAllAttributes(attrs, attrTypes) {
+		return attrs, nil
+	}
+
+	template, err := sel.template(pkcs11.CKO_PUBLIC_KEY)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := c.FindOneObject(ctx, template)
+	if err != nil {
+		return nil, fmt.Errorf("public key: %w", err)
+	}
+	attrs, err := c.GetObjectAttributes(ctx, pub, attrTypes)
+	if err != nil {
+		return nil, err
+	}
+	if !hasAllAttributes(attrs, attrTypes) {
+		return nil, errors.New("public key lacks the attributes needed to decode it")
+	}
+	return attrs, nil
+}
+
+func hasAllAttributes(attrs map[uint][]byte, attrTypes []uint) bool {
+	for _, t := range attrTypes {
+		if len(attrs[t]) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func keyTypeName(keyType uint) string {
+	if name, ok := KeyTypeIDToName[keyType]; ok {
+		return name
+	}
+	return fmt.Sprintf("key type 0x%08X", keyType)
+}
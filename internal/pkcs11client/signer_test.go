@@ -0,0 +1,270 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+func newRSAKeyPair(t *testing.T, client *Client, label string) *rsa.PrivateKey {
+	ctx := t.Context()
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, key.N.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{0x01, 0x00, 0x01}),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+	return key
+}
+
+func TestSigner_RSAPKCS1v15(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	key := newRSAKeyPair(t, client, "rsa")
+	mock.SignFunc = func(mech *pkcs11.Mechanism, data []byte) ([]byte, error) {
+		if mech.Mechanism != pkcs11.CKM_RSA_PKCS {
+			t.Errorf("expected CKM_RSA_PKCS, got 0x%X", mech.Mechanism)
+		}
+		return rsa.SignPKCS1v15(nil, key, crypto.Hash(0), data)
+	}
+
+	signer, err := client.Signer(ctx, KeySelector{Label: "rsa"})
+	if err != nil {
+		t.Fatalf("Signer failed: %v", err)
+	}
+	if !key.PublicKey.Equal(signer.Public()) {
+		t.Fatal("public key does not match")
+	}
+
+	digest := sha256.Sum256([]byte("message"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+
+	if _, err := signer.Sign(rand.Reader, digest[:20], crypto.SHA256); err == nil {
+		t.Error("expected error for digest of wrong length")
+	}
+}
+
+func TestSigner_RSAPSS(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	key := newRSAKeyPair(t, client, "rsa")
+	opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	mock.SignFunc = func(mech *pkcs11.Mechanism, data []byte) ([]byte, error) {
+		if mech.Mechanism != pkcs11.CKM_RSA_PKCS_PSS {
+			t.Errorf("expected CKM_RSA_PKCS_PSS, got 0x%X", mech.Mechanism)
+		}
+		return rsa.SignPSS(rand.Reader, key, crypto.SHA256, data, opts)
+	}
+
+	signer, err := client.Signer(ctx, KeySelector{Label: "rsa"})
+	if err != nil {
+		t.Fatalf("Signer failed: %v", err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	sig, err := signer.Sign(rand.Reader, digest[:], opts)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := rsa.VerifyPSS(&key.PublicKey, crypto.SHA256, digest[:], sig, opts); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+}
+
+func TestSigner_ECDSA(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	point, err := key.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	derPoint, _ := asn1.Marshal(point)
+	params, _ := asn1.Marshal(oidP256)
+	id := []byte{0x01}
+
+	// CKA_EC_POINT is only available on the public key
+	for _, class := range []uint{pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_PUBLIC_KEY} {
+		attrs := []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+		}
+		if class == pkcs11.CKO_PUBLIC_KEY {
+			attrs = append(attrs, pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, derPoint))
+		}
+		if _, err := client.CreateObject(ctx, attrs); err != nil {
+			t.Fatalf("CreateObject failed: %v", err)
+		}
+	}
+
+	mock.SignFunc = func(mech *pkcs11.Mechanism, data []byte) ([]byte, error) {
+		if mech.Mechanism != pkcs11.CKM_ECDSA {
+			t.Errorf("expected CKM_ECDSA, got 0x%X", mech.Mechanism)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, key, data)
+		if err != nil {
+			return nil, err
+		}
+		raw := make([]byte, 64)
+		r.FillBytes(raw[:32])
+		s.FillBytes(raw[32:])
+		return raw, nil
+	}
+
+	signer, err := client.Signer(ctx, KeySelector{ID: id})
+	if err != nil {
+		t.Fatalf("Signer failed: %v", err)
+	}
+	if !key.PublicKey.Equal(signer.Public()) {
+		t.Fatal("public key does not match")
+	}
+
+	digest := sha256.Sum256([]byte("message"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig) {
+		t.Error("signature does not verify")
+	}
+}
+
+func TestSigner_Ed25519(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, _ := asn1.Marshal("edwards25519")
+	_, err = client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, KeyTypeNameToID["CKK_EC_EDWARDS"]),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "ed25519"),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, []byte(pub)),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+	mock.SignFunc = func(mech *pkcs11.Mechanism, data []byte) ([]byte, error) {
+		return ed25519.Sign(priv, data), nil
+	}
+
+	signer, err := client.Signer(ctx, KeySelector{Label: "ed25519"})
+	if err != nil {
+		t.Fatalf("Signer failed: %v", err)
+	}
+	if !pub.Equal(signer.Public()) {
+		t.Fatal("public key does not match")
+	}
+
+	message := []byte("message")
+	sig, err := signer.Sign(rand.Reader, message, crypto.Hash(0))
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if !ed25519.Verify(pub, message, sig) {
+		t.Error("signature does not verify")
+	}
+
+	if _, err := signer.Sign(rand.Reader, message, crypto.SHA512); err == nil {
+		t.Error("expected error for Ed25519ph")
+	}
+}
+
+func TestDecrypter_OAEP(t *testing.T) {
+	ctx := t.Context()
+	client, mock := newTestClient("test-token")
+	defer client.Close()
+
+	key := newRSAKeyPair(t, client, "rsa")
+	label := []byte("label")
+	mock.DecryptFunc = func(mech *pkcs11.Mechanism, data []byte) ([]byte, error) {
+		if mech.Mechanism != pkcs11.CKM_RSA_PKCS_OAEP {
+			t.Errorf("expected CKM_RSA_PKCS_OAEP, got 0x%X", mech.Mechanism)
+		}
+		return rsa.DecryptOAEP(sha256.New(), nil, key, data, label)
+	}
+
+	decrypter, err := client.Decrypter(ctx, KeySelector{Label: "rsa"})
+	if err != nil {
+		t.Fatalf("Decrypter failed: %v", err)
+	}
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, decrypter.Public().(*rsa.PublicKey), []byte("secret"), label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := decrypter.Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256, Label: label})
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if !bytes.Equal(plaintext, []byte("secret")) {
+		t.Errorf("expected %q, got %q", "secret", plaintext)
+	}
+}
+
+func TestDecrypter_RequiresRSAKey(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	point, _ := key.PublicKey.Bytes()
+	params, _ := asn1.Marshal(oidP256)
+	_, err = client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "ec"),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, point),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+	if _, err := client.Decrypter(ctx, KeySelector{Label: "ec"}); err == nil {
+		t.Error("expected error for EC key")
+	}
+	if _, err := client.Signer(ctx, KeySelector{}); err == nil {
+		t.Error("expected error for empty selector")
+	}
+}
@@ -1,6 +1,8 @@
 package pkcs11client
 
 import (
+	"context"
+
 	"github.com/miekg/pkcs11"
 )
 
@@ -46,36 +48,57 @@ type MechanismInfo struct {
 }
 
 // GetSlotList returns a list of available slots.
-func (c *Client) GetSlotList(tokenPresent bool) ([]SlotInfo, error) {
-	slotIDs, err := c.ctx.GetSlotList(tokenPresent)
-	if err != nil {
-		return nil, wrapError("GetSlotList", err)
-	}
-
-	slots := make([]SlotInfo, 0, len(slotIDs))
-	for _, id := range slotIDs {
-		info, err := c.ctx.GetSlotInfo(id)
+func (c *Client) GetSlotList(ctx context.Context, tokenPresent bool) ([]SlotInfo, error) {
+	var slots []SlotInfo
+	err := c.call(ctx, func() error {
+		slotIDs, err := c.ctx.GetSlotList(tokenPresent)
 		if err != nil {
-			return nil, wrapError("GetSlotInfo", err)
+			return wrapError("GetSlotList", err)
 		}
-		slots = append(slots, SlotInfo{
-			SlotID:          id,
-			SlotDescription: info.SlotDescription,
-			ManufacturerID:  info.ManufacturerID,
-			HardwareVersion: formatVersion(info.HardwareVersion),
-			FirmwareVersion: formatVersion(info.FirmwareVersion),
-			Flags:           info.Flags,
-			TokenPresent:    info.Flags&pkcs11.CKF_TOKEN_PRESENT != 0,
-		})
+
+		slots = make([]SlotInfo, 0, len(slotIDs))
+		for _, id := range slotIDs {
+			info, err := c.ctx.GetSlotInfo(id)
+			if err != nil {
+				return wrapError("GetSlotInfo", err)
+			}
+			slots = append(slots, SlotInfo{
+				SlotID:          id,
+				SlotDescription: info.SlotDescription,
+				ManufacturerID:  info.ManufacturerID,
+				HardwareVersion: formatVersion(info.HardwareVersion),
+				FirmwareVersion: formatVersion(info.FirmwareVersion),
+				Flags:           info.Flags,
+				TokenPresent:    info.Flags&pkcs11.CKF_TOKEN_PRESENT != 0,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return slots, nil
 }
 
 // GetTokenInfo returns information about the token in the configured slot.
-func (c *Client) GetTokenInfo() (*TokenInfo, error) {
-	info, err := c.ctx.GetTokenInfo(c.slotID)
+func (c *Client) GetTokenInfo(ctx context.Context) (*TokenInfo, error) {
+	if err := c.ensureToken(); err != nil {
+		return nil, err
+	}
+	return c.GetSlotTokenInfo(ctx, c.slotID)
+}
+
+// GetSlotTokenInfo returns information about the token in the given slot,
+// which need not be the configured one.
+func (c *Client) GetSlotTokenInfo(ctx context.Context, slotID uint) (*TokenInfo, error) {
+	var info pkcs11.TokenInfo
+	err := c.call(ctx, func() error {
+		var err error
+		info, err = c.ctx.GetTokenInfo(slotID)
+		return wrapError("GetTokenInfo", err)
+	})
 	if err != nil {
-		return nil, wrapError("GetTokenInfo", err)
+		return nil, err
 	}
 	return &TokenInfo{
 		Label:              info.Label,
@@ -99,30 +122,40 @@ func (c *Client) GetTokenInfo() (*TokenInfo, error) {
 }
 
 // GetMechanismList returns the mechanisms supported by the token.
-func (c *Client) GetMechanismList() ([]MechanismInfo, error) {
-	mechs, err := c.ctx.GetMechanismList(c.slotID)
-	if err != nil {
-		return nil, wrapError("GetMechanismList", err)
+func (c *Client) GetMechanismList(ctx context.Context) ([]MechanismInfo, error) {
+	if err := c.ensureToken(); err != nil {
+		return nil, err
 	}
-
-	result := make([]MechanismInfo, 0, len(mechs))
-	for _, m := range mechs {
-		info, err := c.ctx.GetMechanismInfo(c.slotID, []*pkcs11.Mechanism{m})
+	var result []MechanismInfo
+	err := c.call(ctx, func() error {
+		mechs, err := c.ctx.GetMechanismList(c.slotID)
 		if err != nil {
-			continue
+			return wrapError("GetMechanismList", err)
 		}
-		mechType := m.Mechanism
-		name := MechanismIDToName[mechType]
-		if name == "" {
-			name = "UNKNOWN"
+
+		result = make([]MechanismInfo, 0, len(mechs))
+		for _, m := range mechs {
+			info, err := c.ctx.GetMechanismInfo(c.slotID, []*pkcs11.Mechanism{m})
+			if err != nil {
+				continue
+			}
+			mechType := m.Mechanism
+			name := MechanismIDToName[mechType]
+			if name == "" {
+				name = "UNKNOWN"
+			}
+			result = append(result, MechanismInfo{
+				Type:       mechType,
+				Name:       name,
+				MinKeySize: info.MinKeySize,
+				MaxKeySize: info.MaxKeySize,
+				Flags:      info.Flags,
+			})
 		}
-		result = append(result, MechanismInfo{
-			Type:       mechType,
-			Name:       name,
-			MinKeySize: info.MinKeySize,
-			MaxKeySize: info.MaxKeySize,
-			Flags:      info.Flags,
-		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return result, nil
 }
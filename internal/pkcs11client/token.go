@@ -0,0 +1,175 @@
+package pkcs11client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/miekg/pkcs11"
+)
+
+// maxTokenLabelLen is the size of the blank-padded CK_TOKEN_INFO label field.
+const maxTokenLabelLen = 32
+
+// InitToken initializes the token in slotID with the given SO PIN and label via
+// C_InitToken, which destroys all objects on the token. On the configured
+// token, it waits like GetSO until no pooled session is in use and closes the
+// pooled sessions. If soPin is empty, the SO PIN from the provider
+// configuration is used.
+func (c *Client) InitToken(ctx context.Context, slotID uint, soPin, label string) error {
+	soPin, err := c.soPin(soPin)
+	if err != nil {
+		return err
+	}
+	if len(label) > maxTokenLabelLen {
+		return fmt.Errorf("token label must be at most %d bytes, got %d", maxTokenLabelLen, len(label))
+	}
+
+	initToken := func() error {
+		return wrapError("InitToken", c.ctx.InitToken(slotID, soPin, label))
+	}
+	pool := c.slotPool(slotID)
+	if pool == nil {
+		return c.call(ctx, initToken)
+	}
+
+	// C_InitToken fails with CKR_SESSION_EXISTS while sessions are open, so
+	// Get must not open new ones until it has returned.
+	if err := pool.lockSO(ctx); err != nil {
+		return err
+	}
+	pool.CloseAll()
+	completed, err := interruptible(ctx, initToken, pool.unlockSO)
+	if completed {
+		pool.unlockSO()
+	}
+	return err
+}
+
+// InitPIN sets the user PIN of the token in slotID via C_InitPIN in a session
+// logged in as Security Officer. On the configured token, the session is
+// obtained like with AsSecurityOfficer, waiting for the pooled sessions in
+// use. Empty PINs default to the SO PIN and user PIN from the provider
+// configuration.
+func (c *Client) InitPIN(ctx context.Context, slotID uint, soPin, userPin string) error {
+	soPin, err := c.soPin(soPin)
+	if err != nil {
+		return err
+	}
+	userPin, err = c.userPin(userPin)
+	if err != nil {
+		return err
+	}
+
+	if c.slotPool(slotID) != nil {
+		soCtx, endSO := AsSecurityOfficer(ctx, soPin)
+		defer endSO()
+		return c.withSessionOnce(soCtx, func(sh pkcs11.SessionHandle) error {
+			return wrapError("InitPIN", c.ctx.InitPIN(sh, userPin))
+		})
+	}
+
+	return c.call(ctx, func() error {
+		sh, err := c.ctx.OpenSession(slotID, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return wrapError("OpenSession", err)
+		}
+		defer c.ctx.CloseSession(sh)
+
+		if err := c.ctx.Login(sh, pkcs11.CKU_SO, soPin); err != nil {
+			return loginError(err)
+		}
+		defer c.ctx.Logout(sh)
+
+		return wrapError("InitPIN", c.ctx.InitPIN(sh, userPin))
+	})
+}
+
+// SetPIN changes the PIN of userType (CKU_USER or CKU_SO) on the configured
+// token via C_SetPIN. If oldPin is empty, the current PIN from the provider
+// configuration is used. The client uses the new PIN from then on; pooled
+// sessions are closed so that they log in again with it.
+func (c *Client) SetPIN(ctx context.Context, userType uint, oldPin, newPin string) error {
+	if err := c.ensureToken(); err != nil {
+		return err
+	}
+	if newPin == "" {
+		return errors.New("new PIN must not be empty")
+	}
+
+	switch userType {
+	case pkcs11.CKU_USER:
+		oldPin, err := c.userPin(oldPin)
+		if err != nil {
+			return err
+		}
+		err = c.withSessionOnce(ctx, func(sh pkcs11.SessionHandle) error {
+			return wrapError("SetPIN", c.ctx.SetPIN(sh, oldPin, newPin))
+		})
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.config.Pin = newPin
+		c.mu.Unlock()
+		c.pool.SetPin(newPin)
+		return nil
+
+	case pkcs11.CKU_SO:
+		oldPin, err := c.soPin(oldPin)
+		if err != nil {
+			return err
+		}
+		soCtx, endSO := AsSecurityOfficer(ctx, oldPin)
+		err = c.withSessionOnce(soCtx, func(sh pkcs11.SessionHandle) error {
+			return wrapError("SetPIN", c.ctx.SetPIN(sh, oldPin, newPin))
+		})
+		endSO()
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.config.SoPin = newPin
+		c.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unsupported user type %d, expected CKU_USER or CKU_SO", userType)
+}
+
+// soPin returns pin, or the SO PIN from the provider configuration if pin is
+// empty. With the protected authentication path, the PIN may be empty.
+func (c *Client) soPin(pin string) (string, error) {
+	if pin != "" {
+		return pin, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config.SoPin == "" && !c.config.ProtectedAuthPath {
+		return "", errors.New("no SO PIN given and so_pin is not set in the provider configuration")
+	}
+	return c.config.SoPin, nil
+}
+
+// userPin returns pin, or the user PIN from the provider configuration if pin
+// is empty. With the protected authentication path, the PIN may be empty.
+func (c *Client) userPin(pin string) (string, error) {
+	if pin != "" {
+		return pin, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config.Pin == "" && !c.config.ProtectedAuthPath {
+		return "", errors.New("no user PIN given and pin is not set in the provider configuration")
+	}
+	return c.config.Pin, nil
+}
+
+// slotPool returns the session pool if it belongs to slotID, or nil.
+func (c *Client) slotPool(slotID uint) *SessionPool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pool != nil && c.slotID == slotID {
+		return c.pool
+	}
+	return nil
+}
@@ -0,0 +1,531 @@
+package pkcs11client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/miekg/pkcs11"
+)
+
+// redacted replaces PINs and sensitive attribute values in trace logs.
+const redacted = "<redacted>"
+
+// Tracing returns a middleware that logs every call to the module at debug
+// level, with the slot, session, mechanisms, attribute templates, duration
+// and return value of the call. Calls on the session of an operation of a
+// Client are logged to the tflog logger of the operation's context, all
+// other calls, e.g. to resolve the token, to the logger of ctx. PINs and the
+// values of sensitive or unknown attributes are redacted; data passed to or
+// returned by cryptographic operations is only logged by length.
+func Tracing(ctx context.Context) Middleware {
+	return func(next Pkcs11Context) Pkcs11Context {
+		return &tracingContext{next: next, ctx: ctx, sessions: make(map[pkcs11.SessionHandle]context.Context)}
+	}
+}
+
+// tracingContext is the Pkcs11Context returned by the Tracing middleware.
+type tracingContext struct {
+	next Pkcs11Context
+	ctx  context.Context
+
+	mu       sync.Mutex
+	sessions map[pkcs11.SessionHandle]context.Context // contexts of the operations by session
+}
+
+func (t *tracingContext) bindSession(sh pkcs11.SessionHandle, ctx context.Context) func() {
+	t.mu.Lock()
+	t.sessions[sh] = ctx
+	t.mu.Unlock()
+	unbind := bindSession(t.next, sh, ctx)
+	return func() {
+		unbind()
+		t.mu.Lock()
+		delete(t.sessions, sh)
+		t.mu.Unlock()
+	}
+}
+
+// logContext returns the context to log a call with fields to.
+func (t *tracingContext) logContext(fields map[string]any) context.Context {
+	sh, ok := fields["session"].(pkcs11.SessionHandle)
+	if !ok {
+		return t.ctx
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ctx, ok := t.sessions[sh]; ok {
+		return ctx
+	}
+	return t.ctx
+}
+
+// trace logs a call of operation that started at start and returned err.
+func (t *tracingContext) trace(operation string, start time.Time, err error, fields map[string]any) {
+	if fields == nil {
+		fields = make(map[string]any)
+	}
+	fields["operation"] = operation
+	fields["duration"] = time.Since(start).String()
+	fields["result"] = traceResult(err)
+	tflog.Debug(t.logContext(fields), "PKCS#11 call", fields)
+}
+
+// traceResult returns the CKR_* name of err, or its message if it is not a
+// PKCS#11 return value.
+func traceResult(err error) string {
+	if err == nil {
+		return "CKR_OK"
+	}
+	var code pkcs11.Error
+	if errors.As(err, &code) {
+		return ReturnValueEnum.Format(uint(code))
+	}
+	return err.Error()
+}
+
+// traceMechanisms returns the names of the mechanisms without their parameters.
+func traceMechanisms(mechanisms []*pkcs11.Mechanism) []string {
+	names := make([]string, 0, len(mechanisms))
+	for _, m := range mechanisms {
+		if m == nil {
+			continue
+		}
+		if name, ok := MechanismIDToName[m.Mechanism]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("0x%08X", m.Mechanism))
+		}
+	}
+	return names
+}
+
+// traceAttrDefs maps attribute types to their definitions.
+var traceAttrDefs = func() map[uint]AttrDef {
+	defs := make(map[uint]AttrDef, len(ObjectAttrs))
+	for _, def := range ObjectAttrs {
+		defs[def.Type] = def
+	}
+	return defs
+}()
+
+// traceTemplate formats the attributes of template as name=value. Values of
+// sensitive attributes and of attributes without a definition are redacted,
+// as they may hold key material.
+func traceTemplate(template []*pkcs11.Attribute) []string {
+	attrs := make([]string, 0, len(template))
+	for _, attr := range template {
+		if attr == nil {
+			continue
+		}
+		def, ok := traceAttrDefs[attr.Type]
+		if !ok {
+			name := fmt.Sprintf("0x%08X", attr.Type)
+			if attr.Value != nil {
+				name += "=" + redacted
+			}
+			attrs = append(attrs, name)
+			continue
+		}
+		if attr.Value == nil {
+			// Attribute queried by C_GetAttributeValue
+			attrs = append(attrs, def.TFKey)
+			continue
+		}
+		attrs = append(attrs, def.TFKey+"="+traceAttrValue(def, attr.Value))
+	}
+	return attrs
+}
+
+// traceAttrValue formats a single attribute value for the trace log.
+func traceAttrValue(def AttrDef, value []byte) string {
+	if def.Sensitive {
+		return redacted
+	}
+	switch def.AttrType {
+	case AttrTypeBool:
+		return strconv.FormatBool(BytesToBool(value))
+	case AttrTypeUlong:
+		if def.Pkcs11Enum != nil {
+			return def.Pkcs11Enum.Format(BytesToUlong(value))
+		}
+		return strconv.FormatUint(uint64(BytesToUlong(value)), 10)
+	case AttrTypeString:
+		return strconv.Quote(string(value))
+	default:
+		return EncodeHex(value)
+	}
+}
+
+func (t *tracingContext) Initialize(opts ...pkcs11.InitializeOption) error {
+	start := time.Now()
+	err := t.next.Initialize(opts...)
+	t.trace("C_Initialize", start, err, nil)
+	return err
+}
+
+func (t *tracingContext) Finalize() error {
+	start := time.Now()
+	err := t.next.Finalize()
+	t.trace("C_Finalize", start, err, nil)
+	return err
+}
+
+func (t *tracingContext) GetSlotList(tokenPresent bool) ([]uint, error) {
+	start := time.Now()
+	slots, err := t.next.GetSlotList(tokenPresent)
+	t.trace("C_GetSlotList", start, err, map[string]any{"token_present": tokenPresent, "slots": slots})
+	return slots, err
+}
+
+func (t *tracingContext) GetSlotInfo(slotID uint) (pkcs11.SlotInfo, error) {
+	start := time.Now()
+	info, err := t.next.GetSlotInfo(slotID)
+	t.trace("C_GetSlotInfo", start, err, map[string]any{"slot_id": slotID})
+	return info, err
+}
+
+func (t *tracingContext) GetTokenInfo(slotID uint) (pkcs11.TokenInfo, error) {
+	start := time.Now()
+	info, err := t.next.GetTokenInfo(slotID)
+	t.trace("C_GetTokenInfo", start, err, map[string]any{"slot_id": slotID, "label": info.Label})
+	return info, err
+}
+
+func (t *tracingContext) GetMechanismList(slotID uint) ([]*pkcs11.Mechanism, error) {
+	start := time.Now()
+	mechanisms, err := t.next.GetMechanismList(slotID)
+	t.trace("C_GetMechanismList", start, err, map[string]any{"slot_id": slotID, "count": len(mechanisms)})
+	return mechanisms, err
+}
+
+func (t *tracingContext) GetMechanismInfo(slotID uint, m []*pkcs11.Mechanism) (pkcs11.MechanismInfo, error) {
+	start := time.Now()
+	info, err := t.next.GetMechanismInfo(slotID, m)
+	t.trace("C_GetMechanismInfo", start, err, map[string]any{"slot_id": slotID, "mechanism": traceMechanisms(m)})
+	return info, err
+}
+
+func (t *tracingContext) OpenSession(slotID uint, flags uint) (pkcs11.SessionHandle, error) {
+	start := time.Now()
+	sh, err := t.next.OpenSession(slotID, flags)
+	t.trace("C_OpenSession", start, err, map[string]any{"slot_id": slotID, "flags": flags, "session": sh})
+	return sh, err
+}
+
+func (t *tracingContext) CloseSession(sh pkcs11.SessionHandle) error {
+	start := time.Now()
+	err := t.next.CloseSession(sh)
+	t.trace("C_CloseSession", start, err, map[string]any{"session": sh})
+	return err
+}
+
+func (t *tracingContext) GetSessionInfo(sh pkcs11.SessionHandle) (pkcs11.SessionInfo, error) {
+	start := time.Now()
+	info, err := t.next.GetSessionInfo(sh)
+	t.trace("C_GetSessionInfo", start, err, map[string]any{"session": sh, "slot_id": info.SlotID, "state": info.State})
+	return info, err
+}
+
+func (t *tracingContext) Login(sh pkcs11.SessionHandle, userType uint, pin string) error {
+	start := time.Now()
+	err := t.next.Login(sh, userType, pin)
+	t.trace("C_Login", start, err, map[string]any{"session": sh, "user_type": UserTypeEnum.Format(userType), "pin": redacted})
+	return err
+}
+
+func (t *tracingContext) Logout(sh pkcs11.SessionHandle) error {
+	start := time.Now()
+	err := t.next.Logout(sh)
+	t.trace("C_Logout", start, err, map[string]any{"session": sh})
+	return err
+}
+
+func (t *tracingContext) InitToken(slotID uint, soPin string, label string) error {
+	start := time.Now()
+	err := t.next.InitToken(slotID, soPin, label)
+	t.trace("C_InitToken", start, err, map[string]any{"slot_id": slotID, "label": label, "so_pin": redacted})
+	return err
+}
+
+func (t *tracingContext) InitPIN(sh pkcs11.SessionHandle, pin string) error {
+	start := time.Now()
+	err := t.next.InitPIN(sh, pin)
+	t.trace("C_InitPIN", start, err, map[string]any{"session": sh, "pin": redacted})
+	return err
+}
+
+func (t *tracingContext) SetPIN(sh pkcs11.SessionHandle, oldPin string, newPin string) error {
+	start := time.Now()
+	err := t.next.SetPIN(sh, oldPin, newPin)
+	t.trace("C_SetPIN", start, err, map[string]any{"session": sh, "old_pin": redacted, "new_pin": redacted})
+	return err
+}
+
+func (t *tracingContext) CreateObject(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	start := time.Now()
+	oh, err := t.next.CreateObject(sh, temp)
+	t.trace("C_CreateObject", start, err, map[string]any{"session": sh, "template": traceTemplate(temp), "object": oh})
+	return oh, err
+}
+
+func (t *tracingContext) DestroyObject(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle) error {
+	start := time.Now()
+	err := t.next.DestroyObject(sh, oh)
+	t.trace("C_DestroyObject", start, err, map[string]any{"session": sh, "object": oh})
+	return err
+}
+
+func (t *tracingContext) CopyObject(sh pkcs11.SessionHandle, o pkcs11.ObjectHandle, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	start := time.Now()
+	oh, err := t.next.CopyObject(sh, o, temp)
+	t.trace("C_CopyObject", start, err, map[string]any{"session": sh, "source": o, "template": traceTemplate(temp), "object": oh})
+	return oh, err
+}
+
+func (t *tracingContext) FindObjectsInit(sh pkcs11.SessionHandle, temp []*pkcs11.Attribute) error {
+	start := time.Now()
+	err := t.next.FindObjectsInit(sh, temp)
+	t.trace("C_FindObjectsInit", start, err, map[string]any{"session": sh, "template": traceTemplate(temp)})
+	return err
+}
+
+func (t *tracingContext) FindObjects(sh pkcs11.SessionHandle, max int) ([]pkcs11.ObjectHandle, bool, error) {
+	start := time.Now()
+	objects, more, err := t.next.FindObjects(sh, max)
+	t.trace("C_FindObjects", start, err, map[string]any{"session": sh, "max": max, "objects": objects})
+	return objects, more, err
+}
+
+func (t *tracingContext) FindObjectsFinal(sh pkcs11.SessionHandle) error {
+	start := time.Now()
+	err := t.next.FindObjectsFinal(sh)
+	t.trace("C_FindObjectsFinal", start, err, map[string]any{"session": sh})
+	return err
+}
+
+func (t *tracingContext) GetAttributeValue(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle, temp []*pkcs11.Attribute) ([]*pkcs11.Attribute, error) {
+	start := time.Now()
+	attrs, err := t.next.GetAttributeValue(sh, oh, temp)
+	t.trace("C_GetAttributeValue", start, err, map[string]any{"session": sh, "object": oh, "template": traceTemplate(temp)})
+	return attrs, err
+}
+
+func (t *tracingContext) SetAttributeValue(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle, temp []*pkcs11.Attribute) error {
+	start := time.Now()
+	err := t.next.SetAttributeValue(sh, oh, temp)
+	t.trace("C_SetAttributeValue", start, err, map[string]any{"session": sh, "object": oh, "template": traceTemplate(temp)})
+	return err
+}
+
+func (t *tracingContext) GenerateKeyPair(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, public, private []*pkcs11.Attribute) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	start := time.Now()
+	pub, priv, err := t.next.GenerateKeyPair(sh, m, public, private)
+	t.trace("C_GenerateKeyPair", start, err, map[string]any{
+		"session":            sh,
+		"mechanism":          traceMechanisms(m),
+		"public_template":    traceTemplate(public),
+		"private_template":   traceTemplate(private),
+		"public_key_object":  pub,
+		"private_key_object": priv,
+	})
+	return pub, priv, err
+}
+
+func (t *tracingContext) GenerateKey(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, temp []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	start := time.Now()
+	oh, err := t.next.GenerateKey(sh, m, temp)
+	t.trace("C_GenerateKey", start, err, map[string]any{"session": sh, "mechanism": traceMechanisms(m), "template": traceTemplate(temp), "object": oh})
+	return oh, err
+}
+
+func (t *tracingContext) WrapKey(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, wrappingKey, key pkcs11.ObjectHandle) ([]byte, error) {
+	start := time.Now()
+	wrapped, err := t.next.WrapKey(sh, m, wrappingKey, key)
+	t.trace("C_WrapKey", start, err, map[string]any{
+		"session":      sh,
+		"mechanism":    traceMechanisms(m),
+		"wrapping_key": wrappingKey,
+		"object":       key,
+		"output_len":   len(wrapped),
+	})
+	return wrapped, err
+}
+
+func (t *tracingContext) UnwrapKey(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, unwrappingKey pkcs11.ObjectHandle, wrappedKey []byte, a []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	start := time.Now()
+	oh, err := t.next.UnwrapKey(sh, m, unwrappingKey, wrappedKey, a)
+	t.trace("C_UnwrapKey", start, err, map[string]any{
+		"session":        sh,
+		"mechanism":      traceMechanisms(m),
+		"unwrapping_key": unwrappingKey,
+		"input_len":      len(wrappedKey),
+		"template":       traceTemplate(a),
+		"object":         oh,
+	})
+	return oh, err
+}
+
+func (t *tracingContext) DeriveKey(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, baseKey pkcs11.ObjectHandle, a []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	start := time.Now()
+	oh, err := t.next.DeriveKey(sh, m, baseKey, a)
+	t.trace("C_DeriveKey", start, err, map[string]any{
+		"session":   sh,
+		"mechanism": traceMechanisms(m),
+		"base_key":  baseKey,
+		"template":  traceTemplate(a),
+		"object":    oh,
+	})
+	return oh, err
+}
+
+func (t *tracingContext) EncryptInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error {
+	start := time.Now()
+	err := t.next.EncryptInit(sh, m, o)
+	t.trace("C_EncryptInit", start, err, map[string]any{"session": sh, "mechanism": traceMechanisms(m), "object": o})
+	return err
+}
+
+func (t *tracingContext) Encrypt(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
+	start := time.Now()
+	out, err := t.next.Encrypt(sh, message)
+	t.trace("C_Encrypt", start, err, map[string]any{"session": sh, "input_len": len(message), "output_len": len(out)})
+	return out, err
+}
+
+func (t *tracingContext) EncryptUpdate(sh pkcs11.SessionHandle, plain []byte) ([]byte, error) {
+	start := time.Now()
+	out, err := t.next.EncryptUpdate(sh, plain)
+	t.trace("C_EncryptUpdate", start, err, map[string]any{"session": sh, "input_len": len(plain), "output_len": len(out)})
+	return out, err
+}
+
+func (t *tracingContext) EncryptFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	start := time.Now()
+	out, err := t.next.EncryptFinal(sh)
+	t.trace("C_EncryptFinal", start, err, map[string]any{"session": sh, "output_len": len(out)})
+	return out, err
+}
+
+func (t *tracingContext) DecryptInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error {
+	start := time.Now()
+	err := t.next.DecryptInit(sh, m, o)
+	t.trace("C_DecryptInit", start, err, map[string]any{"session": sh, "mechanism": traceMechanisms(m), "object": o})
+	return err
+}
+
+func (t *tracingContext) Decrypt(sh pkcs11.SessionHandle, cipher []byte) ([]byte, error) {
+	start := time.Now()
+	out, err := t.next.Decrypt(sh, cipher)
+	t.trace("C_Decrypt", start, err, map[string]any{"session": sh, "input_len": len(cipher), "output_len": len(out)})
+	return out, err
+}
+
+func (t *tracingContext) DecryptUpdate(sh pkcs11.SessionHandle, cipher []byte) ([]byte, error) {
+	start := time.Now()
+	out, err := t.next.DecryptUpdate(sh, cipher)
+	t.trace("C_DecryptUpdate", start, err, map[string]any{"session": sh, "input_len": len(cipher), "output_len": len(out)})
+	return out, err
+}
+
+func (t *tracingContext) DecryptFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	start := time.Now()
+	out, err := t.next.DecryptFinal(sh)
+	t.trace("C_DecryptFinal", start, err, map[string]any{"session": sh, "output_len": len(out)})
+	return out, err
+}
+
+func (t *tracingContext) SignInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, o pkcs11.ObjectHandle) error {
+	start := time.Now()
+	err := t.next.SignInit(sh, m, o)
+	t.trace("C_SignInit", start, err, map[string]any{"session": sh, "mechanism": traceMechanisms(m), "object": o})
+	return err
+}
+
+func (t *tracingContext) Sign(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
+	start := time.Now()
+	out, err := t.next.Sign(sh, message)
+	t.trace("C_Sign", start, err, map[string]any{"session": sh, "input_len": len(message), "output_len": len(out)})
+	return out, err
+}
+
+func (t *tracingContext) SignUpdate(sh pkcs11.SessionHandle, message []byte) error {
+	start := time.Now()
+	err := t.next.SignUpdate(sh, message)
+	t.trace("C_SignUpdate", start, err, map[string]any{"session": sh, "input_len": len(message)})
+	return err
+}
+
+func (t *tracingContext) SignFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	start := time.Now()
+	out, err := t.next.SignFinal(sh)
+	t.trace("C_SignFinal", start, err, map[string]any{"session": sh, "output_len": len(out)})
+	return out, err
+}
+
+func (t *tracingContext) VerifyInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism, key pkcs11.ObjectHandle) error {
+	start := time.Now()
+	err := t.next.VerifyInit(sh, m, key)
+	t.trace("C_VerifyInit", start, err, map[string]any{"session": sh, "mechanism": traceMechanisms(m), "object": key})
+	return err
+}
+
+func (t *tracingContext) Verify(sh pkcs11.SessionHandle, data []byte, signature []byte) error {
+	start := time.Now()
+	err := t.next.Verify(sh, data, signature)
+	t.trace("C_Verify", start, err, map[string]any{"session": sh, "input_len": len(data), "signature_len": len(signature)})
+	return err
+}
+
+func (t *tracingContext) DigestInit(sh pkcs11.SessionHandle, m []*pkcs11.Mechanism) error {
+	start := time.Now()
+	err := t.next.DigestInit(sh, m)
+	t.trace("C_DigestInit", start, err, map[string]any{"session": sh, "mechanism": traceMechanisms(m)})
+	return err
+}
+
+func (t *tracingContext) Digest(sh pkcs11.SessionHandle, message []byte) ([]byte, error) {
+	start := time.Now()
+	out, err := t.next.Digest(sh, message)
+	t.trace("C_Digest", start, err, map[string]any{"session": sh, "input_len": len(message), "output_len": len(out)})
+	return out, err
+}
+
+func (t *tracingContext) DigestUpdate(sh pkcs11.SessionHandle, message []byte) error {
+	start := time.Now()
+	err := t.next.DigestUpdate(sh, message)
+	t.trace("C_DigestUpdate", start, err, map[string]any{"session": sh, "input_len": len(message)})
+	return err
+}
+
+func (t *tracingContext) DigestKey(sh pkcs11.SessionHandle, key pkcs11.ObjectHandle) error {
+	start := time.Now()
+	err := t.next.DigestKey(sh, key)
+	t.trace("C_DigestKey", start, err, map[string]any{"session": sh, "object": key})
+	return err
+}
+
+func (t *tracingContext) DigestFinal(sh pkcs11.SessionHandle) ([]byte, error) {
+	start := time.Now()
+	out, err := t.next.DigestFinal(sh)
+	t.trace("C_DigestFinal", start, err, map[string]any{"session": sh, "output_len": len(out)})
+	return out, err
+}
+
+func (t *tracingContext) SeedRandom(sh pkcs11.SessionHandle, seed []byte) error {
+	start := time.Now()
+	err := t.next.SeedRandom(sh, seed)
+	t.trace("C_SeedRandom", start, err, map[string]any{"session": sh, "input_len": len(seed)})
+	return err
+}
+
+func (t *tracingContext) GenerateRandom(sh pkcs11.SessionHandle, length int) ([]byte, error) {
+	start := time.Now()
+	out, err := t.next.GenerateRandom(sh, length)
+	t.trace("C_GenerateRandom", start, err, map[string]any{"session": sh, "output_len": len(out)})
+	return out, err
+}
@@ -0,0 +1,119 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+	"github.com/miekg/pkcs11"
+)
+
+// tracedCalls returns the logged calls of operation.
+func tracedCalls(t *testing.T, output *bytes.Buffer, operation string) []map[string]any {
+	t.Helper()
+	entries, err := tflogtest.MultilineJSONDecode(bytes.NewReader(output.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to decode log output: %v", err)
+	}
+	var calls []map[string]any
+	for _, entry := range entries {
+		if entry["operation"] == operation {
+			calls = append(calls, entry)
+		}
+	}
+	return calls
+}
+
+func TestTracing(t *testing.T) {
+	// Calls outside of operations are logged to the provider's logger, the
+	// calls of operations to the logger of their context
+	var providerOutput, output bytes.Buffer
+	logCtx := tflogtest.RootLogger(context.Background(), &providerOutput)
+	opCtx := tflogtest.RootLogger(t.Context(), &output)
+
+	mock := NewMockContext("test-token")
+	client, err := NewClientWithContext(Chain(mock, Tracing(logCtx)), Config{
+		TokenLabel: "test-token",
+		Pin:        "secret-user-pin",
+		PoolSize:   1,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	_, err = client.CreateObject(opCtx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "traced"),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, "secret-key-material"),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	mock.SignErr = pkcs11.Error(pkcs11.CKR_KEY_HANDLE_INVALID)
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256_HMAC, nil)}
+	if _, err := client.Sign(opCtx, mech, 42, []byte("data")); err == nil {
+		t.Fatal("expected Sign to fail")
+	}
+
+	logs := providerOutput.String() + output.String()
+	for _, secret := range []string{"secret-user-pin", "secret-key-material", EncodeHex([]byte("secret-key-material"))} {
+		if strings.Contains(logs, secret) {
+			t.Errorf("log output contains secret %q", secret)
+		}
+	}
+
+	logins := tracedCalls(t, &providerOutput, "C_Login")
+	if len(logins) != 1 || logins[0]["pin"] != redacted || logins[0]["user_type"] != "CKU_USER" {
+		t.Errorf("unexpected C_Login entries: %v", logins)
+	}
+
+	if calls := tracedCalls(t, &providerOutput, "C_CreateObject"); len(calls) != 0 {
+		t.Errorf("expected C_CreateObject to be logged with the operation's context, got %v", calls)
+	}
+	creates := tracedCalls(t, &output, "C_CreateObject")
+	if len(creates) != 1 {
+		t.Fatalf("expected one C_CreateObject entry, got %d", len(creates))
+	}
+	template, _ := creates[0]["template"].([]any)
+	want := []any{"class=CKO_SECRET_KEY", `label="traced"`, "token=true", "value=" + redacted}
+	if len(template) != len(want) {
+		t.Fatalf("expected template %v, got %v", want, template)
+	}
+	for i := range want {
+		if template[i] != want[i] {
+			t.Errorf("expected template %v, got %v", want, template)
+		}
+	}
+	if creates[0]["result"] != "CKR_OK" || creates[0]["duration"] == nil || creates[0]["session"] == nil {
+		t.Errorf("unexpected C_CreateObject entry: %v", creates[0])
+	}
+
+	signInits := tracedCalls(t, &output, "C_SignInit")
+	if len(signInits) == 0 {
+		t.Fatal("expected C_SignInit entries")
+	}
+	mechanisms, _ := signInits[0]["mechanism"].([]any)
+	if len(mechanisms) != 1 || mechanisms[0] != "CKM_SHA256_HMAC" {
+		t.Errorf("unexpected mechanisms: %v", signInits[0]["mechanism"])
+	}
+	if signInits[0]["result"] != "CKR_KEY_HANDLE_INVALID" || signInits[0]["object"] != float64(42) {
+		t.Errorf("unexpected C_SignInit entry: %v", signInits[0])
+	}
+}
+
+func TestTraceTemplate_UnknownAttributeRedacted(t *testing.T) {
+	got := traceTemplate([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VENDOR_DEFINED+1, []byte("vendor-secret")),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIME_1, []byte{1, 2, 3}),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, nil),
+	})
+	want := []string{"0x80000001=" + redacted, "prime_1=" + redacted, "modulus_bits"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,515 @@
+package pkcs11client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// ErrNotRecorded is returned by a ReplayContext for calls that are not in its
+// transcript, or whose recorded responses were all served already.
+var ErrNotRecorded = errors.New("pkcs11: call not recorded in transcript")
+
+// transcriptCall is a single call in a transcript. Transcripts are JSON Lines
+// files with one call per line, in the order the calls returned.
+type transcriptCall struct {
+	Method   string             `json:"method"`
+	Request  transcriptRequest  `json:"request"`
+	Response transcriptResponse `json:"response"`
+}
+
+// transcriptRequest is the transcript form of a hostRequest. Byte strings are
+// hex encoded or, if they may hold secrets, replaced with their length.
+type transcriptRequest struct {
+	Slot         uint                  `json:"slot,omitempty"`
+	Session      pkcs11.SessionHandle  `json:"session,omitempty"`
+	Object       pkcs11.ObjectHandle   `json:"object,omitempty"`
+	Key          pkcs11.ObjectHandle   `json:"key,omitempty"`
+	Flags        uint                  `json:"flags,omitempty"`
+	Count        int                   `json:"count,omitempty"`
+	TokenPresent bool                  `json:"token_present,omitempty"`
+	PIN          string                `json:"pin,omitempty"`
+	NewPIN       string                `json:"new_pin,omitempty"`
+	Label        string                `json:"label,omitempty"`
+	Template     []transcriptAttribute `json:"template,omitempty"`
+	Template2    []transcriptAttribute `json:"template2,omitempty"`
+	Mechanism    []transcriptMechanism `json:"mechanism,omitempty"`
+	Data         string                `json:"data,omitempty"`
+	Data2        string                `json:"data2,omitempty"`
+}
+
+// transcriptResponse is the transcript form of a hostResponse. Result is the
+// name of Code for readers of the transcript and ignored when replaying.
+type transcriptResponse struct {
+	Result        string                `json:"result"`
+	Code          uint                  `json:"code,omitempty"`
+	Error         string                `json:"error,omitempty"`
+	Slots         []uint                `json:"slots,omitempty"`
+	SlotInfo      *pkcs11.SlotInfo      `json:"slot_info,omitempty"`
+	TokenInfo     *pkcs11.TokenInfo     `json:"token_info,omitempty"`
+	MechanismInfo *pkcs11.MechanismInfo `json:"mechanism_info,omitempty"`
+	SessionInfo   *pkcs11.SessionInfo   `json:"session_info,omitempty"`
+	Mechanisms    []uint                `json:"mechanisms,omitempty"`
+	Session       pkcs11.SessionHandle  `json:"session,omitempty"`
+	Object        pkcs11.ObjectHandle   `json:"object,omitempty"`
+	Object2       pkcs11.ObjectHandle   `json:"object2,omitempty"`
+	Objects       []pkcs11.ObjectHandle `json:"objects,omitempty"`
+	More          bool                  `json:"more,omitempty"`
+	Template      []transcriptAttribute `json:"template,omitempty"`
+	Data          string                `json:"data,omitempty"`
+}
+
+// transcriptAttribute is an attribute of a template. Name is informational.
+// Value is the hex encoded raw value, so that values such as CK_ULONG keep
+// the size the module used, and is omitted for queried attributes.
+type transcriptAttribute struct {
+	Type  uint    `json:"type"`
+	Name  string  `json:"name,omitempty"`
+	Value *string `json:"value,omitempty"`
+}
+
+// transcriptMechanism is a mechanism of a request. Name is informational. The
+// parameter is recorded as Params if it was created with NewMechanism, as
+// the marshaled parameter may contain pointers, and as hex otherwise.
+type transcriptMechanism struct {
+	ID        uint             `json:"id"`
+	Name      string           `json:"name,omitempty"`
+	Parameter string           `json:"parameter,omitempty"`
+	Params    *MechanismParams `json:"params,omitempty"`
+}
+
+// publicRequestData lists the methods whose request data is kept in
+// transcripts. The data of other methods, e.g. plaintext or seeds, is
+// recorded only by length.
+var publicRequestData = map[string]bool{
+	"UnwrapKey":     true,
+	"Decrypt":       true,
+	"DecryptUpdate": true,
+	"Verify":        true,
+}
+
+// secretResponseData lists the methods whose response data is recorded only
+// by length, as it is plaintext or random.
+var secretResponseData = map[string]bool{
+	"Decrypt":        true,
+	"DecryptUpdate":  true,
+	"DecryptFinal":   true,
+	"GenerateRandom": true,
+}
+
+// transcriptBytes encodes b as hex, or as its length if redact is set.
+func transcriptBytes(b []byte, redact bool) string {
+	if redact && len(b) > 0 {
+		return fmt.Sprintf("<redacted %d bytes>", len(b))
+	}
+	return hex.EncodeToString(b)
+}
+
+// parseTranscriptBytes decodes a string of transcriptBytes. Redacted values
+// are replaced with zeros of the recorded length.
+func parseTranscriptBytes(s string) ([]byte, error) {
+	var n int
+	if _, err := fmt.Sscanf(s, "<redacted %d bytes>", &n); err == nil {
+		return make([]byte, n), nil
+	}
+	return hex.DecodeString(s)
+}
+
+// redactPIN replaces a PIN, if set.
+func redactPIN(pin string) string {
+	if pin == "" {
+		return ""
+	}
+	return redacted
+}
+
+// newTranscriptTemplate converts template. Values of sensitive attributes and
+// of attributes without a definition are redacted, as in trace logs.
+func newTranscriptTemplate(template []*pkcs11.Attribute) []transcriptAttribute {
+	var attrs []transcriptAttribute
+	for _, attr := range template {
+		if attr == nil {
+			continue
+		}
+		def, ok := traceAttrDefs[attr.Type]
+		a := transcriptAttribute{Type: attr.Type, Name: def.TFKey}
+		if !ok {
+			a.Name = fmt.Sprintf("0x%08X", attr.Type)
+		}
+		if attr.Value != nil {
+			value := transcriptBytes(attr.Value, !ok || def.Sensitive)
+			a.Value = &value
+		}
+		attrs = append(attrs, a)
+	}
+	return attrs
+}
+
+// parseTranscriptTemplate converts attrs back into a template.
+func parseTranscriptTemplate(attrs []transcriptAttribute) ([]*pkcs11.Attribute, error) {
+	var template []*pkcs11.Attribute
+	for _, a := range attrs {
+		attr := &pkcs11.Attribute{Type: a.Type}
+		if a.Value != nil {
+			value, err := parseTranscriptBytes(*a.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of attribute %s: %w", a.Name, err)
+			}
+			attr.Value = value
+		}
+		template = append(template, attr)
+	}
+	return template, nil
+}
+
+// newTranscriptRequest converts req with the secrets redacted.
+func newTranscriptRequest(req hostRequest) transcriptRequest {
+	r := transcriptRequest{
+		Slot:         req.Slot,
+		Session:      req.Session,
+		Object:       req.Object,
+		Key:          req.Key,
+		Flags:        req.Flags,
+		Count:        req.Count,
+		TokenPresent: req.TokenPresent,
+		PIN:          redactPIN(req.PIN),
+		NewPIN:       redactPIN(req.NewPIN),
+		Label:        req.Label,
+		Template:     newTranscriptTemplate(req.Template),
+		Template2:    newTranscriptTemplate(req.Template2),
+		Data:         transcriptBytes(req.Data, !publicRequestData[req.Method]),
+		Data2:        transcriptBytes(req.Data2, false),
+	}
+	for _, m := range req.Mechanism {
+		mech := transcriptMechanism{ID: m.ID, Name: MechanismIDToName[m.ID], Params: m.Params}
+		if m.Params == nil {
+			mech.Parameter = hex.EncodeToString(m.Parameter)
+		}
+		r.Mechanism = append(r.Mechanism, mech)
+	}
+	return r
+}
+
+// key returns the form of r that identifies a call when replaying, without
+// the informational names.
+func (r transcriptRequest) key(method string) string {
+	r.Template = unnamedAttributes(r.Template)
+	r.Template2 = unnamedAttributes(r.Template2)
+	mechs := make([]transcriptMechanism, len(r.Mechanism))
+	for i, m := range r.Mechanism {
+		m.Name = ""
+		mechs[i] = m
+	}
+	r.Mechanism = mechs
+	return method + " " + strings.TrimSpace(string(marshalTranscript(r)))
+}
+
+func unnamedAttributes(attrs []transcriptAttribute) []transcriptAttribute {
+	result := make([]transcriptAttribute, len(attrs))
+	for i, a := range attrs {
+		a.Name = ""
+		result[i] = a
+	}
+	return result
+}
+
+// marshalTranscript encodes v as JSON without escaping the angle brackets of
+// redacted values.
+func marshalTranscript(v any) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		panic(err) // the transcript types always encode
+	}
+	return buf.Bytes()
+}
+
+// newTranscriptResponse converts the response to a call of method with the
+// secrets redacted.
+func newTranscriptResponse(method string, resp hostResponse) transcriptResponse {
+	r := transcriptResponse{
+		Result:     traceResult(responseError(resp)),
+		Code:       resp.Code,
+		Error:      resp.Err,
+		Slots:      resp.Slots,
+		Mechanisms: resp.Mechanisms,
+		Session:    resp.Session,
+		Object:     resp.Object,
+		Object2:    resp.Object2,
+		Objects:    resp.Objects,
+		More:       resp.More,
+		Template:   newTranscriptTemplate(resp.Template),
+		Data:       transcriptBytes(resp.Data, secretResponseData[method]),
+	}
+	switch method {
+	case "GetSlotInfo":
+		r.SlotInfo = &resp.SlotInfo
+	case "GetTokenInfo":
+		r.TokenInfo = &resp.TokenInfo
+	case "GetMechanismInfo":
+		r.MechanismInfo = &resp.MechanismInfo
+	case "GetSessionInfo":
+		r.SessionInfo = &resp.SessionInfo
+	}
+	return r
+}
+
+// hostResponse converts r back into the response it was recorded from.
+func (r transcriptResponse) hostResponse() (hostResponse, error) {
+	resp := hostResponse{
+		Code:       r.Code,
+		Err:        r.Error,
+		Slots:      r.Slots,
+		Mechanisms: r.Mechanisms,
+		Session:    r.Session,
+		Object:     r.Object,
+		Object2:    r.Object2,
+		Objects:    r.Objects,
+		More:       r.More,
+	}
+	if r.SlotInfo != nil {
+		resp.SlotInfo = *r.SlotInfo
+	}
+	if r.TokenInfo != nil {
+		resp.TokenInfo = *r.TokenInfo
+	}
+	if r.MechanismInfo != nil {
+		resp.MechanismInfo = *r.MechanismInfo
+	}
+	if r.SessionInfo != nil {
+		resp.SessionInfo = *r.SessionInfo
+	}
+	var err error
+	if resp.Template, err = parseTranscriptTemplate(r.Template); err != nil {
+		return resp, err
+	}
+	if r.Data != "" {
+		if resp.Data, err = parseTranscriptBytes(r.Data); err != nil {
+			return resp, fmt.Errorf("invalid data: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+// Recorder writes the calls to modules to a transcript, e.g. to attach it to
+// a bug report. Each call is written with its arguments and results as a
+// line of JSON, with PINs, the values of sensitive or unknown attributes,
+// plaintext and random data replaced with their length. A ReplayContext
+// serves the calls of a transcript.
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File // nil once closed
+	err  error    // first write error
+}
+
+// NewRecorder returns a Recorder that appends to file, so that the
+// transcripts of several processes, e.g. of terraform plan and apply, can be
+// written to the same file.
+func NewRecorder(file string) (*Recorder, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transcript: %w", err)
+	}
+	return &Recorder{file: f}, nil
+}
+
+// Middleware returns a middleware that records the calls to the module.
+func (r *Recorder) Middleware() Middleware {
+	return func(next Pkcs11Context) Pkcs11Context {
+		rc := &transcriptContext{next: next, rec: r}
+		rc.requestContext = requestContext{caller: rc}
+		return rc
+	}
+}
+
+// Close closes the transcript and returns the first error writing to it.
+// Calls made after Close are not recorded.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return r.err
+	}
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	r.file = nil
+	return r.err
+}
+
+// record writes a call to the transcript. Each call is written at once, so
+// that concurrent processes appending to the same file do not mix lines.
+func (r *Recorder) record(req hostRequest, resp hostResponse) {
+	line := marshalTranscript(transcriptCall{
+		Method:   req.Method,
+		Request:  newTranscriptRequest(req),
+		Response: newTranscriptResponse(req.Method, resp),
+	})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil || r.err != nil {
+		return
+	}
+	_, r.err = r.file.Write(line)
+}
+
+// transcriptContext is the Pkcs11Context returned by the middleware of a
+// Recorder. It passes the calls to next as hostRequest, so that they are
+// recorded in the same form as they are sent to module hosts.
+type transcriptContext struct {
+	requestContext
+	next Pkcs11Context
+	rec  *Recorder
+}
+
+func (r *transcriptContext) bindSession(sh pkcs11.SessionHandle, ctx context.Context) func() {
+	return bindSession(r.next, sh, ctx)
+}
+
+func (r *transcriptContext) call(req hostRequest) (hostResponse, error) {
+	resp := handleHostRequest(r.next, req)
+	r.rec.record(req, resp)
+	return resp, responseError(resp)
+}
+
+func (r *transcriptContext) callSession(sh pkcs11.SessionHandle, req hostRequest) (hostResponse, error) {
+	req.Session = sh
+	return r.call(req)
+}
+
+// Initialize is called directly, as handleHostRequest drops the options.
+func (r *transcriptContext) Initialize(opts ...pkcs11.InitializeOption) error {
+	var resp hostResponse
+	err := r.next.Initialize(opts...)
+	resp.setError(err)
+	r.rec.record(hostRequest{Method: "Initialize"}, resp)
+	return err
+}
+
+func (r *transcriptContext) Finalize() error {
+	_, err := r.call(hostRequest{Method: "Finalize"})
+	return err
+}
+
+// ReplayContext is a Pkcs11Context that serves the calls of a transcript
+// written by a Recorder, e.g. to reproduce the behavior of a token in tests
+// without it. Each call is answered with the response of the first recorded
+// call with the same method and arguments that was not served yet, so that
+// calls of concurrent sessions may be replayed in a different order than
+// recorded. Other calls fail with ErrNotRecorded. Redacted data is replayed
+// as zeros of the recorded length. Initialize and Finalize succeed if they
+// are not recorded, as clients sharing a module do not call them.
+type ReplayContext struct {
+	requestContext
+	mu    sync.Mutex
+	calls []replayCall
+}
+
+type replayCall struct {
+	key      string
+	response transcriptResponse
+	served   bool
+}
+
+// LoadReplayContext returns a ReplayContext for the transcript file.
+func LoadReplayContext(file string) (*ReplayContext, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transcript: %w", err)
+	}
+	defer f.Close()
+	return NewReplayContext(f)
+}
+
+// NewReplayContext returns a ReplayContext for the transcript read from r.
+func NewReplayContext(r io.Reader) (*ReplayContext, error) {
+	rc := &ReplayContext{}
+	rc.requestContext = requestContext{caller: rc}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var call transcriptCall
+		if err := json.Unmarshal([]byte(text), &call); err != nil {
+			return nil, fmt.Errorf("invalid transcript line %d: %w", line, err)
+		}
+		if call.Method == "" {
+			return nil, fmt.Errorf("invalid transcript line %d: missing method", line)
+		}
+		rc.calls = append(rc.calls, replayCall{key: call.Request.key(call.Method), response: call.Response})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
+	}
+	return rc, nil
+}
+
+// Unserved returns the number of recorded calls that were not served yet.
+func (r *ReplayContext) Unserved() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, c := range r.calls {
+		if !c.served {
+			n++
+		}
+	}
+	return n
+}
+
+// replay returns the recorded response to req, or ok false if there is none.
+func (r *ReplayContext) replay(req hostRequest) (resp hostResponse, ok bool, err error) {
+	key := newTranscriptRequest(req).key(req.Method)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.calls {
+		c := &r.calls[i]
+		if c.served || c.key != key {
+			continue
+		}
+		c.served = true
+		resp, err := c.response.hostResponse()
+		if err != nil {
+			return resp, true, fmt.Errorf("pkcs11: invalid recorded response to %s: %w", req.Method, err)
+		}
+		return resp, true, responseError(resp)
+	}
+	return hostResponse{}, false, nil
+}
+
+func (r *ReplayContext) call(req hostRequest) (hostResponse, error) {
+	resp, ok, err := r.replay(req)
+	if !ok {
+		return resp, fmt.Errorf("%w: %s", ErrNotRecorded, newTranscriptRequest(req).key(req.Method))
+	}
+	return resp, err
+}
+
+func (r *ReplayContext) callSession(sh pkcs11.SessionHandle, req hostRequest) (hostResponse, error) {
+	req.Session = sh
+	return r.call(req)
+}
+
+func (r *ReplayContext) Initialize(opts ...pkcs11.InitializeOption) error {
+	_, _, err := r.replay(hostRequest{Method: "Initialize"})
+	return err
+}
+
+func (r *ReplayContext) Finalize() error {
+	_, _, err := r.replay(hostRequest{Method: "Finalize"})
+	return err
+}
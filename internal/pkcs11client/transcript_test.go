@@ -0,0 +1,134 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+// transcriptOps runs calls through client whose results are compared between
+// recording and replay.
+func transcriptOps(t *testing.T, client *Client) (pkcs11.ObjectHandle, map[uint][]byte, []byte) {
+	t.Helper()
+	ctx := t.Context()
+	handle, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "recorded"),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, []byte("top secret value")),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+	attrs, err := client.GetObjectAttributes(ctx, handle, []uint{pkcs11.CKA_CLASS, pkcs11.CKA_LABEL, pkcs11.CKA_VALUE})
+	if err != nil {
+		t.Fatalf("GetObjectAttributes failed: %v", err)
+	}
+	random, err := client.GenerateRandom(ctx, 16)
+	if err != nil {
+		t.Fatalf("GenerateRandom failed: %v", err)
+	}
+	return handle, attrs, random
+}
+
+func TestRecorder_Replay(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "transcript.jsonl")
+	rec, err := NewRecorder(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{TokenLabel: "test-token", Pin: "1234", PoolSize: 1}
+	client, err := NewClientWithContext(Chain(NewMockContext("test-token"), rec.Middleware()), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, attrs, _ := transcriptOps(t, client)
+	client.Close()
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	transcript, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{`"1234"`, EncodeHex([]byte("top secret value")), EncodeHex(attrs[pkcs11.CKA_VALUE])} {
+		if bytes.Contains(transcript, []byte(secret)) {
+			t.Errorf("transcript contains secret %s", secret)
+		}
+	}
+	if !bytes.Contains(transcript, []byte(`"method":"Login"`)) || !bytes.Contains(transcript, []byte(`"pin":"<redacted>"`)) {
+		t.Errorf("expected redacted login in transcript:\n%s", transcript)
+	}
+
+	replay, err := LoadReplayContext(file)
+	if err != nil {
+		t.Fatalf("LoadReplayContext failed: %v", err)
+	}
+	client, err = NewClientWithContext(replay, cfg)
+	if err != nil {
+		t.Fatalf("NewClientWithContext failed: %v", err)
+	}
+	replayedHandle, replayedAttrs, random := transcriptOps(t, client)
+	client.Close()
+	if replayedHandle != handle {
+		t.Errorf("expected handle %d, got %d", handle, replayedHandle)
+	}
+	if !bytes.Equal(replayedAttrs[pkcs11.CKA_LABEL], attrs[pkcs11.CKA_LABEL]) ||
+		!bytes.Equal(replayedAttrs[pkcs11.CKA_CLASS], attrs[pkcs11.CKA_CLASS]) {
+		t.Errorf("expected attributes %v, got %v", attrs, replayedAttrs)
+	}
+	// Redacted values are replayed as zeros of the recorded length
+	if !bytes.Equal(replayedAttrs[pkcs11.CKA_VALUE], make([]byte, len(attrs[pkcs11.CKA_VALUE]))) {
+		t.Errorf("expected zeroed value, got %x", replayedAttrs[pkcs11.CKA_VALUE])
+	}
+	if !bytes.Equal(random, make([]byte, 16)) {
+		t.Errorf("expected zeroed random data, got %x", random)
+	}
+	if n := replay.Unserved(); n != 0 {
+		t.Errorf("expected all calls to be replayed, %d left", n)
+	}
+}
+
+func TestReplayContext_VendorQuirks(t *testing.T) {
+	// A module with a 4-byte CK_ULONG and a vendor defined return value
+	replay, err := NewReplayContext(strings.NewReader(`
+{"method":"GetAttributeValue","request":{"session":1,"object":2,"template":[{"type":0},{"type":256}]},"response":{"result":"CKR_OK","template":[{"type":0,"name":"class","value":"04000000"},{"type":256,"name":"key_type","value":"1f000000"}]}}
+{"method":"SignInit","request":{"session":1,"key":2,"mechanism":[{"id":4234,"name":"CKM_AES_CMAC"}]},"response":{"result":"CKR_OK"}}
+{"method":"Sign","request":{"session":1,"data":"<redacted 3 bytes>"},"response":{"result":"2147483905","code":2147483905}}
+`))
+	if err != nil {
+		t.Fatalf("NewReplayContext failed: %v", err)
+	}
+
+	attrs, err := replay.GetAttributeValue(1, 2, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		t.Fatalf("GetAttributeValue failed: %v", err)
+	}
+	if len(attrs[0].Value) != 4 || BytesToUlong(attrs[0].Value) != pkcs11.CKO_SECRET_KEY || BytesToUlong(attrs[1].Value) != pkcs11.CKK_AES {
+		t.Errorf("unexpected attributes %x %x", attrs[0].Value, attrs[1].Value)
+	}
+
+	if err := replay.SignInit(1, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_CMAC, nil)}, 2); err != nil {
+		t.Fatalf("SignInit failed: %v", err)
+	}
+	_, err = replay.Sign(1, []byte("abc"))
+	if err = wrapError("Sign", err); !errors.Is(err, pkcs11.Error(0x80000101)) {
+		t.Errorf("expected vendor return value, got: %v", err)
+	}
+
+	// Each recorded call is served once
+	if _, err := replay.Sign(1, []byte("abc")); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("expected ErrNotRecorded, got: %v", err)
+	}
+	if _, err := replay.GetSlotList(true); !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("expected ErrNotRecorded, got: %v", err)
+	}
+}
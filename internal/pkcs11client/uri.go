@@ -0,0 +1,380 @@
+package pkcs11client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/pkcs11"
+)
+
+// URIScheme is the scheme of PKCS#11 URIs as defined in RFC 7512.
+const URIScheme = "pkcs11:"
+
+// uriObjectTypes maps the RFC 7512 type attribute to object classes.
+var uriObjectTypes = map[string]uint{
+	"public":     pkcs11.CKO_PUBLIC_KEY,
+	"private":    pkcs11.CKO_PRIVATE_KEY,
+	"secret-key": pkcs11.CKO_SECRET_KEY,
+	"cert":       pkcs11.CKO_CERTIFICATE,
+	"data":       pkcs11.CKO_DATA,
+}
+
+// URI is a parsed PKCS#11 URI (RFC 7512). Only the attributes used by the
+// provider are kept; unknown attributes are ignored as the RFC requires.
+type URI struct {
+	// Token attributes
+	Token        string
+	Manufacturer string
+	Model        string
+	Serial       string
+	SlotID       *uint
+
+	// Object attributes
+	Object string
+	ID     []byte
+	Type   string
+
+	// Query attributes
+	ModulePath string
+	PinValue   string
+	PinSource  string
+}
+
+// IsURI returns true if s is a PKCS#11 URI rather than a plain label.
+func IsURI(s string) bool {
+	return strings.HasPrefix(s, URIScheme)
+}
+
+// ParseURI parses a PKCS#11 URI.
+func ParseURI(s string) (*URI, error) {
+	if !IsURI(s) {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q: missing %q scheme", s, URIScheme)
+	}
+	rest := strings.TrimPrefix(s, URIScheme)
+	pathPart, queryPart, _ := strings.Cut(rest, "?")
+
+	u := &URI{}
+	if err := parseURIAttrs(pathPart, ";", u.setPathAttr); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q: %w", s, err)
+	}
+	if err := parseURIAttrs(queryPart, "&", u.setQueryAttr); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#11 URI %q: %w", s, err)
+	}
+	return u, nil
+}
+
+// parseURIAttrs splits attrs at sep and passes each decoded name-value pair to set.
+func parseURIAttrs(attrs, sep string, set func(name, value string) error) error {
+	if attrs == "" {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, attr := range strings.Split(attrs, sep) {
+		name, rawValue, ok := strings.Cut(attr, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("malformed attribute %q", attr)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate attribute %q", name)
+		}
+		seen[name] = true
+
+		value, err := url.PathUnescape(rawValue)
+		if err != nil {
+			return fmt.Errorf("attribute %q: %w", name, err)
+		}
+		if err := set(name, value); err != nil {
+			return fmt.Errorf("attribute %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (u *URI) setPathAttr(name, value string) error {
+	switch name {
+	case "token":
+		u.Token = value
+	case "manufacturer":
+		u.Manufacturer = value
+	case "model":
+		u.Model = value
+	case "serial":
+		u.Serial = value
+	case "slot-id":
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid slot ID: %w", err)
+		}
+		slotID := uint(v)
+		u.SlotID = &slotID
+	case "object":
+		u.Object = value
+	case "id":
+		u.ID = []byte(value)
+	case "type":
+		if _, ok := uriObjectTypes[value]; !ok {
+			return fmt.Errorf("unknown object type %q", value)
+		}
+		u.Type = value
+	}
+	return nil
+}
+
+func (u *URI) setQueryAttr(name, value string) error {
+	switch name {
+	case "module-path":
+		u.ModulePath = value
+	case "pin-value":
+		u.PinValue = value
+	case "pin-source":
+		u.PinSource = value
+	}
+	return nil
+}
+
+// HasTokenAttrs returns true if the URI selects a token.
+func (u *URI) HasTokenAttrs() bool {
+	return u.Token != "" || u.Manufacturer != "" || u.Model != "" || u.Serial != "" || u.SlotID != nil
+}
+
+// HasObjectAttrs returns true if the URI selects an object.
+func (u *URI) HasObjectAttrs() bool {
+	return u.Object != "" || u.ID != nil || u.Type != ""
+}
+
+// Class returns the object class selected by the type attribute.
+func (u *URI) Class() (uint, bool) {
+	class, ok := uriObjectTypes[u.Type]
+	return class, ok
+}
+
+// ObjectTemplate returns the search template for the object attributes of
+// the URI. defaultClass is used if the URI has no type attribute.
+func (u *URI) ObjectTemplate(defaultClass uint) []*pkcs11.Attribute {
+	var template []*pkcs11.Attribute
+	if u.Object != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, u.Object))
+	}
+	if u.ID != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, u.ID))
+	}
+	class, ok := u.Class()
+	if !ok {
+		class = defaultClass
+	}
+	return append(template, pkcs11.NewAttribute(pkcs11.CKA_CLASS, class))
+}
+
+// Apply sets the token selection, module path and PIN in cfg from the URI.
+// It fails if one of them is also set in cfg.
+func (u *URI) Apply(cfg *Config) error {
+	if u.HasObjectAttrs() {
+		return errors.New("a token URI must not contain object attributes")
+	}
+	if (HasTokenFilters(*cfg) || cfg.SlotID != nil) && u.HasTokenAttrs() {
+		return errors.New("a URI selecting a token is mutually exclusive with token_label, serial_number, token_manufacturer, token_model, and slot_id")
+	}
+	if u.PinValue != "" && u.PinSource != "" {
+		return errors.New("pin-value and pin-source are mutually exclusive")
+	}
+
+	if u.HasTokenAttrs() {
+		cfg.TokenLabel = u.Token
+		cfg.TokenManufacturer = u.Manufacturer
+		cfg.TokenModel = u.Model
+		cfg.SerialNumber = u.Serial
+		cfg.SlotID = u.SlotID
+	}
+
+	if u.ModulePath != "" {
+		if cfg.ModulePath != "" && cfg.ModulePath != u.ModulePath {
+			return errors.New("module-path of the URI conflicts with module_path")
+		}
+		cfg.ModulePath = u.ModulePath
+	}
+
+	pin := u.PinValue
+	if u.PinSource != "" {
+		var err error
+		if pin, err = ReadPinSource(u.PinSource); err != nil {
+			return err
+		}
+	}
+	if pin != "" {
+		if cfg.Pin != "" {
+			return errors.New("the PIN of the URI conflicts with pin")
+		}
+		cfg.Pin = pin
+	}
+	return nil
+}
+
+// String formats the URI. Attributes are written in a fixed order and only
+// if set; PIN values are never included.
+func (u *URI) String() string {
+	var attrs []string
+	add := func(name, value string) {
+		if value != "" {
+			attrs = append(attrs, name+"="+escapeURIValue(value, false))
+		}
+	}
+	add("token", u.Token)
+	add("manufacturer", u.Manufacturer)
+	add("model", u.Model)
+	add("serial", u.Serial)
+	if u.SlotID != nil {
+		add("slot-id", strconv.FormatUint(uint64(*u.SlotID), 10))
+	}
+	add("object", u.Object)
+	if len(u.ID) > 0 {
+		// IDs are binary, so every byte is percent-encoded like p11tool does.
+		var id strings.Builder
+		for _, b := range u.ID {
+			fmt.Fprintf(&id, "%%%02X", b)
+		}
+		attrs = append(attrs, "id="+id.String())
+	}
+	add("type", u.Type)
+
+	s := URIScheme + strings.Join(attrs, ";")
+
+	var query []string
+	if u.ModulePath != "" {
+		query = append(query, "module-path="+escapeURIValue(u.ModulePath, true))
+	}
+	if u.PinSource != "" {
+		query = append(query, "pin-source="+escapeURIValue(u.PinSource, true))
+	}
+	if len(query) > 0 {
+		s += "?" + strings.Join(query, "&")
+	}
+	return s
+}
+
+// escapeURIValue percent-encodes all characters of an attribute value except
+// unreserved ones and those that RFC 7512 allows unencoded in the path or,
+// if query is set, the query component.
+func escapeURIValue(s string, query bool) string {
+	allowed := "-._~:[]@!$'()*+,="
+	if query {
+		allowed += "/?|"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			strings.IndexByte(allowed, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectURITypes maps object classes to the RFC 7512 type attribute.
+var objectURITypes = func() map[uint]string {
+	m := make(map[uint]string, len(uriObjectTypes))
+	for name, class := range uriObjectTypes {
+		m[class] = name
+	}
+	return m
+}()
+
+// TokenURI returns the PKCS#11 URI of the configured token.
+func (c *Client) TokenURI(ctx context.Context) (string, error) {
+	info, err := c.GetTokenInfo(ctx)
+	if err != nil {
+		return "", err
+	}
+	return info.URI().String(), nil
+}
+
+// ObjectURI returns the PKCS#11 URI of an object on the configured token,
+// identified by its token, label, ID and type.
+func (c *Client) ObjectURI(ctx context.Context, handle pkcs11.ObjectHandle) (string, error) {
+	info, err := c.GetTokenInfo(ctx)
+	if err != nil {
+		return "", err
+	}
+	u := info.URI()
+
+	attrs, err := c.GetObjectAttributes(ctx, handle, []uint{pkcs11.CKA_CLASS, pkcs11.CKA_LABEL})
+	if err != nil {
+		return "", err
+	}
+	u.Object = string(attrs[pkcs11.CKA_LABEL])
+	if class := attrs[pkcs11.CKA_CLASS]; class != nil {
+		u.Type = objectURITypes[BytesToUlong(class)]
+	}
+
+	// Data objects have no CKA_ID
+	if attrs, err := c.GetObjectAttributes(ctx, handle, []uint{pkcs11.CKA_ID}); err == nil {
+		u.ID = attrs[pkcs11.CKA_ID]
+	}
+	return u.String(), nil
+}
+
+// URI returns the PKCS#11 URI that identifies the token.
+func (t *TokenInfo) URI() *URI {
+	return &URI{
+		Token:        strings.TrimRight(t.Label, " "),
+		Manufacturer: strings.TrimRight(t.ManufacturerID, " "),
+		Model:        strings.TrimRight(t.Model, " "),
+		Serial:       strings.TrimRight(t.SerialNumber, " "),
+	}
+}
+
+// FindKey finds the key referenced by ref, which is either a label or a
+// PKCS#11 URI. Labels are looked up with class; URIs may override it with
+// their type attribute. Token attributes of a URI must match the configured
+// token.
+func (c *Client) FindKey(ctx context.Context, ref string, class uint) (pkcs11.ObjectHandle, error) {
+	if !IsURI(ref) {
+		return c.FindObjectByLabelAndClass(ctx, ref, class)
+	}
+
+	u, err := ParseURI(ref)
+	if err != nil {
+		return 0, err
+	}
+	if u.Object == "" && u.ID == nil {
+		return 0, fmt.Errorf("PKCS#11 URI %q must contain an object or id attribute", ref)
+	}
+	if u.HasTokenAttrs() {
+		if err := c.checkURIToken(ctx, u); err != nil {
+			return 0, fmt.Errorf("PKCS#11 URI %q: %w", ref, err)
+		}
+	}
+	return c.FindOneObject(ctx, u.ObjectTemplate(class))
+}
+
+// checkURIToken verifies that the token attributes of u match the configured token.
+func (c *Client) checkURIToken(ctx context.Context, u *URI) error {
+	info, err := c.GetTokenInfo(ctx)
+	if err != nil {
+		return err
+	}
+	token := info.URI()
+	mismatch := func(name, want, got string) error {
+		return fmt.Errorf("%s %q does not match the configured token's %s %q", name, want, name, got)
+	}
+	switch {
+	case u.Token != "" && u.Token != token.Token:
+		return mismatch("token", u.Token, token.Token)
+	case u.Manufacturer != "" && u.Manufacturer != token.Manufacturer:
+		return mismatch("manufacturer", u.Manufacturer, token.Manufacturer)
+	case u.Model != "" && u.Model != token.Model:
+		return mismatch("model", u.Model, token.Model)
+	case u.Serial != "" && u.Serial != token.Serial:
+		return mismatch("serial", u.Serial, token.Serial)
+	case u.SlotID != nil && *u.SlotID != c.SlotID():
+		return fmt.Errorf("slot-id %d does not match the configured slot %d", *u.SlotID, c.SlotID())
+	}
+	return nil
+}
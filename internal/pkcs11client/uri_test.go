@@ -0,0 +1,246 @@
+package pkcs11client
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestParseURI(t *testing.T) {
+	u, err := ParseURI("pkcs11:token=My%20Token;serial=SN123;slot-id=2;object=my-key;id=%01%02;type=private?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if u.Token != "My Token" || u.Serial != "SN123" {
+		t.Errorf("unexpected token attributes: %+v", u)
+	}
+	if u.SlotID == nil || *u.SlotID != 2 {
+		t.Errorf("expected slot-id 2, got %v", u.SlotID)
+	}
+	if u.Object != "my-key" || !bytes.Equal(u.ID, []byte{1, 2}) || u.Type != "private" {
+		t.Errorf("unexpected object attributes: %+v", u)
+	}
+	if u.ModulePath != "/usr/lib/softhsm/libsofthsm2.so" || u.PinValue != "1234" {
+		t.Errorf("unexpected query attributes: %+v", u)
+	}
+}
+
+func TestParseURI_Invalid(t *testing.T) {
+	for _, s := range []string{
+		"token=foo",
+		"pkcs11:token",
+		"pkcs11:token=a;token=b",
+		"pkcs11:type=unknown",
+		"pkcs11:slot-id=abc",
+		"pkcs11:object=%zz",
+	} {
+		if _, err := ParseURI(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestURIString(t *testing.T) {
+	u := &URI{
+		Token:      "My Token",
+		Object:     "a;b/c",
+		ID:         []byte{0x01, 0xab},
+		Type:       "secret-key",
+		ModulePath: "/usr/lib/p11.so",
+	}
+	want := "pkcs11:token=My%20Token;object=a%3Bb%2Fc;id=%01%AB;type=secret-key?module-path=/usr/lib/p11.so"
+	if got := u.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	parsed, err := ParseURI(want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed.Object != u.Object || !bytes.Equal(parsed.ID, u.ID) {
+		t.Errorf("round trip mismatch: %+v", parsed)
+	}
+}
+
+func TestURIApply(t *testing.T) {
+	u, _ := ParseURI("pkcs11:token=MyToken?module-path=/lib/p11.so&pin-value=1234")
+	cfg := Config{}
+	if err := u.Apply(&cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.TokenLabel != "MyToken" || cfg.ModulePath != "/lib/p11.so" || cfg.Pin != "1234" {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+
+	slotID := uint(0)
+	if err := u.Apply(&Config{SlotID: &slotID}); err == nil {
+		t.Error("expected error for URI combined with slot_id")
+	}
+	if err := u.Apply(&Config{Pin: "5678"}); err == nil {
+		t.Error("expected error for conflicting PIN")
+	}
+
+	objectURI, _ := ParseURI("pkcs11:object=my-key")
+	if err := objectURI.Apply(&Config{}); err == nil {
+		t.Error("expected error for object attributes in a token URI")
+	}
+}
+
+func TestURIApply_PinSource(t *testing.T) {
+	pinFile := filepath.Join(t.TempDir(), "pin")
+	if err := os.WriteFile(pinFile, []byte("1234\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range []string{pinFile, "file:" + pinFile} {
+		u, err := ParseURI("pkcs11:token=MyToken?pin-source=" + source)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cfg := Config{}
+		if err := u.Apply(&cfg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Pin != "1234" {
+			t.Errorf("expected PIN from %q, got %q", source, cfg.Pin)
+		}
+	}
+}
+
+func TestFindKey(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	secret, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "my-key"),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, []byte{1}),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+	private, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "my-key"),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, []byte{2}),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	tests := []struct {
+		ref  string
+		want pkcs11.ObjectHandle
+	}{
+		{"my-key", secret},
+		{"pkcs11:object=my-key", secret},
+		{"pkcs11:object=my-key;type=private", private},
+		{"pkcs11:id=%02;type=private", private},
+		{"pkcs11:token=test-token;serial=0001;object=my-key", secret},
+	}
+	for _, tt := range tests {
+		got, err := client.FindKey(ctx, tt.ref, pkcs11.CKO_SECRET_KEY)
+		if err != nil {
+			t.Errorf("FindKey(%q) failed: %v", tt.ref, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("FindKey(%q) = %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+
+	if _, err := client.FindKey(ctx, "pkcs11:token=other-token;object=my-key", pkcs11.CKO_SECRET_KEY); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected token mismatch error, got: %v", err)
+	}
+	if _, err := client.FindKey(ctx, "pkcs11:type=private", pkcs11.CKO_SECRET_KEY); err == nil {
+		t.Error("expected error for URI without object or id")
+	}
+}
+
+func TestObjectURI(t *testing.T) {
+	ctx := t.Context()
+	client, _ := newTestClient("test-token")
+	defer client.Close()
+
+	handle, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "my key"),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, []byte{0x0a}),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	uri, err := client.ObjectURI(ctx, handle)
+	if err != nil {
+		t.Fatalf("ObjectURI failed: %v", err)
+	}
+	want := "pkcs11:token=test-token;manufacturer=Test%20Manufacturer;model=Mock%20HSM;serial=0001;object=my%20key;id=%0A;type=private"
+	if uri != want {
+		t.Errorf("expected %q, got %q", want, uri)
+	}
+
+	found, err := client.FindKey(ctx, uri, pkcs11.CKO_SECRET_KEY)
+	if err != nil {
+		t.Fatalf("FindKey failed: %v", err)
+	}
+	if found != handle {
+		t.Errorf("expected handle %v, got %v", handle, found)
+	}
+}
+
+// uriAttributeContext records the queried attribute types and, like for
+// data objects, reports CKA_ID as invalid.
+type uriAttributeContext struct {
+	*MockContext
+	queried []uint
+}
+
+func (u *uriAttributeContext) GetAttributeValue(sh pkcs11.SessionHandle, oh pkcs11.ObjectHandle, temp []*pkcs11.Attribute) ([]*pkcs11.Attribute, error) {
+	for _, attr := range temp {
+		u.queried = append(u.queried, attr.Type)
+		if attr.Type == pkcs11.CKA_ID {
+			return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_TYPE_INVALID)
+		}
+	}
+	return u.MockContext.GetAttributeValue(sh, oh, temp)
+}
+
+func TestObjectURI_QueriesOnlyURIAttributes(t *testing.T) {
+	ctx := t.Context()
+	mock := &uriAttributeContext{MockContext: NewMockContext("test-token")}
+	client, err := NewClientWithContext(mock, Config{TokenLabel: "test-token", Pin: "1234", PoolSize: 1})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	handle, err := client.CreateObject(ctx, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "my data"),
+	})
+	if err != nil {
+		t.Fatalf("CreateObject failed: %v", err)
+	}
+
+	mock.queried = nil
+	uri, err := client.ObjectURI(ctx, handle)
+	if err != nil {
+		t.Fatalf("ObjectURI failed: %v", err)
+	}
+	want := "pkcs11:token=test-token;manufacturer=Test%20Manufacturer;model=Mock%20HSM;serial=0001;object=my%20data;type=data"
+	if uri != want {
+		t.Errorf("expected %q, got %q", want, uri)
+	}
+	for _, typ := range mock.queried {
+		if typ != pkcs11.CKA_CLASS && typ != pkcs11.CKA_LABEL && typ != pkcs11.CKA_ID {
+			t.Errorf("unexpected query of attribute 0x%x", typ)
+		}
+	}
+}
@@ -1,27 +1,35 @@
 package pkcs11client
 
 import (
+	"context"
+
 	"github.com/miekg/pkcs11"
 )
 
 // WrapKey wraps a key using the specified wrapping key and mechanism.
-func (c *Client) WrapKey(mechanism []*pkcs11.Mechanism, wrappingKey, key pkcs11.ObjectHandle) ([]byte, error) {
+func (c *Client) WrapKey(ctx context.Context, mechanism []*pkcs11.Mechanism, wrappingKey, key pkcs11.ObjectHandle) ([]byte, error) {
 	var wrappedKey []byte
-	err := c.withSession(func(sh pkcs11.SessionHandle) error {
+	err := c.withSession(ctx, func(sh pkcs11.SessionHandle) error {
 		var wrapErr error
 		wrappedKey, wrapErr = c.ctx.WrapKey(sh, mechanism, wrappingKey, key)
 		return wrapError("WrapKey", wrapErr)
 	})
-	return wrappedKey, err
+	if err != nil {
+		return nil, err
+	}
+	return wrappedKey, nil
 }
 
 // UnwrapKey unwraps a key using the specified unwrapping key, mechanism, and template.
-func (c *Client) UnwrapKey(mechanism []*pkcs11.Mechanism, unwrappingKey pkcs11.ObjectHandle, wrappedKey []byte, attrs []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+func (c *Client) UnwrapKey(ctx context.Context, mechanism []*pkcs11.Mechanism, unwrappingKey pkcs11.ObjectHandle, wrappedKey []byte, attrs []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
 	var handle pkcs11.ObjectHandle
-	err := c.withSession(func(sh pkcs11.SessionHandle) error {
+	err := c.withObjectCreation(ctx, [][]*pkcs11.Attribute{attrs}, func(sh pkcs11.SessionHandle) error {
 		var unwrapErr error
 		handle, unwrapErr = c.ctx.UnwrapKey(sh, mechanism, unwrappingKey, wrappedKey, attrs)
 		return wrapError("UnwrapKey", unwrapErr)
 	})
-	return handle, err
+	if err != nil {
+		return 0, err
+	}
+	return handle, nil
 }
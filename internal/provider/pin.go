@@ -0,0 +1,105 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+)
+
+func pinFileSchema() schema.StringAttribute {
+	return schema.StringAttribute{
+		Description: "Path to a file containing the user PIN. A trailing newline is ignored.",
+		Optional:    true,
+	}
+}
+
+func pinCommandSchema() schema.ListAttribute {
+	return schema.ListAttribute{
+		Description: "Command that prints the user PIN on the first line of its standard output, e.g. the CLI of a password manager. " +
+			"The first element is the program, which is looked up in PATH, the others are its arguments. " +
+			"The command runs whenever the provider is configured.",
+		Optional:    true,
+		ElementType: types.StringType,
+	}
+}
+
+func pinSourceSchema() schema.StringAttribute {
+	return schema.StringAttribute{
+		Description: "PIN source as defined by RFC 7512 for the pin-source attribute of PKCS#11 URIs: a file path or file: URI to read the user PIN from.",
+		Optional:    true,
+	}
+}
+
+func protectedAuthPathSchema() schema.BoolAttribute {
+	return schema.BoolAttribute{
+		Description: "Log in through the protected authentication path of the token, e.g. a PIN pad on the reader, by calling C_Login without PIN. " +
+			"The token must report CKF_PROTECTED_AUTHENTICATION_PATH. Mutually exclusive with the user PIN attributes; " +
+			"without so_pin, the SO PIN is entered through the protected authentication path as well.",
+		Optional: true,
+	}
+}
+
+// pinConfig holds the attributes that provide the user PIN of a token, of
+// which at most one may be set.
+type pinConfig struct {
+	Pin        types.String
+	PinFile    types.String
+	PinCommand []types.String
+	PinSource  types.String
+}
+
+// isSet reports whether any of the PIN attributes is set.
+func (c pinConfig) isSet() bool {
+	return !c.Pin.IsNull() || !c.PinFile.IsNull() || c.PinCommand != nil || !c.PinSource.IsNull()
+}
+
+// resolve returns the user PIN from the attribute that is set, running
+// pin_command or reading pin_file or pin_source if necessary. It returns an
+// empty PIN if none is set. attrPath is empty for the token at the top level.
+func (c pinConfig) resolve(ctx context.Context, attrPath path.Path) (string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	set := 0
+	for _, isSet := range []bool{!c.Pin.IsNull(), !c.PinFile.IsNull(), c.PinCommand != nil, !c.PinSource.IsNull()} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		diags.AddAttributeError(attrPath, "Conflicting PIN configuration",
+			"Only one of pin, pin_file, pin_command and pin_source may be set")
+		return "", diags
+	}
+
+	var pin string
+	var err error
+	switch {
+	case !c.PinFile.IsNull():
+		pin, err = pkcs11client.ReadPinFile(c.PinFile.ValueString())
+		if err != nil {
+			diags.AddAttributeError(attrPath.AtName("pin_file"), "Invalid pin_file", err.Error())
+		}
+	case c.PinCommand != nil:
+		command := make([]string, len(c.PinCommand))
+		for i, arg := range c.PinCommand {
+			command[i] = arg.ValueString()
+		}
+		pin, err = pkcs11client.RunPinCommand(ctx, command)
+		if err != nil {
+			diags.AddAttributeError(attrPath.AtName("pin_command"), "Invalid pin_command", err.Error())
+		}
+	case !c.PinSource.IsNull():
+		pin, err = pkcs11client.ReadPinSource(c.PinSource.ValueString())
+		if err != nil {
+			diags.AddAttributeError(attrPath.AtName("pin_source"), "Invalid pin_source", err.Error())
+		}
+	default:
+		pin = c.Pin.ValueString()
+	}
+	return pin, diags
+}
@@ -2,25 +2,37 @@ package provider
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
 	"blechschmidt.io/terraform-provider-pkcs11/internal/datasources/constants"
 	"blechschmidt.io/terraform-provider-pkcs11/internal/datasources/decrypt"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/datasources/digest"
 	"blechschmidt.io/terraform-provider-pkcs11/internal/datasources/encrypt"
 	"blechschmidt.io/terraform-provider-pkcs11/internal/datasources/mechanisms"
 	"blechschmidt.io/terraform-provider-pkcs11/internal/datasources/object"
 	"blechschmidt.io/terraform-provider-pkcs11/internal/datasources/signature"
 	"blechschmidt.io/terraform-provider-pkcs11/internal/datasources/slots"
 	"blechschmidt.io/terraform-provider-pkcs11/internal/datasources/token_info"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/datasources/verify"
 	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	derived_key_resource "blechschmidt.io/terraform-provider-pkcs11/internal/resources/derived_key"
+	ecdh_key_resource "blechschmidt.io/terraform-provider-pkcs11/internal/resources/ecdh_key"
 	key_pair_resource "blechschmidt.io/terraform-provider-pkcs11/internal/resources/key_pair"
 	object_resource "blechschmidt.io/terraform-provider-pkcs11/internal/resources/object"
+	object_copy_resource "blechschmidt.io/terraform-provider-pkcs11/internal/resources/object_copy"
+	pin_resource "blechschmidt.io/terraform-provider-pkcs11/internal/resources/pin"
+	random_resource "blechschmidt.io/terraform-provider-pkcs11/internal/resources/random"
 	symmetric_key_resource "blechschmidt.io/terraform-provider-pkcs11/internal/resources/symmetric_key"
+	token_resource "blechschmidt.io/terraform-provider-pkcs11/internal/resources/token"
 	unwrapped_key_resource "blechschmidt.io/terraform-provider-pkcs11/internal/resources/unwrapped_key"
 	wrapped_key_resource "blechschmidt.io/terraform-provider-pkcs11/internal/resources/wrapped_key"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/provider"
 	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
@@ -59,15 +71,57 @@ type Pkcs11Provider struct {
 
 // Pkcs11ProviderModel describes the provider configuration data model.
 type Pkcs11ProviderModel struct {
-	ModulePath        types.String `tfsdk:"module_path"`
-	TokenLabel        types.String `tfsdk:"token_label"`
-	SerialNumber      types.String `tfsdk:"serial_number"`
-	TokenManufacturer types.String `tfsdk:"token_manufacturer"`
-	TokenModel        types.String `tfsdk:"token_model"`
-	SlotID            types.Int64  `tfsdk:"slot_id"`
-	Pin               types.String `tfsdk:"pin"`
-	SoPin             types.String `tfsdk:"so_pin"`
-	Env               types.Map    `tfsdk:"env"`
+	ModulePath           types.String          `tfsdk:"module_path"`
+	TokenLabel           types.String          `tfsdk:"token_label"`
+	SerialNumber         types.String          `tfsdk:"serial_number"`
+	TokenManufacturer    types.String          `tfsdk:"token_manufacturer"`
+	TokenModel           types.String          `tfsdk:"token_model"`
+	SlotID               types.Int64           `tfsdk:"slot_id"`
+	SessionPoolSize      types.Int64           `tfsdk:"session_pool_size"`
+	Pin                  types.String          `tfsdk:"pin"`
+	PinFile              types.String          `tfsdk:"pin_file"`
+	PinCommand           []types.String        `tfsdk:"pin_command"`
+	PinSource            types.String          `tfsdk:"pin_source"`
+	ProtectedAuthPath    types.Bool            `tfsdk:"protected_authentication_path"`
+	SoPin                types.String          `tfsdk:"so_pin"`
+	DeferTokenResolution types.Bool            `tfsdk:"defer_token_resolution"`
+	URI                  types.String          `tfsdk:"uri"`
+	Env                  types.Map             `tfsdk:"env"`
+	IsolateModule        types.Bool            `tfsdk:"isolate_module"`
+	AgentAddress         types.String          `tfsdk:"agent_address"`
+	AgentCAFile          types.String          `tfsdk:"agent_ca_file"`
+	AgentCertFile        types.String          `tfsdk:"agent_cert_file"`
+	AgentKeyFile         types.String          `tfsdk:"agent_key_file"`
+	RecordFile           types.String          `tfsdk:"record_file"`
+	Tokens               map[string]TokenModel `tfsdk:"tokens"`
+	Retry                *RetryModel           `tfsdk:"retry"`
+}
+
+// TokenModel describes an entry of the tokens map in the provider configuration.
+type TokenModel struct {
+	ModulePath           types.String   `tfsdk:"module_path"`
+	TokenLabel           types.String   `tfsdk:"token_label"`
+	SerialNumber         types.String   `tfsdk:"serial_number"`
+	TokenManufacturer    types.String   `tfsdk:"token_manufacturer"`
+	TokenModel           types.String   `tfsdk:"token_model"`
+	SlotID               types.Int64    `tfsdk:"slot_id"`
+	SessionPoolSize      types.Int64    `tfsdk:"session_pool_size"`
+	Pin                  types.String   `tfsdk:"pin"`
+	PinFile              types.String   `tfsdk:"pin_file"`
+	PinCommand           []types.String `tfsdk:"pin_command"`
+	PinSource            types.String   `tfsdk:"pin_source"`
+	ProtectedAuthPath    types.Bool     `tfsdk:"protected_authentication_path"`
+	SoPin                types.String   `tfsdk:"so_pin"`
+	DeferTokenResolution types.Bool     `tfsdk:"defer_token_resolution"`
+	URI                  types.String   `tfsdk:"uri"`
+}
+
+// RetryModel describes the retry policy in the provider configuration.
+type RetryModel struct {
+	MaxAttempts     types.Int64    `tfsdk:"max_attempts"`
+	InitialBackoff  types.String   `tfsdk:"initial_backoff"`
+	MaxBackoff      types.String   `tfsdk:"max_backoff"`
+	RetryableErrors []types.String `tfsdk:"retryable_errors"`
 }
 
 // New creates a factory function for the provider.
@@ -87,7 +141,7 @@ func (p *Pkcs11Provider) Schema(_ context.Context, _ provider.SchemaRequest, res
 		Description: "Terraform provider for managing cryptographic objects on PKCS#11 tokens and HSMs.",
 		Attributes: map[string]schema.Attribute{
 			"module_path": schema.StringAttribute{
-				Description: "Path to the PKCS#11 shared library module. Can also be set via PKCS11_MODULE_PATH env var.",
+				Description: "Path to the PKCS#11 shared library module, or memory: followed by an optional file path to use an in-memory token emulator for development and tests. Can also be set via PKCS11_MODULE_PATH env var.",
 				Optional:    true,
 			},
 			"token_label": schema.StringAttribute{
@@ -110,21 +164,150 @@ func (p *Pkcs11Provider) Schema(_ context.Context, _ provider.SchemaRequest, res
 				Description: "Slot ID to use. Mutually exclusive with token_label, serial_number, token_manufacturer, and token_model. Can also be set via PKCS11_SLOT_ID env var.",
 				Optional:    true,
 			},
+			"session_pool_size": schema.Int64Attribute{
+				Description: "Maximum number of concurrent sessions with the token. Operations wait for a free session once all are in use. " +
+					"Defaults to the maximum number of R/W sessions reported by the token, capped at 10. Can also be set via PKCS11_SESSION_POOL_SIZE env var.",
+				Optional: true,
+			},
 			"pin": schema.StringAttribute{
-				Description: "User PIN for the token. Can also be set via PKCS11_PIN env var.",
+				Description: "User PIN for the token. Mutually exclusive with pin_file, pin_command and pin_source. Can also be set via PKCS11_PIN env var.",
 				Optional:    true,
 				Sensitive:   true,
 			},
+			"pin_file":                      pinFileSchema(),
+			"pin_command":                   pinCommandSchema(),
+			"pin_source":                    pinSourceSchema(),
+			"protected_authentication_path": protectedAuthPathSchema(),
 			"so_pin": schema.StringAttribute{
 				Description: "Security Officer PIN. Can also be set via PKCS11_SO_PIN env var.",
 				Optional:    true,
 				Sensitive:   true,
 			},
+			"defer_token_resolution": deferTokenResolutionSchema(),
+			"uri": schema.StringAttribute{
+				Description: "PKCS#11 URI (RFC 7512) of the token to use, e.g. pkcs11:token=MyToken?module-path=/usr/lib/softhsm/libsofthsm2.so. " +
+					"The token, manufacturer, model, serial and slot-id attributes select the token and are mutually exclusive with token_label, serial_number, token_manufacturer, token_model, and slot_id. " +
+					"The module-path, pin-value and pin-source query attributes can replace module_path and pin. Can also be set via PKCS11_URI env var.",
+				Optional: true,
+			},
 			"env": schema.MapAttribute{
 				Description: "Additional environment variables to set for the provider process. This can be used to pass configuration to the PKCS#11 module or for debugging purposes. Values will override any conflicting environment variables set in the shell.",
 				Optional:    true,
 				ElementType: types.StringType,
 			},
+			"isolate_module": schema.BoolAttribute{
+				Description: "Run the PKCS#11 modules in helper processes instead of the provider process, one per module and env. " +
+					"A crash of a module then fails the running operations with a retryable error instead of terminating the provider, " +
+					"and env is only set for the helper processes of this provider configuration.",
+				Optional: true,
+			},
+			"agent_address": schema.StringAttribute{
+				Description: "Address of a pkcs11-agent that serves the module of a remote host, either unix: followed by the path of a Unix socket, or the host:port of an agent listening with TLS. " +
+					"If set, all tokens are accessed through the agent and module_path is ignored. Can also be set via PKCS11_AGENT_ADDRESS env var.",
+				Optional: true,
+			},
+			"agent_ca_file": schema.StringAttribute{
+				Description: "PEM file with the CA certificates that verify the certificate of the agent. Defaults to the system certificates. Can also be set via PKCS11_AGENT_CA_FILE env var.",
+				Optional:    true,
+			},
+			"agent_cert_file": schema.StringAttribute{
+				Description: "PEM file with the client certificate that authenticates the provider to an agent listening with TLS. Can also be set via PKCS11_AGENT_CERT_FILE env var.",
+				Optional:    true,
+			},
+			"agent_key_file": schema.StringAttribute{
+				Description: "PEM file with the key of agent_cert_file. Can also be set via PKCS11_AGENT_KEY_FILE env var.",
+				Optional:    true,
+			},
+			"record_file": schema.StringAttribute{
+				Description: "File to which every call to the PKCS#11 modules is appended as a line of JSON with its arguments and results, e.g. to attach it to a bug report. " +
+					"PINs, the values of sensitive attributes, plaintext and random data are replaced with their length. Can also be set via PKCS11_RECORD_FILE env var.",
+				Optional: true,
+			},
+			"tokens": schema.MapNestedAttribute{
+				Description: "Additional named tokens, which resources and data sources select through their token_name attribute. " +
+					"Each token has its own session pool. Tokens accessed through the same module share a single loaded module. " +
+					"If set, the token at the top level of the configuration is optional.",
+				Optional: true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"module_path": schema.StringAttribute{
+							Description: "Path to the PKCS#11 shared library module, or memory: followed by an optional file path to use an in-memory token emulator. Defaults to the module_path of the provider.",
+							Optional:    true,
+						},
+						"token_label": schema.StringAttribute{
+							Description: "Label of the token to use. Can be combined with serial_number, token_manufacturer, and token_model. Mutually exclusive with slot_id.",
+							Optional:    true,
+						},
+						"serial_number": schema.StringAttribute{
+							Description: "Serial number of the token to use. Can be combined with token_label, token_manufacturer, and token_model. Mutually exclusive with slot_id.",
+							Optional:    true,
+						},
+						"token_manufacturer": schema.StringAttribute{
+							Description: "Manufacturer of the token to use. Can be combined with token_label, serial_number, and token_model. Mutually exclusive with slot_id.",
+							Optional:    true,
+						},
+						"token_model": schema.StringAttribute{
+							Description: "Model of the token to use. Can be combined with token_label, serial_number, and token_manufacturer. Mutually exclusive with slot_id.",
+							Optional:    true,
+						},
+						"slot_id": schema.Int64Attribute{
+							Description: "Slot ID to use. Mutually exclusive with token_label, serial_number, token_manufacturer, and token_model.",
+							Optional:    true,
+						},
+						"session_pool_size": schema.Int64Attribute{
+							Description: "Maximum number of concurrent sessions with the token. Defaults to the maximum number of R/W sessions reported by the token, capped at 10.",
+							Optional:    true,
+						},
+						"pin": schema.StringAttribute{
+							Description: "User PIN for the token. Mutually exclusive with pin_file, pin_command and pin_source.",
+							Optional:    true,
+							Sensitive:   true,
+						},
+						"pin_file":                      pinFileSchema(),
+						"pin_command":                   pinCommandSchema(),
+						"pin_source":                    pinSourceSchema(),
+						"protected_authentication_path": protectedAuthPathSchema(),
+						"so_pin": schema.StringAttribute{
+							Description: "Security Officer PIN.",
+							Optional:    true,
+							Sensitive:   true,
+						},
+						"defer_token_resolution": deferTokenResolutionSchema(),
+						"uri": schema.StringAttribute{
+							Description: "PKCS#11 URI (RFC 7512) of the token to use, as an alternative to the other token identifiers. " +
+								"The module-path, pin-value and pin-source query attributes can replace module_path and pin.",
+							Optional: true,
+						},
+					},
+				},
+			},
+			"retry": schema.SingleNestedAttribute{
+				Description: "Retry policy for transient token errors, e.g. of network HSMs, applied to all tokens. " +
+					"Without it, operations are not retried, except once with a new session after the session became invalid. " +
+					"Operations that cannot safely be repeated, such as changing a PIN or streaming data, are never retried. " +
+					"Operations that create objects are only retried if no object with the label or ID of the template exists after the failed attempt.",
+				Optional: true,
+				Attributes: map[string]schema.Attribute{
+					"max_attempts": schema.Int64Attribute{
+						Description: fmt.Sprintf("Maximum number of attempts of an operation, including the first one. Set to 1 to disable retries. Defaults to %d.", pkcs11client.DefaultRetryMaxAttempts),
+						Optional:    true,
+					},
+					"initial_backoff": schema.StringAttribute{
+						Description: fmt.Sprintf("Delay before the first retry as a Go duration string, e.g. 250ms. The delay doubles with every retry and half of it is random. Defaults to %s.", pkcs11client.DefaultRetryInitialBackoff),
+						Optional:    true,
+					},
+					"max_backoff": schema.StringAttribute{
+						Description: fmt.Sprintf("Maximum delay between two attempts as a Go duration string. Defaults to %s.", pkcs11client.DefaultRetryMaxBackoff),
+						Optional:    true,
+					},
+					"retryable_errors": schema.ListAttribute{
+						Description: "PKCS#11 return values that are retried, e.g. CKR_DEVICE_ERROR. " +
+							"Defaults to CKR_DEVICE_ERROR, CKR_DEVICE_MEMORY, and CKR_FUNCTION_FAILED.",
+						Optional:    true,
+						ElementType: types.StringType,
+					},
+				},
+			},
 		},
 	}
 }
@@ -142,17 +325,36 @@ func (p *Pkcs11Provider) Configure(ctx context.Context, req provider.ConfigureRe
 	serialNumber := stringValueOrEnv(config.SerialNumber, "PKCS11_SERIAL_NUMBER")
 	tokenManufacturer := stringValueOrEnv(config.TokenManufacturer, "PKCS11_TOKEN_MANUFACTURER")
 	tokenModel := stringValueOrEnv(config.TokenModel, "PKCS11_TOKEN_MODEL")
-	pin := stringValueOrEnv(config.Pin, "PKCS11_PIN")
 	soPin := stringValueOrEnv(config.SoPin, "PKCS11_SO_PIN")
 
+	// Isolated modules get env as environment of their processes instead
+	var host *pkcs11client.ModuleHost
+	if config.IsolateModule.ValueBool() {
+		host = &pkcs11client.ModuleHost{}
+	}
 	for k, v := range config.Env.Elements() {
 		value := v.(types.String).ValueString()
+		if host != nil {
+			host.Env = append(host.Env, k+"="+value)
+			continue
+		}
 		os.Setenv(k, value)
 	}
 
-	if modulePath == "" {
-		resp.Diagnostics.AddError("Missing module_path", "module_path must be set in provider config or PKCS11_MODULE_PATH env var")
-		return
+	// Agents serve a single module, so module_path is not needed
+	var agent *pkcs11client.AgentConfig
+	if address := stringValueOrEnv(config.AgentAddress, "PKCS11_AGENT_ADDRESS"); address != "" {
+		if host != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("agent_address"), "Conflicting attributes",
+				"agent_address and isolate_module cannot be used together, the agent loads the module on its host")
+			return
+		}
+		agent = &pkcs11client.AgentConfig{
+			Address:  address,
+			CAFile:   stringValueOrEnv(config.AgentCAFile, "PKCS11_AGENT_CA_FILE"),
+			CertFile: stringValueOrEnv(config.AgentCertFile, "PKCS11_AGENT_CERT_FILE"),
+			KeyFile:  stringValueOrEnv(config.AgentKeyFile, "PKCS11_AGENT_KEY_FILE"),
+		}
 	}
 
 	var slotID *uint
@@ -167,7 +369,155 @@ func (p *Pkcs11Provider) Configure(ctx context.Context, req provider.ConfigureRe
 		}
 	}
 
-	cfg := pkcs11client.Config{
+	retry, diags := retryPolicy(config.Retry)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	pins := pinConfig{Pin: config.Pin, PinFile: config.PinFile, PinCommand: config.PinCommand, PinSource: config.PinSource}
+	if !pins.isSet() && !config.ProtectedAuthPath.ValueBool() {
+		pins.Pin = types.StringValue(os.Getenv("PKCS11_PIN"))
+	}
+	pin, diags := pins.resolve(ctx, path.Empty())
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	configs := make(map[string]pkcs11client.Config, len(config.Tokens)+1)
+
+	cfg := newTokenConfig(modulePath, tokenLabel, serialNumber, tokenManufacturer, tokenModel, slotID, pin, soPin)
+	cfg.ProtectedAuthPath = config.ProtectedAuthPath.ValueBool()
+	cfg.DeferTokenResolution = config.DeferTokenResolution.ValueBool()
+	cfg.Retry = retry
+	cfg.Host = host
+	cfg.Agent = agent
+	if !config.SessionPoolSize.IsNull() && !config.SessionPoolSize.IsUnknown() {
+		cfg.PoolSize = int(config.SessionPoolSize.ValueInt64())
+	} else if envVal := os.Getenv("PKCS11_SESSION_POOL_SIZE"); envVal != "" {
+		v, err := strconv.Atoi(envVal)
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid PKCS11_SESSION_POOL_SIZE", err.Error())
+			return
+		}
+		cfg.PoolSize = v
+	}
+	if uri := stringValueOrEnv(config.URI, "PKCS11_URI"); uri != "" {
+		resp.Diagnostics.Append(applyURI(path.Root("uri"), uri, &cfg)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	// The top-level token may be omitted if named tokens are configured
+	if len(config.Tokens) == 0 || pkcs11client.HasTokenFilters(cfg) || cfg.SlotID != nil {
+		if cfg.ModulePath == "" && agent == nil {
+			resp.Diagnostics.AddError("Missing module_path", "module_path must be set in provider config or PKCS11_MODULE_PATH env var")
+			return
+		}
+		resp.Diagnostics.Append(validateTokenConfig(path.Empty(), cfg)...)
+		configs[pkcs11client.DefaultToken] = cfg
+	}
+
+	for name, token := range config.Tokens {
+		attrPath := path.Root("tokens").AtMapKey(name)
+		if name == pkcs11client.DefaultToken {
+			resp.Diagnostics.AddAttributeError(attrPath, "Invalid token name", "Token names must not be empty")
+			continue
+		}
+
+		var tokenSlotID *uint
+		if !token.SlotID.IsNull() && !token.SlotID.IsUnknown() {
+			v := uint(token.SlotID.ValueInt64())
+			tokenSlotID = &v
+		}
+
+		tokenPins := pinConfig{Pin: token.Pin, PinFile: token.PinFile, PinCommand: token.PinCommand, PinSource: token.PinSource}
+		tokenPin, diags := tokenPins.resolve(ctx, attrPath)
+		resp.Diagnostics.Append(diags...)
+		if diags.HasError() {
+			continue
+		}
+
+		tokenCfg := newTokenConfig(token.ModulePath.ValueString(), token.TokenLabel.ValueString(), token.SerialNumber.ValueString(),
+			token.TokenManufacturer.ValueString(), token.TokenModel.ValueString(), tokenSlotID,
+			tokenPin, token.SoPin.ValueString())
+		tokenCfg.ProtectedAuthPath = token.ProtectedAuthPath.ValueBool()
+		tokenCfg.DeferTokenResolution = token.DeferTokenResolution.ValueBool()
+		tokenCfg.PoolSize = int(token.SessionPoolSize.ValueInt64())
+		tokenCfg.Retry = retry
+		tokenCfg.Host = host
+		tokenCfg.Agent = agent
+		if !token.URI.IsNull() && !token.URI.IsUnknown() {
+			diags := applyURI(attrPath.AtName("uri"), token.URI.ValueString(), &tokenCfg)
+			resp.Diagnostics.Append(diags...)
+			if diags.HasError() {
+				continue
+			}
+		}
+
+		if tokenCfg.ModulePath == "" {
+			tokenCfg.ModulePath = cfg.ModulePath
+		}
+		if tokenCfg.ModulePath == "" && agent == nil {
+			resp.Diagnostics.AddAttributeError(attrPath.AtName("module_path"), "Missing module_path",
+				fmt.Sprintf("module_path must be set for token %q or at the top level of the provider config", name))
+			continue
+		}
+
+		resp.Diagnostics.Append(validateTokenConfig(attrPath, tokenCfg)...)
+		configs[name] = tokenCfg
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// The calls of operations to the modules are logged with the logger of
+	// the operation's request, other calls with the logger of the provider
+	middlewares := []pkcs11client.Middleware{pkcs11client.Tracing(ctx)}
+	var recorder *pkcs11client.Recorder
+	if file := stringValueOrEnv(config.RecordFile, "PKCS11_RECORD_FILE"); file != "" {
+		var err error
+		if recorder, err = pkcs11client.NewRecorder(file); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("record_file"), "Invalid record_file", err.Error())
+			return
+		}
+		middlewares = append(middlewares, recorder.Middleware())
+	}
+
+	clients, err := pkcs11client.NewClients(configs, middlewares...)
+	if err != nil {
+		if recorder != nil {
+			recorder.Close()
+		}
+		resp.Diagnostics.AddError("Failed to initialize PKCS#11 client", err.Error())
+		return
+	}
+
+	RegisterCleanup(clients.Close)
+	if recorder != nil {
+		// Registered after clients.Close to record the calls that close the sessions
+		RegisterCleanup(func() { recorder.Close() })
+	}
+
+	resp.DataSourceData = clients
+	resp.ResourceData = clients
+}
+
+// deferTokenResolutionSchema returns the defer_token_resolution attribute of a token.
+func deferTokenResolutionSchema() schema.BoolAttribute {
+	return schema.BoolAttribute{
+		Description: "Resolve the token on its first use instead of when the provider is configured, " +
+			"for a token that is initialized by a pkcs11_token resource in the same configuration. " +
+			"Without this, a configuration that matches no token fails right away.",
+		Optional: true,
+	}
+}
+
+// newTokenConfig creates the client configuration of a single token.
+func newTokenConfig(modulePath, tokenLabel, serialNumber, tokenManufacturer, tokenModel string, slotID *uint, pin, soPin string) pkcs11client.Config {
+	return pkcs11client.Config{
 		ModulePath:        modulePath,
 		TokenLabel:        tokenLabel,
 		SerialNumber:      serialNumber,
@@ -176,31 +526,104 @@ func (p *Pkcs11Provider) Configure(ctx context.Context, req provider.ConfigureRe
 		SlotID:            slotID,
 		Pin:               pin,
 		SoPin:             soPin,
-		PoolSize:          5,
 	}
+}
 
-	hasTokenFilter := pkcs11client.HasTokenFilters(cfg)
+// retryPolicy converts the retry attribute of the provider configuration into
+// a retry policy, or nil if it is not set. Unset values keep their defaults.
+func retryPolicy(model *RetryModel) (*pkcs11client.RetryPolicy, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	if model == nil {
+		return nil, diags
+	}
+	policy := pkcs11client.DefaultRetryPolicy()
+	attrPath := path.Root("retry")
 
-	if !hasTokenFilter && slotID == nil {
-		resp.Diagnostics.AddError("Missing token identifier", "At least one of token_label, serial_number, token_manufacturer, token_model, or slot_id must be set")
-		return
+	if !model.MaxAttempts.IsNull() && !model.MaxAttempts.IsUnknown() {
+		policy.MaxAttempts = int(model.MaxAttempts.ValueInt64())
+		if policy.MaxAttempts < 1 {
+			diags.AddAttributeError(attrPath.AtName("max_attempts"), "Invalid max_attempts", "max_attempts must be at least 1")
+		}
 	}
 
-	if hasTokenFilter && slotID != nil {
-		resp.Diagnostics.AddError("Conflicting token identifiers", "slot_id is mutually exclusive with token_label, serial_number, token_manufacturer, and token_model")
-		return
+	diags.Append(parseDuration(attrPath.AtName("initial_backoff"), model.InitialBackoff, &policy.InitialBackoff)...)
+	diags.Append(parseDuration(attrPath.AtName("max_backoff"), model.MaxBackoff, &policy.MaxBackoff)...)
+	if model.MaxBackoff.IsNull() {
+		policy.MaxBackoff = max(policy.MaxBackoff, policy.InitialBackoff)
 	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		diags.AddAttributeError(attrPath.AtName("max_backoff"), "Invalid max_backoff",
+			fmt.Sprintf("max_backoff (%s) must not be less than initial_backoff (%s)", policy.MaxBackoff, policy.InitialBackoff))
+	}
+
+	if model.RetryableErrors != nil {
+		policy.RetryableErrors = make([]uint, 0, len(model.RetryableErrors))
+		for i, name := range model.RetryableErrors {
+			code, err := pkcs11client.ReturnValueEnum.Resolve(name.ValueString())
+			if err != nil {
+				diags.AddAttributeError(attrPath.AtName("retryable_errors").AtListIndex(i), "Invalid retryable error", err.Error())
+				continue
+			}
+			policy.RetryableErrors = append(policy.RetryableErrors, code)
+		}
+	}
+	return &policy, diags
+}
 
-	client, err := pkcs11client.NewClient(cfg)
+// parseDuration parses the Go duration string value into d, if it is set.
+func parseDuration(attrPath path.Path, value types.String, d *time.Duration) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if value.IsNull() || value.IsUnknown() {
+		return diags
+	}
+	parsed, err := time.ParseDuration(value.ValueString())
+	if err == nil && parsed < 0 {
+		err = fmt.Errorf("duration %q must not be negative", value.ValueString())
+	}
 	if err != nil {
-		resp.Diagnostics.AddError("Failed to initialize PKCS#11 client", err.Error())
-		return
+		diags.AddAttributeError(attrPath, "Invalid duration", err.Error())
+		return diags
 	}
+	*d = parsed
+	return diags
+}
 
-	RegisterCleanup(func() { client.Close() })
+// applyURI applies the PKCS#11 URI set in the attribute at attrPath to cfg.
+func applyURI(attrPath path.Path, uri string, cfg *pkcs11client.Config) diag.Diagnostics {
+	var diags diag.Diagnostics
+	u, err := pkcs11client.ParseURI(uri)
+	if err == nil {
+		err = u.Apply(cfg)
+	}
+	if err != nil {
+		diags.AddAttributeError(attrPath, "Invalid uri", err.Error())
+	}
+	return diags
+}
+
+// validateTokenConfig checks that cfg selects a token either by slot ID or by
+// token filters, and that its session pool size is valid. attrPath is empty for the token at the top level.
+func validateTokenConfig(attrPath path.Path, cfg pkcs11client.Config) diag.Diagnostics {
+	var diags diag.Diagnostics
+	hasTokenFilter := pkcs11client.HasTokenFilters(cfg)
+
+	if !hasTokenFilter && cfg.SlotID == nil {
+		diags.AddAttributeError(attrPath, "Missing token identifier", "At least one of uri, token_label, serial_number, token_manufacturer, token_model, or slot_id must be set")
+	}
+
+	if hasTokenFilter && cfg.SlotID != nil {
+		diags.AddAttributeError(attrPath, "Conflicting token identifiers", "slot_id is mutually exclusive with token_label, serial_number, token_manufacturer, and token_model")
+	}
 
-	resp.DataSourceData = client
-	resp.ResourceData = client
+	if cfg.PoolSize < 0 {
+		diags.AddAttributeError(attrPath, "Invalid session_pool_size", "session_pool_size must be positive")
+	}
+
+	if cfg.ProtectedAuthPath && cfg.Pin != "" {
+		diags.AddAttributeError(attrPath.AtName("protected_authentication_path"), "Conflicting PIN configuration",
+			"protected_authentication_path is mutually exclusive with pin, pin_file, pin_command, pin_source and the PIN of the uri")
+	}
+	return diags
 }
 
 func (p *Pkcs11Provider) Resources(_ context.Context) []func() resource.Resource {
@@ -210,6 +633,12 @@ func (p *Pkcs11Provider) Resources(_ context.Context) []func() resource.Resource
 		key_pair_resource.NewResource,
 		wrapped_key_resource.NewResource,
 		unwrapped_key_resource.NewResource,
+		derived_key_resource.NewResource,
+		ecdh_key_resource.NewResource,
+		random_resource.NewResource,
+		token_resource.NewResource,
+		pin_resource.NewResource,
+		object_copy_resource.NewResource,
 	}
 }
 
@@ -223,6 +652,8 @@ func (p *Pkcs11Provider) DataSources(_ context.Context) []func() datasource.Data
 		encrypt.NewDataSource,
 		decrypt.NewDataSource,
 		signature.NewDataSource,
+		verify.NewDataSource,
+		digest.NewDataSource,
 	}
 }
 
@@ -0,0 +1,373 @@
+package derived_key
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/miekg/pkcs11"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
+)
+
+var (
+	_ resource.Resource                = &DerivedKeyResource{}
+	_ resource.ResourceWithImportState = &DerivedKeyResource{}
+)
+
+type DerivedKeyResource struct {
+	clients *pkcs11client.Clients
+}
+
+func NewResource() resource.Resource {
+	return &DerivedKeyResource{}
+}
+
+func (r *DerivedKeyResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_derived_key"
+}
+
+func (r *DerivedKeyResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	classEnum := pkcs11client.AttributeNameToDef["class"].Pkcs11Enum
+
+	attrs := shared.ObjectAttrSchema()
+	attrs["id"] = schema.StringAttribute{
+		Computed:    true,
+		Description: "Composite resource identifier (label/key_id_hex/CKO_SECRET_KEY).",
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.UseStateForUnknown(),
+		},
+	}
+	attrs["token_name"] = shared.TokenNameSchema()
+	attrs["login_as"] = shared.LoginAsSchema()
+	attrs["uri"] = shared.ObjectURISchema()
+	attrs["mechanism"] = schema.StringAttribute{
+		Required: true,
+		Description: "Key derivation mechanism name (e.g., CKM_ECDH1_DERIVE, CKM_HKDF_DERIVE, CKM_SP800_108_COUNTER_KDF, " +
+			"CKM_CONCATENATE_BASE_AND_KEY, CKM_AES_ECB_ENCRYPT_DATA). Accepts name with or without CKM_ prefix, or numeric value.",
+		PlanModifiers: []planmodifier.String{
+			shared.MechanismNormalizer{},
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+	attrs["mechanism_parameters"] = shared.MechanismParamsSchema()
+	attrs["base_key_label"] = schema.StringAttribute{
+		Required:    true,
+		Description: "Label or PKCS#11 URI of the base key on the token.",
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+	attrs["base_key_class"] = schema.StringAttribute{
+		Optional:    true,
+		Computed:    true,
+		Description: "Object class of the base key (default: CKO_SECRET_KEY). Use CKO_PRIVATE_KEY for ECDH.",
+		PlanModifiers: []planmodifier.String{
+			shared.EnumNormalizer{Enum: classEnum},
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+
+	resp.Schema = schema.Schema{
+		Description: "Derives a key from a base key on a PKCS#11 token using C_DeriveKey. " +
+			"The PKCS#11 attributes form the template of the derived key.",
+		Attributes: attrs,
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{Create: true, Read: true, Update: true, Delete: true}),
+		},
+	}
+}
+
+func (r *DerivedKeyResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Resource Configure Type",
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
+		return
+	}
+	r.clients = clients
+}
+
+func (r *DerivedKeyResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, cancel, diags := shared.CreateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var mechanismName string
+	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("mechanism"), &mechanismName)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	mechanismID, err := pkcs11client.MechanismEnum.Resolve(mechanismName)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid mechanism", err.Error())
+		return
+	}
+
+	params, diags := shared.MechanismParamsFrom(ctx, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Resolve key references (e.g. the second key of CKM_CONCATENATE_BASE_AND_KEY)
+	if params != nil && params.Key != nil {
+		if err := client.ResolveKeyRef(ctx, params.Key); err != nil {
+			resp.Diagnostics.AddError("Failed to find key referenced in mechanism_parameters",
+				fmt.Sprintf("Failed to find key with label %q: %s", params.Key.Label, err))
+			return
+		}
+	}
+
+	mechanism, freeMechanism, err := pkcs11client.NewMechanism(mechanismID, params)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid mechanism_parameters", err.Error())
+		return
+	}
+	defer freeMechanism()
+
+	baseKeyHandle, baseKeyClass, diags := r.findBaseKey(ctx, client, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	template, diags := shared.AttrsFromPlan(ctx, req.Plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := client.DeriveKey(ctx, mechanism, baseKeyHandle, template)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to derive key", err.Error())
+		return
+	}
+
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var baseKeyLabel types.String
+	req.Plan.GetAttribute(ctx, path.Root("base_key_label"), &baseKeyLabel)
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("mechanism"), mechanismName)...)
+	resp.Diagnostics.Append(shared.CopyMechanismParams(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("base_key_label"), baseKeyLabel.ValueString())...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("base_key_class"), baseKeyClass)...)
+	resp.Diagnostics.Append(shared.CopyTokenName(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyLoginAs(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
+}
+
+func (r *DerivedKeyResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	ctx, cancel, diags := shared.ReadContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
+	if err != nil {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
+}
+
+func (r *DerivedKeyResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	ctx, cancel, diags := shared.UpdateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to find key for update", err.Error())
+		return
+	}
+
+	planAttrs, diags := shared.AttrsFromPlan(ctx, req.Plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	stateAttrs, diags := shared.AttrsFromState(ctx, req.State)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	stateByType := make(map[uint]*pkcs11.Attribute, len(stateAttrs))
+	for _, a := range stateAttrs {
+		stateByType[a.Type] = a
+	}
+
+	var updates []*pkcs11.Attribute
+	for _, planned := range planAttrs {
+		def, ok := shared.AttrDefByType(planned.Type)
+		if !ok || def.Immutable || def.Computed {
+			continue
+		}
+		if !shared.AttributeValuesEqual(planned, stateByType[planned.Type]) {
+			updates = append(updates, planned)
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := client.SetAttributeValue(ctx, handle, updates); err != nil {
+			resp.Diagnostics.AddError("Failed to update key", err.Error())
+			return
+		}
+	}
+
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
+}
+
+func (r *DerivedKeyResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	ctx, cancel, diags := shared.DeleteContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
+	if err != nil {
+		return // Already gone
+	}
+
+	if err := client.DestroyObject(ctx, handle); err != nil {
+		resp.Diagnostics.AddError("Failed to destroy key", err.Error())
+	}
+}
+
+func (r *DerivedKeyResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	label, keyID, classID, diags := shared.ParseImportID(req.ID)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), req.ID)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("label"), label)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("key_id"), pkcs11client.EncodeBase64(keyID))...)
+	classEnum := pkcs11client.AttributeNameToDef["class"].Pkcs11Enum
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("class"), classEnum.Format(classID))...)
+}
+
+// findBaseKey locates the base key by label and class and returns its handle
+// along with the canonical class name.
+func (r *DerivedKeyResource) findBaseKey(ctx context.Context, client *pkcs11client.Client, src shared.AttrReader) (pkcs11.ObjectHandle, string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	var baseKeyLabel, baseKeyClass types.String
+	src.GetAttribute(ctx, path.Root("base_key_label"), &baseKeyLabel)
+	src.GetAttribute(ctx, path.Root("base_key_class"), &baseKeyClass)
+
+	classEnum := pkcs11client.AttributeNameToDef["class"].Pkcs11Enum
+	classID := uint(pkcs11.CKO_SECRET_KEY)
+	if !baseKeyClass.IsNull() && !baseKeyClass.IsUnknown() && baseKeyClass.ValueString() != "" {
+		var err error
+		classID, err = classEnum.Resolve(baseKeyClass.ValueString())
+		if err != nil {
+			diags.AddError("Invalid base_key_class", err.Error())
+			return 0, "", diags
+		}
+	}
+
+	handle, err := client.FindKey(ctx, baseKeyLabel.ValueString(), classID)
+	if err != nil {
+		diags.AddError("Failed to find base key", err.Error())
+		return 0, "", diags
+	}
+
+	return handle, classEnum.Format(classID), diags
+}
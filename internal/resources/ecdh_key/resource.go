@@ -0,0 +1,449 @@
+package ecdh_key
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/miekg/pkcs11"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
+	customtypes "blechschmidt.io/terraform-provider-pkcs11/internal/types"
+)
+
+var (
+	_ resource.Resource                = &ECDHKeyResource{}
+	_ resource.ResourceWithImportState = &ECDHKeyResource{}
+)
+
+type ECDHKeyResource struct {
+	clients *pkcs11client.Clients
+}
+
+func NewResource() resource.Resource {
+	return &ECDHKeyResource{}
+}
+
+func (r *ECDHKeyResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_ecdh_key"
+}
+
+func (r *ECDHKeyResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	attrs := shared.ObjectAttrSchema()
+	attrs["id"] = schema.StringAttribute{
+		Computed:    true,
+		Description: "Composite resource identifier (label/key_id_hex/CKO_SECRET_KEY).",
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.UseStateForUnknown(),
+		},
+	}
+	attrs["token_name"] = shared.TokenNameSchema()
+	attrs["login_as"] = shared.LoginAsSchema()
+	attrs["uri"] = shared.ObjectURISchema()
+	attrs["mechanism"] = schema.StringAttribute{
+		Optional:    true,
+		Computed:    true,
+		Description: "Key agreement mechanism, CKM_ECDH1_DERIVE (default) or CKM_ECDH1_COFACTOR_DERIVE.",
+		PlanModifiers: []planmodifier.String{
+			shared.MechanismNormalizer{},
+			stringplanmodifier.UseStateForUnknown(),
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+	attrs["private_key_label"] = schema.StringAttribute{
+		Required:    true,
+		Description: "Label or PKCS#11 URI of the EC private key on the token.",
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+	attrs["peer_public_key"] = schema.StringAttribute{
+		Required: true,
+		Description: "Public key of the other party: a PEM-encoded public key or certificate, or a base64-encoded " +
+			"DER SubjectPublicKeyInfo, DER-encoded EC point (as in CKA_EC_POINT) or raw EC point. " +
+			"It must be on the curve given by the ec_params of the private key.",
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+	attrs["peer_point_encoding"] = schema.StringAttribute{
+		Optional: true,
+		Computed: true,
+		Description: "Encoding of the peer EC point passed to the module: raw (default, as specified by PKCS#11) " +
+			"or der for modules that expect the DER-encoded CKA_EC_POINT format.",
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.UseStateForUnknown(),
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+	attrs["kdf"] = schema.StringAttribute{
+		Optional:    true,
+		Computed:    true,
+		Description: "Key derivation function applied to the shared secret (e.g. CKD_NULL, CKD_SHA256_KDF). Defaults to CKD_NULL.",
+		PlanModifiers: []planmodifier.String{
+			shared.EnumNormalizer{Enum: pkcs11client.KDFEnum},
+			stringplanmodifier.UseStateForUnknown(),
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+	attrs["shared_data"] = schema.StringAttribute{
+		Optional:    true,
+		Description: "Base64-encoded shared data for the KDF.",
+		Validators:  []validator.String{customtypes.Base64Validator{}},
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+
+	resp.Schema = schema.Schema{
+		Description: "Derives a key on a PKCS#11 token by ECDH key agreement between an EC private key on the token " +
+			"and an external peer public key, using C_DeriveKey. The PKCS#11 attributes form the template of the " +
+			"derived key. With CKD_NULL the shared secret becomes the key value; with a KDF such as CKD_SHA256_KDF " +
+			"the key (e.g. a wrapping key) is derived from the shared secret and shared_data.",
+		Attributes: attrs,
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{Create: true, Read: true, Update: true, Delete: true}),
+		},
+	}
+}
+
+func (r *ECDHKeyResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Resource Configure Type",
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
+		return
+	}
+	r.clients = clients
+}
+
+func (r *ECDHKeyResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, cancel, diags := shared.CreateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	src := shared.PlanReader{Plan: req.Plan}
+
+	mechanismName := stringOr(ctx, src, "mechanism", "CKM_ECDH1_DERIVE")
+	mechanismID, err := pkcs11client.MechanismEnum.Resolve(mechanismName)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid mechanism", err.Error())
+		return
+	}
+	if mechanismID != pkcs11.CKM_ECDH1_DERIVE && mechanismID != pkcs11.CKM_ECDH1_COFACTOR_DERIVE {
+		resp.Diagnostics.AddError("Invalid mechanism",
+			fmt.Sprintf("Expected CKM_ECDH1_DERIVE or CKM_ECDH1_COFACTOR_DERIVE, got %s", mechanismName))
+		return
+	}
+
+	kdfName := stringOr(ctx, src, "kdf", "CKD_NULL")
+	kdf, err := pkcs11client.KDFEnum.Resolve(kdfName)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid kdf", err.Error())
+		return
+	}
+
+	pointEncoding := stringOr(ctx, src, "peer_point_encoding", "raw")
+	if pointEncoding != "raw" && pointEncoding != "der" {
+		resp.Diagnostics.AddError("Invalid peer_point_encoding", fmt.Sprintf("Expected raw or der, got %q", pointEncoding))
+		return
+	}
+
+	var sharedData types.String
+	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("shared_data"), &sharedData)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	var sharedDataBytes []byte
+	if !sharedData.IsNull() {
+		sharedDataBytes, err = pkcs11client.DecodeBase64(sharedData.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid shared_data", fmt.Sprintf("not valid base64: %s", err))
+			return
+		}
+	}
+
+	peer, diags := r.parsePeerPublicKey(ctx, client, src)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var privateKeyLabel types.String
+	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("private_key_label"), &privateKeyLabel)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	privateKey, err := client.FindKey(ctx, privateKeyLabel.ValueString(), pkcs11.CKO_PRIVATE_KEY)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to find private key", err.Error())
+		return
+	}
+
+	publicData, err := client.ECDHPublicData(ctx, privateKey, peer, pointEncoding == "der")
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid peer_public_key", err.Error())
+		return
+	}
+
+	mechanism, freeMechanism, err := pkcs11client.NewMechanism(mechanismID, &pkcs11client.MechanismParams{
+		ECDH1: &pkcs11client.ECDH1Params{KDF: kdf, SharedData: sharedDataBytes, PublicData: publicData},
+	})
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid mechanism parameters", err.Error())
+		return
+	}
+	defer freeMechanism()
+
+	template, diags := shared.AttrsFromPlan(ctx, req.Plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := client.DeriveKey(ctx, mechanism, privateKey, template)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to derive key", err.Error())
+		return
+	}
+
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State, src)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var peerPublicKey types.String
+	req.Plan.GetAttribute(ctx, path.Root("peer_public_key"), &peerPublicKey)
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("mechanism"), mechanismName)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("kdf"), kdfName)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("peer_point_encoding"), pointEncoding)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("shared_data"), sharedData)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("private_key_label"), privateKeyLabel)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("peer_public_key"), peerPublicKey)...)
+	resp.Diagnostics.Append(shared.CopyTokenName(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyLoginAs(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
+}
+
+func (r *ECDHKeyResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	ctx, cancel, diags := shared.ReadContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
+	if err != nil {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
+}
+
+func (r *ECDHKeyResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	ctx, cancel, diags := shared.UpdateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to find key for update", err.Error())
+		return
+	}
+
+	planAttrs, diags := shared.AttrsFromPlan(ctx, req.Plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	stateAttrs, diags := shared.AttrsFromState(ctx, req.State)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	stateByType := make(map[uint]*pkcs11.Attribute, len(stateAttrs))
+	for _, a := range stateAttrs {
+		stateByType[a.Type] = a
+	}
+
+	var updates []*pkcs11.Attribute
+	for _, planned := range planAttrs {
+		def, ok := shared.AttrDefByType(planned.Type)
+		if !ok || def.Immutable || def.Computed {
+			continue
+		}
+		if !shared.AttributeValuesEqual(planned, stateByType[planned.Type]) {
+			updates = append(updates, planned)
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := client.SetAttributeValue(ctx, handle, updates); err != nil {
+			resp.Diagnostics.AddError("Failed to update key", err.Error())
+			return
+		}
+	}
+
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
+}
+
+func (r *ECDHKeyResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	ctx, cancel, diags := shared.DeleteContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
+	if err != nil {
+		return // Already gone
+	}
+
+	if err := client.DestroyObject(ctx, handle); err != nil {
+		resp.Diagnostics.AddError("Failed to destroy key", err.Error())
+	}
+}
+
+func (r *ECDHKeyResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	label, keyID, classID, diags := shared.ParseImportID(req.ID)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), req.ID)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("label"), label)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("key_id"), pkcs11client.EncodeBase64(keyID))...)
+	classEnum := pkcs11client.AttributeNameToDef["class"].Pkcs11Enum
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("class"), classEnum.Format(classID))...)
+}
+
+// parsePeerPublicKey reads peer_public_key, which is either PEM text or base64-encoded DER or point data.
+func (r *ECDHKeyResource) parsePeerPublicKey(ctx context.Context, client *pkcs11client.Client, src shared.AttrReader) (*pkcs11client.ECPublicKey, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	var value types.String
+	diags.Append(src.GetAttribute(ctx, path.Root("peer_public_key"), &value)...)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	data := []byte(value.ValueString())
+	if !strings.HasPrefix(strings.TrimSpace(value.ValueString()), "-----BEGIN") {
+		var err error
+		data, err = pkcs11client.DecodeBase64(strings.TrimSpace(value.ValueString()))
+		if err != nil {
+			diags.AddError("Invalid peer_public_key", fmt.Sprintf("Expected PEM or base64: %s", err))
+			return nil, diags
+		}
+	}
+
+	peer, err := pkcs11client.ParseECPublicKey(data)
+	if err != nil {
+		diags.AddError("Invalid peer_public_key", err.Error())
+		return nil, diags
+	}
+	return peer, diags
+}
+
+// stringOr returns the string attribute at name, or def if it is null or unknown.
+func stringOr(ctx context.Context, src shared.AttrReader, name, def string) string {
+	var v types.String
+	src.GetAttribute(ctx, path.Root(name), &v)
+	if v.IsNull() || v.IsUnknown() {
+		return def
+	}
+	return v.ValueString()
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
@@ -24,7 +25,7 @@ var (
 )
 
 type KeyPairResource struct {
-	client *pkcs11client.Client
+	clients *pkcs11client.Clients
 }
 
 func NewResource() resource.Resource {
@@ -35,7 +36,7 @@ func (r *KeyPairResource) Metadata(_ context.Context, req resource.MetadataReque
 	resp.TypeName = req.ProviderTypeName + "_key_pair"
 }
 
-func (r *KeyPairResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *KeyPairResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
 		Description: "Generates an asymmetric key pair on a PKCS#11 token using C_GenerateKeyPair. " +
 			"Public and private key attributes are specified separately in the public_key and private_key blocks.",
@@ -47,6 +48,8 @@ func (r *KeyPairResource) Schema(_ context.Context, _ resource.SchemaRequest, re
 					stringplanmodifier.UseStateForUnknown(),
 				},
 			},
+			"token_name": shared.TokenNameSchema(),
+			"login_as":   shared.LoginAsSchema(),
 			"mechanism": schema.StringAttribute{
 				Required:    true,
 				Description: "Key pair generation mechanism name (e.g., CKM_RSA_PKCS_KEY_PAIR_GEN, CKM_EC_KEY_PAIR_GEN). Accepts name with or without CKM_ prefix.",
@@ -55,45 +58,76 @@ func (r *KeyPairResource) Schema(_ context.Context, _ resource.SchemaRequest, re
 					stringplanmodifier.RequiresReplace(),
 				},
 			},
+			"mechanism_parameters": shared.MechanismParamsSchema(),
 			"public_key": schema.SingleNestedAttribute{
 				Required:    true,
 				Description: "Attributes for the public key template.",
-				Attributes:  shared.ObjectAttrSchema(),
+				Attributes:  keyAttrSchema(),
 			},
 			"private_key": schema.SingleNestedAttribute{
 				Required:    true,
 				Description: "Attributes for the private key template.",
-				Attributes:  shared.ObjectAttrSchema(),
+				Attributes:  keyAttrSchema(),
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{Create: true, Read: true, Update: true, Delete: true}),
+		},
 	}
 }
 
+// keyAttrSchema returns the attributes of the public_key and private_key blocks.
+func keyAttrSchema() map[string]schema.Attribute {
+	attrs := shared.ObjectAttrSchema()
+	attrs["uri"] = shared.URISchema("PKCS#11 URI (RFC 7512) of the key, for use with tools such as OpenSSL or p11tool.")
+	return attrs
+}
+
 func (r *KeyPairResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
 	if req.ProviderData == nil {
 		return
 	}
-	client, ok := req.ProviderData.(*pkcs11client.Client)
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
 	if !ok {
 		resp.Diagnostics.AddError("Unexpected Resource Configure Type",
-			fmt.Sprintf("Expected *pkcs11client.Client, got: %T", req.ProviderData))
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
 		return
 	}
-	r.client = client
+	r.clients = clients
 }
 
 func (r *KeyPairResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, cancel, diags := shared.CreateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	var mechanismName string
 	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("mechanism"), &mechanismName)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	mechanismID, err := pkcs11client.MechanismEnum.Resolve(mechanismName)
-	if err != nil {
-		resp.Diagnostics.AddError("Invalid mechanism", err.Error())
+	mechanism, freeMechanism, diags := shared.BuildMechanism(ctx, shared.PlanReader{Plan: req.Plan}, mechanismName)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
 		return
 	}
+	defer freeMechanism()
 
 	pubAttrs, diags := shared.AttrsFromNestedPlan(ctx, req.Plan, "public_key")
 	resp.Diagnostics.Append(diags...)
@@ -107,31 +141,53 @@ func (r *KeyPairResource) Create(ctx context.Context, req resource.CreateRequest
 		return
 	}
 
-	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, nil)}
-	pubHandle, privHandle, err := r.client.GenerateKeyPair(mechanism, pubAttrs, privAttrs)
+	pubHandle, privHandle, err := client.GenerateKeyPair(ctx, mechanism, pubAttrs, privAttrs)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to generate key pair", err.Error())
 		return
 	}
 
-	diags = r.readBothKeysIntoState(ctx, pubHandle, privHandle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	diags = r.readBothKeysIntoState(ctx, client, pubHandle, privHandle, &resp.State, shared.PlanReader{Plan: req.Plan})
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("mechanism"), mechanismName)...)
+	resp.Diagnostics.Append(shared.CopyMechanismParams(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyTokenName(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyLoginAs(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildKeyPairID(ctx, &resp.State, "public_key"))...)
 }
 
 func (r *KeyPairResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
-	pubHandle, privHandle, err := r.findBothKeys(ctx, req.State)
+	ctx, cancel, diags := shared.ReadContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	pubHandle, privHandle, err := r.findBothKeys(ctx, client, req.State)
 	if err != nil {
 		resp.State.RemoveResource(ctx)
 		return
 	}
 
-	diags := r.readBothKeysIntoState(ctx, pubHandle, privHandle, &resp.State)
+	diags = r.readBothKeysIntoState(ctx, client, pubHandle, privHandle, &resp.State)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
@@ -141,7 +197,26 @@ func (r *KeyPairResource) Read(ctx context.Context, req resource.ReadRequest, re
 }
 
 func (r *KeyPairResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
-	pubHandle, privHandle, err := r.findBothKeys(ctx, req.State)
+	ctx, cancel, diags := shared.UpdateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	pubHandle, privHandle, err := r.findBothKeys(ctx, client, req.State)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to find key pair for update", err.Error())
 		return
@@ -175,37 +250,57 @@ func (r *KeyPairResource) Update(ctx context.Context, req resource.UpdateRequest
 	privUpdates := computeUpdates(privPlanAttrs, privStateAttrs)
 
 	if len(pubUpdates) > 0 {
-		if err := r.client.SetAttributeValue(pubHandle, pubUpdates); err != nil {
+		if err := client.SetAttributeValue(ctx, pubHandle, pubUpdates); err != nil {
 			resp.Diagnostics.AddError("Failed to update public key", err.Error())
 			return
 		}
 	}
 	if len(privUpdates) > 0 {
-		if err := r.client.SetAttributeValue(privHandle, privUpdates); err != nil {
+		if err := client.SetAttributeValue(ctx, privHandle, privUpdates); err != nil {
 			resp.Diagnostics.AddError("Failed to update private key", err.Error())
 			return
 		}
 	}
 
-	diags = r.readBothKeysIntoState(ctx, pubHandle, privHandle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	diags = r.readBothKeysIntoState(ctx, client, pubHandle, privHandle, &resp.State, shared.PlanReader{Plan: req.Plan})
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildKeyPairID(ctx, &resp.State, "public_key"))...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
 }
 
 func (r *KeyPairResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
-	pubHandle, privHandle, err := r.findBothKeys(ctx, req.State)
+	ctx, cancel, diags := shared.DeleteContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	pubHandle, privHandle, err := r.findBothKeys(ctx, client, req.State)
 	if err != nil {
 		return // Already gone
 	}
 
-	if err := r.client.DestroyObject(pubHandle); err != nil {
+	if err := client.DestroyObject(ctx, pubHandle); err != nil {
 		resp.Diagnostics.AddError("Failed to destroy public key", err.Error())
 	}
-	if err := r.client.DestroyObject(privHandle); err != nil {
+	if err := client.DestroyObject(ctx, privHandle); err != nil {
 		resp.Diagnostics.AddError("Failed to destroy private key", err.Error())
 	}
 }
@@ -234,12 +329,12 @@ func (r *KeyPairResource) ImportState(ctx context.Context, req resource.ImportSt
 }
 
 // findBothKeys locates the public and private key objects using label + key_id from nested state blocks.
-func (r *KeyPairResource) findBothKeys(ctx context.Context, state tfsdk.State) (pub, priv pkcs11.ObjectHandle, err error) {
-	pub, err = shared.FindObjectFromNested(ctx, r.client, state, "public_key", pkcs11.CKO_PUBLIC_KEY)
+func (r *KeyPairResource) findBothKeys(ctx context.Context, client *pkcs11client.Client, state tfsdk.State) (pub, priv pkcs11.ObjectHandle, err error) {
+	pub, err = shared.FindObjectFromNested(ctx, client, state, "public_key", pkcs11.CKO_PUBLIC_KEY)
 	if err != nil {
 		return 0, 0, fmt.Errorf("public key: %w", err)
 	}
-	priv, err = shared.FindObjectFromNested(ctx, r.client, state, "private_key", pkcs11.CKO_PRIVATE_KEY)
+	priv, err = shared.FindObjectFromNested(ctx, client, state, "private_key", pkcs11.CKO_PRIVATE_KEY)
 	if err != nil {
 		return 0, 0, fmt.Errorf("private key: %w", err)
 	}
@@ -247,15 +342,21 @@ func (r *KeyPairResource) findBothKeys(ctx context.Context, state tfsdk.State) (
 }
 
 // readBothKeysIntoState reads attributes from both keys into their respective nested state blocks.
-func (r *KeyPairResource) readBothKeysIntoState(ctx context.Context, pubHandle, privHandle pkcs11.ObjectHandle, state *tfsdk.State, ref ...shared.AttrReader) diag.Diagnostics {
+func (r *KeyPairResource) readBothKeysIntoState(ctx context.Context, client *pkcs11client.Client, pubHandle, privHandle pkcs11.ObjectHandle, state *tfsdk.State, ref ...shared.AttrReader) diag.Diagnostics {
 	var diags diag.Diagnostics
 
-	diags.Append(shared.ReadObjectIntoNestedState(ctx, r.client, pubHandle, state, "public_key", ref...)...)
+	diags.Append(shared.ReadObjectIntoNestedState(ctx, client, pubHandle, state, "public_key", ref...)...)
+	if diags.HasError() {
+		return diags
+	}
+
+	diags.Append(shared.ReadObjectIntoNestedState(ctx, client, privHandle, state, "private_key", ref...)...)
 	if diags.HasError() {
 		return diags
 	}
 
-	diags.Append(shared.ReadObjectIntoNestedState(ctx, r.client, privHandle, state, "private_key", ref...)...)
+	diags.Append(shared.SetObjectURI(ctx, client, pubHandle, state, path.Root("public_key").AtName("uri"))...)
+	diags.Append(shared.SetObjectURI(ctx, client, privHandle, state, path.Root("private_key").AtName("uri"))...)
 	return diags
 }
 
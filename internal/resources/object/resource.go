@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
@@ -22,7 +23,7 @@ var (
 )
 
 type ObjectResource struct {
-	client *pkcs11client.Client
+	clients *pkcs11client.Clients
 }
 
 func NewResource() resource.Resource {
@@ -33,7 +34,7 @@ func (r *ObjectResource) Metadata(_ context.Context, req resource.MetadataReques
 	resp.TypeName = req.ProviderTypeName + "_object"
 }
 
-func (r *ObjectResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *ObjectResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	attrs := shared.ObjectAttrSchema()
 	attrs["id"] = schema.StringAttribute{
 		Computed:    true,
@@ -42,11 +43,17 @@ func (r *ObjectResource) Schema(_ context.Context, _ resource.SchemaRequest, res
 			stringplanmodifier.UseStateForUnknown(),
 		},
 	}
+	attrs["token_name"] = shared.TokenNameSchema()
+	attrs["login_as"] = shared.LoginAsSchema()
+	attrs["uri"] = shared.ObjectURISchema()
 
 	resp.Schema = schema.Schema{
 		Description: "Manages a generic PKCS#11 object on a token. All attributes can be specified manually, " +
 			"providing full control over object creation for any object type.",
 		Attributes: attrs,
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{Create: true, Read: true, Update: true, Delete: true}),
+		},
 	}
 }
 
@@ -54,16 +61,35 @@ func (r *ObjectResource) Configure(_ context.Context, req resource.ConfigureRequ
 	if req.ProviderData == nil {
 		return
 	}
-	client, ok := req.ProviderData.(*pkcs11client.Client)
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
 	if !ok {
 		resp.Diagnostics.AddError("Unexpected Resource Configure Type",
-			fmt.Sprintf("Expected *pkcs11client.Client, got: %T", req.ProviderData))
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
 		return
 	}
-	r.client = client
+	r.clients = clients
 }
 
 func (r *ObjectResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, cancel, diags := shared.CreateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	pkcsAttrs, diags := shared.AttrsFromPlan(ctx, req.Plan)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
@@ -75,39 +101,82 @@ func (r *ObjectResource) Create(ctx context.Context, req resource.CreateRequest,
 		return
 	}
 
-	handle, err := r.client.CreateObject(pkcsAttrs)
+	handle, err := client.CreateObject(ctx, pkcsAttrs)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to create object", err.Error())
 		return
 	}
 
-	diags = shared.ReadObjectIntoState(ctx, r.client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	resp.Diagnostics.Append(shared.CopyTokenName(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyLoginAs(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
 }
 
 func (r *ObjectResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
-	handle, err := shared.FindObject(ctx, r.client, req.State)
+	ctx, cancel, diags := shared.ReadContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
 	if err != nil {
 		resp.State.RemoveResource(ctx)
 		return
 	}
 
-	diags := shared.ReadObjectIntoState(ctx, r.client, handle, &resp.State)
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
 }
 
 func (r *ObjectResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
-	handle, err := shared.FindObject(ctx, r.client, req.State)
+	ctx, cancel, diags := shared.UpdateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to find object for update", err.Error())
 		return
@@ -142,28 +211,49 @@ func (r *ObjectResource) Update(ctx context.Context, req resource.UpdateRequest,
 	}
 
 	if len(updates) > 0 {
-		if err := r.client.SetAttributeValue(handle, updates); err != nil {
+		if err := client.SetAttributeValue(ctx, handle, updates); err != nil {
 			resp.Diagnostics.AddError("Failed to update object", err.Error())
 			return
 		}
 	}
 
-	diags = shared.ReadObjectIntoState(ctx, r.client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
 }
 
 func (r *ObjectResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
-	handle, err := shared.FindObject(ctx, r.client, req.State)
+	ctx, cancel, diags := shared.DeleteContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
 	if err != nil {
 		return // Already gone
 	}
 
-	if err := r.client.DestroyObject(handle); err != nil {
+	if err := client.DestroyObject(ctx, handle); err != nil {
 		resp.Diagnostics.AddError("Failed to destroy object", err.Error())
 	}
 }
@@ -0,0 +1,384 @@
+package object_copy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/miekg/pkcs11"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
+	customtypes "blechschmidt.io/terraform-provider-pkcs11/internal/types"
+)
+
+var _ resource.Resource = &ObjectCopyResource{}
+
+type ObjectCopyResource struct {
+	clients *pkcs11client.Clients
+}
+
+func NewResource() resource.Resource {
+	return &ObjectCopyResource{}
+}
+
+func (r *ObjectCopyResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_object_copy"
+}
+
+func (r *ObjectCopyResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	classEnum := pkcs11client.AttributeNameToDef["class"].Pkcs11Enum
+
+	attrs := shared.ObjectAttrSchema()
+	attrs["id"] = schema.StringAttribute{
+		Computed:    true,
+		Description: "Composite resource identifier (label/key_id_hex/class).",
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.UseStateForUnknown(),
+		},
+	}
+	attrs["token_name"] = shared.TokenNameSchema()
+	attrs["login_as"] = shared.LoginAsSchema()
+	attrs["uri"] = shared.ObjectURISchema()
+	attrs["source_label"] = schema.StringAttribute{
+		Required:    true,
+		Description: "Label or PKCS#11 URI of the object to copy.",
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+	attrs["source_class"] = schema.StringAttribute{
+		Optional:    true,
+		Computed:    true,
+		Description: "Object class of the object to copy (default: CKO_SECRET_KEY).",
+		PlanModifiers: []planmodifier.String{
+			shared.EnumNormalizer{Enum: classEnum},
+			stringplanmodifier.UseStateForUnknown(),
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+	attrs["source_key_id"] = schema.StringAttribute{
+		Optional:    true,
+		Description: "Base64-encoded CKA_ID of the object to copy, to disambiguate objects with the same label.",
+		Validators:  []validator.String{customtypes.Base64Validator{}},
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+
+	resp.Schema = schema.Schema{
+		Description: "Copies an object on a PKCS#11 token using C_CopyObject. The PKCS#11 attributes that are set " +
+			"override those of the source in the copy, e.g. a new label, token = true for a session object, or " +
+			"restricted usage flags for a non-extractable key. The copy must differ from the source in label or key_id.",
+		Attributes: attrs,
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{Create: true, Read: true, Update: true, Delete: true}),
+		},
+	}
+}
+
+func (r *ObjectCopyResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Resource Configure Type",
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
+		return
+	}
+	r.clients = clients
+}
+
+func (r *ObjectCopyResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, cancel, diags := shared.CreateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	sourceHandle, sourceClass, diags := r.findSource(ctx, client, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	template, diags := shared.AttrsFromPlan(ctx, req.Plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// The copy is located by label, key_id and class later, so it must not be
+	// indistinguishable from its source.
+	sourceAttrs, err := client.GetObjectAttributes(ctx, sourceHandle, []uint{pkcs11.CKA_LABEL, pkcs11.CKA_ID})
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to read source object", err.Error())
+		return
+	}
+	overridden := false
+	for _, a := range template {
+		if (a.Type == pkcs11.CKA_LABEL || a.Type == pkcs11.CKA_ID) && !bytes.Equal(a.Value, sourceAttrs[a.Type]) {
+			overridden = true
+		}
+	}
+	if !overridden {
+		resp.Diagnostics.AddError("Copy indistinguishable from source",
+			"Set label or key_id to a value different from the source object.")
+		return
+	}
+
+	handle, err := client.CopyObject(ctx, sourceHandle, template)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to copy object", err.Error())
+		return
+	}
+
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var sourceLabel, sourceKeyID types.String
+	req.Plan.GetAttribute(ctx, path.Root("source_label"), &sourceLabel)
+	req.Plan.GetAttribute(ctx, path.Root("source_key_id"), &sourceKeyID)
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("source_label"), sourceLabel)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("source_class"), sourceClass)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("source_key_id"), sourceKeyID)...)
+	resp.Diagnostics.Append(shared.CopyTokenName(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyLoginAs(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
+}
+
+func (r *ObjectCopyResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	ctx, cancel, diags := shared.ReadContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
+	if err != nil {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
+}
+
+func (r *ObjectCopyResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	ctx, cancel, diags := shared.UpdateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to find object for update", err.Error())
+		return
+	}
+
+	planAttrs, diags := shared.AttrsFromPlan(ctx, req.Plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	stateAttrs, diags := shared.AttrsFromState(ctx, req.State)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	stateByType := make(map[uint]*pkcs11.Attribute, len(stateAttrs))
+	for _, a := range stateAttrs {
+		stateByType[a.Type] = a
+	}
+
+	var updates []*pkcs11.Attribute
+	for _, planned := range planAttrs {
+		def, ok := shared.AttrDefByType(planned.Type)
+		if !ok || def.Immutable || def.Computed {
+			continue
+		}
+		if !shared.AttributeValuesEqual(planned, stateByType[planned.Type]) {
+			updates = append(updates, planned)
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := client.SetAttributeValue(ctx, handle, updates); err != nil {
+			resp.Diagnostics.AddError("Failed to update object", err.Error())
+			return
+		}
+	}
+
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
+}
+
+func (r *ObjectCopyResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	ctx, cancel, diags := shared.DeleteContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
+	if err != nil {
+		return // Already gone
+	}
+
+	if err := client.DestroyObject(ctx, handle); err != nil {
+		resp.Diagnostics.AddError("Failed to destroy object", err.Error())
+	}
+}
+
+// findSource locates the source object by label, class and optional CKA_ID,
+// or by a PKCS#11 URI in source_label, and returns its handle along with the
+// canonical class name.
+func (r *ObjectCopyResource) findSource(ctx context.Context, client *pkcs11client.Client, src shared.AttrReader) (pkcs11.ObjectHandle, string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	var sourceLabel, sourceClass, sourceKeyID types.String
+	src.GetAttribute(ctx, path.Root("source_label"), &sourceLabel)
+	src.GetAttribute(ctx, path.Root("source_class"), &sourceClass)
+	src.GetAttribute(ctx, path.Root("source_key_id"), &sourceKeyID)
+
+	classEnum := pkcs11client.AttributeNameToDef["class"].Pkcs11Enum
+	classID := uint(pkcs11.CKO_SECRET_KEY)
+	if !sourceClass.IsNull() && !sourceClass.IsUnknown() && sourceClass.ValueString() != "" {
+		var err error
+		classID, err = classEnum.Resolve(sourceClass.ValueString())
+		if err != nil {
+			diags.AddError("Invalid source_class", err.Error())
+			return 0, "", diags
+		}
+	}
+
+	if pkcs11client.IsURI(sourceLabel.ValueString()) {
+		uri, err := pkcs11client.ParseURI(sourceLabel.ValueString())
+		if err != nil {
+			diags.AddError("Invalid source_label", err.Error())
+			return 0, "", diags
+		}
+		if !sourceKeyID.IsNull() && !sourceKeyID.IsUnknown() {
+			diags.AddError("Conflicting source identifiers", "source_key_id cannot be combined with a PKCS#11 URI in source_label, use its id attribute instead")
+			return 0, "", diags
+		}
+		if uriClass, ok := uri.Class(); ok {
+			if !sourceClass.IsNull() && !sourceClass.IsUnknown() && sourceClass.ValueString() != "" && uriClass != classID {
+				diags.AddError("Conflicting source class", "source_class does not match the type attribute of the PKCS#11 URI in source_label")
+				return 0, "", diags
+			}
+			classID = uriClass
+		}
+		handle, err := client.FindKey(ctx, sourceLabel.ValueString(), classID)
+		if err != nil {
+			diags.AddError("Failed to find source object", err.Error())
+			return 0, "", diags
+		}
+		return handle, classEnum.Format(classID), diags
+	}
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, sourceLabel.ValueString()),
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, classID),
+	}
+	if !sourceKeyID.IsNull() && !sourceKeyID.IsUnknown() {
+		id, err := pkcs11client.DecodeBase64(sourceKeyID.ValueString())
+		if err != nil {
+			diags.AddError("Invalid source_key_id", fmt.Sprintf("Failed to decode base64: %s", err))
+			return 0, "", diags
+		}
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+
+	handle, err := client.FindOneObject(ctx, template)
+	if err != nil {
+		diags.AddError("Failed to find source object", err.Error())
+		return 0, "", diags
+	}
+
+	return handle, classEnum.Format(classID), diags
+}
@@ -0,0 +1,167 @@
+package pin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/miekg/pkcs11"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
+)
+
+var _ resource.Resource = &PinResource{}
+
+type PinResource struct {
+	clients *pkcs11client.Clients
+}
+
+func NewResource() resource.Resource {
+	return &PinResource{}
+}
+
+func (r *PinResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_pin"
+}
+
+func (r *PinResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Changes the user or SO PIN of the configured token via C_SetPIN. The PIN is changed on creation " +
+			"and again whenever user_type or rotation_trigger changes. The PINs are write-only and never stored in state. " +
+			"Later operations in the same run use the new PIN; the provider configuration must be updated for subsequent runs. " +
+			"Destroying the resource leaves the PIN unchanged.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:    true,
+				Description: "User type and time of the last PIN change.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"token_name": shared.TokenNameSchema(),
+			"uri":        shared.URISchema("PKCS#11 URI (RFC 7512) of the token."),
+			"user_type": schema.StringAttribute{
+				Optional:    true,
+				Computed:    true,
+				Description: "User whose PIN is changed: CKU_USER (default) or CKU_SO.",
+				PlanModifiers: []planmodifier.String{
+					shared.EnumNormalizer{Enum: pkcs11client.UserTypeEnum},
+					stringplanmodifier.UseStateForUnknown(),
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"old_pin": schema.StringAttribute{
+				Optional:    true,
+				Sensitive:   true,
+				WriteOnly:   true,
+				Description: "Current PIN. Defaults to pin or so_pin of the provider configuration, depending on user_type.",
+			},
+			"new_pin": schema.StringAttribute{
+				Required:    true,
+				Sensitive:   true,
+				WriteOnly:   true,
+				Description: "New PIN.",
+			},
+			"rotation_trigger": schema.StringAttribute{
+				Optional:    true,
+				Description: "Arbitrary value such as the rotation date. Changing it changes the PIN again with the configured old_pin and new_pin.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"rotated_at": schema.StringAttribute{
+				Computed:    true,
+				Description: "RFC 3339 timestamp of the last PIN change.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{Create: true}),
+		},
+	}
+}
+
+func (r *PinResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Resource Configure Type",
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
+		return
+	}
+	r.clients = clients
+}
+
+func (r *PinResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, cancel, diags := shared.CreateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var userType, oldPin, newPin types.String
+	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("user_type"), &userType)...)
+	// Write-only values are only available in the configuration
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("old_pin"), &oldPin)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("new_pin"), &newPin)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	userTypeName := "CKU_USER"
+	if !userType.IsNull() && !userType.IsUnknown() {
+		userTypeName = userType.ValueString()
+	}
+	userTypeID, err := pkcs11client.UserTypeEnum.Resolve(userTypeName)
+	if err != nil || (userTypeID != pkcs11.CKU_USER && userTypeID != pkcs11.CKU_SO) {
+		resp.Diagnostics.AddAttributeError(path.Root("user_type"), "Invalid user_type",
+			fmt.Sprintf("Expected CKU_USER or CKU_SO, got %q", userTypeName))
+		return
+	}
+	userTypeName = pkcs11client.UserTypeEnum.Format(userTypeID)
+
+	if err := client.SetPIN(ctx, userTypeID, oldPin.ValueString(), newPin.ValueString()); err != nil {
+		resp.Diagnostics.AddError("Failed to change PIN", err.Error())
+		return
+	}
+
+	rotatedAt := time.Now().UTC().Format(time.RFC3339)
+	resp.State.Raw = req.Plan.Raw
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), userTypeName+"/"+rotatedAt)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("user_type"), userTypeName)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("rotated_at"), rotatedAt)...)
+	resp.Diagnostics.Append(shared.SetTokenURI(ctx, client, &resp.State)...)
+}
+
+func (r *PinResource) Read(_ context.Context, _ resource.ReadRequest, _ *resource.ReadResponse) {
+	// A PIN cannot be read back from the token.
+}
+
+func (r *PinResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	// All stored arguments except the timeouts force replacement; changes to the
+	// write-only PINs alone do not rotate.
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
+}
+
+func (r *PinResource) Delete(_ context.Context, _ resource.DeleteRequest, _ *resource.DeleteResponse) {
+	// The PIN stays as it is.
+}
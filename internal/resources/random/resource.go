@@ -0,0 +1,214 @@
+package random
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/mapplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
+	customtypes "blechschmidt.io/terraform-provider-pkcs11/internal/types"
+)
+
+// defaultAlphabet is used for the password output when no alphabet is configured.
+const defaultAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+var _ resource.Resource = &RandomResource{}
+
+type RandomResource struct {
+	clients *pkcs11client.Clients
+}
+
+func NewResource() resource.Resource {
+	return &RandomResource{}
+}
+
+func (r *RandomResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_random"
+}
+
+func (r *RandomResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Generates random data with the token RNG via C_GenerateRandom. The value is generated once " +
+			"and kept in state until one of the arguments, including keepers, changes.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:    true,
+				Description: "Random identifier, independent of the generated values.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"token_name": shared.TokenNameSchema(),
+			"uri":        shared.URISchema("PKCS#11 URI (RFC 7512) of the token."),
+			"length": schema.Int64Attribute{
+				Required:    true,
+				Description: "Number of random bytes for result and result_hex, and number of characters for password.",
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.RequiresReplace(),
+				},
+			},
+			"alphabet": schema.StringAttribute{
+				Optional:    true,
+				Description: "Characters the password is drawn from, each with equal probability. Defaults to ASCII letters and digits.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"seed": schema.StringAttribute{
+				Optional:    true,
+				Sensitive:   true,
+				Description: "Base64-encoded additional entropy mixed into the token RNG via C_SeedRandom before generating. Not supported by all tokens.",
+				Validators:  []validator.String{customtypes.Base64Validator{}},
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"keepers": schema.MapAttribute{
+				Optional:    true,
+				ElementType: types.StringType,
+				Description: "Arbitrary map of values that, when changed, trigger generation of new random data.",
+				PlanModifiers: []planmodifier.Map{
+					mapplanmodifier.RequiresReplace(),
+				},
+			},
+			"result": schema.StringAttribute{
+				Computed:    true,
+				Sensitive:   true,
+				Description: "Base64-encoded random bytes.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"result_hex": schema.StringAttribute{
+				Computed:    true,
+				Sensitive:   true,
+				Description: "Hex-encoded random bytes.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"password": schema.StringAttribute{
+				Computed:    true,
+				Sensitive:   true,
+				Description: "Random string of length characters drawn from alphabet, generated independently of result.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{Create: true}),
+		},
+	}
+}
+
+func (r *RandomResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Resource Configure Type",
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
+		return
+	}
+	r.clients = clients
+}
+
+func (r *RandomResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, cancel, diags := shared.CreateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var length types.Int64
+	var alphabet, seed types.String
+	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("length"), &length)...)
+	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("alphabet"), &alphabet)...)
+	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("seed"), &seed)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if length.ValueInt64() < 1 {
+		resp.Diagnostics.AddAttributeError(path.Root("length"), "Invalid length",
+			fmt.Sprintf("length must be at least 1, got %d", length.ValueInt64()))
+		return
+	}
+	n := int(length.ValueInt64())
+
+	chars := defaultAlphabet
+	if !alphabet.IsNull() {
+		chars = alphabet.ValueString()
+	}
+
+	if !seed.IsNull() {
+		seedBytes, err := pkcs11client.DecodeBase64(seed.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddError("Invalid seed", fmt.Sprintf("Failed to decode base64: %s", err))
+			return
+		}
+		if err := client.SeedRandom(ctx, seedBytes); err != nil {
+			resp.Diagnostics.AddError("Failed to seed random number generator", err.Error())
+			return
+		}
+	}
+
+	random, err := client.GenerateRandom(ctx, n)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to generate random data", err.Error())
+		return
+	}
+
+	password, err := client.RandomString(ctx, n, chars)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to generate password", err.Error())
+		return
+	}
+
+	id, err := client.GenerateRandom(ctx, 16)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to generate random data", err.Error())
+		return
+	}
+
+	resp.State.Raw = req.Plan.Raw
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), pkcs11client.EncodeHex(id))...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("result"), pkcs11client.EncodeBase64(random))...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("result_hex"), pkcs11client.EncodeHex(random))...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("password"), password)...)
+	resp.Diagnostics.Append(shared.SetTokenURI(ctx, client, &resp.State)...)
+}
+
+func (r *RandomResource) Read(_ context.Context, _ resource.ReadRequest, _ *resource.ReadResponse) {
+	// The random data only exists in state.
+}
+
+func (r *RandomResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	// All arguments except the timeouts force replacement, so there is nothing
+	// to update on the token.
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
+}
+
+func (r *RandomResource) Delete(_ context.Context, _ resource.DeleteRequest, _ *resource.DeleteResponse) {
+	// Nothing to clean up on the token.
+}
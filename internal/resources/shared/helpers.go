@@ -370,7 +370,7 @@ func ReadObjectIntoNestedState(ctx context.Context, client *pkcs11client.Client,
 func readObjectIntoStateAt(ctx context.Context, client *pkcs11client.Client, handle pkcs11.ObjectHandle, state *tfsdk.State, pathFn func(string) path.Path, ref AttrReader) diag.Diagnostics {
 	var diags diag.Diagnostics
 
-	rawAttrs := client.GetAllObjectAttributes(handle)
+	rawAttrs := client.GetAllObjectAttributes(ctx, handle)
 
 	for _, def := range pkcs11client.ObjectAttrs {
 		val, ok := rawAttrs[def.Type]
@@ -453,7 +453,7 @@ func FindObject(ctx context.Context, client *pkcs11client.Client, state tfsdk.St
 		return 0, fmt.Errorf("no identifying attributes (label, class, key_id) found in state")
 	}
 
-	return client.FindOneObject(template)
+	return client.FindOneObject(ctx, template)
 }
 
 // FindObjectWithClass locates a PKCS#11 object using label + key_id from state, with an explicit class override.
@@ -487,7 +487,7 @@ func findObjectWithClassAt(ctx context.Context, client *pkcs11client.Client, sta
 		}
 	}
 
-	return client.FindOneObject(template)
+	return client.FindOneObject(ctx, template)
 }
 
 // BuildObjectID builds a composite resource ID from label + key_id + class in state.
@@ -0,0 +1,62 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+)
+
+// Values of the login_as attribute.
+const (
+	LoginAsUser = "user"
+	LoginAsSO   = "so"
+)
+
+// LoginAsSchema returns the login_as attribute for resources that manage objects.
+func LoginAsSchema() schema.StringAttribute {
+	return schema.StringAttribute{
+		Optional: true,
+		Description: "User to log in as for managing the object: user (default) or so for the Security Officer, " +
+			"which is required e.g. to set trusted. Requires so_pin in the provider configuration. " +
+			"The Security Officer can only access public objects.",
+	}
+}
+
+// LoginContext returns a context for operations as the user selected by the
+// login_as attribute in src. The Security Officer stays logged in with a single
+// session until the returned function is called at the end of the operation.
+func LoginContext(ctx context.Context, src AttrReader) (context.Context, func(), diag.Diagnostics) {
+	noop := func() {}
+	var loginAs types.String
+	diags := src.GetAttribute(ctx, path.Root("login_as"), &loginAs)
+	if diags.HasError() {
+		return ctx, noop, diags
+	}
+
+	switch loginAs.ValueString() {
+	case "", LoginAsUser:
+		return ctx, noop, diags
+	case LoginAsSO:
+		soCtx, end := pkcs11client.AsSecurityOfficer(ctx, "")
+		return soCtx, end, diags
+	}
+	diags.AddAttributeError(path.Root("login_as"), "Invalid login_as",
+		fmt.Sprintf("Expected %q or %q, got %q", LoginAsUser, LoginAsSO, loginAs.ValueString()))
+	return ctx, noop, diags
+}
+
+// CopyLoginAs copies login_as from the plan into state, since it is not
+// populated by ReadObjectIntoState.
+func CopyLoginAs(ctx context.Context, plan tfsdk.Plan, state *tfsdk.State) diag.Diagnostics {
+	var loginAs types.String
+	diags := plan.GetAttribute(ctx, path.Root("login_as"), &loginAs)
+	diags.Append(state.SetAttribute(ctx, path.Root("login_as"), loginAs)...)
+	return diags
+}
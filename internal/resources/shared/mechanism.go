@@ -0,0 +1,431 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+
+	dsschema "github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/objectplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/miekg/pkcs11"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	customtypes "blechschmidt.io/terraform-provider-pkcs11/internal/types"
+)
+
+// paramKind describes how a mechanism parameter field is encoded in Terraform.
+type paramKind int
+
+const (
+	paramBytes  paramKind = iota // base64 string
+	paramString                  // plain string (constant names)
+	paramUlong                   // number
+	paramBool                    // boolean
+)
+
+// paramField describes a single field of a mechanism parameter structure.
+type paramField struct {
+	name     string
+	kind     paramKind
+	required bool
+	desc     string
+}
+
+// paramGroup describes a typed mechanism parameter structure (e.g. gcm, oaep).
+type paramGroup struct {
+	name   string
+	desc   string
+	fields []paramField
+}
+
+// mechanismParamLeaves are top-level parameter fields that are not structures.
+var mechanismParamLeaves = []paramField{
+	{"raw", paramBytes, false, "Base64-encoded raw mechanism parameter, passed to the module unchanged. Use for vendor-defined mechanisms."},
+	{"iv", paramBytes, false, "Base64-encoded initialization vector (e.g. for CKM_AES_CBC, CKM_AES_CBC_PAD)."},
+	{"derivation_data", paramBytes, false, "Base64-encoded CK_KEY_DERIVATION_STRING_DATA for CKM_*_ECB_ENCRYPT_DATA, CKM_CONCATENATE_BASE_AND_DATA, CKM_CONCATENATE_DATA_AND_BASE and CKM_XOR_BASE_AND_DATA."},
+}
+
+// mechanismParamGroups lists the typed parameter structures supported by pkcs11client.NewMechanism.
+var mechanismParamGroups = []paramGroup{
+	{"gcm", "CK_GCM_PARAMS for CKM_AES_GCM.", []paramField{
+		{"iv", paramBytes, true, "Base64-encoded initialization vector."},
+		{"aad", paramBytes, false, "Base64-encoded additional authenticated data."},
+		{"tag_bits", paramUlong, false, "Authentication tag length in bits (default: 128)."},
+	}},
+	{"ccm", "CK_CCM_PARAMS for CKM_AES_CCM.", []paramField{
+		{"nonce", paramBytes, true, "Base64-encoded nonce."},
+		{"aad", paramBytes, false, "Base64-encoded additional authenticated data."},
+		{"mac_len", paramUlong, false, "MAC length in bytes (default: 16)."},
+		{"data_len", paramUlong, false, "Length of the message in bytes. Derived from the input by the encrypt and decrypt data sources when omitted."},
+	}},
+	{"oaep", "CK_RSA_PKCS_OAEP_PARAMS for CKM_RSA_PKCS_OAEP.", []paramField{
+		{"hash", paramString, true, "Digest mechanism (e.g. CKM_SHA256)."},
+		{"mgf", paramString, false, "Mask generation function (e.g. CKG_MGF1_SHA256). Defaults to MGF1 with the digest given in hash."},
+		{"source_data", paramBytes, false, "Base64-encoded encoding parameter (label)."},
+	}},
+	{"pss", "CK_RSA_PKCS_PSS_PARAMS for CKM_RSA_PKCS_PSS and CKM_SHA*_RSA_PKCS_PSS.", []paramField{
+		{"hash", paramString, true, "Digest mechanism (e.g. CKM_SHA256)."},
+		{"mgf", paramString, false, "Mask generation function (e.g. CKG_MGF1_SHA256). Defaults to MGF1 with the digest given in hash."},
+		{"salt_len", paramUlong, false, "Salt length in bytes, which may be 0. Defaults to the digest length."},
+	}},
+	{"ecdh1", "CK_ECDH1_DERIVE_PARAMS for CKM_ECDH1_DERIVE and CKM_ECDH1_COFACTOR_DERIVE.", []paramField{
+		{"kdf", paramString, false, "Key derivation function (e.g. CKD_NULL, CKD_SHA256_KDF). Defaults to CKD_NULL."},
+		{"shared_data", paramBytes, false, "Base64-encoded shared data for the KDF."},
+		{"public_data", paramBytes, true, "Base64-encoded public value of the other party (EC point)."},
+	}},
+	{"hkdf", "CK_HKDF_PARAMS for CKM_HKDF_DERIVE and CKM_HKDF_DATA.", []paramField{
+		{"prf", paramString, true, "Digest mechanism of the HMAC (e.g. CKM_SHA256)."},
+		{"extract", paramBool, false, "Whether to perform the extract step (default: true)."},
+		{"expand", paramBool, false, "Whether to perform the expand step (default: true)."},
+		{"salt", paramBytes, false, "Base64-encoded salt. No salt is used when omitted."},
+		{"info", paramBytes, false, "Base64-encoded context and application specific information."},
+	}},
+	{"kbkdf", "CK_SP800_108_KDF_PARAMS for CKM_SP800_108_COUNTER_KDF and CKM_SP800_108_DOUBLE_PIPELINE_KDF, CK_SP800_108_FEEDBACK_KDF_PARAMS for CKM_SP800_108_FEEDBACK_KDF. The PRF input is built as [i] || fixed_input || [L], preceded by the feedback or pipeline value outside of counter mode.", []paramField{
+		{"prf", paramString, true, "PRF mechanism (e.g. CKM_SHA256_HMAC, CKM_AES_CMAC)."},
+		{"counter_bits", paramUlong, false, "Width of the counter [i] in bits. Defaults to 32 in counter mode; the counter is omitted in the other modes when unset."},
+		{"fixed_input", paramBytes, false, "Base64-encoded fixed input data, typically Label || 0x00 || Context."},
+		{"dkm_length_bits", paramUlong, false, "Width of the derived key length [L] in bits (default: 32)."},
+		{"iv", paramBytes, false, "Base64-encoded initial feedback value. Feedback mode only."},
+	}},
+	{"cbc_encrypt_data", "CK_AES_CBC_ENCRYPT_DATA_PARAMS and CK_DES_CBC_ENCRYPT_DATA_PARAMS for CKM_*_CBC_ENCRYPT_DATA.", []paramField{
+		{"iv", paramBytes, true, "Base64-encoded initialization vector (8 bytes for DES, 16 bytes for AES, Camellia, ARIA and SEED)."},
+		{"data", paramBytes, true, "Base64-encoded data to encrypt."},
+	}},
+	{"key", "Another key on the token, passed as CK_OBJECT_HANDLE for CKM_CONCATENATE_BASE_AND_KEY.", []paramField{
+		{"label", paramString, true, "Label or PKCS#11 URI of the key."},
+		{"class", paramString, false, "Object class of the key (default: CKO_SECRET_KEY)."},
+	}},
+}
+
+const mechanismParamsDescription = "Typed mechanism parameters. At most one of the nested attributes may be set."
+
+// MechanismParamsSchema returns the mechanism_parameters attribute for resources.
+// Changing the parameters forces a new resource.
+func MechanismParamsSchema() schema.SingleNestedAttribute {
+	attrs := map[string]schema.Attribute{}
+	for _, f := range mechanismParamLeaves {
+		attrs[f.name] = resourceParamAttr(f)
+	}
+	for _, g := range mechanismParamGroups {
+		nested := map[string]schema.Attribute{}
+		for _, f := range g.fields {
+			nested[f.name] = resourceParamAttr(f)
+		}
+		attrs[g.name] = schema.SingleNestedAttribute{
+			Optional:    true,
+			Description: g.desc,
+			Attributes:  nested,
+		}
+	}
+	return schema.SingleNestedAttribute{
+		Optional:    true,
+		Description: mechanismParamsDescription,
+		Attributes:  attrs,
+		PlanModifiers: []planmodifier.Object{
+			objectplanmodifier.RequiresReplace(),
+		},
+	}
+}
+
+// MechanismParamsDataSourceSchema returns the mechanism_parameters attribute for data sources.
+func MechanismParamsDataSourceSchema() dsschema.SingleNestedAttribute {
+	attrs := map[string]dsschema.Attribute{}
+	for _, f := range mechanismParamLeaves {
+		attrs[f.name] = dataSourceParamAttr(f)
+	}
+	for _, g := range mechanismParamGroups {
+		nested := map[string]dsschema.Attribute{}
+		for _, f := range g.fields {
+			nested[f.name] = dataSourceParamAttr(f)
+		}
+		attrs[g.name] = dsschema.SingleNestedAttribute{
+			Optional:    true,
+			Description: g.desc,
+			Attributes:  nested,
+		}
+	}
+	return dsschema.SingleNestedAttribute{
+		Optional:    true,
+		Description: mechanismParamsDescription,
+		Attributes:  attrs,
+	}
+}
+
+func resourceParamAttr(f paramField) schema.Attribute {
+	switch f.kind {
+	case paramUlong:
+		return schema.Int64Attribute{Required: f.required, Optional: !f.required, Description: f.desc}
+	case paramBool:
+		return schema.BoolAttribute{Required: f.required, Optional: !f.required, Description: f.desc}
+	case paramBytes:
+		return schema.StringAttribute{
+			Required:    f.required,
+			Optional:    !f.required,
+			Description: f.desc,
+			Validators:  []validator.String{customtypes.Base64Validator{}},
+		}
+	default:
+		return schema.StringAttribute{Required: f.required, Optional: !f.required, Description: f.desc}
+	}
+}
+
+func dataSourceParamAttr(f paramField) dsschema.Attribute {
+	switch f.kind {
+	case paramUlong:
+		return dsschema.Int64Attribute{Required: f.required, Optional: !f.required, Description: f.desc}
+	case paramBool:
+		return dsschema.BoolAttribute{Required: f.required, Optional: !f.required, Description: f.desc}
+	case paramBytes:
+		return dsschema.StringAttribute{
+			Required:    f.required,
+			Optional:    !f.required,
+			Description: f.desc,
+			Validators:  []validator.String{customtypes.Base64Validator{}},
+		}
+	default:
+		return dsschema.StringAttribute{Required: f.required, Optional: !f.required, Description: f.desc}
+	}
+}
+
+// MechanismParamsFrom reads the mechanism_parameters attribute from a plan, state or config
+// and converts it into pkcs11client.MechanismParams. Returns nil if the attribute is not set.
+func MechanismParamsFrom(ctx context.Context, src AttrReader) (*pkcs11client.MechanismParams, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	root := path.Root("mechanism_parameters")
+
+	var obj types.Object
+	diags.Append(src.GetAttribute(ctx, root, &obj)...)
+	if diags.HasError() || obj.IsNull() || obj.IsUnknown() {
+		return nil, diags
+	}
+
+	r := paramReader{ctx: ctx, src: src}
+	params := &pkcs11client.MechanismParams{
+		Raw:        r.bytes(root.AtName("raw")),
+		IV:         r.bytes(root.AtName("iv")),
+		StringData: r.bytes(root.AtName("derivation_data")),
+	}
+
+	if p := root.AtName("gcm"); r.isSet(p) {
+		params.GCM = &pkcs11client.GCMParams{
+			IV:      r.bytes(p.AtName("iv")),
+			AAD:     r.bytes(p.AtName("aad")),
+			TagBits: r.ulong(p.AtName("tag_bits")),
+		}
+	}
+	if p := root.AtName("ccm"); r.isSet(p) {
+		params.CCM = &pkcs11client.CCMParams{
+			Nonce:   r.bytes(p.AtName("nonce")),
+			AAD:     r.bytes(p.AtName("aad")),
+			MACLen:  r.ulong(p.AtName("mac_len")),
+			DataLen: r.ulong(p.AtName("data_len")),
+		}
+	}
+	if p := root.AtName("oaep"); r.isSet(p) {
+		params.OAEP = &pkcs11client.OAEPParams{
+			Hash:       r.enum(p.AtName("hash"), pkcs11client.MechanismEnum),
+			MGF:        r.enum(p.AtName("mgf"), pkcs11client.MGFEnum),
+			SourceData: r.bytes(p.AtName("source_data")),
+		}
+	}
+	if p := root.AtName("pss"); r.isSet(p) {
+		params.PSS = &pkcs11client.PSSParams{
+			Hash:    r.enum(p.AtName("hash"), pkcs11client.MechanismEnum),
+			MGF:     r.enum(p.AtName("mgf"), pkcs11client.MGFEnum),
+			SaltLen: r.optionalUlong(p.AtName("salt_len")),
+		}
+	}
+	if p := root.AtName("ecdh1"); r.isSet(p) {
+		params.ECDH1 = &pkcs11client.ECDH1Params{
+			KDF:        r.enum(p.AtName("kdf"), pkcs11client.KDFEnum),
+			SharedData: r.bytes(p.AtName("shared_data")),
+			PublicData: r.bytes(p.AtName("public_data")),
+		}
+	}
+	if p := root.AtName("hkdf"); r.isSet(p) {
+		params.HKDF = &pkcs11client.HKDFParams{
+			PRF:     r.enum(p.AtName("prf"), pkcs11client.MechanismEnum),
+			Extract: r.boolOr(p.AtName("extract"), true),
+			Expand:  r.boolOr(p.AtName("expand"), true),
+			Salt:    r.bytes(p.AtName("salt")),
+			Info:    r.bytes(p.AtName("info")),
+		}
+	}
+	if p := root.AtName("kbkdf"); r.isSet(p) {
+		params.SP800108 = &pkcs11client.SP800108Params{
+			PRF:           r.enum(p.AtName("prf"), pkcs11client.MechanismEnum),
+			CounterBits:   r.ulong(p.AtName("counter_bits")),
+			FixedInput:    r.bytes(p.AtName("fixed_input")),
+			DKMLengthBits: r.ulong(p.AtName("dkm_length_bits")),
+			IV:            r.bytes(p.AtName("iv")),
+		}
+	}
+	if p := root.AtName("cbc_encrypt_data"); r.isSet(p) {
+		params.CBCEncryptData = &pkcs11client.CBCEncryptDataParams{
+			IV:   r.bytes(p.AtName("iv")),
+			Data: r.bytes(p.AtName("data")),
+		}
+	}
+	if p := root.AtName("key"); r.isSet(p) {
+		var label, class types.String
+		r.src.GetAttribute(ctx, p.AtName("label"), &label)
+		r.src.GetAttribute(ctx, p.AtName("class"), &class)
+		ref := &pkcs11client.KeyRef{Label: label.ValueString(), Class: pkcs11.CKO_SECRET_KEY}
+		if !class.IsNull() && !class.IsUnknown() {
+			ref.Class = r.enum(p.AtName("class"), pkcs11client.AttributeNameToDef["class"].Pkcs11Enum)
+		}
+		params.Key = ref
+	}
+
+	diags.Append(r.diags...)
+	if diags.HasError() {
+		return nil, diags
+	}
+	return params, diags
+}
+
+// BuildMechanism reads the mechanism name and mechanism_parameters from src and
+// returns the mechanism list along with a function releasing the marshaled parameters.
+func BuildMechanism(ctx context.Context, src AttrReader, mechanismName string) ([]*pkcs11.Mechanism, func(), diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	mechanismID, err := pkcs11client.MechanismEnum.Resolve(mechanismName)
+	if err != nil {
+		diags.AddError("Invalid mechanism", err.Error())
+		return nil, nil, diags
+	}
+
+	params, paramDiags := MechanismParamsFrom(ctx, src)
+	diags.Append(paramDiags...)
+	if diags.HasError() {
+		return nil, nil, diags
+	}
+
+	mechanism, free, err := pkcs11client.NewMechanism(mechanismID, params)
+	if err != nil {
+		diags.AddError("Invalid mechanism_parameters", err.Error())
+		return nil, nil, diags
+	}
+	return mechanism, free, diags
+}
+
+// CopyMechanismParams copies mechanism_parameters from the plan into state,
+// so that later operations (e.g. re-wrapping on Read) use the same parameters.
+func CopyMechanismParams(ctx context.Context, plan tfsdk.Plan, state *tfsdk.State) diag.Diagnostics {
+	var obj types.Object
+	diags := plan.GetAttribute(ctx, path.Root("mechanism_parameters"), &obj)
+	if diags.HasError() {
+		return diags
+	}
+	diags.Append(state.SetAttribute(ctx, path.Root("mechanism_parameters"), obj)...)
+	return diags
+}
+
+// paramReader reads individual mechanism parameter values, collecting errors.
+type paramReader struct {
+	ctx   context.Context
+	src   AttrReader
+	diags diag.Diagnostics
+}
+
+func (r *paramReader) isSet(p path.Path) bool {
+	var v types.Object
+	r.src.GetAttribute(r.ctx, p, &v)
+	return !v.IsNull() && !v.IsUnknown()
+}
+
+func (r *paramReader) bytes(p path.Path) []byte {
+	var v types.String
+	r.src.GetAttribute(r.ctx, p, &v)
+	if v.IsNull() || v.IsUnknown() {
+		return nil
+	}
+	b, err := pkcs11client.DecodeBase64(v.ValueString())
+	if err != nil {
+		r.diags.AddAttributeError(p, "Invalid base64", err.Error())
+		return nil
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return b
+}
+
+func (r *paramReader) ulong(p path.Path) uint {
+	var v types.Int64
+	r.src.GetAttribute(r.ctx, p, &v)
+	if v.IsNull() || v.IsUnknown() {
+		return 0
+	}
+	if v.ValueInt64() < 0 {
+		r.diags.AddAttributeError(p, "Invalid value", fmt.Sprintf("value must not be negative, got %d", v.ValueInt64()))
+		return 0
+	}
+	return uint(v.ValueInt64())
+}
+
+// optionalUlong reads an unsigned integer that is nil if not set.
+func (r *paramReader) optionalUlong(p path.Path) *uint {
+	var v types.Int64
+	r.src.GetAttribute(r.ctx, p, &v)
+	if v.IsNull() || v.IsUnknown() {
+		return nil
+	}
+	n := r.ulong(p)
+	return &n
+}
+
+func (r *paramReader) boolOr(p path.Path, def bool) bool {
+	var v types.Bool
+	r.src.GetAttribute(r.ctx, p, &v)
+	if v.IsNull() || v.IsUnknown() {
+		return def
+	}
+	return v.ValueBool()
+}
+
+func (r *paramReader) enum(p path.Path, e *pkcs11client.Pkcs11Enum) uint {
+	var v types.String
+	r.src.GetAttribute(r.ctx, p, &v)
+	if v.IsNull() || v.IsUnknown() {
+		return 0
+	}
+	id, err := e.Resolve(v.ValueString())
+	if err != nil {
+		r.diags.AddAttributeError(p, "Invalid value", err.Error())
+		return 0
+	}
+	return id
+}
+
+// DataSourceMechanismParams reads mechanism parameters for the crypto data sources.
+// It accepts either the typed mechanism_parameters attribute or the base64-encoded
+// mechanism_parameter attribute, but not both.
+func DataSourceMechanismParams(ctx context.Context, src AttrReader) (*pkcs11client.MechanismParams, diag.Diagnostics) {
+	params, diags := MechanismParamsFrom(ctx, src)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	var raw types.String
+	diags.Append(src.GetAttribute(ctx, path.Root("mechanism_parameter"), &raw)...)
+	if raw.IsNull() || raw.IsUnknown() {
+		return params, diags
+	}
+	if params != nil {
+		diags.AddError("Conflicting mechanism parameters", "mechanism_parameter and mechanism_parameters are mutually exclusive")
+		return nil, diags
+	}
+	b, err := pkcs11client.DecodeBase64(raw.ValueString())
+	if err != nil {
+		diags.AddError("Invalid mechanism_parameter", fmt.Sprintf("Failed to decode base64: %s", err))
+		return nil, diags
+	}
+	return &pkcs11client.MechanismParams{Raw: b}, diags
+}
@@ -0,0 +1,65 @@
+package shared
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+)
+
+// DefaultTimeout is how long an operation may take when the resource does
+// not configure a timeout for it.
+const DefaultTimeout = 20 * time.Minute
+
+// CreateContext returns a context that is cancelled after the create timeout of the plan.
+func CreateContext(ctx context.Context, plan tfsdk.Plan) (context.Context, context.CancelFunc, diag.Diagnostics) {
+	return withTimeout(ctx, PlanReader{Plan: plan}, timeouts.Value.Create)
+}
+
+// ReadContext returns a context that is cancelled after the read timeout of the state.
+func ReadContext(ctx context.Context, state tfsdk.State) (context.Context, context.CancelFunc, diag.Diagnostics) {
+	return withTimeout(ctx, StateReader{State: state}, timeouts.Value.Read)
+}
+
+// UpdateContext returns a context that is cancelled after the update timeout of the plan.
+func UpdateContext(ctx context.Context, plan tfsdk.Plan) (context.Context, context.CancelFunc, diag.Diagnostics) {
+	return withTimeout(ctx, PlanReader{Plan: plan}, timeouts.Value.Update)
+}
+
+// DeleteContext returns a context that is cancelled after the delete timeout of the state.
+func DeleteContext(ctx context.Context, state tfsdk.State) (context.Context, context.CancelFunc, diag.Diagnostics) {
+	return withTimeout(ctx, StateReader{State: state}, timeouts.Value.Delete)
+}
+
+// withTimeout reads the timeouts block from src and applies the timeout
+// selected by get to ctx. The returned cancel function is never nil.
+func withTimeout(ctx context.Context, src AttrReader, get func(timeouts.Value, context.Context, time.Duration) (time.Duration, diag.Diagnostics)) (context.Context, context.CancelFunc, diag.Diagnostics) {
+	var value timeouts.Value
+	diags := src.GetAttribute(ctx, path.Root("timeouts"), &value)
+	if diags.HasError() {
+		return ctx, func() {}, diags
+	}
+
+	timeout, timeoutDiags := get(value, ctx, DefaultTimeout)
+	diags.Append(timeoutDiags...)
+	if diags.HasError() {
+		return ctx, func() {}, diags
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, diags
+}
+
+// CopyTimeouts copies the timeouts block from the plan to state, so that
+// state matches the configuration.
+func CopyTimeouts(ctx context.Context, plan tfsdk.Plan, state *tfsdk.State) diag.Diagnostics {
+	var value timeouts.Value
+	diags := plan.GetAttribute(ctx, path.Root("timeouts"), &value)
+	if diags.HasError() {
+		return diags
+	}
+	return state.SetAttribute(ctx, path.Root("timeouts"), value)
+}
@@ -0,0 +1,66 @@
+package shared
+
+import (
+	"context"
+
+	dsschema "github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+)
+
+const tokenNameDescription = "Name of the entry in the tokens map of the provider configuration to use. " +
+	"Defaults to the token configured at the top level of the provider configuration."
+
+// TokenNameSchema returns the token_name attribute for resources.
+// Moving a resource to another token forces a new resource.
+func TokenNameSchema() schema.StringAttribute {
+	return schema.StringAttribute{
+		Optional:    true,
+		Description: tokenNameDescription,
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.RequiresReplace(),
+		},
+	}
+}
+
+// TokenNameDataSourceSchema returns the token_name attribute for data sources.
+func TokenNameDataSourceSchema() dsschema.StringAttribute {
+	return dsschema.StringAttribute{
+		Optional:    true,
+		Description: tokenNameDescription,
+	}
+}
+
+// ClientFor returns the client of the token selected by the token_name attribute in src.
+func ClientFor(ctx context.Context, clients *pkcs11client.Clients, src AttrReader) (*pkcs11client.Client, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	var tokenName types.String
+	diags.Append(src.GetAttribute(ctx, path.Root("token_name"), &tokenName)...)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	client, err := clients.Get(tokenName.ValueString())
+	if err != nil {
+		diags.AddAttributeError(path.Root("token_name"), "Invalid token_name", err.Error())
+		return nil, diags
+	}
+	return client, diags
+}
+
+// CopyTokenName copies token_name from the plan into state, since it is not
+// populated by ReadObjectIntoState.
+func CopyTokenName(ctx context.Context, plan tfsdk.Plan, state *tfsdk.State) diag.Diagnostics {
+	var tokenName types.String
+	diags := plan.GetAttribute(ctx, path.Root("token_name"), &tokenName)
+	diags.Append(state.SetAttribute(ctx, path.Root("token_name"), tokenName)...)
+	return diags
+}
@@ -0,0 +1,62 @@
+package shared
+
+import (
+	"context"
+
+	dsschema "github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/miekg/pkcs11"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+)
+
+// URISchema returns the computed uri attribute of resources with the given description.
+func URISchema(description string) schema.StringAttribute {
+	return schema.StringAttribute{
+		Computed:    true,
+		Description: description,
+		PlanModifiers: []planmodifier.String{
+			stringplanmodifier.UseStateForUnknown(),
+		},
+	}
+}
+
+// ObjectURISchema returns the computed uri attribute of resources that manage an object.
+func ObjectURISchema() schema.StringAttribute {
+	return URISchema("PKCS#11 URI (RFC 7512) of the object, for use with tools such as OpenSSL or p11tool.")
+}
+
+// ObjectURIDataSourceSchema returns the computed uri attribute of data sources that read an object.
+func ObjectURIDataSourceSchema() dsschema.StringAttribute {
+	return dsschema.StringAttribute{
+		Computed:    true,
+		Description: "PKCS#11 URI (RFC 7512) of the object, for use with tools such as OpenSSL or p11tool.",
+	}
+}
+
+// SetObjectURI writes the PKCS#11 URI of the object to state at p.
+func SetObjectURI(ctx context.Context, client *pkcs11client.Client, handle pkcs11.ObjectHandle, state *tfsdk.State, p path.Path) diag.Diagnostics {
+	var diags diag.Diagnostics
+	uri, err := client.ObjectURI(ctx, handle)
+	if err != nil {
+		diags.AddError("Failed to build object URI", err.Error())
+		return diags
+	}
+	return state.SetAttribute(ctx, p, uri)
+}
+
+// SetTokenURI writes the PKCS#11 URI of the token to the uri attribute in state.
+func SetTokenURI(ctx context.Context, client *pkcs11client.Client, state *tfsdk.State) diag.Diagnostics {
+	var diags diag.Diagnostics
+	uri, err := client.TokenURI(ctx)
+	if err != nil {
+		diags.AddError("Failed to build token URI", err.Error())
+		return diags
+	}
+	return state.SetAttribute(ctx, path.Root("uri"), uri)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
@@ -21,7 +22,7 @@ var (
 )
 
 type SymmetricKeyResource struct {
-	client *pkcs11client.Client
+	clients *pkcs11client.Clients
 }
 
 func NewResource() resource.Resource {
@@ -32,7 +33,7 @@ func (r *SymmetricKeyResource) Metadata(_ context.Context, req resource.Metadata
 	resp.TypeName = req.ProviderTypeName + "_symmetric_key"
 }
 
-func (r *SymmetricKeyResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *SymmetricKeyResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	attrs := shared.ObjectAttrSchema()
 	attrs["id"] = schema.StringAttribute{
 		Computed:    true,
@@ -41,6 +42,9 @@ func (r *SymmetricKeyResource) Schema(_ context.Context, _ resource.SchemaReques
 			stringplanmodifier.UseStateForUnknown(),
 		},
 	}
+	attrs["token_name"] = shared.TokenNameSchema()
+	attrs["login_as"] = shared.LoginAsSchema()
+	attrs["uri"] = shared.ObjectURISchema()
 	attrs["mechanism"] = schema.StringAttribute{
 		Required:    true,
 		Description: "Key generation mechanism name (e.g., CKM_AES_KEY_GEN, CKM_DES3_KEY_GEN, CKM_GENERIC_SECRET_KEY_GEN). Accepts name with or without CKM_ prefix.",
@@ -49,11 +53,15 @@ func (r *SymmetricKeyResource) Schema(_ context.Context, _ resource.SchemaReques
 			stringplanmodifier.RequiresReplace(),
 		},
 	}
+	attrs["mechanism_parameters"] = shared.MechanismParamsSchema()
 
 	resp.Schema = schema.Schema{
 		Description: "Generates a symmetric key on a PKCS#11 token using C_GenerateKey. " +
 			"All PKCS#11 attributes can be specified to control key properties.",
 		Attributes: attrs,
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{Create: true, Read: true, Update: true, Delete: true}),
+		},
 	}
 }
 
@@ -61,27 +69,47 @@ func (r *SymmetricKeyResource) Configure(_ context.Context, req resource.Configu
 	if req.ProviderData == nil {
 		return
 	}
-	client, ok := req.ProviderData.(*pkcs11client.Client)
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
 	if !ok {
 		resp.Diagnostics.AddError("Unexpected Resource Configure Type",
-			fmt.Sprintf("Expected *pkcs11client.Client, got: %T", req.ProviderData))
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
 		return
 	}
-	r.client = client
+	r.clients = clients
 }
 
 func (r *SymmetricKeyResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, cancel, diags := shared.CreateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	var mechanismName string
 	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("mechanism"), &mechanismName)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	mechanismID, err := pkcs11client.MechanismEnum.Resolve(mechanismName)
-	if err != nil {
-		resp.Diagnostics.AddError("Invalid mechanism", err.Error())
+	mechanism, freeMechanism, diags := shared.BuildMechanism(ctx, shared.PlanReader{Plan: req.Plan}, mechanismName)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
 		return
 	}
+	defer freeMechanism()
 
 	pkcsAttrs, diags := shared.AttrsFromPlan(ctx, req.Plan)
 	resp.Diagnostics.Append(diags...)
@@ -89,41 +117,84 @@ func (r *SymmetricKeyResource) Create(ctx context.Context, req resource.CreateRe
 		return
 	}
 
-	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, nil)}
-	handle, err := r.client.GenerateSymmetricKey(mechanism, pkcsAttrs)
+	handle, err := client.GenerateSymmetricKey(ctx, mechanism, pkcsAttrs)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to generate symmetric key", err.Error())
 		return
 	}
 
-	diags = shared.ReadObjectIntoState(ctx, r.client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("mechanism"), mechanismName)...)
+	resp.Diagnostics.Append(shared.CopyMechanismParams(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyTokenName(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyLoginAs(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
 }
 
 func (r *SymmetricKeyResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
-	handle, err := shared.FindObject(ctx, r.client, req.State)
+	ctx, cancel, diags := shared.ReadContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
 	if err != nil {
 		resp.State.RemoveResource(ctx)
 		return
 	}
 
-	diags := shared.ReadObjectIntoState(ctx, r.client, handle, &resp.State)
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
 }
 
 func (r *SymmetricKeyResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
-	handle, err := shared.FindObject(ctx, r.client, req.State)
+	ctx, cancel, diags := shared.UpdateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to find key for update", err.Error())
 		return
@@ -158,28 +229,49 @@ func (r *SymmetricKeyResource) Update(ctx context.Context, req resource.UpdateRe
 	}
 
 	if len(updates) > 0 {
-		if err := r.client.SetAttributeValue(handle, updates); err != nil {
+		if err := client.SetAttributeValue(ctx, handle, updates); err != nil {
 			resp.Diagnostics.AddError("Failed to update key", err.Error())
 			return
 		}
 	}
 
-	diags = shared.ReadObjectIntoState(ctx, r.client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
 }
 
 func (r *SymmetricKeyResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
-	handle, err := shared.FindObject(ctx, r.client, req.State)
+	ctx, cancel, diags := shared.DeleteContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
 	if err != nil {
 		return // Already gone
 	}
 
-	if err := r.client.DestroyObject(handle); err != nil {
+	if err := client.DestroyObject(ctx, handle); err != nil {
 		resp.Diagnostics.AddError("Failed to destroy key", err.Error())
 	}
 }
@@ -0,0 +1,326 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/miekg/pkcs11"
+
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
+	"blechschmidt.io/terraform-provider-pkcs11/internal/resources/shared"
+)
+
+var (
+	_ resource.Resource                = &TokenResource{}
+	_ resource.ResourceWithImportState = &TokenResource{}
+)
+
+type TokenResource struct {
+	clients *pkcs11client.Clients
+}
+
+type TokenModel struct {
+	ID                 types.String   `tfsdk:"id"`
+	SlotID             types.Int64    `tfsdk:"slot_id"`
+	Label              types.String   `tfsdk:"label"`
+	SoPin              types.String   `tfsdk:"so_pin"`
+	UserPin            types.String   `tfsdk:"user_pin"`
+	UserPinVersion     types.String   `tfsdk:"user_pin_version"`
+	Reinitialize       types.Bool     `tfsdk:"reinitialize"`
+	ManufacturerID     types.String   `tfsdk:"manufacturer_id"`
+	Model              types.String   `tfsdk:"model"`
+	SerialNumber       types.String   `tfsdk:"serial_number"`
+	UserPinInitialized types.Bool     `tfsdk:"user_pin_initialized"`
+	TokenName          types.String   `tfsdk:"token_name"`
+	URI                types.String   `tfsdk:"uri"`
+	Timeouts           timeouts.Value `tfsdk:"timeouts"`
+}
+
+func NewResource() resource.Resource {
+	return &TokenResource{}
+}
+
+func (r *TokenResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_token"
+}
+
+func (r *TokenResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Initializes the token in a slot via C_InitToken and sets its user PIN via C_InitPIN. " +
+			"A token that is already initialized with the same label is adopted as is. " +
+			"The PINs are write-only and never stored in state. " +
+			"With defer_token_resolution in the provider configuration, the token does not need to match its token filters yet; " +
+			"the provider then resolves its token on first use, so resources on the new token must depend on this resource. " +
+			"Destroying the resource leaves the token and its objects untouched.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Computed:    true,
+				Description: "Slot ID of the token.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"token_name": shared.TokenNameSchema(),
+			"slot_id": schema.Int64Attribute{
+				Required:    true,
+				Description: "Slot holding the token to initialize.",
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.RequiresReplace(),
+				},
+			},
+			"label": schema.StringAttribute{
+				Required:    true,
+				Description: "Token label, at most 32 bytes.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"so_pin": schema.StringAttribute{
+				Optional:    true,
+				Sensitive:   true,
+				WriteOnly:   true,
+				Description: "Security Officer PIN set by C_InitToken and used to log in for C_InitPIN. Defaults to so_pin of the provider configuration.",
+			},
+			"user_pin": schema.StringAttribute{
+				Optional:    true,
+				Sensitive:   true,
+				WriteOnly:   true,
+				Description: "User PIN set via C_InitPIN. Defaults to pin of the provider configuration.",
+			},
+			"user_pin_version": schema.StringAttribute{
+				Optional:    true,
+				Description: "Arbitrary value such as a version number. Changing it resets the user PIN to the configured user_pin as Security Officer.",
+			},
+			"reinitialize": schema.BoolAttribute{
+				Optional: true,
+				Description: "Reinitialize a token that is already initialized with a different label, which destroys all objects on it. " +
+					"Without this, creation fails for such tokens.",
+			},
+			"manufacturer_id": schema.StringAttribute{
+				Computed:    true,
+				Description: "Manufacturer of the token.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"model": schema.StringAttribute{
+				Computed:    true,
+				Description: "Model of the token.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"serial_number": schema.StringAttribute{
+				Computed:    true,
+				Description: "Serial number of the token.",
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"uri": shared.URISchema("PKCS#11 URI (RFC 7512) of the token, for use with tools such as OpenSSL or p11tool."),
+			"user_pin_initialized": schema.BoolAttribute{
+				Computed:    true,
+				Description: "Whether the user PIN of the token has been initialized (CKF_USER_PIN_INITIALIZED).",
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{Create: true, Read: true, Update: true}),
+		},
+	}
+}
+
+func (r *TokenResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Resource Configure Type",
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
+		return
+	}
+	r.clients = clients
+}
+
+func (r *TokenResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, cancel, diags := shared.CreateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var plan TokenModel
+	var soPin, userPin types.String
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	// Write-only values are only available in the configuration
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("so_pin"), &soPin)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("user_pin"), &userPin)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	slotID := uint(plan.SlotID.ValueInt64())
+	label := plan.Label.ValueString()
+
+	info, err := client.GetSlotTokenInfo(ctx, slotID)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to read token info", err.Error())
+		return
+	}
+
+	initialize := false
+	switch {
+	case info.Flags&pkcs11.CKF_TOKEN_INITIALIZED == 0:
+		initialize = true
+	case info.Label == label:
+		// Already initialized with the requested label
+	case plan.Reinitialize.ValueBool():
+		initialize = true
+	default:
+		resp.Diagnostics.AddError("Token already initialized",
+			fmt.Sprintf("Slot %d holds the initialized token %q. Set reinitialize = true to reinitialize it as %q, which destroys all objects on it.",
+				slotID, info.Label, label))
+		return
+	}
+
+	if initialize {
+		if err := client.InitToken(ctx, slotID, soPin.ValueString(), label); err != nil {
+			resp.Diagnostics.AddError("Failed to initialize token", err.Error())
+			return
+		}
+	}
+
+	// An adopted token keeps its user PIN, which cannot be verified here
+	if initialize || info.Flags&pkcs11.CKF_USER_PIN_INITIALIZED == 0 {
+		if err := client.InitPIN(ctx, slotID, soPin.ValueString(), userPin.ValueString()); err != nil {
+			resp.Diagnostics.AddError("Failed to initialize user PIN", err.Error())
+			return
+		}
+	}
+
+	info, err = client.GetSlotTokenInfo(ctx, slotID)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to read token info", err.Error())
+		return
+	}
+
+	plan.ID = types.StringValue(strconv.FormatUint(uint64(slotID), 10))
+	applyTokenInfo(&plan, info)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *TokenResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	ctx, cancel, diags := shared.ReadContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var state TokenModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	info, err := client.GetSlotTokenInfo(ctx, uint(state.SlotID.ValueInt64()))
+	if err != nil || info.Flags&pkcs11.CKF_TOKEN_INITIALIZED == 0 {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+
+	state.Label = types.StringValue(info.Label)
+	applyTokenInfo(&state, info)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &state)...)
+}
+
+func (r *TokenResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	ctx, cancel, diags := shared.UpdateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var plan, state TokenModel
+	var soPin, userPin types.String
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("so_pin"), &soPin)...)
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("user_pin"), &userPin)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// The write-only PINs cannot be compared with the state, so only a new
+	// user_pin_version resets the user PIN
+	slotID := uint(plan.SlotID.ValueInt64())
+	if !plan.UserPinVersion.Equal(state.UserPinVersion) {
+		if err := client.InitPIN(ctx, slotID, soPin.ValueString(), userPin.ValueString()); err != nil {
+			resp.Diagnostics.AddError("Failed to reset user PIN", err.Error())
+			return
+		}
+	}
+
+	info, err := client.GetSlotTokenInfo(ctx, slotID)
+	if err != nil {
+		resp.Diagnostics.AddError("Failed to read token info", err.Error())
+		return
+	}
+
+	plan.ID = state.ID
+	applyTokenInfo(&plan, info)
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *TokenResource) Delete(_ context.Context, _ resource.DeleteRequest, _ *resource.DeleteResponse) {
+	// A token cannot be uninitialized, so it is left as it is.
+}
+
+func (r *TokenResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	slotID, err := strconv.ParseUint(req.ID, 10, 64)
+	if err != nil {
+		resp.Diagnostics.AddError("Invalid import ID", fmt.Sprintf("Expected a slot ID, got %q", req.ID))
+		return
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), req.ID)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("slot_id"), int64(slotID))...)
+}
+
+// applyTokenInfo copies the computed token properties into the model.
+func applyTokenInfo(m *TokenModel, info *pkcs11client.TokenInfo) {
+	m.ManufacturerID = types.StringValue(info.ManufacturerID)
+	m.Model = types.StringValue(info.Model)
+	m.SerialNumber = types.StringValue(info.SerialNumber)
+	m.UserPinInitialized = types.BoolValue(info.Flags&pkcs11.CKF_USER_PIN_INITIALIZED != 0)
+	m.URI = types.StringValue(info.URI().String())
+}
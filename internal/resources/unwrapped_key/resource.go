@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
@@ -25,7 +26,7 @@ var (
 )
 
 type UnwrappedKeyResource struct {
-	client *pkcs11client.Client
+	clients *pkcs11client.Clients
 }
 
 func NewResource() resource.Resource {
@@ -36,7 +37,7 @@ func (r *UnwrappedKeyResource) Metadata(_ context.Context, req resource.Metadata
 	resp.TypeName = req.ProviderTypeName + "_unwrapped_key"
 }
 
-func (r *UnwrappedKeyResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *UnwrappedKeyResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	classEnum := pkcs11client.AttributeNameToDef["class"].Pkcs11Enum
 
 	// For unwrapped keys, PKCS#11 object attributes are Optional+Computed.
@@ -50,6 +51,9 @@ func (r *UnwrappedKeyResource) Schema(_ context.Context, _ resource.SchemaReques
 			stringplanmodifier.UseStateForUnknown(),
 		},
 	}
+	attrs["token_name"] = shared.TokenNameSchema()
+	attrs["login_as"] = shared.LoginAsSchema()
+	attrs["uri"] = shared.ObjectURISchema()
 	attrs["mechanism"] = schema.StringAttribute{
 		Required:    true,
 		Description: "Unwrapping mechanism name (e.g., CKM_AES_KEY_WRAP). Accepts name with or without CKM_ prefix, or numeric value.",
@@ -58,9 +62,10 @@ func (r *UnwrappedKeyResource) Schema(_ context.Context, _ resource.SchemaReques
 			stringplanmodifier.RequiresReplace(),
 		},
 	}
+	attrs["mechanism_parameters"] = shared.MechanismParamsSchema()
 	attrs["unwrapping_key_label"] = schema.StringAttribute{
 		Required:    true,
-		Description: "Label of the unwrapping key on the token.",
+		Description: "Label or PKCS#11 URI of the unwrapping key on the token.",
 		PlanModifiers: []planmodifier.String{
 			stringplanmodifier.RequiresReplace(),
 		},
@@ -90,6 +95,9 @@ func (r *UnwrappedKeyResource) Schema(_ context.Context, _ resource.SchemaReques
 			"attributes (label, class, key_type, etc.). For vendor-specific mechanisms that embed " +
 			"attributes in the wrapped blob, the template can be omitted.",
 		Attributes: attrs,
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{Create: true, Read: true, Delete: true}),
+		},
 	}
 }
 
@@ -97,30 +105,50 @@ func (r *UnwrappedKeyResource) Configure(_ context.Context, req resource.Configu
 	if req.ProviderData == nil {
 		return
 	}
-	client, ok := req.ProviderData.(*pkcs11client.Client)
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
 	if !ok {
 		resp.Diagnostics.AddError("Unexpected Resource Configure Type",
-			fmt.Sprintf("Expected *pkcs11client.Client, got: %T", req.ProviderData))
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
 		return
 	}
-	r.client = client
+	r.clients = clients
 }
 
 func (r *UnwrappedKeyResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, cancel, diags := shared.CreateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.PlanReader{Plan: req.Plan})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	var mechanismName string
 	resp.Diagnostics.Append(req.Plan.GetAttribute(ctx, path.Root("mechanism"), &mechanismName)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	mechanismID, err := pkcs11client.MechanismEnum.Resolve(mechanismName)
-	if err != nil {
-		resp.Diagnostics.AddError("Invalid mechanism", err.Error())
+	mechanism, freeMechanism, diags := shared.BuildMechanism(ctx, shared.PlanReader{Plan: req.Plan}, mechanismName)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
 		return
 	}
+	defer freeMechanism()
 
 	// Find the unwrapping key
-	unwrappingKeyHandle, diags := r.findUnwrappingKey(ctx, shared.PlanReader{Plan: req.Plan})
+	unwrappingKeyHandle, diags := r.findUnwrappingKey(ctx, client, shared.PlanReader{Plan: req.Plan})
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
@@ -148,8 +176,7 @@ func (r *UnwrappedKeyResource) Create(ctx context.Context, req resource.CreateRe
 		return
 	}
 
-	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, nil)}
-	handle, err := r.client.UnwrapKey(mechanism, unwrappingKeyHandle, wrappedBytes, template)
+	handle, err := client.UnwrapKey(ctx, mechanism, unwrappingKeyHandle, wrappedBytes, template)
 	if err != nil {
 		resp.Diagnostics.AddError("Failed to unwrap key", err.Error())
 		return
@@ -157,7 +184,7 @@ func (r *UnwrappedKeyResource) Create(ctx context.Context, req resource.CreateRe
 
 	// Read the unwrapped object attributes back into state, using the plan as
 	// reference to preserve user-provided enum values.
-	diags = shared.ReadObjectIntoState(ctx, r.client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State, shared.PlanReader{Plan: req.Plan})
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
@@ -165,6 +192,7 @@ func (r *UnwrappedKeyResource) Create(ctx context.Context, req resource.CreateRe
 
 	// Set resource-specific attributes
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("mechanism"), mechanismName)...)
+	resp.Diagnostics.Append(shared.CopyMechanismParams(ctx, req.Plan, &resp.State)...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("wrapped_key_material"), wrappedMaterial.ValueString())...)
 
 	var unwrappingKeyLabel types.String
@@ -179,39 +207,82 @@ func (r *UnwrappedKeyResource) Create(ctx context.Context, req resource.CreateRe
 	}
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("unwrapping_key_class"), ukClass)...)
 
+	resp.Diagnostics.Append(shared.CopyTokenName(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyLoginAs(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
 }
 
 func (r *UnwrappedKeyResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
-	handle, err := shared.FindObject(ctx, r.client, req.State)
+	ctx, cancel, diags := shared.ReadContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
 	if err != nil {
 		resp.State.RemoveResource(ctx)
 		return
 	}
 
-	diags := shared.ReadObjectIntoState(ctx, r.client, handle, &resp.State)
+	diags = shared.ReadObjectIntoState(ctx, client, handle, &resp.State)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), shared.BuildObjectID(ctx, &resp.State))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, handle, &resp.State, path.Root("uri"))...)
 }
 
-func (r *UnwrappedKeyResource) Update(_ context.Context, _ resource.UpdateRequest, resp *resource.UpdateResponse) {
+func (r *UnwrappedKeyResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
 	// All PKCS#11 attributes are Computed-only, so no user-driven updates are possible.
 	// All user-specified inputs (mechanism, unwrapping_key_label, wrapped_key_material)
-	// have RequiresReplace, so Terraform will never call Update.
-	resp.Diagnostics.AddError("Update not supported", "pkcs11_unwrapped_key does not support in-place updates")
+	// have RequiresReplace, so only the timeouts and login_as can change in place.
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
 }
 
 func (r *UnwrappedKeyResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
-	handle, err := shared.FindObject(ctx, r.client, req.State)
+	ctx, cancel, diags := shared.DeleteContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	ctx, endLogin, diags := shared.LoginContext(ctx, shared.StateReader{State: req.State})
+	defer endLogin()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	handle, err := shared.FindObject(ctx, client, req.State)
 	if err != nil {
 		return // Already gone
 	}
 
-	if err := r.client.DestroyObject(handle); err != nil {
+	if err := client.DestroyObject(ctx, handle); err != nil {
 		resp.Diagnostics.AddError("Failed to destroy key", err.Error())
 	}
 }
@@ -231,7 +302,7 @@ func (r *UnwrappedKeyResource) ImportState(ctx context.Context, req resource.Imp
 }
 
 // findUnwrappingKey locates the unwrapping key by label and class.
-func (r *UnwrappedKeyResource) findUnwrappingKey(ctx context.Context, src shared.AttrReader) (pkcs11.ObjectHandle, diag.Diagnostics) {
+func (r *UnwrappedKeyResource) findUnwrappingKey(ctx context.Context, client *pkcs11client.Client, src shared.AttrReader) (pkcs11.ObjectHandle, diag.Diagnostics) {
 	var diags diag.Diagnostics
 
 	var unwrappingKeyLabel, unwrappingKeyClass types.String
@@ -249,7 +320,7 @@ func (r *UnwrappedKeyResource) findUnwrappingKey(ctx context.Context, src shared
 		}
 	}
 
-	handle, err := r.client.FindObjectByLabelAndClass(unwrappingKeyLabel.ValueString(), classID)
+	handle, err := client.FindKey(ctx, unwrappingKeyLabel.ValueString(), classID)
 	if err != nil {
 		diags.AddError("Failed to find unwrapping key", err.Error())
 		return 0, diags
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
@@ -22,7 +23,7 @@ import (
 var _ resource.Resource = &WrappedKeyResource{}
 
 type WrappedKeyResource struct {
-	client *pkcs11client.Client
+	clients *pkcs11client.Clients
 }
 
 func NewResource() resource.Resource {
@@ -33,7 +34,7 @@ func (r *WrappedKeyResource) Metadata(_ context.Context, req resource.MetadataRe
 	resp.TypeName = req.ProviderTypeName + "_wrapped_key"
 }
 
-func (r *WrappedKeyResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+func (r *WrappedKeyResource) Schema(ctx context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	classEnum := pkcs11client.AttributeNameToDef["class"].Pkcs11Enum
 
 	resp.Schema = schema.Schema{
@@ -47,6 +48,8 @@ func (r *WrappedKeyResource) Schema(_ context.Context, _ resource.SchemaRequest,
 					stringplanmodifier.UseStateForUnknown(),
 				},
 			},
+			"token_name": shared.TokenNameSchema(),
+			"uri":        shared.URISchema("PKCS#11 URI (RFC 7512) of the wrapped key on the token."),
 			"mechanism": schema.StringAttribute{
 				Required:    true,
 				Description: "Wrapping mechanism name (e.g., CKM_AES_KEY_WRAP). Accepts name with or without CKM_ prefix, or numeric value.",
@@ -55,9 +58,10 @@ func (r *WrappedKeyResource) Schema(_ context.Context, _ resource.SchemaRequest,
 					stringplanmodifier.RequiresReplace(),
 				},
 			},
+			"mechanism_parameters": shared.MechanismParamsSchema(),
 			"wrapping_key_label": schema.StringAttribute{
 				Required:    true,
-				Description: "Label of the wrapping key on the token.",
+				Description: "Label or PKCS#11 URI of the wrapping key on the token.",
 				PlanModifiers: []planmodifier.String{
 					stringplanmodifier.RequiresReplace(),
 				},
@@ -73,7 +77,7 @@ func (r *WrappedKeyResource) Schema(_ context.Context, _ resource.SchemaRequest,
 			},
 			"key_label": schema.StringAttribute{
 				Required:    true,
-				Description: "Label of the key to wrap.",
+				Description: "Label or PKCS#11 URI of the key to wrap.",
 				PlanModifiers: []planmodifier.String{
 					stringplanmodifier.RequiresReplace(),
 				},
@@ -94,6 +98,9 @@ func (r *WrappedKeyResource) Schema(_ context.Context, _ resource.SchemaRequest,
 				Validators:  []validator.String{customtypes.Base64Validator{}},
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeouts.Block(ctx, timeouts.Opts{Create: true, Read: true}),
+		},
 	}
 }
 
@@ -101,17 +108,30 @@ func (r *WrappedKeyResource) Configure(_ context.Context, req resource.Configure
 	if req.ProviderData == nil {
 		return
 	}
-	client, ok := req.ProviderData.(*pkcs11client.Client)
+	clients, ok := req.ProviderData.(*pkcs11client.Clients)
 	if !ok {
 		resp.Diagnostics.AddError("Unexpected Resource Configure Type",
-			fmt.Sprintf("Expected *pkcs11client.Client, got: %T", req.ProviderData))
+			fmt.Sprintf("Expected *pkcs11client.Clients, got: %T", req.ProviderData))
 		return
 	}
-	r.client = client
+	r.clients = clients
 }
 
 func (r *WrappedKeyResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
-	wrappedData, diags := r.wrapKey(ctx, shared.PlanReader{Plan: req.Plan})
+	ctx, cancel, diags := shared.CreateContext(ctx, req.Plan)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.PlanReader{Plan: req.Plan})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	wrappedData, keyHandle, diags := r.wrapKey(ctx, client, shared.PlanReader{Plan: req.Plan})
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
@@ -135,18 +155,35 @@ func (r *WrappedKeyResource) Create(ctx context.Context, req resource.CreateRequ
 	}
 
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("mechanism"), mechanism.ValueString())...)
+	resp.Diagnostics.Append(shared.CopyMechanismParams(ctx, req.Plan, &resp.State)...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("wrapping_key_label"), wrappingKeyLabel.ValueString())...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("wrapping_key_class"), wkClass)...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("key_label"), keyLabel.ValueString())...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("key_class"), kClass)...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("wrapped_key_material"), pkcs11client.EncodeBase64(wrappedData))...)
+	resp.Diagnostics.Append(shared.CopyTokenName(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, keyHandle, &resp.State, path.Root("uri"))...)
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"),
 		fmt.Sprintf("%s/%s", wrappingKeyLabel.ValueString(), keyLabel.ValueString()))...)
 }
 
 func (r *WrappedKeyResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	ctx, cancel, diags := shared.ReadContext(ctx, req.State)
+	defer cancel()
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	client, diags := shared.ClientFor(ctx, r.clients, shared.StateReader{State: req.State})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	// Re-wrap to get current material
-	wrappedData, diags := r.wrapKey(ctx, shared.StateReader{State: req.State})
+	wrappedData, keyHandle, diags := r.wrapKey(ctx, client, shared.StateReader{State: req.State})
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		resp.State.RemoveResource(ctx)
@@ -154,11 +191,12 @@ func (r *WrappedKeyResource) Read(ctx context.Context, req resource.ReadRequest,
 	}
 
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("wrapped_key_material"), pkcs11client.EncodeBase64(wrappedData))...)
+	resp.Diagnostics.Append(shared.SetObjectURI(ctx, client, keyHandle, &resp.State, path.Root("uri"))...)
 }
 
-func (r *WrappedKeyResource) Update(_ context.Context, _ resource.UpdateRequest, resp *resource.UpdateResponse) {
-	// All inputs are RequiresReplace, so Update should never be called.
-	resp.Diagnostics.AddError("Unexpected update", "All attributes require replacement; update should not be called.")
+func (r *WrappedKeyResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	// All inputs are RequiresReplace, so only the timeouts can change in place.
+	resp.Diagnostics.Append(shared.CopyTimeouts(ctx, req.Plan, &resp.State)...)
 }
 
 func (r *WrappedKeyResource) Delete(_ context.Context, _ resource.DeleteRequest, _ *resource.DeleteResponse) {
@@ -166,7 +204,8 @@ func (r *WrappedKeyResource) Delete(_ context.Context, _ resource.DeleteRequest,
 }
 
 // wrapKey performs the actual C_WrapKey operation using attributes from a plan or state.
-func (r *WrappedKeyResource) wrapKey(ctx context.Context, src shared.AttrReader) ([]byte, diag.Diagnostics) {
+// It returns the wrapped key material and the handle of the wrapped key.
+func (r *WrappedKeyResource) wrapKey(ctx context.Context, client *pkcs11client.Client, src shared.AttrReader) ([]byte, pkcs11.ObjectHandle, diag.Diagnostics) {
 	var diags diag.Diagnostics
 
 	var mechanismName, wrappingKeyLabel, wrappingKeyClass, keyLabel, keyClass types.String
@@ -176,19 +215,21 @@ func (r *WrappedKeyResource) wrapKey(ctx context.Context, src shared.AttrReader)
 	src.GetAttribute(ctx, path.Root("key_label"), &keyLabel)
 	src.GetAttribute(ctx, path.Root("key_class"), &keyClass)
 
-	mechanismID, err := pkcs11client.MechanismEnum.Resolve(mechanismName.ValueString())
-	if err != nil {
-		diags.AddError("Invalid mechanism", err.Error())
-		return nil, diags
+	mechanism, freeMechanism, mechDiags := shared.BuildMechanism(ctx, src, mechanismName.ValueString())
+	diags.Append(mechDiags...)
+	if diags.HasError() {
+		return nil, 0, diags
 	}
+	defer freeMechanism()
 
+	var err error
 	wkClassID := uint(pkcs11.CKO_SECRET_KEY)
 	if !wrappingKeyClass.IsNull() && !wrappingKeyClass.IsUnknown() && wrappingKeyClass.ValueString() != "" {
 		classEnum := pkcs11client.AttributeNameToDef["class"].Pkcs11Enum
 		wkClassID, err = classEnum.Resolve(wrappingKeyClass.ValueString())
 		if err != nil {
 			diags.AddError("Invalid wrapping_key_class", err.Error())
-			return nil, diags
+			return nil, 0, diags
 		}
 	}
 
@@ -198,28 +239,27 @@ func (r *WrappedKeyResource) wrapKey(ctx context.Context, src shared.AttrReader)
 		kClassID, err = classEnum.Resolve(keyClass.ValueString())
 		if err != nil {
 			diags.AddError("Invalid key_class", err.Error())
-			return nil, diags
+			return nil, 0, diags
 		}
 	}
 
-	wrappingKeyHandle, err := r.client.FindObjectByLabelAndClass(wrappingKeyLabel.ValueString(), wkClassID)
+	wrappingKeyHandle, err := client.FindKey(ctx, wrappingKeyLabel.ValueString(), wkClassID)
 	if err != nil {
 		diags.AddError("Failed to find wrapping key", err.Error())
-		return nil, diags
+		return nil, 0, diags
 	}
 
-	keyHandle, err := r.client.FindObjectByLabelAndClass(keyLabel.ValueString(), kClassID)
+	keyHandle, err := client.FindKey(ctx, keyLabel.ValueString(), kClassID)
 	if err != nil {
 		diags.AddError("Failed to find key to wrap", err.Error())
-		return nil, diags
+		return nil, 0, diags
 	}
 
-	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanismID, nil)}
-	wrappedData, err := r.client.WrapKey(mechanism, wrappingKeyHandle, keyHandle)
+	wrappedData, err := client.WrapKey(ctx, mechanism, wrappingKeyHandle, keyHandle)
 	if err != nil {
 		diags.AddError("Failed to wrap key", err.Error())
-		return nil, diags
+		return nil, 0, diags
 	}
 
-	return wrappedData, diags
+	return wrappedData, keyHandle, diags
 }
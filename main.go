@@ -4,7 +4,9 @@ import (
 	"context"
 	"flag"
 	"log"
+	"os"
 
+	"blechschmidt.io/terraform-provider-pkcs11/internal/pkcs11client"
 	"blechschmidt.io/terraform-provider-pkcs11/internal/provider"
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
 )
@@ -12,6 +14,21 @@ import (
 var version = "v0.0.4-pre"
 
 func main() {
+	// The provider starts itself as host of modules with isolate_module set
+	if len(os.Args) == 3 && os.Args[1] == pkcs11client.ModuleHostCommand {
+		if err := pkcs11client.ServeModuleHost(os.Args[2]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// The provider serves modules to providers on other hosts as agent
+	if len(os.Args) > 1 && os.Args[1] == pkcs11client.AgentCommand {
+		if err := pkcs11client.ServeAgent(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var debug bool
 
 	flag.BoolVar(&debug, "debug", false, "set to true to run the provider with support for debuggers like delve")
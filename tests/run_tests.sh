@@ -7,10 +7,11 @@ set -euo pipefail
 #   ./tests/run_tests.sh                            # Run all tests with YubiHSM (default)
 #   HSM=softhsm ./tests/run_tests.sh                # Run all tests with SoftHSM
 #   HSM=softhsm ./tests/run_tests.sh test_31        # Run specific tests with SoftHSM
+#   HSM=memory ./tests/run_tests.sh                 # Run all tests with the in-memory token
 #   PKCS11_PIN=mypin ./tests/run_tests.sh            # Override PIN via env
 #
 # Environment variables:
-#   HSM           - HSM backend: "yubihsm" (default) or "softhsm"
+#   HSM           - HSM backend: "yubihsm" (default), "softhsm" or "memory"
 #   PKCS11_PIN    - PIN for the token (defaults per HSM)
 #   PKCS11_MODULE - Override PKCS#11 module path
 #   PKCS11_SLOT   - Override slot ID
@@ -50,8 +51,16 @@ case "${HSM}" in
         fi
         PROVIDER_ENV=""
         ;;
+    memory)
+        # The token is initialized below and kept in a file across terraform runs
+        PKCS11_PIN="${PKCS11_PIN:-1234}"
+        MEMORY_TOKEN_FILE="$(mktemp -u)"
+        PKCS11_MODULE="${PKCS11_MODULE:-memory:${MEMORY_TOKEN_FILE}}"
+        PKCS11_SLOT="${PKCS11_SLOT:-0}"
+        PROVIDER_ENV=""
+        ;;
     *)
-        echo "Unknown HSM backend: ${HSM}. Use 'yubihsm' or 'softhsm'."
+        echo "Unknown HSM backend: ${HSM}. Use 'yubihsm', 'softhsm' or 'memory'."
         exit 1
         ;;
 esac
@@ -63,6 +72,9 @@ cleanup() {
     if [[ -n "${GENERATED_PROVIDER_TF:-}" && -f "${GENERATED_PROVIDER_TF}" ]]; then
         rm -f "${GENERATED_PROVIDER_TF}"
     fi
+    if [[ -n "${MEMORY_TOKEN_FILE:-}" ]]; then
+        rm -f "${MEMORY_TOKEN_FILE}"
+    fi
 }
 trap cleanup EXIT
 
@@ -125,6 +137,29 @@ provider "pkcs11" {
 }
 EOF
 
+# The in-memory token starts uninitialized like a fresh SoftHSM slot
+if [[ "${HSM}" == "memory" ]]; then
+    log_info "Initializing in-memory token..."
+    init_dir="$(mktemp -d)"
+    cp "${GENERATED_PROVIDER_TF}" "${init_dir}/provider.tf"
+    cat > "${init_dir}/token.tf" <<EOF
+resource "pkcs11_token" "test" {
+  slot_id  = ${PKCS11_SLOT}
+  label    = "test"
+  so_pin   = "12345678"
+  user_pin = var.pkcs11_pin
+}
+EOF
+    echo "pkcs11_pin = \"${PKCS11_PIN}\"" > "${init_dir}/terraform.tfvars"
+    if ! init_output=$(cd "${init_dir}" && terraform apply -auto-approve -no-color 2>&1); then
+        log_fail "Failed to initialize in-memory token"
+        echo "${init_output}" | tail -20
+        rm -rf "${init_dir}"
+        exit 1
+    fi
+    rm -rf "${init_dir}"
+fi
+
 # Determine which tests to run
 if [[ $# -gt 0 ]]; then
     TESTS=("$@")
@@ -0,0 +1,117 @@
+# Test 61: Encrypt/decrypt round-trips with CKM_AES_GCM and CKM_RSA_PKCS_OAEP and typed mechanism parameters
+resource "pkcs11_symmetric_key" "aes_key" {
+  mechanism   = "CKM_AES_KEY_GEN"
+  label       = "test-61-aes-key"
+  class       = "CKO_SECRET_KEY"
+  key_type    = "CKK_AES"
+  value_len   = 32
+  encrypt     = true
+  decrypt     = true
+  token       = true
+  sensitive   = true
+  extractable = false
+}
+
+locals {
+  gcm = {
+    iv       = base64encode("0123456789ab")
+    aad      = base64encode("test-61-aad")
+    tag_bits = 128
+  }
+}
+
+data "pkcs11_encrypt" "encrypted" {
+  depends_on = [pkcs11_symmetric_key.aes_key]
+  mechanism  = "CKM_AES_GCM"
+  key_label  = "test-61-aes-key"
+  plaintext  = base64encode("authenticated encryption")
+
+  mechanism_parameters = {
+    gcm = local.gcm
+  }
+}
+
+data "pkcs11_decrypt" "decrypted" {
+  depends_on = [data.pkcs11_encrypt.encrypted]
+  mechanism  = "CKM_AES_GCM"
+  key_label  = "test-61-aes-key"
+  ciphertext = data.pkcs11_encrypt.encrypted.ciphertext
+
+  mechanism_parameters = {
+    gcm = local.gcm
+  }
+}
+
+check "aes_gcm_round_trip" {
+  assert {
+    condition     = data.pkcs11_decrypt.decrypted.plaintext == data.pkcs11_encrypt.encrypted.plaintext
+    error_message = "AES GCM round-trip should preserve plaintext"
+  }
+}
+
+check "aes_gcm_ciphertext_includes_tag" {
+  assert {
+    condition     = length(base64decode(data.pkcs11_encrypt.encrypted.ciphertext)) == length("authenticated encryption") + 16
+    error_message = "AES GCM ciphertext should carry a 16-byte authentication tag"
+  }
+}
+
+resource "pkcs11_key_pair" "rsa_key" {
+  mechanism = "CKM_RSA_PKCS_KEY_PAIR_GEN"
+
+  public_key = {
+    key_type        = "CKK_RSA"
+    class           = "CKO_PUBLIC_KEY"
+    token           = true
+    encrypt         = true
+    label           = "test-61-rsa-key"
+    modulus_bits    = 2048
+    public_exponent = "010001"
+  }
+
+  private_key = {
+    key_type = "CKK_RSA"
+    class    = "CKO_PRIVATE_KEY"
+    token    = true
+    decrypt  = true
+    label    = "test-61-rsa-key"
+  }
+}
+
+# Without source_data, the OAEP label is empty
+data "pkcs11_encrypt" "oaep_encrypted" {
+  depends_on = [pkcs11_key_pair.rsa_key]
+  mechanism  = "CKM_RSA_PKCS_OAEP"
+  key_label  = "test-61-rsa-key"
+  key_class  = "CKO_PUBLIC_KEY"
+  plaintext  = base64encode("oaep without label")
+
+  mechanism_parameters = {
+    oaep = {
+      hash = "CKM_SHA256"
+      mgf  = "CKG_MGF1_SHA256"
+    }
+  }
+}
+
+data "pkcs11_decrypt" "oaep_decrypted" {
+  depends_on = [data.pkcs11_encrypt.oaep_encrypted]
+  mechanism  = "CKM_RSA_PKCS_OAEP"
+  key_label  = "test-61-rsa-key"
+  key_class  = "CKO_PRIVATE_KEY"
+  ciphertext = data.pkcs11_encrypt.oaep_encrypted.ciphertext
+
+  mechanism_parameters = {
+    oaep = {
+      hash = "CKM_SHA256"
+      mgf  = "CKG_MGF1_SHA256"
+    }
+  }
+}
+
+check "rsa_oaep_round_trip" {
+  assert {
+    condition     = data.pkcs11_decrypt.oaep_decrypted.plaintext == data.pkcs11_encrypt.oaep_encrypted.plaintext
+    error_message = "RSA OAEP round-trip should preserve plaintext"
+  }
+}
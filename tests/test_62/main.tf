@@ -0,0 +1,62 @@
+# Test 62: Verify EC signature with pkcs11_verify, including a tampered signature
+resource "pkcs11_key_pair" "ec_key" {
+  mechanism = "CKM_EC_KEY_PAIR_GEN"
+
+  public_key = {
+    label     = "test-62-ec-pub"
+    class     = "CKO_PUBLIC_KEY"
+    key_type  = "CKK_EC"
+    ec_params = "BggqhkjOPQMBBw==" # P-256 OID
+    token     = true
+    verify    = true
+  }
+
+  private_key = {
+    label       = "test-62-ec-priv"
+    class       = "CKO_PRIVATE_KEY"
+    key_type    = "CKK_EC"
+    token       = true
+    sign        = true
+    sensitive   = true
+    extractable = false
+  }
+}
+
+locals {
+  digest = base64encode("01234567890123456789012345678901") # 32 bytes for SHA-256 hash
+}
+
+data "pkcs11_signature" "sig" {
+  depends_on = [pkcs11_key_pair.ec_key]
+  mechanism  = "CKM_ECDSA"
+  key_label  = "test-62-ec-priv"
+  data       = local.digest
+}
+
+data "pkcs11_verify" "good" {
+  mechanism = "CKM_ECDSA"
+  key_label = "test-62-ec-pub"
+  data      = local.digest
+  signature = data.pkcs11_signature.sig.signature
+}
+
+data "pkcs11_verify" "tampered" {
+  mechanism = "CKM_ECDSA"
+  key_label = "test-62-ec-pub"
+  data      = base64encode("10234567890123456789012345678901")
+  signature = data.pkcs11_signature.sig.signature
+}
+
+check "ec_verify_valid" {
+  assert {
+    condition     = data.pkcs11_verify.good.valid
+    error_message = "Signature over the original data should verify"
+  }
+}
+
+check "ec_verify_invalid" {
+  assert {
+    condition     = !data.pkcs11_verify.tampered.valid
+    error_message = "Signature over different data should not verify"
+  }
+}
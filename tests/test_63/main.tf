@@ -0,0 +1,24 @@
+# Test 63: Digest data and a file with CKM_SHA256 and compare against Terraform's sha256 functions
+data "pkcs11_digest" "data" {
+  mechanism = "CKM_SHA256"
+  data      = base64encode("test 63 digest input")
+}
+
+data "pkcs11_digest" "file" {
+  mechanism  = "CKM_SHA256"
+  input_file = "${path.module}/main.tf"
+}
+
+check "digest_data_matches" {
+  assert {
+    condition     = data.pkcs11_digest.data.digest_hex == sha256("test 63 digest input")
+    error_message = "CKM_SHA256 digest of data should match sha256()"
+  }
+}
+
+check "digest_file_matches" {
+  assert {
+    condition     = data.pkcs11_digest.file.digest_hex == filesha256("${path.module}/main.tf")
+    error_message = "CKM_SHA256 digest of input_file should match filesha256()"
+  }
+}
@@ -0,0 +1,78 @@
+# Test 64: Derive AES keys from a master key with CKM_AES_ECB_ENCRYPT_DATA and
+# CKM_CONCATENATE_BASE_AND_KEY, then use the derived key for encryption
+resource "pkcs11_symmetric_key" "master" {
+  mechanism = "CKM_AES_KEY_GEN"
+  label     = "test-64-master"
+  key_type  = "CKK_AES"
+  value_len = 32
+  token     = true
+  derive    = true
+}
+
+resource "pkcs11_derived_key" "tenant" {
+  mechanism      = "CKM_AES_ECB_ENCRYPT_DATA"
+  base_key_label = pkcs11_symmetric_key.master.label
+
+  mechanism_parameters = {
+    derivation_data = base64encode("tenant-a-0000001")
+  }
+
+  label     = "test-64-tenant"
+  class     = "CKO_SECRET_KEY"
+  key_type  = "CKK_AES"
+  value_len = 16
+  token     = true
+  encrypt   = true
+  decrypt   = true
+  derive    = true
+}
+
+resource "pkcs11_derived_key" "concatenated" {
+  mechanism      = "CKM_CONCATENATE_BASE_AND_KEY"
+  base_key_label = pkcs11_symmetric_key.master.label
+
+  mechanism_parameters = {
+    key = {
+      label = pkcs11_derived_key.tenant.label
+    }
+  }
+
+  label    = "test-64-concatenated"
+  class    = "CKO_SECRET_KEY"
+  key_type = "CKK_GENERIC_SECRET"
+  token    = true
+}
+
+data "pkcs11_encrypt" "tenant" {
+  mechanism = "CKM_AES_CBC_PAD"
+  key_label = pkcs11_derived_key.tenant.label
+  plaintext = base64encode("test 64 derived key")
+
+  mechanism_parameters = {
+    iv = base64encode("0123456789abcdef")
+  }
+}
+
+data "pkcs11_decrypt" "tenant" {
+  mechanism  = "CKM_AES_CBC_PAD"
+  key_label  = pkcs11_derived_key.tenant.label
+  ciphertext = data.pkcs11_encrypt.tenant.ciphertext
+
+  mechanism_parameters = {
+    iv = base64encode("0123456789abcdef")
+  }
+}
+
+check "derived_key_roundtrip" {
+  assert {
+    condition     = base64decode(data.pkcs11_decrypt.tenant.plaintext) == "test 64 derived key"
+    error_message = "Data encrypted with the derived key should decrypt to the original plaintext"
+  }
+}
+
+check "derived_key_class" {
+  assert {
+    condition     = pkcs11_derived_key.tenant.base_key_class == "CKO_SECRET_KEY"
+    error_message = "base_key_class should default to CKO_SECRET_KEY"
+  }
+}
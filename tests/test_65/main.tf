@@ -0,0 +1,106 @@
+# Test 65: ECDH key agreement between two EC key pairs on the token, each side
+# using the other's CKA_EC_POINT as peer public key, then verify that both
+# derived AES keys are identical by encrypting with one and decrypting with the other
+resource "pkcs11_key_pair" "alice" {
+  mechanism = "CKM_EC_KEY_PAIR_GEN"
+
+  public_key = {
+    label     = "test-65-alice-pub"
+    class     = "CKO_PUBLIC_KEY"
+    key_type  = "CKK_EC"
+    ec_params = "BggqhkjOPQMBBw==" # P-256 OID
+    token     = true
+  }
+
+  private_key = {
+    label     = "test-65-alice-priv"
+    class     = "CKO_PRIVATE_KEY"
+    key_type  = "CKK_EC"
+    token     = true
+    derive    = true
+    sensitive = true
+  }
+}
+
+resource "pkcs11_key_pair" "bob" {
+  mechanism = "CKM_EC_KEY_PAIR_GEN"
+
+  public_key = {
+    label     = "test-65-bob-pub"
+    class     = "CKO_PUBLIC_KEY"
+    key_type  = "CKK_EC"
+    ec_params = "BggqhkjOPQMBBw==" # P-256 OID
+    token     = true
+  }
+
+  private_key = {
+    label     = "test-65-bob-priv"
+    class     = "CKO_PRIVATE_KEY"
+    key_type  = "CKK_EC"
+    token     = true
+    derive    = true
+    sensitive = true
+  }
+}
+
+resource "pkcs11_ecdh_key" "alice" {
+  private_key_label = pkcs11_key_pair.alice.private_key.label
+  peer_public_key   = pkcs11_key_pair.bob.public_key.ec_point
+  kdf               = "CKD_SHA256_KDF"
+  shared_data       = base64encode("test-65")
+
+  label     = "test-65-alice-shared"
+  class     = "CKO_SECRET_KEY"
+  key_type  = "CKK_AES"
+  value_len = 32
+  token     = true
+  encrypt   = true
+}
+
+resource "pkcs11_ecdh_key" "bob" {
+  private_key_label = pkcs11_key_pair.bob.private_key.label
+  peer_public_key   = pkcs11_key_pair.alice.public_key.ec_point
+  kdf               = "CKD_SHA256_KDF"
+  shared_data       = base64encode("test-65")
+
+  label     = "test-65-bob-shared"
+  class     = "CKO_SECRET_KEY"
+  key_type  = "CKK_AES"
+  value_len = 32
+  token     = true
+  decrypt   = true
+}
+
+data "pkcs11_encrypt" "alice" {
+  mechanism = "CKM_AES_CBC_PAD"
+  key_label = pkcs11_ecdh_key.alice.label
+  plaintext = base64encode("test 65 ecdh")
+
+  mechanism_parameters = {
+    iv = base64encode("0123456789abcdef")
+  }
+}
+
+data "pkcs11_decrypt" "bob" {
+  mechanism  = "CKM_AES_CBC_PAD"
+  key_label  = pkcs11_ecdh_key.bob.label
+  ciphertext = data.pkcs11_encrypt.alice.ciphertext
+
+  mechanism_parameters = {
+    iv = base64encode("0123456789abcdef")
+  }
+}
+
+check "shared_key_matches" {
+  assert {
+    condition     = base64decode(data.pkcs11_decrypt.bob.plaintext) == "test 65 ecdh"
+    error_message = "Both parties should derive the same key"
+  }
+}
+
+check "defaults" {
+  assert {
+    condition     = pkcs11_ecdh_key.alice.mechanism == "CKM_ECDH1_DERIVE" && pkcs11_ecdh_key.alice.peer_point_encoding == "raw"
+    error_message = "mechanism and peer_point_encoding should default to CKM_ECDH1_DERIVE and raw"
+  }
+}
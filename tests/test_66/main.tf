@@ -0,0 +1,23 @@
+# Test 66: Generate random data with the token RNG and check the output encodings
+resource "pkcs11_random" "bytes" {
+  length = 32
+}
+
+resource "pkcs11_random" "pin" {
+  length   = 8
+  alphabet = "0123456789"
+}
+
+check "random_lengths" {
+  assert {
+    condition     = length(base64decode(pkcs11_random.bytes.result)) == 32 && length(pkcs11_random.bytes.result_hex) == 64
+    error_message = "result should hold 32 bytes and result_hex 64 hex characters"
+  }
+}
+
+check "random_pin" {
+  assert {
+    condition     = can(regex("^[0-9]{8}$", pkcs11_random.pin.password))
+    error_message = "password should consist of 8 digits"
+  }
+}
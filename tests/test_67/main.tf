@@ -0,0 +1,33 @@
+# Test 67: Copy a non-extractable AES key into an encrypt-only variant with C_CopyObject
+resource "pkcs11_symmetric_key" "source" {
+  mechanism   = "CKM_AES_KEY_GEN"
+  label       = "test-67-source"
+  key_type    = "CKK_AES"
+  value_len   = 32
+  token       = true
+  sensitive   = true
+  extractable = false
+  encrypt     = true
+  decrypt     = true
+}
+
+resource "pkcs11_object_copy" "encrypt_only" {
+  source_label = pkcs11_symmetric_key.source.label
+
+  label   = "test-67-encrypt-only"
+  decrypt = false
+}
+
+check "copy_attributes" {
+  assert {
+    condition     = pkcs11_object_copy.encrypt_only.encrypt && !pkcs11_object_copy.encrypt_only.decrypt
+    error_message = "The copy should keep encrypt and drop decrypt"
+  }
+}
+
+check "copy_inherits" {
+  assert {
+    condition     = pkcs11_object_copy.encrypt_only.key_type == "CKK_AES" && !pkcs11_object_copy.encrypt_only.extractable
+    error_message = "The copy should inherit key type and extractability from the source"
+  }
+}
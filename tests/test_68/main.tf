@@ -0,0 +1,44 @@
+# Test 68: Sign a file in chunks with CKM_ECDSA_SHA256 and verify the signature over its contents
+resource "pkcs11_key_pair" "ec_key" {
+  mechanism = "CKM_EC_KEY_PAIR_GEN"
+
+  public_key = {
+    label     = "test-68-ec-pub"
+    class     = "CKO_PUBLIC_KEY"
+    key_type  = "CKK_EC"
+    ec_params = "BggqhkjOPQMBBw==" # P-256 OID
+    token     = true
+    verify    = true
+  }
+
+  private_key = {
+    label       = "test-68-ec-priv"
+    class       = "CKO_PRIVATE_KEY"
+    key_type    = "CKK_EC"
+    token       = true
+    sign        = true
+    sensitive   = true
+    extractable = false
+  }
+}
+
+data "pkcs11_signature" "file" {
+  depends_on = [pkcs11_key_pair.ec_key]
+  mechanism  = "CKM_ECDSA_SHA256"
+  key_label  = "test-68-ec-priv"
+  input_file = "${path.module}/main.tf"
+}
+
+data "pkcs11_verify" "file" {
+  mechanism = "CKM_ECDSA_SHA256"
+  key_label = "test-68-ec-pub"
+  data      = filebase64("${path.module}/main.tf")
+  signature = data.pkcs11_signature.file.signature
+}
+
+check "file_signature_valid" {
+  assert {
+    condition     = data.pkcs11_verify.file.valid
+    error_message = "Signature over input_file should verify against the file contents"
+  }
+}
@@ -0,0 +1,52 @@
+# Test 69: Reference keys by the PKCS#11 URIs exposed by pkcs11_key_pair
+resource "pkcs11_key_pair" "ec_key" {
+  mechanism = "CKM_EC_KEY_PAIR_GEN"
+
+  public_key = {
+    label     = "test-69-ec"
+    key_id    = "aQ=="
+    class     = "CKO_PUBLIC_KEY"
+    key_type  = "CKK_EC"
+    ec_params = "BggqhkjOPQMBBw==" # P-256 OID
+    token     = true
+    verify    = true
+  }
+
+  private_key = {
+    label       = "test-69-ec"
+    key_id      = "aQ=="
+    class       = "CKO_PRIVATE_KEY"
+    key_type    = "CKK_EC"
+    token       = true
+    sign        = true
+    sensitive   = true
+    extractable = false
+  }
+}
+
+data "pkcs11_signature" "sig" {
+  mechanism = "CKM_ECDSA_SHA256"
+  key_label = pkcs11_key_pair.ec_key.private_key.uri
+  data      = base64encode("test-69")
+}
+
+data "pkcs11_verify" "sig" {
+  mechanism = "CKM_ECDSA_SHA256"
+  key_label = pkcs11_key_pair.ec_key.public_key.uri
+  data      = base64encode("test-69")
+  signature = data.pkcs11_signature.sig.signature
+}
+
+check "uri_signature_valid" {
+  assert {
+    condition     = data.pkcs11_verify.sig.valid
+    error_message = "Signature made with the private key URI should verify with the public key URI"
+  }
+}
+
+check "uri_format" {
+  assert {
+    condition     = startswith(pkcs11_key_pair.ec_key.private_key.uri, "pkcs11:") && strcontains(pkcs11_key_pair.ec_key.private_key.uri, "object=test-69-ec;id=%69;type=private")
+    error_message = "Private key URI should identify the key by label, id and type"
+  }
+}
@@ -0,0 +1,32 @@
+# Test 70: Resources accept timeouts blocks for their token operations
+resource "pkcs11_symmetric_key" "aes" {
+  mechanism = "CKM_AES_KEY_GEN"
+  label     = "test-70-aes"
+  key_type  = "CKK_AES"
+  value_len = 32
+  token     = true
+  encrypt   = true
+  decrypt   = true
+
+  timeouts {
+    create = "1m"
+    read   = "30s"
+    update = "1m"
+    delete = "1m"
+  }
+}
+
+resource "pkcs11_random" "bytes" {
+  length = 16
+
+  timeouts {
+    create = "30s"
+  }
+}
+
+check "timeouts_applied" {
+  assert {
+    condition     = pkcs11_symmetric_key.aes.timeouts.create == "1m" && length(base64decode(pkcs11_random.bytes.result)) == 16
+    error_message = "Resources with timeouts should be created and keep the configured timeouts"
+  }
+}